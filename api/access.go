@@ -0,0 +1,73 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	"github.com/lbryio/transcoder/video"
+
+	"github.com/valyala/fasthttp"
+)
+
+// AccessRuleView is a JSON representation of a channel or claim access rule.
+type AccessRuleView struct {
+	Kind      string `json:"kind"`
+	Subject   string `json:"subject"`
+	Policy    string `json:"policy"`
+	Reason    string `json:"reason,omitempty"`
+	CreatedAt string `json:"created_at,omitempty"`
+}
+
+func newAccessRuleView(r *video.AccessRule) *AccessRuleView {
+	return &AccessRuleView{Kind: r.Kind, Subject: r.Subject, Policy: r.Policy, Reason: r.Reason, CreatedAt: r.CreatedAt}
+}
+
+func (h *APIServer) handleListAccessRules(ctx *fasthttp.RequestCtx) {
+	rules, err := h.videoManager.library.AccessRules(string(ctx.QueryArgs().Peek("kind")))
+	if err != nil {
+		writeError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	views := []*AccessRuleView{}
+	for _, r := range rules {
+		views = append(views, newAccessRuleView(r))
+	}
+	writeJSON(ctx, http.StatusOK, views)
+}
+
+func (h *APIServer) handleSetAccessRule(ctx *fasthttp.RequestCtx) {
+	var req AccessRuleView
+	if err := json.Unmarshal(ctx.PostBody(), &req); err != nil {
+		writeError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	r, err := h.videoManager.library.SetAccessRule(video.AccessRule{
+		Kind: req.Kind, Subject: req.Subject, Policy: req.Policy, Reason: req.Reason,
+	})
+	if err == video.ErrInvalidAccessRule {
+		writeError(ctx, http.StatusBadRequest, err)
+		return
+	} else if err != nil {
+		writeError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(ctx, http.StatusOK, newAccessRuleView(r))
+}
+
+func (h *APIServer) handleDeleteAccessRule(ctx *fasthttp.RequestCtx) {
+	subject, err := url.PathUnescape(ctx.UserValue("subject").(string))
+	if err != nil {
+		writeError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	err = h.videoManager.library.DeleteAccessRule(ctx.UserValue("kind").(string), subject)
+	if err == video.ErrAccessRuleMissing {
+		writeError(ctx, http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		writeError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	ctx.SetStatusCode(http.StatusNoContent)
+}
@@ -0,0 +1,280 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/lbryio/transcoder/formats"
+	"github.com/lbryio/transcoder/pkg/claim"
+	"github.com/lbryio/transcoder/queue"
+	"github.com/lbryio/transcoder/video"
+
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 500
+	maxBulkEnqueue   = 100
+)
+
+const (
+	enqueueResultQueued    = "queued"
+	enqueueResultExists    = "exists"
+	enqueueResultForbidden = "forbidden"
+	enqueueResultNotFound  = "not_found"
+	enqueueResultError     = "error"
+)
+
+// TaskView is a JSON representation of a queued task.
+type TaskView struct {
+	ID            uint32   `json:"id"`
+	SDHash        string   `json:"sd_hash"`
+	URL           string   `json:"url"`
+	Type          string   `json:"type"`
+	Status        string   `json:"status"`
+	Stage         string   `json:"stage,omitempty"`
+	Priority      int      `json:"priority"`
+	Progress      *float64 `json:"progress,omitempty"`
+	Speed         *float64 `json:"speed,omitempty"`
+	CreatedAt     string   `json:"created_at"`
+	StartedAt     string   `json:"started_at,omitempty"`
+	QueuePosition *int     `json:"queue_position,omitempty"`
+}
+
+type TaskPage struct {
+	Tasks  []*TaskView `json:"tasks"`
+	Total  int         `json:"total"`
+	Limit  int         `json:"limit"`
+	Offset int         `json:"offset"`
+}
+
+type enqueueRequest struct {
+	URLs     []string `json:"urls"`
+	Priority int      `json:"priority"`
+	Callback string   `json:"callback"`
+}
+
+type enqueueResult struct {
+	URL    string    `json:"url"`
+	Result string    `json:"result"`
+	Task   *TaskView `json:"task,omitempty"`
+	Error  string    `json:"error,omitempty"`
+}
+
+type priorityRequest struct {
+	Priority int `json:"priority"`
+}
+
+func newTaskView(t *queue.Task) *TaskView {
+	v := &TaskView{
+		ID:        t.ID,
+		SDHash:    t.SDHash,
+		URL:       t.URL,
+		Type:      t.Type,
+		Status:    t.Status,
+		Stage:     t.Stage.String,
+		Priority:  t.Priority,
+		CreatedAt: t.CreatedAt,
+		StartedAt: t.StartedAt.String,
+	}
+	if t.Progress.Valid {
+		v.Progress = &t.Progress.Float64
+	}
+	if t.Speed.Valid {
+		v.Speed = &t.Speed.Float64
+	}
+	return v
+}
+
+func taskID(ctx *fasthttp.RequestCtx) (uint32, error) {
+	id, err := strconv.ParseUint(ctx.UserValue("id").(string), 10, 32)
+	return uint32(id), err
+}
+
+// pagination reads `limit` and `offset` query parameters.
+func pagination(ctx *fasthttp.RequestCtx) (int, int) {
+	limit, offset := defaultPageLimit, 0
+	if l, err := strconv.Atoi(string(ctx.QueryArgs().Peek("limit"))); err == nil && l > 0 {
+		limit = l
+	}
+	if limit > maxPageLimit {
+		limit = maxPageLimit
+	}
+	if o, err := strconv.Atoi(string(ctx.QueryArgs().Peek("offset"))); err == nil && o > 0 {
+		offset = o
+	}
+	return limit, offset
+}
+
+func writeTaskError(ctx *fasthttp.RequestCtx, err error) {
+	switch err {
+	case queue.ErrTaskNotFound:
+		writeError(ctx, http.StatusNotFound, err)
+	case queue.ErrInvalidTransition:
+		writeError(ctx, http.StatusConflict, err)
+	default:
+		writeError(ctx, http.StatusInternalServerError, err)
+	}
+}
+
+func (h *APIServer) handleListTasks(ctx *fasthttp.RequestCtx) {
+	limit, offset := pagination(ctx)
+	q := h.videoManager.queue
+	tasks, total, err := q.ListPage(queue.ListParams{
+		Status: string(ctx.QueryArgs().Peek("status")),
+		Limit:  limit,
+		Offset: offset,
+	})
+	if err != nil {
+		writeError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	page := &TaskPage{Tasks: []*TaskView{}, Total: total, Limit: limit, Offset: offset}
+	for _, t := range tasks {
+		page.Tasks = append(page.Tasks, newTaskView(t))
+	}
+	writeJSON(ctx, http.StatusOK, page)
+}
+
+func (h *APIServer) handleGetTask(ctx *fasthttp.RequestCtx) {
+	id, err := taskID(ctx)
+	if err != nil {
+		writeError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	q := h.videoManager.queue
+	t, err := q.Get(id)
+	if err != nil {
+		writeError(ctx, http.StatusInternalServerError, err)
+		return
+	} else if t == nil {
+		writeError(ctx, http.StatusNotFound, queue.ErrTaskNotFound)
+		return
+	}
+	v := newTaskView(t)
+	pos, err := q.Position(t)
+	if err != nil {
+		writeError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	v.QueuePosition = &pos
+	writeJSON(ctx, http.StatusOK, v)
+}
+
+func (h *APIServer) handleRequeueTask(ctx *fasthttp.RequestCtx) {
+	h.transitionTask(ctx, h.videoManager.queue.Requeue)
+}
+
+func (h *APIServer) handleCancelTask(ctx *fasthttp.RequestCtx) {
+	h.transitionTask(ctx, h.videoManager.queue.Cancel)
+}
+
+func (h *APIServer) handleRejectTask(ctx *fasthttp.RequestCtx) {
+	h.transitionTask(ctx, h.videoManager.queue.Reject)
+}
+
+func (h *APIServer) handleTaskPriority(ctx *fasthttp.RequestCtx) {
+	var req priorityRequest
+	if err := json.Unmarshal(ctx.PostBody(), &req); err != nil {
+		writeError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	h.transitionTask(ctx, func(id uint32) (*queue.Task, error) {
+		return h.videoManager.queue.SetPriority(id, req.Priority)
+	})
+}
+
+func (h *APIServer) transitionTask(ctx *fasthttp.RequestCtx, op func(uint32) (*queue.Task, error)) {
+	id, err := taskID(ctx)
+	if err != nil {
+		writeError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	t, err := op(id)
+	if err != nil {
+		writeTaskError(ctx, err)
+		return
+	}
+	logger.Infow("task updated by admin", "id", t.ID, "status", t.Status, "priority", t.Priority)
+	writeJSON(ctx, http.StatusOK, newTaskView(t))
+}
+
+// handleEnqueue validates and adds multiple URLs to the queue, reporting outcome for each of them.
+func (h *APIServer) handleEnqueue(ctx *fasthttp.RequestCtx) {
+	var req enqueueRequest
+	if err := json.Unmarshal(ctx.PostBody(), &req); err != nil {
+		writeError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	if len(req.URLs) == 0 || len(req.URLs) > maxBulkEnqueue {
+		writeError(ctx, http.StatusBadRequest, errors.New("between 1 and 100 urls should be supplied"))
+		return
+	}
+	if err := h.checkCallback(req.Callback); err != nil {
+		writeError(ctx, http.StatusBadRequest, err)
+		return
+	}
+
+	results := []*enqueueResult{}
+	for _, u := range req.URLs {
+		results = append(results, h.enqueue(u, req.Priority, req.Callback))
+	}
+	writeJSON(ctx, http.StatusOK, results)
+}
+
+// checkCallback validates callback URL supplied with a transcoding request, if there is one.
+func (h *APIServer) checkCallback(callback string) error {
+	if callback == "" {
+		return nil
+	}
+	if h.webhooks == nil {
+		return errors.New("webhooks are not enabled")
+	}
+	return validateCallbackURL(callback)
+}
+
+func (h *APIServer) enqueue(url string, priority int, callback string) *enqueueResult {
+	_, t, err := h.videoManager.GetVideoOrTask(url, formats.TypeHLS, "")
+	return h.enqueueResult(url, t, err, priority, callback)
+}
+
+// enqueueResult reports outcome of a video lookup, setting `priority` and `callback` for the queued task.
+func (h *APIServer) enqueueResult(url string, t *queue.Task, err error, priority int, callback string) *enqueueResult {
+	r := &enqueueResult{URL: url}
+	switch {
+	case err == nil:
+		r.Result = enqueueResultExists
+		return r
+	case isForbidden(err):
+		r.Result = enqueueResultForbidden
+		r.Error = err.Error()
+		return r
+	case err == claim.ErrStreamNotFound || err == sql.ErrNoRows:
+		r.Result = enqueueResultNotFound
+		return r
+	case err != video.ErrTranscodingUnderway || t == nil:
+		r.Result = enqueueResultError
+		r.Error = err.Error()
+		return r
+	}
+
+	r.Result = enqueueResultQueued
+	if priority != 0 && t.Priority != priority {
+		if pt, err := h.videoManager.queue.SetPriority(t.ID, priority); err == nil {
+			t = pt
+		} else {
+			r.Error = err.Error()
+		}
+	}
+	if callback != "" {
+		if err := h.webhooks.AddCallback(t.SDHash, callback); err != nil {
+			r.Error = err.Error()
+		}
+	}
+	r.Task = newTaskView(t)
+	return r
+}
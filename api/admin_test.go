@@ -0,0 +1,393 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/lbryio/transcoder/auth"
+	"github.com/lbryio/transcoder/db"
+	"github.com/lbryio/transcoder/formats"
+	"github.com/lbryio/transcoder/pkg/claim"
+	"github.com/lbryio/transcoder/queue"
+	"github.com/lbryio/transcoder/storage"
+	"github.com/lbryio/transcoder/video"
+
+	"github.com/stretchr/testify/suite"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+type AdminSuite struct {
+	suite.Suite
+	q      *queue.Queue
+	lib    *video.Library
+	auth   *auth.Manager
+	server *APIServer
+	client *fasthttp.Client
+	ingest claim.DirectFetcher
+}
+
+func TestAdminSuite(t *testing.T) {
+	suite.Run(t, new(AdminSuite))
+}
+
+func (s *AdminSuite) SetupTest() {
+	vdb := db.OpenTestDB()
+	s.Require().NoError(vdb.Migrate(video.Migrations...))
+	qdb := db.OpenTestDB()
+	s.Require().NoError(qdb.Migrate(queue.Migrations...))
+	s.lib = video.NewLibrary(video.Configure().LocalStorage(storage.Local("/tmp/test")).DB(vdb))
+	s.q = queue.NewQueue(qdb)
+
+	adb := db.OpenTestDB()
+	s.Require().NoError(adb.Migrate(auth.Migrations...))
+	s.auth = auth.NewManager(auth.Configure().DB(adb).StaticKey("bootstrap", "adm1n", auth.ScopeAdmin).Public(auth.ScopePlayback))
+
+	s.ingest = claim.DirectFetcher{LocalRoot: s.T().TempDir(), UploadPath: s.T().TempDir()}
+	s.server = NewServer(Configure().VideoManager(NewManager(s.q, s.lib)).Auth(s.auth).Ingest(s.ingest, 0))
+	ln := fasthttputil.NewInmemoryListener()
+	go s.server.httpServer.Serve(ln)
+	s.client = &fasthttp.Client{Dial: func(addr string) (net.Conn, error) { return ln.Dial() }}
+}
+
+func (s *AdminSuite) TearDownTest() {
+	s.server.Shutdown()
+}
+
+func (s *AdminSuite) request(method, path, token, body string, target interface{}) int {
+	req := fasthttp.AcquireRequest()
+	defer fasthttp.ReleaseRequest(req)
+	res := fasthttp.AcquireResponse()
+	defer fasthttp.ReleaseResponse(res)
+
+	req.SetConnectionClose()
+	req.Header.SetMethod(method)
+	req.SetRequestURI("http://transcoder" + path)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	req.SetBodyString(body)
+	s.Require().NoError(s.client.Do(req, res))
+	if target != nil {
+		s.Require().NoError(json.Unmarshal(res.Body(), target), string(res.Body()))
+	}
+	return res.StatusCode()
+}
+
+func (s *AdminSuite) TestAuth() {
+	s.Equal(http.StatusUnauthorized, s.request(http.MethodGet, "/api/v1/admin/tasks", "", "", nil))
+	s.Equal(http.StatusUnauthorized, s.request(http.MethodGet, "/api/v1/admin/tasks", "wrong", "", nil))
+	s.Equal(http.StatusOK, s.request(http.MethodGet, "/api/v1/admin/tasks", "adm1n", "", nil))
+	// Events of all streams are only available to admins.
+	s.Equal(http.StatusUnauthorized, s.request(http.MethodGet, "/api/v1/events", "", "", nil))
+}
+
+func (s *AdminSuite) TestProgressRetryAfter() {
+	t, err := s.q.Add("lbry://progress", "progress", formats.TypeHLS)
+	s.Require().NoError(err)
+
+	ctx := &fasthttp.RequestCtx{}
+	s.server.writeProgress(ctx, t, logger)
+	s.Equal(http.StatusAccepted, ctx.Response.StatusCode())
+	s.Equal("15", string(ctx.Response.Header.Peek("Retry-After")))
+
+	pt, err := s.q.Poll()
+	s.Require().NoError(err)
+	s.Require().NoError(s.q.Start(pt.ID))
+	t, err = s.q.Get(pt.ID)
+	s.Require().NoError(err)
+
+	ctx = &fasthttp.RequestCtx{}
+	s.server.writeProgress(ctx, t, logger)
+	s.Equal("5", string(ctx.Response.Header.Peek("Retry-After")))
+}
+
+func (s *AdminSuite) TestKeys() {
+	var created struct {
+		ID     int64  `json:"id"`
+		Token  string `json:"token"`
+		Scopes []string
+	}
+	s.Equal(http.StatusBadRequest, s.request(http.MethodPost, "/api/v1/admin/keys", "adm1n", `{"name": "x", "scopes": ["root"]}`, nil))
+	s.Equal(http.StatusCreated, s.request(http.MethodPost, "/api/v1/admin/keys", "adm1n", `{"name": "prometheus", "scopes": ["metrics"]}`, &created))
+	s.Require().NotEmpty(created.Token)
+
+	s.Equal(http.StatusUnauthorized, s.request(http.MethodGet, "/metrics", "", "", nil))
+	s.Equal(http.StatusOK, s.request(http.MethodGet, "/metrics", created.Token, "", nil))
+	s.Equal(http.StatusOK, s.request(http.MethodGet, "/metrics?api_key="+created.Token, "", "", nil))
+	s.Equal(http.StatusForbidden, s.request(http.MethodGet, "/api/v1/admin/tasks", created.Token, "", nil))
+
+	var keys []*auth.Key
+	s.Equal(http.StatusOK, s.request(http.MethodGet, "/api/v1/admin/keys", "adm1n", "", &keys))
+	s.Require().Len(keys, 1)
+	s.Equal("prometheus", keys[0].Name)
+
+	s.Equal(http.StatusNoContent, s.request(http.MethodDelete, fmt.Sprintf("/api/v1/admin/keys/%v", created.ID), "adm1n", "", nil))
+	s.Equal(http.StatusUnauthorized, s.request(http.MethodGet, "/metrics", created.Token, "", nil))
+	s.Equal(http.StatusNotFound, s.request(http.MethodDelete, fmt.Sprintf("/api/v1/admin/keys/%v", created.ID), "adm1n", "", nil))
+
+	var entries []*auth.AuditEntry
+	s.Equal(http.StatusOK, s.request(http.MethodGet, "/api/v1/admin/audit", "adm1n", "", &entries))
+	s.Require().Len(entries, 4)
+	s.Equal("bootstrap", entries[0].KeyName)
+	s.Equal(http.MethodDelete, entries[0].Action)
+	s.Equal(http.StatusNotFound, entries[0].StatusCode)
+	s.Equal(fmt.Sprintf("/api/v1/admin/keys/%v", created.ID), entries[0].Target)
+}
+
+func (s *AdminSuite) TestTasks() {
+	tasks := []*queue.Task{}
+	for range [3]int{} {
+		t, err := s.q.Add("lbry://"+db.RandomString(32), db.RandomString(96), formats.TypeHLS)
+		s.Require().NoError(err)
+		tasks = append(tasks, t)
+	}
+
+	var page TaskPage
+	s.Equal(http.StatusOK, s.request(http.MethodGet, "/api/v1/admin/tasks?limit=2&status=new", "adm1n", "", &page))
+	s.Equal(3, page.Total)
+	s.Require().Len(page.Tasks, 2)
+	s.Equal(tasks[2].ID, page.Tasks[0].ID)
+
+	var tv TaskView
+	s.Equal(http.StatusOK, s.request(http.MethodPost, fmt.Sprintf("/api/v1/admin/tasks/%v/priority", tasks[2].ID), "adm1n", `{"priority": 5}`, &tv))
+	s.Equal(5, tv.Priority)
+	s.Equal(http.StatusOK, s.request(http.MethodGet, fmt.Sprintf("/api/v1/admin/tasks/%v", tasks[2].ID), "adm1n", "", &tv))
+	s.Require().NotNil(tv.QueuePosition)
+	s.Equal(0, *tv.QueuePosition)
+
+	s.Equal(http.StatusOK, s.request(http.MethodPost, fmt.Sprintf("/api/v1/admin/tasks/%v/cancel", tasks[0].ID), "adm1n", "", &tv))
+	s.Equal(queue.StatusCanceled, tv.Status)
+	s.Equal(http.StatusConflict, s.request(http.MethodPost, fmt.Sprintf("/api/v1/admin/tasks/%v/cancel", tasks[0].ID), "adm1n", "", nil))
+	s.Equal(http.StatusOK, s.request(http.MethodPost, fmt.Sprintf("/api/v1/admin/tasks/%v/requeue", tasks[0].ID), "adm1n", "", &tv))
+	s.Equal(queue.StatusNew, tv.Status)
+	s.Equal(http.StatusOK, s.request(http.MethodPost, fmt.Sprintf("/api/v1/admin/tasks/%v/reject", tasks[1].ID), "adm1n", "", &tv))
+	s.Equal(queue.StatusRejected, tv.Status)
+	s.Equal(http.StatusConflict, s.request(http.MethodPost, fmt.Sprintf("/api/v1/admin/tasks/%v/reject", tasks[1].ID), "adm1n", "", nil))
+
+	s.Equal(http.StatusNotFound, s.request(http.MethodGet, "/api/v1/admin/tasks/1000", "adm1n", "", nil))
+	s.Equal(http.StatusNotFound, s.request(http.MethodPost, "/api/v1/admin/tasks/1000/requeue", "adm1n", "", nil))
+	s.Equal(http.StatusBadRequest, s.request(http.MethodPost, "/api/v1/admin/tasks", "adm1n", `{"urls": []}`, nil))
+}
+
+func (s *AdminSuite) TestVideos() {
+	channel := "@specialoperationstest#3"
+	v, err := s.lib.Add(video.AddParams{
+		URL: "lbry://" + db.RandomString(32), SDHash: db.RandomString(96), Type: formats.TypeHLS,
+		Path: db.RandomString(96), Channel: channel, Size: 1000, Checksum: "abc",
+	})
+	s.Require().NoError(err)
+
+	var page VideoPage
+	s.Equal(http.StatusOK, s.request(http.MethodGet, "/api/v1/admin/videos?channel="+url.QueryEscape(channel), "adm1n", "", &page))
+	s.Equal(1, page.Total)
+	s.Require().Len(page.Videos, 1)
+	s.Equal(v.SDHash, page.Videos[0].SDHash)
+	s.Equal(http.StatusOK, s.request(http.MethodGet, "/api/v1/admin/videos?location=remote", "adm1n", "", &page))
+	s.Equal(0, page.Total)
+	s.Equal(http.StatusBadRequest, s.request(http.MethodGet, "/api/v1/admin/videos?location=moon", "adm1n", "", nil))
+
+	var vv VideoView
+	s.Equal(http.StatusOK, s.request(http.MethodGet, "/api/v1/admin/videos/"+v.SDHash, "adm1n", "", &vv))
+	s.Equal(v.Path, vv.Path)
+	s.Equal("abc", vv.Checksum)
+	s.EqualValues(1000, vv.Size)
+
+	var totals LibraryTotals
+	s.Equal(http.StatusOK, s.request(http.MethodGet, "/api/v1/admin/library", "adm1n", "", &totals))
+	s.Equal(TotalView{Count: 1, Size: 1000}, totals.Tiers[video.TierLocal])
+	s.Equal(TotalView{Count: 1, Size: 1000}, totals.Channels[channel])
+
+	s.Equal(http.StatusConflict, s.request(http.MethodPost, "/api/v1/admin/videos/"+v.SDHash+"/furlough", "adm1n", "", nil))
+	s.Equal(http.StatusConflict, s.request(http.MethodPost, "/api/v1/admin/videos/"+v.SDHash+"/retire", "adm1n", "", nil))
+	s.Equal(http.StatusNoContent, s.request(http.MethodDelete, "/api/v1/admin/videos/"+v.SDHash, "adm1n", "", nil))
+	s.Equal(http.StatusNotFound, s.request(http.MethodGet, "/api/v1/admin/videos/"+v.SDHash, "adm1n", "", nil))
+}
+
+func (s *AdminSuite) TestAccessRules() {
+	defer video.LoadEnabledChannels(nil)
+
+	var rule AccessRuleView
+	s.Equal(http.StatusBadRequest, s.request(http.MethodPost, "/api/v1/admin/access", "adm1n", `{"kind": "channel", "policy": "maybe", "subject": "@x#1"}`, nil))
+	s.Equal(http.StatusOK, s.request(http.MethodPost, "/api/v1/admin/access", "adm1n", `{"kind": "channel", "policy": "block", "subject": "lbry://@Spam#1", "reason": "spam"}`, &rule))
+	s.Equal("@spam#1", rule.Subject)
+
+	var rules []*AccessRuleView
+	s.Equal(http.StatusOK, s.request(http.MethodGet, "/api/v1/admin/access?kind=channel", "adm1n", "", &rules))
+	s.Require().Len(rules, 1)
+	s.Equal("spam", rules[0].Reason)
+
+	s.Equal(http.StatusNoContent, s.request(http.MethodDelete, "/api/v1/admin/access/channel/"+url.PathEscape("@spam#1"), "adm1n", "", nil))
+	s.Equal(http.StatusNotFound, s.request(http.MethodDelete, "/api/v1/admin/access/channel/"+url.PathEscape("@spam#1"), "adm1n", "", nil))
+}
+
+func (s *AdminSuite) TestBlockedStreams() {
+	defer video.LoadEnabledChannels(nil)
+
+	v, err := s.lib.Add(video.AddParams{
+		URL: "lbry://" + db.RandomString(32), SDHash: db.RandomString(96), Type: formats.TypeHLS,
+		Path: db.RandomString(96), Channel: "@streams#1", ClaimID: "abcd",
+	})
+	s.Require().NoError(err)
+	s.Require().NoError(s.lib.UpdateRemotePath(v.SDHash, "https://storage/"+v.SDHash+"/"+storage.MasterPlaylistName))
+	local := path.Join(httpVideoPath, v.SDHash, storage.MasterPlaylistName)
+	remote := path.Join(httpRemotePath, v.SDHash, "s0_000000.ts")
+
+	s.Equal(http.StatusNotFound, s.request(http.MethodGet, local, "", "", nil))
+	s.Equal(http.StatusFound, s.request(http.MethodGet, remote, "", "", nil))
+
+	_, err = s.lib.SetAccessRule(video.AccessRule{Kind: video.RuleClaim, Subject: "abcd", Policy: video.PolicyBlock})
+	s.Require().NoError(err)
+	s.Equal(http.StatusForbidden, s.request(http.MethodGet, local, "", "", nil))
+	s.Equal(http.StatusForbidden, s.request(http.MethodGet, remote, "", "", nil))
+
+	_, err = s.lib.SetAccessRule(video.AccessRule{Kind: video.RuleClaim, Subject: "abcd", Policy: video.PolicyAllow})
+	s.Require().NoError(err)
+	_, err = s.lib.SetAccessRule(video.AccessRule{Kind: video.RuleChannel, Subject: "@streams#1", Policy: video.PolicyBlock})
+	s.Require().NoError(err)
+	s.Equal(http.StatusFound, s.request(http.MethodGet, remote, "", "", nil))
+	s.Require().NoError(s.lib.DeleteAccessRule(video.RuleClaim, "abcd"))
+	s.Equal(http.StatusForbidden, s.request(http.MethodGet, local, "", "", nil))
+	s.Equal(http.StatusForbidden, s.request(http.MethodGet, remote, "", "", nil))
+}
+
+func (s *AdminSuite) TestChannels() {
+	var cs ChannelSettingsView
+	s.Equal(http.StatusBadRequest, s.request(http.MethodPost, "/api/v1/admin/channels", "adm1n", `{"channel": "@x#1", "profile": "vp9"}`, nil))
+	s.Equal(http.StatusOK, s.request(http.MethodPost, "/api/v1/admin/channels", "adm1n", `{"channel": "lbry://@Small#1", "max_height": 720, "monthly_minutes": 600}`, &cs))
+	s.Equal("@small#1", cs.Channel)
+	s.Equal(720, cs.MaxHeight)
+
+	var list []*ChannelSettingsView
+	s.Equal(http.StatusOK, s.request(http.MethodGet, "/api/v1/admin/channels", "adm1n", "", &list))
+	s.Require().Len(list, 1)
+	s.Require().NotNil(list[0].Usage)
+	s.Zero(list[0].Usage.Minutes)
+
+	s.Equal(http.StatusOK, s.request(http.MethodGet, "/api/v1/admin/channels/"+url.PathEscape("@small#1"), "adm1n", "", &cs))
+	s.Equal(600, cs.MonthlyMinutes)
+
+	s.Equal(http.StatusNoContent, s.request(http.MethodDelete, "/api/v1/admin/channels/"+url.PathEscape("@small#1"), "adm1n", "", nil))
+	s.Equal(http.StatusNotFound, s.request(http.MethodDelete, "/api/v1/admin/channels/"+url.PathEscape("@small#1"), "adm1n", "", nil))
+}
+
+func (s *AdminSuite) TestIngest() {
+	var r enqueueResult
+	s.Equal(http.StatusBadRequest, s.request(http.MethodPost, "/api/v1/admin/ingest", "adm1n", `{"url": "lbry://video"}`, nil))
+	s.Equal(http.StatusBadRequest, s.request(http.MethodPost, "/api/v1/admin/ingest", "adm1n", `{"url": "https://cdn.example.com/trailer.mp4"}`, nil))
+	s.Equal(http.StatusBadRequest, s.request(http.MethodPost, "/api/v1/admin/ingest", "adm1n", `{"url": "https://cdn.example.com/trailer.mp4", "id": "../x"}`, nil))
+	s.Equal(http.StatusForbidden, s.request(http.MethodPost, "/api/v1/admin/ingest", "adm1n", `{"url": "file:///etc/passwd"}`, nil))
+
+	s.Equal(http.StatusAccepted, s.request(http.MethodPost, "/api/v1/admin/ingest", "adm1n",
+		`{"url": "https://cdn.example.com/trailer.mp4", "id": "trailer-1", "priority": 5}`, &r))
+	s.Equal(enqueueResultQueued, r.Result)
+	s.Require().NotNil(r.Task)
+	s.Equal("trailer-1", r.Task.SDHash)
+	s.Equal(5, r.Task.Priority)
+
+	local := path.Join(s.ingest.LocalRoot, "ad.mp4")
+	s.Require().NoError(ioutil.WriteFile(local, []byte("ad"), 0644))
+	s.Equal(http.StatusAccepted, s.request(http.MethodPost, "/api/v1/admin/ingest", "adm1n", fmt.Sprintf(`{"url": "file://%v"}`, local), &r))
+	s.Require().NotNil(r.Task)
+	// Identified by content hash.
+	s.Equal("70ba33708cbfb103f1a8e34afef333ba7dc021022b2d9aaa583aabb8058d8d67", r.Task.SDHash)
+
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	s.Require().NoError(mw.WriteField("id", "internal_1"))
+	fw, err := mw.CreateFormFile("file", "internal.mp4")
+	s.Require().NoError(err)
+	fw.Write([]byte("internal video"))
+	s.Require().NoError(mw.Close())
+	upload := func() int {
+		req := fasthttp.AcquireRequest()
+		defer fasthttp.ReleaseRequest(req)
+		res := fasthttp.AcquireResponse()
+		defer fasthttp.ReleaseResponse(res)
+		req.SetConnectionClose()
+		req.Header.SetMethod(http.MethodPost)
+		req.SetRequestURI("http://transcoder/api/v1/admin/ingest/upload")
+		req.Header.Set("Authorization", "Bearer adm1n")
+		req.Header.SetContentType(mw.FormDataContentType())
+		req.SetBody(body.Bytes())
+		s.Require().NoError(s.client.Do(req, res))
+		s.Require().NoError(json.Unmarshal(res.Body(), &r), string(res.Body()))
+		return res.StatusCode()
+	}
+	s.Equal(http.StatusAccepted, upload())
+	s.Equal("upload://internal_1", r.URL)
+	s.Require().NotNil(r.Task)
+	data, err := ioutil.ReadFile(s.ingest.UploadFile("internal_1"))
+	s.Require().NoError(err)
+	s.Equal("internal video", string(data))
+
+	// Already queued.
+	s.Equal(http.StatusAccepted, upload())
+	s.Equal(enqueueResultQueued, r.Result)
+	entries, err := ioutil.ReadDir(s.ingest.UploadPath)
+	s.Require().NoError(err)
+	s.Len(entries, 1)
+}
+
+func (s *AdminSuite) TestUploadBodyLimit() {
+	s.server.maxUploadSize = 2 * fasthttp.DefaultMaxRequestBodySize
+	large := strings.Repeat("x", fasthttp.DefaultMaxRequestBodySize+1)
+
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+	fw, err := mw.CreateFormFile("file", "large.mp4")
+	s.Require().NoError(err)
+	fw.Write([]byte(large))
+	s.Require().NoError(mw.Close())
+	upload := func() int {
+		req := fasthttp.AcquireRequest()
+		defer fasthttp.ReleaseRequest(req)
+		res := fasthttp.AcquireResponse()
+		defer fasthttp.ReleaseResponse(res)
+		req.SetConnectionClose()
+		req.Header.SetMethod(http.MethodPost)
+		req.SetRequestURI("http://transcoder/api/v1/admin/ingest/upload")
+		req.Header.Set("Authorization", "Bearer adm1n")
+		req.Header.SetContentType(mw.FormDataContentType())
+		req.SetBody(body.Bytes())
+		s.Require().NoError(s.client.Do(req, res))
+		return res.StatusCode()
+	}
+
+	// Oversized requests are rejected by their Content-Length, sending only headers keeps the server
+	// from closing the connection while the body is still being written.
+	oversized := func(uri, token string) int {
+		conn, err := s.client.Dial("transcoder")
+		s.Require().NoError(err)
+		defer conn.Close()
+		hdr := fasthttp.RequestHeader{}
+		hdr.SetMethod(http.MethodPost)
+		hdr.SetRequestURI(uri)
+		hdr.SetHost("transcoder")
+		if token != "" {
+			hdr.Set("Authorization", "Bearer "+token)
+		}
+		hdr.SetContentType(mw.FormDataContentType())
+		hdr.SetContentLength(body.Len())
+		_, err = hdr.WriteTo(conn)
+		s.Require().NoError(err)
+		res := fasthttp.AcquireResponse()
+		defer fasthttp.ReleaseResponse(res)
+		s.Require().NoError(res.Read(bufio.NewReader(conn)))
+		return res.StatusCode()
+	}
+
+	s.Equal(http.StatusRequestEntityTooLarge, oversized("/api/v1/admin/ingest/upload", ""))
+	s.Equal(http.StatusAccepted, upload())
+	s.Equal(http.StatusRequestEntityTooLarge, oversized("/api/v1/admin/ingest", "adm1n"))
+}
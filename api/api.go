@@ -3,14 +3,17 @@ package api
 import (
 	"database/sql"
 	"errors"
+	"time"
 
 	"github.com/lbryio/transcoder/pkg/claim"
+	"github.com/lbryio/transcoder/pkg/events"
 	"github.com/lbryio/transcoder/queue"
 	"github.com/lbryio/transcoder/video"
 )
 
 const (
 	videoPlaylistPath = "."
+	sqliteTimeLayout  = "2006-01-02 15:04:05"
 )
 
 type Queue interface {
@@ -25,6 +28,7 @@ type Library interface {
 
 type Video interface {
 	GetLocation() (location string, external bool)
+	GetStorageKey() string
 }
 
 type Task interface {
@@ -33,47 +37,168 @@ type Task interface {
 type VideoManager struct {
 	// queue   Queue
 	// library Library
-	queue   *queue.Queue
-	library *video.Library
+	queue    *queue.Queue
+	library  *video.Library
+	resolver claim.Resolver
 }
 
 func NewManager(q *queue.Queue, l *video.Library) *VideoManager {
 	m := &VideoManager{
-		queue:   q,
-		library: l,
+		queue:    q,
+		library:  l,
+		resolver: claim.DefaultResolver,
 	}
 	return m
 }
 
+// Resolver replaces resolver used for looking up requested claims.
+func (m *VideoManager) Resolver(r claim.Resolver) *VideoManager {
+	m.resolver = r
+	return m
+}
+
+// TranscodingProgress describes the state of a video waiting in the queue or being transcoded.
+type TranscodingProgress struct {
+	// Progress is encoding completion percentage.
+	Progress int `json:"progress"`
+	// Speed is encoding speed relative to playback rate.
+	Speed float64 `json:"speed"`
+	// Stage is the processing stage of a started task: downloading, ready or encoding.
+	Stage string `json:"stage,omitempty"`
+	// Started is the time when encoding has started, nil if the task is still waiting in the queue.
+	Started *time.Time `json:"started,omitempty"`
+	// QueuePosition is the number of tasks waiting to be processed ahead of this one.
+	QueuePosition int `json:"queue_position"`
+}
+
 // GetVideoOrCreateTask checks if video exists in the library or is waiting in the queue.
 // If neither, it validates and adds video for later processing.
 func (m *VideoManager) GetVideoOrCreateTask(uri, kind string) (Video, error) {
-	claim, err := claim.Resolve(uri)
+	v, _, err := m.GetVideoOrTask(uri, kind, "")
+	return v, err
+}
+
+// SignedRequest is a transcoding request signed by the owner of the stream's channel,
+// see claim.VerifyChannelSignature for signature format.
+type SignedRequest struct {
+	URL string `json:"url"`
+	// ClaimID is optional, if present it must match the claim `URL` resolves to.
+	ClaimID   string `json:"claim_id"`
+	Signature string `json:"signature"`
+	SigningTS string `json:"signing_ts"`
+}
+
+var ErrClaimMismatch = errors.New("claim_id does not match url")
+
+// isForbidden checks if error means that video is not allowed to be transcoded or served.
+func isForbidden(err error) bool {
+	switch err {
+	case video.ErrChannelNotEnabled, video.ErrNoSigningChannel, video.ErrChannelBlocked, video.ErrClaimBlocked,
+		video.ErrMinutesQuotaExceeded, video.ErrStorageQuotaExceeded:
+		return true
+	}
+	return false
+}
+
+// GetVideoOrTask does the same as GetVideoOrCreateTask but also returns the queued task
+// along with video.ErrTranscodingUnderway. Views of rejected videos are counted once per `client`, if it's set.
+func (m *VideoManager) GetVideoOrTask(uri, kind, client string) (Video, *queue.Task, error) {
+	c, err := m.resolver.Resolve(uri)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	return m.getVideoOrTask(c, uri, kind, client, video.ValidateByClaim)
+}
+
+// RequestSigned does the same as GetVideoOrTask for a request signed by the channel owner,
+// accepting it for processing regardless of enabled channels.
+func (m *VideoManager) RequestSigned(r SignedRequest, kind string) (Video, *queue.Task, error) {
+	c, err := m.resolver.Resolve(r.URL)
+	if err != nil {
+		return nil, nil, err
+	}
+	if r.ClaimID != "" && r.ClaimID != c.ClaimID {
+		return nil, nil, ErrClaimMismatch
 	}
-	v, err := m.library.Get(claim.SDHash)
+	return m.getVideoOrTask(c, r.URL, kind, "", func(c *claim.Claim) error {
+		return video.ValidateSignedRequest(c, r.Signature, r.SigningTS, time.Now())
+	})
+}
+
+// Ingest does the same as GetVideoOrTask for a source that is not a LBRY claim: a local file, HTTP(S) URL
+// or an upload, see claim.DirectFetcher. Transcoded video is stored under `id`.
+func (m *VideoManager) Ingest(uri, id, kind string) (Video, *queue.Task, error) {
+	c, err := claim.NewDirectClaim(uri, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	return m.getVideoOrTask(c, uri, kind, "", func(*claim.Claim) error { return nil })
+}
+
+func (m *VideoManager) getVideoOrTask(c *claim.Claim, uri, kind, client string, validate func(*claim.Claim) error) (Video, *queue.Task, error) {
+	if err := video.CheckBlocked(c); err != nil {
+		return nil, nil, err
+	}
+	v, err := m.library.Get(c.SDHash)
 	if v == nil || err == sql.ErrNoRows {
-		err := video.ValidateByClaim(claim)
+		err := validate(c)
 		if err != nil {
 			if errors.Is(err, video.ErrChannelNotEnabled) {
-				m.library.IncViews(claim.PermanentURL, claim.SDHash)
+				m.library.IncClientViews(c.PermanentURL, c.SDHash, client)
 			}
-			return nil, err
+			return nil, nil, err
 		}
 
-		t, err := m.queue.GetBySDHash(claim.SDHash)
+		t, err := m.queue.GetBySDHash(c.SDHash)
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
 		if t != nil {
-			return nil, video.ErrTranscodingUnderway
+			return nil, t, video.ErrTranscodingUnderway
+		}
+		t, err = m.queue.Add(uri, c.SDHash, kind)
+		if err != nil {
+			return nil, nil, err
+		}
+		if c.SigningChannel != nil {
+			if p := video.GetChannelSettings(c.SigningChannel.CanonicalURL).Priority; p != 0 {
+				if pt, err := m.queue.SetPriority(t.ID, p); err != nil {
+					logger.Errorw("setting channel priority failed", "task_id", t.ID, "err", err)
+				} else {
+					t = pt
+				}
+			}
+		}
+		m.library.Events().Publish(events.Event{Stage: events.StageQueued, SDHash: t.SDHash, URL: t.URL, TaskID: t.ID})
+		return nil, t, video.ErrTranscodingUnderway
+	}
+	if v.SDHash == c.SDHash && v.ClaimID == "" && c.ClaimID != "" {
+		// Videos added before claim IDs were recorded get them here so claim blocks apply to their stream files.
+		if err := m.library.SetClaimID(c.SDHash, c.ClaimID); err != nil {
+			logger.Errorw("setting claim id failed", "sd_hash", c.SDHash, "err", err)
 		}
-		_, err = m.queue.Add(uri, claim.SDHash, kind)
+	}
+	return *v, nil, nil
+}
+
+// GetProgress returns transcoding progress of a queued task.
+func (m *VideoManager) GetProgress(t *queue.Task) (*TranscodingProgress, error) {
+	p := &TranscodingProgress{
+		Progress: int(t.Progress.Float64),
+		Speed:    t.Speed.Float64,
+		Stage:    t.Stage.String,
+	}
+	if t.StartedAt.Valid {
+		started, err := time.Parse(sqliteTimeLayout, t.StartedAt.String)
 		if err != nil {
 			return nil, err
 		}
-		return nil, video.ErrTranscodingUnderway
+		p.Started = &started
+	}
+	pos, err := m.queue.Position(t)
+	if err != nil {
+		return nil, err
 	}
-	return *v, nil
+	p.QueuePosition = pos
+	return p, nil
 }
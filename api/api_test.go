@@ -1,28 +1,88 @@
 package api
 
 import (
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/lbryio/transcoder/db"
 	"github.com/lbryio/transcoder/formats"
+	"github.com/lbryio/transcoder/pkg/claim"
 	"github.com/lbryio/transcoder/queue"
 	"github.com/lbryio/transcoder/storage"
 	"github.com/lbryio/transcoder/video"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestGetVideoOrCreateTask(t *testing.T) {
 	vdb := db.OpenTestDB()
-	vdb.MigrateUp(video.InitialMigration)
+	vdb.Migrate(video.Migrations...)
 
 	qdb := db.OpenTestDB()
-	qdb.MigrateUp(queue.InitialMigration)
+	qdb.Migrate(queue.Migrations...)
 
 	lib := video.NewLibrary(video.Configure().LocalStorage(storage.Local("/tmp/test")).DB(vdb))
 	q := queue.NewQueue(qdb)
 
-	m := NewManager(q, lib)
+	resolver := claim.NewMemoryResolver()
+	m := NewManager(q, lib).Resolver(resolver)
 	_, err := m.GetVideoOrCreateTask("lbry://nonexistsaotsaotihasoihfa", formats.TypeHLS)
 	assert.EqualError(t, err, "could not resolve stream URI")
+
+	sdHash := strings.Repeat("ab", 48)
+	c, err := claim.NewFakeClaim("lbry://@enabled#1/video#a", "a1", sdHash, "lbry://@enabled#1")
+	require.NoError(t, err)
+	resolver.Add("lbry://video", c)
+
+	_, err = m.GetVideoOrCreateTask("lbry://video", formats.TypeHLS)
+	assert.Equal(t, video.ErrChannelNotEnabled, err)
+
+	video.LoadEnabledChannels([]string{"@enabled#1"})
+	defer video.LoadEnabledChannels(nil)
+	_, err = m.GetVideoOrCreateTask("lbry://video", formats.TypeHLS)
+	assert.Equal(t, video.ErrTranscodingUnderway, err)
+	task, err := q.GetBySDHash(sdHash)
+	require.NoError(t, err)
+	require.NotNil(t, task)
+	assert.Equal(t, "lbry://video", task.URL)
+}
+
+func TestGetProgress(t *testing.T) {
+	qdb := db.OpenTestDB()
+	require.NoError(t, qdb.Migrate(queue.Migrations...))
+	q := queue.NewQueue(qdb)
+	m := NewManager(q, nil)
+
+	t1, err := q.Add("lbry://"+db.RandomString(32), db.RandomString(96), formats.TypeHLS)
+	require.NoError(t, err)
+	t2, err := q.Add("lbry://"+db.RandomString(32), db.RandomString(96), formats.TypeHLS)
+	require.NoError(t, err)
+
+	p, err := m.GetProgress(t2)
+	require.NoError(t, err)
+	assert.Equal(t, &TranscodingProgress{QueuePosition: 1}, p)
+
+	pt, err := q.Poll()
+	require.NoError(t, err)
+	require.Equal(t, t1.ID, pt.ID)
+	require.NoError(t, q.Start(pt.ID))
+	require.NoError(t, q.UpdateProgress(pt.ID, 43.7, 1.25))
+	pt, err = q.Get(pt.ID)
+	require.NoError(t, err)
+
+	p, err = m.GetProgress(pt)
+	require.NoError(t, err)
+	assert.Equal(t, 43, p.Progress)
+	assert.Equal(t, 1.25, p.Speed)
+	assert.Equal(t, 0, p.QueuePosition)
+	require.NotNil(t, p.Started)
+	assert.WithinDuration(t, time.Now(), *p.Started, time.Minute)
+
+	t2, err = q.Get(t2.ID)
+	require.NoError(t, err)
+	p, err = m.GetProgress(t2)
+	require.NoError(t, err)
+	assert.Equal(t, 0, p.QueuePosition)
 }
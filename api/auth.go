@@ -0,0 +1,160 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/lbryio/transcoder/auth"
+
+	"github.com/valyala/fasthttp"
+)
+
+const (
+	apiKeyHeader     = "X-API-Key"
+	apiKeyQueryParam = "api_key"
+)
+
+var errScopeNotAllowed = errors.New("API key does not allow this action")
+
+type createKeyRequest struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+type createKeyResponse struct {
+	*auth.Key
+	Token string `json:"token"`
+}
+
+// requestToken extracts API key from `Authorization: Bearer`, `X-API-Key` header or `api_key` query parameter.
+func requestToken(ctx *fasthttp.RequestCtx) string {
+	return headerToken(&ctx.Request.Header)
+}
+
+// headerToken does the same as requestToken before the request body is read.
+func headerToken(h *fasthttp.RequestHeader) string {
+	if a := string(h.Peek("Authorization")); strings.HasPrefix(a, "Bearer ") {
+		return strings.TrimPrefix(a, "Bearer ")
+	}
+	if k := h.Peek(apiKeyHeader); len(k) > 0 {
+		return string(k)
+	}
+	u := fasthttp.AcquireURI()
+	defer fasthttp.ReleaseURI(u)
+	u.Parse(nil, h.RequestURI())
+	return string(u.QueryArgs().Peek(apiKeyQueryParam))
+}
+
+// allows checks if API key in request header `h` grants `scope`.
+func (h *APIServer) allows(hdr *fasthttp.RequestHeader, scope string) bool {
+	key := h.auth.Anonymous()
+	if token := headerToken(hdr); token != "" {
+		var err error
+		if key, err = h.auth.Authenticate(token); err != nil {
+			return false
+		}
+	}
+	return key.Allows(scope)
+}
+
+// authorize only lets through requests with an API key granting `scope`, or anonymous ones if `scope` is public.
+// Modifying requests to admin and enqueue endpoints are written to the audit log.
+func (h *APIServer) authorize(scope string, next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		key := h.auth.Anonymous()
+		token := requestToken(ctx)
+		if token != "" {
+			var err error
+			key, err = h.auth.Authenticate(token)
+			if err == auth.ErrInvalidToken {
+				writeError(ctx, http.StatusUnauthorized, err)
+				return
+			} else if err != nil {
+				logger.Errorw("API key authentication failed", "err", err)
+				writeError(ctx, http.StatusInternalServerError, err)
+				return
+			}
+		}
+		if !key.Allows(scope) {
+			if token == "" {
+				ctx.SetStatusCode(http.StatusUnauthorized)
+				return
+			}
+			writeError(ctx, http.StatusForbidden, errScopeNotAllowed)
+			return
+		}
+
+		next(ctx)
+
+		if (scope == auth.ScopeAdmin || scope == auth.ScopeEnqueue) && !ctx.IsGet() && !ctx.IsHead() {
+			h.auth.Audit(auth.AuditEntry{
+				KeyID:      key.ID,
+				KeyName:    key.Name,
+				Action:     string(ctx.Method()),
+				Target:     string(ctx.Path()),
+				StatusCode: ctx.Response.StatusCode(),
+				RemoteAddr: ctx.RemoteIP().String(),
+			})
+		}
+	}
+}
+
+func (h *APIServer) handleListKeys(ctx *fasthttp.RequestCtx) {
+	keys, err := h.auth.ListKeys()
+	if err != nil {
+		writeError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(ctx, http.StatusOK, keys)
+}
+
+func (h *APIServer) handleCreateKey(ctx *fasthttp.RequestCtx) {
+	var req createKeyRequest
+	if err := json.Unmarshal(ctx.PostBody(), &req); err != nil {
+		writeError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	k, token, err := h.auth.CreateKey(req.Name, req.Scopes)
+	if errors.Is(err, auth.ErrInvalidScope) || err == auth.ErrEmptyKeyName || err == auth.ErrNoScopesGiven {
+		writeError(ctx, http.StatusBadRequest, err)
+		return
+	} else if err == auth.ErrNoKeyStorage {
+		writeError(ctx, http.StatusConflict, err)
+		return
+	} else if err != nil {
+		writeError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(ctx, http.StatusCreated, createKeyResponse{Key: k, Token: token})
+}
+
+func (h *APIServer) handleRevokeKey(ctx *fasthttp.RequestCtx) {
+	id, err := strconv.ParseInt(ctx.UserValue("id").(string), 10, 64)
+	if err != nil {
+		writeError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	err = h.auth.RevokeKey(id)
+	if err == auth.ErrKeyNotFound {
+		writeError(ctx, http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		writeError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	ctx.SetStatusCode(http.StatusNoContent)
+}
+
+func (h *APIServer) handleAuditLog(ctx *fasthttp.RequestCtx) {
+	limit, _ := pagination(ctx)
+	keyID, _ := strconv.ParseInt(string(ctx.QueryArgs().Peek("key_id")), 10, 64)
+	entries, err := h.auth.AuditLog(keyID, limit)
+	if err != nil {
+		writeError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(ctx, http.StatusOK, entries)
+}
@@ -0,0 +1,125 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+
+	"github.com/lbryio/transcoder/video"
+
+	"github.com/valyala/fasthttp"
+)
+
+// ChannelSettingsView is a JSON representation of channel settings and quotas.
+type ChannelSettingsView struct {
+	Channel        string `json:"channel"`
+	Profile        string `json:"profile,omitempty"`
+	MaxHeight      int    `json:"max_height,omitempty"`
+	KeepTier       string `json:"keep_tier,omitempty"`
+	Priority       int    `json:"priority,omitempty"`
+	MonthlyMinutes int    `json:"monthly_minutes,omitempty"`
+	MaxStoredBytes int64  `json:"max_stored_bytes,omitempty"`
+	UpdatedAt      string `json:"updated_at,omitempty"`
+
+	Usage *ChannelUsageView `json:"usage,omitempty"`
+}
+
+// ChannelUsageView is what a channel has consumed of its quotas.
+type ChannelUsageView struct {
+	Month       string  `json:"month"`
+	Minutes     float64 `json:"minutes"`
+	StoredBytes int64   `json:"stored_bytes"`
+}
+
+func newChannelSettingsView(cs *video.ChannelSettings, u *video.ChannelUsage) *ChannelSettingsView {
+	v := &ChannelSettingsView{
+		Channel:        cs.Channel,
+		Profile:        cs.Profile,
+		MaxHeight:      cs.MaxHeight,
+		KeepTier:       cs.KeepTier,
+		Priority:       cs.Priority,
+		MonthlyMinutes: cs.MonthlyMinutes,
+		MaxStoredBytes: cs.MaxStoredBytes,
+		UpdatedAt:      cs.UpdatedAt,
+	}
+	if u != nil {
+		v.Usage = &ChannelUsageView{Month: u.Month, Minutes: u.Minutes, StoredBytes: u.StoredBytes}
+	}
+	return v
+}
+
+func (h *APIServer) handleListChannels(ctx *fasthttp.RequestCtx) {
+	lib := h.videoManager.library
+	settings, err := lib.ChannelSettings()
+	if err != nil {
+		writeError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	views := []*ChannelSettingsView{}
+	for _, cs := range settings {
+		u, err := lib.ChannelUsage(cs.Channel)
+		if err != nil {
+			writeError(ctx, http.StatusInternalServerError, err)
+			return
+		}
+		views = append(views, newChannelSettingsView(cs, u))
+	}
+	writeJSON(ctx, http.StatusOK, views)
+}
+
+func (h *APIServer) handleGetChannel(ctx *fasthttp.RequestCtx) {
+	channel, err := url.PathUnescape(ctx.UserValue("channel").(string))
+	if err != nil {
+		writeError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	u, err := h.videoManager.library.ChannelUsage(channel)
+	if err != nil {
+		writeError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	cs := video.GetChannelSettings(channel)
+	writeJSON(ctx, http.StatusOK, newChannelSettingsView(&cs, u))
+}
+
+func (h *APIServer) handleSetChannel(ctx *fasthttp.RequestCtx) {
+	var req ChannelSettingsView
+	if err := json.Unmarshal(ctx.PostBody(), &req); err != nil {
+		writeError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	cs, err := h.videoManager.library.SetChannelSettings(video.ChannelSettings{
+		Channel:        req.Channel,
+		Profile:        req.Profile,
+		MaxHeight:      req.MaxHeight,
+		KeepTier:       req.KeepTier,
+		Priority:       req.Priority,
+		MonthlyMinutes: req.MonthlyMinutes,
+		MaxStoredBytes: req.MaxStoredBytes,
+	})
+	if err == video.ErrInvalidChannelSettings {
+		writeError(ctx, http.StatusBadRequest, err)
+		return
+	} else if err != nil {
+		writeError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(ctx, http.StatusOK, newChannelSettingsView(cs, nil))
+}
+
+func (h *APIServer) handleDeleteChannel(ctx *fasthttp.RequestCtx) {
+	channel, err := url.PathUnescape(ctx.UserValue("channel").(string))
+	if err != nil {
+		writeError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	err = h.videoManager.library.DeleteChannelSettings(channel)
+	if err == video.ErrChannelSettingsMissing {
+		writeError(ctx, http.StatusNotFound, err)
+		return
+	} else if err != nil {
+		writeError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	ctx.SetStatusCode(http.StatusNoContent)
+}
@@ -1,25 +1,53 @@
 package api
 
 import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
 	"path"
+	"strings"
 	"time"
 
+	"github.com/lbryio/transcoder/auth"
 	"github.com/lbryio/transcoder/internal/metrics"
 	"github.com/lbryio/transcoder/pkg/claim"
 	"github.com/lbryio/transcoder/pkg/timer"
+	"github.com/lbryio/transcoder/queue"
+	"github.com/lbryio/transcoder/storage"
 	"github.com/lbryio/transcoder/video"
+	"github.com/lbryio/transcoder/webhooks"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
 	"github.com/fasthttp/router"
 	"github.com/valyala/fasthttp"
 	"github.com/valyala/fasthttp/fasthttpadaptor"
+	"go.uber.org/zap"
 )
 
-var httpVideoPath = "/streams"
+var (
+	httpVideoPath  = "/streams"
+	httpRemotePath = "/remote"
+
+	ingestUploadPath = "/api/v1/admin/ingest/upload"
+
+	eventsKeepAliveInterval = 15 * time.Second
+
+	// keyTokenTTL is for how long encryption key URIs in served playlists remain valid.
+	keyTokenTTL = 6 * time.Hour
+
+	// Retry-After hints for clients polling streams being transcoded or waiting in the queue.
+	encodingRetryAfter = 5 * time.Second
+	queuedRetryAfter   = 15 * time.Second
+)
 
 // APIServer ties HTTP API together and allows to start/shutdown the web server.
 type APIServer struct {
@@ -27,13 +55,25 @@ type APIServer struct {
 	httpServer *fasthttp.Server
 	stopChan   chan os.Signal
 	stopWait   time.Duration
+	stopEvents chan struct{}
+
+	ipLimiter    *rateLimiter
+	claimLimiter *rateLimiter
 }
 
 type Configuration struct {
-	debug        bool
-	videoPath    string
-	addr         string
-	videoManager *VideoManager
+	debug          bool
+	videoPath      string
+	addr           string
+	videoManager   *VideoManager
+	keyTokenSecret string
+	webhooks       *webhooks.Manager
+	auth           *auth.Manager
+	ipLimit        RateLimit
+	claimLimit     RateLimit
+	trustedHops    int
+	ingest         *claim.DirectFetcher
+	maxUploadSize  int
 }
 
 func Configure() *Configuration {
@@ -63,6 +103,49 @@ func (c *Configuration) VideoManager(videoManager *VideoManager) *Configuration
 	return c
 }
 
+// KeyTokenSecret sets a secret for verifying tokens presented to the encryption key-delivery endpoint.
+func (c *Configuration) KeyTokenSecret(secret string) *Configuration {
+	c.keyTokenSecret = secret
+	return c
+}
+
+// Auth sets API key manager used for authorizing requests. Without it, playback and metrics
+// endpoints are public and the rest of the API is not accessible.
+func (c *Configuration) Auth(m *auth.Manager) *Configuration {
+	c.auth = m
+	return c
+}
+
+// Webhooks enables webhook management endpoints and accepting callback URLs along with transcoding requests.
+func (c *Configuration) Webhooks(m *webhooks.Manager) *Configuration {
+	c.webhooks = m
+	return c
+}
+
+// RateLimit limits playback requests per client IP and per requested claim URL.
+// Requests over the limit are rejected with 429 status.
+func (c *Configuration) RateLimit(perIP, perClaim RateLimit) *Configuration {
+	c.ipLimit = perIP
+	c.claimLimit = perClaim
+	return c
+}
+
+// TrustForwardedFor makes client IP be taken from `X-Forwarded-For` header, use it when running behind
+// `hops` reverse proxies. Each of them appends an entry to the header, so the client IP is the `hops`-th one
+// from the right, entries to the left of it are supplied by the client and can't be trusted.
+func (c *Configuration) TrustForwardedFor(hops int) *Configuration {
+	c.trustedHops = hops
+	return c
+}
+
+// Ingest enables endpoints for transcoding local files under `f.LocalRoot`, HTTP(S) URLs and uploads,
+// which are saved to `f.UploadPath` and can be up to `maxUploadSize` bytes.
+func (c *Configuration) Ingest(f claim.DirectFetcher, maxUploadSize int) *Configuration {
+	c.ingest = &f
+	c.maxUploadSize = maxUploadSize
+	return c
+}
+
 func (h *APIServer) handleVideo(ctx *fasthttp.RequestCtx) {
 	urlQ := ctx.UserValue("url").(string)
 	kind := ctx.UserValue("kind").(string)
@@ -81,15 +164,16 @@ func (h *APIServer) handleVideo(ctx *fasthttp.RequestCtx) {
 		"path", path,
 	)
 
-	v, err := h.videoManager.GetVideoOrCreateTask(url, kind)
+	touch := ctx.QueryArgs().Has("touch") && string(ctx.QueryArgs().Peek("touch")) != "false"
 
-	if err == video.ErrChannelNotEnabled || err == video.ErrNoSigningChannel {
+	v, t, err := h.videoManager.GetVideoOrTask(url, kind, h.clientIP(ctx))
+
+	if isForbidden(err) {
 		ctx.SetStatusCode(http.StatusForbidden)
 		ll.Debug("transcoding disabled")
 		return
 	} else if err == video.ErrTranscodingUnderway {
-		ctx.SetStatusCode(http.StatusAccepted)
-		ll.Debug("trancoding pending")
+		h.writeProgress(ctx, t, ll)
 		return
 	} else if err == claim.ErrStreamNotFound {
 		ctx.SetStatusCode(http.StatusNotFound)
@@ -102,18 +186,312 @@ func (h *APIServer) handleVideo(ctx *fasthttp.RequestCtx) {
 		return
 	}
 
-	ctx.Response.StatusCode()
+	if touch {
+		ctx.SetStatusCode(http.StatusOK)
+		return
+	}
+
 	location, remote := v.GetLocation()
 	if !remote {
 		metrics.StreamsRequestedCount.WithLabelValues(metrics.StorageLocal).Inc()
 		location = fmt.Sprintf("%v/%v", httpVideoPath, location)
 	} else {
 		metrics.StreamsRequestedCount.WithLabelValues(metrics.StorageRemote).Inc()
+		// Private bucket objects are not reachable directly and key URIs in playlists of encrypted streams
+		// need to be signed, so such playlists go through the proxy.
+		if h.videoManager.library.SigningRemote() != nil || h.isEncrypted(v.GetStorageKey()) {
+			location = fmt.Sprintf("%v/%v/%v", httpRemotePath, v.GetStorageKey(), storage.MasterPlaylistName)
+		}
 	}
 	ll.Infow("stream found", "location", location)
 	ctx.Redirect(location, http.StatusSeeOther)
 }
 
+// handleSignedRequest accepts transcoding requests signed by channel owners, which are processed
+// even if the channel is not enabled.
+func (h *APIServer) handleSignedRequest(ctx *fasthttp.RequestCtx) {
+	kind := ctx.UserValue("kind").(string)
+	var r SignedRequest
+	if err := json.Unmarshal(ctx.PostBody(), &r); err != nil {
+		writeError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	if r.URL == "" || r.Signature == "" || r.SigningTS == "" {
+		writeError(ctx, http.StatusBadRequest, errors.New("url, signature and signing_ts are required"))
+		return
+	}
+	ll := logger.Named("http").With("url", r.URL, "signed", true)
+
+	_, t, err := h.videoManager.RequestSigned(r, kind)
+	switch err {
+	case nil:
+		ctx.SetStatusCode(http.StatusOK)
+	case video.ErrTranscodingUnderway:
+		h.writeProgress(ctx, t, ll)
+	case video.ErrInvalidSignature, video.ErrSignatureExpired, video.ErrNoSigningChannel, video.ErrChannelBlocked, video.ErrClaimBlocked,
+		video.ErrMinutesQuotaExceeded, video.ErrStorageQuotaExceeded:
+		ll.Debugw("signed request rejected", "err", err)
+		writeError(ctx, http.StatusForbidden, err)
+	case ErrClaimMismatch:
+		writeError(ctx, http.StatusBadRequest, err)
+	case claim.ErrStreamNotFound:
+		writeError(ctx, http.StatusNotFound, err)
+	default:
+		ll.Errorw("internal error", "error", err)
+		writeError(ctx, http.StatusInternalServerError, err)
+	}
+}
+
+// writeProgress responds with transcoding progress of queued task `t`.
+func (h *APIServer) writeProgress(ctx *fasthttp.RequestCtx, t *queue.Task, ll *zap.SugaredLogger) {
+	ctx.SetStatusCode(http.StatusAccepted)
+	ll.Debug("trancoding pending")
+	if t == nil {
+		return
+	}
+	p, err := h.videoManager.GetProgress(t)
+	if err != nil {
+		ll.Errorw("progress retrieval failed", "error", err)
+		return
+	}
+	retryAfter := queuedRetryAfter
+	if p.Started != nil {
+		retryAfter = encodingRetryAfter
+	}
+	ctx.Response.Header.Set("Retry-After", fmt.Sprintf("%.0f", retryAfter.Seconds()))
+	ctx.SetContentType("application/json")
+	if err := json.NewEncoder(ctx).Encode(p); err != nil {
+		ll.Errorw("progress serialization failed", "error", err)
+	}
+}
+
+// handleRemoteFragment serves playlists of streams stored in a private bucket or encrypted, rewriting segment URIs
+// in them to presigned or public URLs and signing key URIs. Requests for other stream files are redirected.
+func (h *APIServer) handleRemoteFragment(ctx *fasthttp.RequestCtx) {
+	sdHash := ctx.UserValue("sdHash").(string)
+	name := ctx.UserValue("name").(string)
+	ll := logger.Named("http").With("sd_hash", sdHash, "name", name)
+	if !storage.IsFragmentName(name) {
+		ctx.SetStatusCode(http.StatusNotFound)
+		return
+	}
+
+	lib := h.videoManager.library
+	v, err := lib.Lookup(sdHash)
+	if err == sql.ErrNoRows || (err == nil && v.RemotePath == "") {
+		ctx.SetStatusCode(http.StatusNotFound)
+		return
+	} else if err != nil {
+		ctx.SetStatusCode(http.StatusInternalServerError)
+		ll.Errorw("video lookup failed", "error", err)
+		return
+	}
+	if err := video.CheckVideoBlocked(v); err != nil {
+		writeError(ctx, http.StatusForbidden, err)
+		return
+	}
+
+	signer := lib.SigningRemote()
+	fragmentURL := func(name string) (string, error) {
+		if signer != nil {
+			return signer.SignFragmentURL(sdHash, name)
+		}
+		return strings.TrimSuffix(v.RemotePath, storage.MasterPlaylistName) + name, nil
+	}
+
+	if path.Ext(name) != storage.PlaylistExt {
+		url, err := fragmentURL(name)
+		if err != nil {
+			ctx.SetStatusCode(http.StatusInternalServerError)
+			ll.Errorw("signing failed", "error", err)
+			return
+		}
+		ctx.Redirect(url, http.StatusFound)
+		return
+	}
+
+	f, err := lib.RemoteFragment(sdHash, name)
+	if err != nil {
+		ctx.SetStatusCode(http.StatusNotFound)
+		ll.Debugw("playlist not found", "error", err)
+		return
+	}
+	defer f.Close()
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		ctx.SetStatusCode(http.StatusInternalServerError)
+		ll.Errorw("playlist read failed", "error", err)
+		return
+	}
+
+	data, err = storage.RewritePlaylist(data, func(uri string) (string, error) {
+		// Child playlists are left relative so they get proxied here as well, keys are signed below.
+		if path.Ext(uri) == storage.PlaylistExt || storage.IsAbsoluteURI(uri) {
+			return uri, nil
+		}
+		return fragmentURL(uri)
+	})
+	if err == nil {
+		data, err = h.signKeyURIs(ctx, data)
+	}
+	if err != nil {
+		ctx.SetStatusCode(http.StatusInternalServerError)
+		ll.Errorw("playlist rewriting failed", "error", err)
+		return
+	}
+
+	ctx.SetContentType(storage.PlaylistContentType)
+	// Signed URLs inside expire so the playlist should not be cached.
+	ctx.Response.Header.Set("Cache-Control", "no-cache")
+	ctx.SetBody(data)
+}
+
+// localFragmentHandler serves files of locally stored streams with `serve`, signing key URIs in playlists
+// of encrypted streams. Files of streams whose claim or channel is blocked are not served.
+func (h *APIServer) localFragmentHandler(serve fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		name, _ := ctx.UserValue("filepath").(string)
+		if video.HasBlocks() {
+			sdHash := strings.SplitN(strings.TrimPrefix(path.Clean("/"+name), "/"), "/", 2)[0]
+			v, err := h.videoManager.library.Lookup(sdHash)
+			if err != nil && err != sql.ErrNoRows {
+				ctx.SetStatusCode(http.StatusInternalServerError)
+				logger.Named("http").Errorw("video lookup failed", "name", name, "error", err)
+				return
+			}
+			if v != nil {
+				if err := video.CheckVideoBlocked(v); err != nil {
+					writeError(ctx, http.StatusForbidden, err)
+					return
+				}
+			}
+		}
+		if !h.videoManager.library.EncryptionEnabled() || path.Ext(name) != storage.PlaylistExt {
+			serve(ctx)
+			return
+		}
+		data, err := ioutil.ReadFile(path.Join(h.videoPath, path.Clean("/"+name)))
+		if err != nil || !bytes.Contains(data, []byte("#EXT-X-KEY:")) {
+			serve(ctx)
+			return
+		}
+		data, err = h.signKeyURIs(ctx, data)
+		if err != nil {
+			ctx.SetStatusCode(http.StatusInternalServerError)
+			logger.Named("http").Errorw("playlist rewriting failed", "name", name, "error", err)
+			return
+		}
+		ctx.SetContentType(storage.PlaylistContentType)
+		// Key tokens inside expire so the playlist should not be cached.
+		ctx.Response.Header.Set("Cache-Control", "no-cache")
+		ctx.SetBody(data)
+	}
+}
+
+// signKeyURIs adds access tokens valid for keyTokenTTL to encryption key URIs in a playlist.
+// Tokens are signed for the last element of key URI path, which is the stream key is requested for.
+// Tokens are only issued to requests allowed the decrypt scope, others get key URIs which keys can't be retrieved with.
+func (h *APIServer) signKeyURIs(ctx *fasthttp.RequestCtx, data []byte) ([]byte, error) {
+	if !h.allows(&ctx.Request.Header, auth.ScopeDecrypt) {
+		return data, nil
+	}
+	return storage.RewriteKeyURIs(data, func(uri string) (string, error) {
+		u, err := url.Parse(uri)
+		if err != nil {
+			return "", err
+		}
+		q := u.Query()
+		q.Set("token", SignKeyToken(h.keyTokenSecret, path.Base(u.Path), time.Now().Add(keyTokenTTL)))
+		u.RawQuery = q.Encode()
+		return u.String(), nil
+	})
+}
+
+// isEncrypted checks if video stream stored under `storageKey` has an encryption key.
+func (h *APIServer) isEncrypted(storageKey string) bool {
+	lib := h.videoManager.library
+	if !lib.EncryptionEnabled() {
+		return false
+	}
+	_, err := lib.GetKey(storageKey)
+	if err != nil && err != sql.ErrNoRows {
+		logger.Errorw("key lookup failed", "sd_hash", storageKey, "error", err)
+	}
+	return err == nil
+}
+
+// handleHealth reports that the server is up, for clients balancing requests across transcoder nodes.
+func (h *APIServer) handleHealth(ctx *fasthttp.RequestCtx) {
+	writeJSON(ctx, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// handleKey delivers stream encryption keys to players presenting a valid token.
+func (h *APIServer) handleKey(ctx *fasthttp.RequestCtx) {
+	sdHash := ctx.UserValue("sdHash").(string)
+	ll := logger.Named("http").With("sd_hash", sdHash)
+
+	token := string(ctx.QueryArgs().Peek("token"))
+	if err := VerifyKeyToken(h.keyTokenSecret, sdHash, token); err != nil {
+		ctx.SetStatusCode(http.StatusForbidden)
+		ll.Debugw("key access denied", "error", err)
+		return
+	}
+
+	key, err := h.videoManager.library.GetKey(sdHash)
+	if err == sql.ErrNoRows {
+		ctx.SetStatusCode(http.StatusNotFound)
+		return
+	} else if err != nil {
+		ctx.SetStatusCode(http.StatusInternalServerError)
+		ll.Errorw("key retrieval failed", "error", err)
+		return
+	}
+
+	ctx.SetContentType("application/octet-stream")
+	ctx.Response.Header.Set("Cache-Control", "private, no-store")
+	ctx.SetBody(key)
+}
+
+// handleEvents streams video processing events as server-sent events,
+// either for a single stream if `sdHash` is given or for all of them, which is only available to admins.
+func (h *APIServer) handleEvents(ctx *fasthttp.RequestCtx) {
+	sdHash, _ := ctx.UserValue("sdHash").(string)
+	sub := h.videoManager.library.Events().Subscribe(sdHash)
+
+	ctx.SetContentType("text/event-stream")
+	ctx.Response.Header.Set("Cache-Control", "no-cache")
+	ctx.Response.Header.Set("X-Accel-Buffering", "no")
+	ctx.SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer sub.Close()
+		keepAlive := time.NewTicker(eventsKeepAliveInterval)
+		defer keepAlive.Stop()
+
+		fmt.Fprint(w, ": subscribed\n\n")
+		for {
+			// Flush failure means the client has gone away.
+			if err := w.Flush(); err != nil {
+				return
+			}
+			select {
+			case e, ok := <-sub.C:
+				if !ok {
+					return
+				}
+				data, err := json.Marshal(e)
+				if err != nil {
+					logger.Errorw("event serialization failed", "error", err)
+					continue
+				}
+				fmt.Fprintf(w, "event: %v\ndata: %s\n\n", e.Stage, data)
+			case <-keepAlive.C:
+				fmt.Fprint(w, ": keep-alive\n\n")
+			case <-h.stopEvents:
+				return
+			}
+		}
+	})
+}
+
 func handlePanic(ctx *fasthttp.RequestCtx, p interface{}) {
 	ctx.SetStatusCode(http.StatusInternalServerError)
 	logger.Errorw("panicked", "url", ctx.Request.URI(), "panic", p)
@@ -126,6 +504,77 @@ func corsMiddleware(h fasthttp.RequestHandler) fasthttp.RequestHandler {
 	}
 }
 
+// rateLimitMiddleware rejects requests exceeding per-IP limit or per-claim limit for requests with `url` path parameter.
+func (h *APIServer) rateLimitMiddleware(next fasthttp.RequestHandler) fasthttp.RequestHandler {
+	return func(ctx *fasthttp.RequestCtx) {
+		now := time.Now()
+		if !h.ipLimiter.allow(h.clientIP(ctx), now) {
+			writeRateLimited(ctx, LimitIP, h.ipLimit)
+			return
+		}
+		if u, ok := ctx.UserValue("url").(string); ok {
+			if uu, err := url.PathUnescape(u); err == nil {
+				u = uu
+			}
+			if !h.claimLimiter.allow(strings.TrimPrefix(strings.ToLower(u), "lbry://"), now) {
+				writeRateLimited(ctx, LimitClaim, h.claimLimit)
+				return
+			}
+		}
+		next(ctx)
+	}
+}
+
+func writeRateLimited(ctx *fasthttp.RequestCtx, limit string, l RateLimit) {
+	metrics.HTTPRateLimited.WithLabelValues(limit).Inc()
+	logger.Debugw("rate limited", "limit", limit, "ip", ctx.RemoteIP().String(), "path", string(ctx.Path()))
+	ctx.Response.Header.Set("Retry-After", fmt.Sprintf("%.0f", math.Ceil(1/l.Rate)))
+	ctx.SetStatusCode(http.StatusTooManyRequests)
+}
+
+// clientIP returns the address of the client, taking it from `X-Forwarded-For` entries added by trusted proxies.
+func (h *APIServer) clientIP(ctx *fasthttp.RequestCtx) string {
+	if h.trustedHops > 0 {
+		if fwd := string(ctx.Request.Header.Peek("X-Forwarded-For")); fwd != "" {
+			entries := strings.Split(fwd, ",")
+			i := len(entries) - h.trustedHops
+			if i < 0 {
+				i = 0
+			}
+			return strings.TrimSpace(entries[i])
+		}
+	}
+	return ctx.RemoteIP().String()
+}
+
+// uploadRequestConfig lifts request body size limit to `maxUploadSize` for upload requests of clients allowed
+// to enqueue, so that other endpoints keep the server default and can't be sent large bodies.
+func (h *APIServer) uploadRequestConfig(hdr *fasthttp.RequestHeader) fasthttp.RequestConfig {
+	if h.ingest == nil || h.maxUploadSize <= 0 || !hdr.IsPost() {
+		return fasthttp.RequestConfig{}
+	}
+	u := fasthttp.AcquireURI()
+	defer fasthttp.ReleaseURI(u)
+	u.Parse(nil, hdr.RequestURI())
+	if string(u.Path()) != ingestUploadPath || !h.allows(hdr, auth.ScopeEnqueue) {
+		return fasthttp.RequestConfig{}
+	}
+	return fasthttp.RequestConfig{MaxRequestBodySize: h.maxUploadSize}
+}
+
+// requestErrorHandler responds to requests that could not be read, telling apart ones with bodies over the limit.
+func requestErrorHandler(ctx *fasthttp.RequestCtx, err error) {
+	if err == fasthttp.ErrBodyTooLarge {
+		writeError(ctx, http.StatusRequestEntityTooLarge, err)
+	} else if _, ok := err.(*fasthttp.ErrSmallBuffer); ok {
+		ctx.Error("Too big request header", http.StatusRequestHeaderFieldsTooLarge)
+	} else if netErr, ok := err.(*net.OpError); ok && netErr.Timeout() {
+		ctx.Error("Request timeout", http.StatusRequestTimeout)
+	} else {
+		ctx.Error("Error when parsing request", http.StatusBadRequest)
+	}
+}
+
 func metricsMiddleware(h fasthttp.RequestHandler) fasthttp.RequestHandler {
 	return func(ctx *fasthttp.RequestCtx) {
 		t := timer.Start()
@@ -139,15 +588,75 @@ func NewServer(cfg *Configuration) *APIServer {
 
 	s := &APIServer{
 		Configuration: cfg,
+		stopEvents:    make(chan struct{}),
+		ipLimiter:     newRateLimiter(cfg.ipLimit),
+		claimLimiter:  newRateLimiter(cfg.claimLimit),
 		httpServer: &fasthttp.Server{
-			Handler: metricsMiddleware(corsMiddleware(r.Handler)),
+			Handler:      metricsMiddleware(corsMiddleware(r.Handler)),
+			ErrorHandler: requestErrorHandler,
 		},
 	}
+	s.httpServer.HeaderReceived = s.uploadRequestConfig
+
+	if s.auth == nil {
+		s.auth = auth.NewManager(auth.Configure().Public(auth.ScopePlayback, auth.ScopeMetrics))
+	}
+	playback := func(h fasthttp.RequestHandler) fasthttp.RequestHandler { return s.authorize(auth.ScopePlayback, h) }
+	admin := func(h fasthttp.RequestHandler) fasthttp.RequestHandler { return s.authorize(auth.ScopeAdmin, h) }
 
 	// r.GET("/api/v1/video/{kind:hls}/{url}/{sdHash:^[a-z0-9]{96}$}", h.handleVideo)
-	r.GET("/api/v1/video/{kind:hls}/{url}", s.handleVideo)
-	r.ServeFiles(path.Join(httpVideoPath, "{filepath:*}"), s.videoPath)
-	r.GET("/metrics", fasthttpadaptor.NewFastHTTPHandler(promhttp.Handler()))
+	r.GET("/api/v1/video/{kind:hls}/{url}", playback(s.rateLimitMiddleware(s.handleVideo)))
+	r.POST("/api/v1/video/{kind:hls}", playback(s.rateLimitMiddleware(s.handleSignedRequest)))
+	r.GET("/api/v1/health", s.handleHealth)
+	r.GET("/api/v1/key/{sdHash}", playback(s.handleKey))
+	r.GET("/api/v1/events", admin(s.handleEvents))
+	r.GET("/api/v1/events/{sdHash}", playback(s.handleEvents))
+	if s.webhooks != nil {
+		r.GET("/api/v1/webhooks", admin(s.handleListHooks))
+		r.POST("/api/v1/webhooks", admin(s.handleAddHook))
+		r.DELETE("/api/v1/webhooks/{id}", admin(s.handleDeleteHook))
+		r.GET("/api/v1/webhooks/deliveries", admin(s.handleListDeliveries))
+	}
+	if s.ingest != nil {
+		r.POST("/api/v1/admin/ingest", s.authorize(auth.ScopeEnqueue, s.handleIngest))
+		r.POST(ingestUploadPath, s.authorize(auth.ScopeEnqueue, s.handleIngestUpload))
+	}
+	r.GET("/api/v1/admin/tasks", admin(s.handleListTasks))
+	r.POST("/api/v1/admin/tasks", s.authorize(auth.ScopeEnqueue, s.handleEnqueue))
+	r.GET("/api/v1/admin/tasks/{id}", admin(s.handleGetTask))
+	r.POST("/api/v1/admin/tasks/{id}/requeue", admin(s.handleRequeueTask))
+	r.POST("/api/v1/admin/tasks/{id}/reject", admin(s.handleRejectTask))
+	r.POST("/api/v1/admin/tasks/{id}/cancel", admin(s.handleCancelTask))
+	r.POST("/api/v1/admin/tasks/{id}/priority", admin(s.handleTaskPriority))
+	r.GET("/api/v1/admin/library", admin(s.handleLibraryTotals))
+	r.GET("/api/v1/admin/videos", admin(s.handleListVideos))
+	r.GET("/api/v1/admin/videos/{sdHash}", admin(s.handleGetVideo))
+	r.DELETE("/api/v1/admin/videos/{sdHash}", admin(s.handleDeleteVideo))
+	r.POST("/api/v1/admin/videos/{sdHash}/furlough", admin(s.handleFurloughVideo))
+	r.POST("/api/v1/admin/videos/{sdHash}/retire", admin(s.handleRetireVideo))
+	r.POST("/api/v1/admin/videos/{sdHash}/reupload", admin(s.handleReuploadVideo))
+	r.GET("/api/v1/admin/keys", admin(s.handleListKeys))
+	r.POST("/api/v1/admin/keys", admin(s.handleCreateKey))
+	r.DELETE("/api/v1/admin/keys/{id}", admin(s.handleRevokeKey))
+	r.GET("/api/v1/admin/audit", admin(s.handleAuditLog))
+	r.GET("/api/v1/admin/access", admin(s.handleListAccessRules))
+	r.POST("/api/v1/admin/access", admin(s.handleSetAccessRule))
+	r.DELETE("/api/v1/admin/access/{kind}/{subject}", admin(s.handleDeleteAccessRule))
+	r.GET("/api/v1/admin/channels", admin(s.handleListChannels))
+	r.POST("/api/v1/admin/channels", admin(s.handleSetChannel))
+	r.GET("/api/v1/admin/channels/{channel}", admin(s.handleGetChannel))
+	r.DELETE("/api/v1/admin/channels/{channel}", admin(s.handleDeleteChannel))
+
+	fs := &fasthttp.FS{
+		Root:               s.videoPath,
+		IndexNames:         []string{"index.html"},
+		GenerateIndexPages: true,
+		AcceptByteRange:    true,
+		PathRewrite:        fasthttp.NewPathSlashesStripper(strings.Count(httpVideoPath, "/")),
+	}
+	r.GET(path.Join(httpVideoPath, "{filepath:*}"), playback(s.localFragmentHandler(fs.NewRequestHandler())))
+	r.GET(path.Join(httpRemotePath, "{sdHash}", "{name}"), playback(s.handleRemoteFragment))
+	r.GET("/metrics", s.authorize(auth.ScopeMetrics, fasthttpadaptor.NewFastHTTPHandler(promhttp.Handler())))
 
 	if !s.debug {
 		r.PanicHandler = handlePanic
@@ -170,5 +679,6 @@ func (s APIServer) Start() error {
 
 func (s APIServer) Shutdown() error {
 	logger.Info("shutting down...")
+	close(s.stopEvents)
 	return s.httpServer.Shutdown()
 }
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lbryio/transcoder/db"
+	"github.com/lbryio/transcoder/pkg/events"
+	"github.com/lbryio/transcoder/storage"
+	"github.com/lbryio/transcoder/video"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+func TestHandleEvents(t *testing.T) {
+	vdb := db.OpenTestDB()
+	require.NoError(t, vdb.Migrate(video.Migrations...))
+	bus := events.NewBus()
+	lib := video.NewLibrary(video.Configure().LocalStorage(storage.Local("/tmp/test")).DB(vdb).EventBus(bus))
+
+	s := NewServer(Configure().VideoManager(NewManager(nil, lib)))
+	ln := fasthttputil.NewInmemoryListener()
+	go s.httpServer.Serve(ln)
+	defer s.Shutdown()
+
+	conn, err := ln.Dial()
+	require.NoError(t, err)
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	_, err = conn.Write([]byte("GET /api/v1/events/abc HTTP/1.1\r\nHost: transcoder\r\n\r\n"))
+	require.NoError(t, err)
+
+	r := bufio.NewReader(conn)
+	// readUntil skips response headers and chunk sizes, returning the first line starting with `prefix`.
+	readUntil := func(prefix string) string {
+		for {
+			line, err := r.ReadString('\n')
+			require.NoError(t, err)
+			if strings.HasPrefix(line, prefix) {
+				return strings.TrimSpace(line)
+			}
+		}
+	}
+	assert.Equal(t, "Content-Type: text/event-stream", readUntil("Content-Type"))
+	readUntil(": subscribed")
+
+	bus.Publish(events.Event{Stage: events.StageEncoding, SDHash: "def", Progress: 10})
+	bus.Publish(events.Event{Stage: events.StageEncoding, SDHash: "abc", Progress: 43, Time: time.Unix(0, 0).UTC()})
+
+	assert.Equal(t, "event: encoding", readUntil("event:"))
+	assert.Equal(t,
+		`data: {"stage":"encoding","sd_hash":"abc","progress":43,"time":"1970-01-01T00:00:00Z"}`,
+		readUntil("data:"))
+}
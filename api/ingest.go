@@ -0,0 +1,187 @@
+package api
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+
+	"github.com/lbryio/transcoder/formats"
+	"github.com/lbryio/transcoder/pkg/claim"
+
+	"github.com/valyala/fasthttp"
+)
+
+var errIDRequired = errors.New("id is required for HTTP(S) sources")
+
+type ingestRequest struct {
+	URL      string `json:"url"`
+	ID       string `json:"id"`
+	Priority int    `json:"priority"`
+	Callback string `json:"callback"`
+}
+
+// handleIngest queues a local file or HTTP(S) URL for transcoding. Local files are identified
+// by their content hash unless an ID is supplied.
+func (h *APIServer) handleIngest(ctx *fasthttp.RequestCtx) {
+	var req ingestRequest
+	if err := json.Unmarshal(ctx.PostBody(), &req); err != nil {
+		writeError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	if err := h.checkCallback(req.Callback); err != nil {
+		writeError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	u, err := url.Parse(req.URL)
+	if err != nil {
+		writeError(ctx, http.StatusBadRequest, claim.ErrInvalidDirectURL)
+		return
+	}
+	switch u.Scheme {
+	case claim.SchemeHTTP, claim.SchemeHTTPS:
+		if req.ID == "" {
+			writeError(ctx, http.StatusBadRequest, errIDRequired)
+			return
+		}
+	case claim.SchemeFile:
+		p, err := h.ingest.LocalPath(req.URL)
+		if err == claim.ErrLocalNotAllowed {
+			writeError(ctx, http.StatusForbidden, err)
+			return
+		} else if err != nil {
+			writeError(ctx, http.StatusBadRequest, err)
+			return
+		}
+		if req.ID == "" {
+			f, err := os.Open(p)
+			if os.IsNotExist(err) {
+				writeError(ctx, http.StatusNotFound, err)
+				return
+			} else if err != nil {
+				writeError(ctx, http.StatusInternalServerError, err)
+				return
+			}
+			req.ID, err = contentHash(f)
+			f.Close()
+			if err != nil {
+				writeError(ctx, http.StatusInternalServerError, err)
+				return
+			}
+		}
+	default:
+		writeError(ctx, http.StatusBadRequest, claim.ErrInvalidDirectURL)
+		return
+	}
+	if !claim.ValidDirectID(req.ID) {
+		writeError(ctx, http.StatusBadRequest, claim.ErrInvalidDirectID)
+		return
+	}
+
+	_, t, err := h.videoManager.Ingest(req.URL, req.ID, formats.TypeHLS)
+	h.writeIngestResult(ctx, h.enqueueResult(req.URL, t, err, req.Priority, req.Callback))
+}
+
+// handleIngestUpload saves the `file` form field and queues it for transcoding,
+// identified by `id` form field or its content hash.
+func (h *APIServer) handleIngestUpload(ctx *fasthttp.RequestCtx) {
+	if h.ingest.UploadPath == "" {
+		writeError(ctx, http.StatusConflict, errors.New("uploads are not enabled"))
+		return
+	}
+	if h.maxUploadSize > 0 && ctx.Request.Header.ContentLength() > h.maxUploadSize {
+		writeError(ctx, http.StatusRequestEntityTooLarge, fasthttp.ErrBodyTooLarge)
+		return
+	}
+	callback := string(ctx.FormValue("callback"))
+	if err := h.checkCallback(callback); err != nil {
+		writeError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	priority, _ := strconv.Atoi(string(ctx.FormValue("priority")))
+	id := string(ctx.FormValue("id"))
+	if id != "" && !claim.ValidDirectID(id) {
+		writeError(ctx, http.StatusBadRequest, claim.ErrInvalidDirectID)
+		return
+	}
+	fh, err := ctx.FormFile("file")
+	if err != nil {
+		writeError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	in, err := fh.Open()
+	if err != nil {
+		writeError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	defer in.Close()
+
+	if err := os.MkdirAll(h.ingest.UploadPath, os.ModePerm); err != nil {
+		writeError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	tmp, err := ioutil.TempFile(h.ingest.UploadPath, "upload")
+	if err != nil {
+		writeError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	defer os.Remove(tmp.Name())
+	hash, err := contentHash(io.TeeReader(in, tmp))
+	tmp.Close()
+	if err != nil {
+		writeError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	if id == "" {
+		id = hash
+	}
+
+	uri := claim.UploadURL(id)
+	// Content already transcoded or queued is not replaced.
+	if v, _ := h.videoManager.library.Get(id); v != nil {
+		h.writeIngestResult(ctx, &enqueueResult{URL: uri, Result: enqueueResultExists})
+		return
+	}
+	if t, _ := h.videoManager.queue.GetBySDHash(id); t != nil {
+		h.writeIngestResult(ctx, &enqueueResult{URL: uri, Result: enqueueResultQueued, Task: newTaskView(t)})
+		return
+	}
+	if err := os.Rename(tmp.Name(), h.ingest.UploadFile(id)); err != nil {
+		writeError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	logger.Infow("upload saved", "id", id, "name", fh.Filename, "size", fh.Size)
+
+	_, t, err := h.videoManager.Ingest(uri, id, formats.TypeHLS)
+	h.writeIngestResult(ctx, h.enqueueResult(uri, t, err, priority, callback))
+}
+
+func (h *APIServer) writeIngestResult(ctx *fasthttp.RequestCtx, r *enqueueResult) {
+	status := http.StatusOK
+	switch r.Result {
+	case enqueueResultQueued:
+		status = http.StatusAccepted
+	case enqueueResultForbidden:
+		status = http.StatusForbidden
+	case enqueueResultNotFound:
+		status = http.StatusNotFound
+	case enqueueResultError:
+		status = http.StatusInternalServerError
+	}
+	writeJSON(ctx, status, r)
+}
+
+// contentHash returns hex-encoded SHA-256 of `r` content.
+func contentHash(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,49 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalidKeyToken = errors.New("invalid key token")
+
+// SignKeyToken creates a token granting access to stream encryption key until `expires`.
+// Token is expected in the `token` query parameter of key-delivery endpoint requests.
+func SignKeyToken(secret, sdHash string, expires time.Time) string {
+	exp := expires.Unix()
+	return fmt.Sprintf("%v.%v", exp, keyTokenSignature(secret, sdHash, exp))
+}
+
+// VerifyKeyToken checks that token was signed with `secret` for the stream and has not yet expired.
+func VerifyKeyToken(secret, sdHash, token string) error {
+	if secret == "" {
+		return ErrInvalidKeyToken
+	}
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 {
+		return ErrInvalidKeyToken
+	}
+	exp, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return ErrInvalidKeyToken
+	}
+	if time.Now().Unix() > exp {
+		return ErrInvalidKeyToken
+	}
+	if !hmac.Equal([]byte(parts[1]), []byte(keyTokenSignature(secret, sdHash, exp))) {
+		return ErrInvalidKeyToken
+	}
+	return nil
+}
+
+func keyTokenSignature(secret, sdHash string, exp int64) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%v:%v", sdHash, exp)
+	return hex.EncodeToString(mac.Sum(nil))
+}
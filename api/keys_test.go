@@ -0,0 +1,102 @@
+package api
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/lbryio/transcoder/auth"
+	"github.com/lbryio/transcoder/db"
+	"github.com/lbryio/transcoder/storage"
+	"github.com/lbryio/transcoder/video"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/valyala/fasthttp"
+	"github.com/valyala/fasthttp/fasthttputil"
+)
+
+func TestKeyToken(t *testing.T) {
+	sdHash := "f12fb044f5805334a473bf9a81363d89bd1cb54c4065ac05be71a599a6c51efc6c6afb257208326af304324094105774"
+	token := SignKeyToken("s3cr3t", sdHash, time.Now().Add(time.Minute))
+
+	assert.NoError(t, VerifyKeyToken("s3cr3t", sdHash, token))
+	assert.Equal(t, ErrInvalidKeyToken, VerifyKeyToken("", sdHash, token))
+	assert.Equal(t, ErrInvalidKeyToken, VerifyKeyToken("other", sdHash, token))
+	assert.Equal(t, ErrInvalidKeyToken, VerifyKeyToken("s3cr3t", sdHash[1:], token))
+	assert.Equal(t, ErrInvalidKeyToken, VerifyKeyToken("s3cr3t", sdHash, "garbage"))
+
+	expired := SignKeyToken("s3cr3t", sdHash, time.Now().Add(-time.Minute))
+	assert.Equal(t, ErrInvalidKeyToken, VerifyKeyToken("s3cr3t", sdHash, expired))
+}
+
+func TestKeyDelivery(t *testing.T) {
+	sdHash := "f12fb044f5805334a473bf9a81363d89bd1cb54c4065ac05be71a599a6c51efc6c6afb257208326af304324094105774"
+	videoPath := t.TempDir()
+	vdb := db.OpenTestDB()
+	require.NoError(t, vdb.Migrate(video.Migrations...))
+	lib := video.NewLibrary(video.Configure().
+		LocalStorage(storage.Local(videoPath)).
+		DB(vdb).
+		KeyServerURL("http://transcoder/api/v1/key"))
+
+	key, err := lib.GenerateKey(sdHash)
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(path.Join(videoPath, sdHash), os.ModePerm))
+	playlist := fmt.Sprintf(
+		"#EXTM3U\n#EXT-X-KEY:METHOD=AES-128,URI=\"%v\",IV=0x01\n#EXTINF:10.0,\nseg_0_000000.ts\n#EXT-X-ENDLIST\n",
+		lib.KeyURI(sdHash))
+	require.NoError(t, ioutil.WriteFile(path.Join(videoPath, sdHash, "stream_0.m3u8"), []byte(playlist), os.ModePerm))
+
+	am := auth.NewManager(auth.Configure().StaticKey("player", "pl4yer", auth.ScopePlayback, auth.ScopeDecrypt).Public(auth.ScopePlayback))
+	s := NewServer(Configure().VideoManager(NewManager(nil, lib)).VideoPath(videoPath).KeyTokenSecret("s3cr3t").Auth(am))
+	ln := fasthttputil.NewInmemoryListener()
+	go s.httpServer.Serve(ln)
+	defer s.Shutdown()
+	client := &fasthttp.Client{Dial: func(addr string) (net.Conn, error) { return ln.Dial() }}
+	getWithKey := func(uri, token string) (int, []byte) {
+		req := fasthttp.AcquireRequest()
+		defer fasthttp.ReleaseRequest(req)
+		res := fasthttp.AcquireResponse()
+		defer fasthttp.ReleaseResponse(res)
+		req.SetConnectionClose()
+		req.SetRequestURI(uri)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		require.NoError(t, client.Do(req, res))
+		return res.StatusCode(), append([]byte{}, res.Body()...)
+	}
+	get := func(uri string) (int, []byte) { return getWithKey(uri, "") }
+
+	// Anonymous requests are not allowed to decrypt, so key URIs are left unsigned.
+	status, body := get(fmt.Sprintf("http://transcoder/streams/%v/stream_0.m3u8", sdHash))
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, playlist, string(body))
+
+	status, body = getWithKey(fmt.Sprintf("http://transcoder/streams/%v/stream_0.m3u8", sdHash), "pl4yer")
+	require.Equal(t, http.StatusOK, status)
+	m := regexp.MustCompile(`#EXT-X-KEY:METHOD=AES-128,URI="([^"]+)",IV=0x01`).FindSubmatch(body)
+	require.NotNil(t, m, string(body))
+	assert.Contains(t, string(m[1]), "token=")
+	assert.Contains(t, string(body), "seg_0_000000.ts")
+
+	status, body = get(string(m[1]))
+	assert.Equal(t, http.StatusOK, status)
+	assert.Equal(t, key, body)
+
+	status, _ = get(lib.KeyURI(sdHash))
+	assert.Equal(t, http.StatusForbidden, status)
+
+	// Neither stored remotely nor a stream file, so not proxied.
+	status, _ = get(fmt.Sprintf("http://transcoder/remote/%v/stream_0.m3u8", sdHash))
+	assert.Equal(t, http.StatusNotFound, status)
+	status, _ = get(fmt.Sprintf("http://transcoder/remote/%v/%v", sdHash, sdHash))
+	assert.Equal(t, http.StatusNotFound, status)
+}
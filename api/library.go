@@ -0,0 +1,227 @@
+package api
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/lbryio/transcoder/video"
+
+	"github.com/valyala/fasthttp"
+)
+
+var errUnknownFilter = errors.New("unknown location or status")
+
+// VideoView is a JSON representation of a library video.
+type VideoView struct {
+	SDHash       string           `json:"sd_hash"`
+	URL          string           `json:"url"`
+	Type         string           `json:"type"`
+	Channel      string           `json:"channel"`
+	Path         string           `json:"path,omitempty"`
+	RemotePath   string           `json:"remote_path,omitempty"`
+	ArchivePath  string           `json:"archive_path,omitempty"`
+	Origin       string           `json:"origin,omitempty"`
+	Size         int64            `json:"size"`
+	Checksum     string           `json:"checksum,omitempty"`
+	CreatedAt    string           `json:"created_at"`
+	LastAccessed string           `json:"last_accessed,omitempty"`
+	AccessCount  int64            `json:"access_count"`
+	Renditions   []*RenditionView `json:"renditions,omitempty"`
+}
+
+type RenditionView struct {
+	Name      string `json:"name"`
+	Height    int    `json:"height"`
+	Bandwidth int    `json:"bandwidth"`
+	Size      int64  `json:"size"`
+	Local     bool   `json:"local"`
+	Remote    bool   `json:"remote"`
+}
+
+type VideoPage struct {
+	Videos []*VideoView `json:"videos"`
+	Total  int          `json:"total"`
+	Limit  int          `json:"limit"`
+	Offset int          `json:"offset"`
+}
+
+type TotalView struct {
+	Count int64 `json:"count"`
+	Size  int64 `json:"size"`
+}
+
+type LibraryTotals struct {
+	Tiers    map[string]TotalView `json:"tiers"`
+	Channels map[string]TotalView `json:"channels"`
+}
+
+func newVideoView(v *video.Video) *VideoView {
+	vv := &VideoView{
+		SDHash:      v.SDHash,
+		URL:         v.URL,
+		Type:        v.Type,
+		Channel:     v.Channel,
+		Path:        v.Path,
+		RemotePath:  v.RemotePath,
+		ArchivePath: v.ArchivePath,
+		Origin:      v.Origin,
+		Size:        v.Size,
+		Checksum:    v.Checksum,
+		CreatedAt:   v.CreatedAt,
+		AccessCount: v.AccessCount,
+	}
+	if v.LastAccessed.Valid {
+		vv.LastAccessed = v.LastAccessed.Time.Format(time.RFC3339)
+	}
+	return vv
+}
+
+func totalViews(totals map[string]video.Total) map[string]TotalView {
+	views := map[string]TotalView{}
+	for k, t := range totals {
+		views[k] = TotalView{Count: t.Count, Size: t.Size}
+	}
+	return views
+}
+
+func (h *APIServer) handleListVideos(ctx *fasthttp.RequestCtx) {
+	limit, offset := pagination(ctx)
+	params := video.ListParams{
+		Channel:  string(ctx.QueryArgs().Peek("channel")),
+		Search:   string(ctx.QueryArgs().Peek("q")),
+		Location: string(ctx.QueryArgs().Peek("location")),
+		Status:   string(ctx.QueryArgs().Peek("status")),
+		Limit:    limit,
+		Offset:   offset,
+	}
+	switch params.Location {
+	case "", video.TierLocal, video.TierRemote, video.TierArchive:
+	default:
+		writeError(ctx, http.StatusBadRequest, errUnknownFilter)
+		return
+	}
+	switch params.Status {
+	case "", video.StatusStored, video.StatusLinked:
+	default:
+		writeError(ctx, http.StatusBadRequest, errUnknownFilter)
+		return
+	}
+
+	videos, total, err := h.videoManager.library.List(params)
+	if err != nil {
+		writeError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	page := VideoPage{Videos: []*VideoView{}, Total: total, Limit: limit, Offset: offset}
+	for _, v := range videos {
+		page.Videos = append(page.Videos, newVideoView(v))
+	}
+	writeJSON(ctx, http.StatusOK, page)
+}
+
+func (h *APIServer) handleLibraryTotals(ctx *fasthttp.RequestCtx) {
+	t, err := h.videoManager.library.Totals()
+	if err != nil {
+		writeError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(ctx, http.StatusOK, LibraryTotals{Tiers: totalViews(t.Tiers), Channels: totalViews(t.Channels)})
+}
+
+// libraryVideo retrieves the video requested by `sdHash` path parameter, writing an error response if that fails.
+func (h *APIServer) libraryVideo(ctx *fasthttp.RequestCtx) *video.Video {
+	v, err := h.videoManager.library.Lookup(ctx.UserValue("sdHash").(string))
+	if err == sql.ErrNoRows {
+		writeError(ctx, http.StatusNotFound, errors.New("video not found"))
+		return nil
+	} else if err != nil {
+		writeError(ctx, http.StatusInternalServerError, err)
+		return nil
+	}
+	return v
+}
+
+func (h *APIServer) handleGetVideo(ctx *fasthttp.RequestCtx) {
+	v := h.libraryVideo(ctx)
+	if v == nil {
+		return
+	}
+	vv := newVideoView(v)
+	rs, err := h.videoManager.library.Renditions(v.GetStorageKey())
+	if err != nil {
+		writeError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	for _, r := range rs {
+		vv.Renditions = append(vv.Renditions, &RenditionView{
+			Name: r.Name, Height: r.Height, Bandwidth: r.Bandwidth, Size: r.Size, Local: r.Local, Remote: r.Remote,
+		})
+	}
+	writeJSON(ctx, http.StatusOK, vv)
+}
+
+func (h *APIServer) handleFurloughVideo(ctx *fasthttp.RequestCtx) {
+	v := h.libraryVideo(ctx)
+	if v == nil {
+		return
+	}
+	if v.Path == "" {
+		writeError(ctx, http.StatusConflict, video.ErrNoLocalCopy)
+		return
+	}
+	if v.RemotePath == "" && v.ArchivePath == "" {
+		writeError(ctx, http.StatusConflict, video.ErrNoRemoteCopy)
+		return
+	}
+	h.updateVideo(ctx, v, "furloughed", h.videoManager.library.Furlough)
+}
+
+func (h *APIServer) handleRetireVideo(ctx *fasthttp.RequestCtx) {
+	v := h.libraryVideo(ctx)
+	if v == nil {
+		return
+	}
+	if v.Path != "" {
+		writeError(ctx, http.StatusConflict, video.ErrLocalCopyPresent)
+		return
+	}
+	h.updateVideo(ctx, v, "retired", h.videoManager.library.Retire)
+}
+
+func (h *APIServer) handleDeleteVideo(ctx *fasthttp.RequestCtx) {
+	v := h.libraryVideo(ctx)
+	if v == nil {
+		return
+	}
+	h.updateVideo(ctx, v, "deleted", h.videoManager.library.Delete)
+}
+
+// handleReuploadVideo queues upload of local copy of the video to remote storage,
+// progress can be followed on the events endpoint.
+func (h *APIServer) handleReuploadVideo(ctx *fasthttp.RequestCtx) {
+	v := h.libraryVideo(ctx)
+	if v == nil {
+		return
+	}
+	if v.Path == "" {
+		writeError(ctx, http.StatusConflict, video.ErrNoLocalCopy)
+		return
+	}
+	if err := h.videoManager.library.QueueUpload(v); err != nil {
+		writeError(ctx, http.StatusConflict, err)
+		return
+	}
+	logger.Infow("video re-upload queued by admin", "sd_hash", v.SDHash)
+	writeJSON(ctx, http.StatusAccepted, newVideoView(v))
+}
+
+func (h *APIServer) updateVideo(ctx *fasthttp.RequestCtx, v *video.Video, action string, op func(*video.Video) error) {
+	if err := op(v); err != nil {
+		writeError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	logger.Infow("video "+action+" by admin", "sd_hash", v.SDHash, "url", v.URL)
+	ctx.SetStatusCode(http.StatusNoContent)
+}
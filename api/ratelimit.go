@@ -0,0 +1,82 @@
+package api
+
+import (
+	"sync"
+	"time"
+)
+
+// Rate limits applied to playback requests.
+const (
+	LimitIP    = "ip"
+	LimitClaim = "claim"
+)
+
+// RateLimit is a token bucket refilled at `Rate` tokens per second, holding up to `Burst` tokens.
+// Zero rate disables the limit.
+type RateLimit struct {
+	Rate  float64
+	Burst int
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// rateLimiter keeps a token bucket per key, buckets that have refilled completely are dropped periodically.
+type rateLimiter struct {
+	mu          sync.Mutex
+	limit       RateLimit
+	buckets     map[string]*bucket
+	lastCleanup time.Time
+}
+
+func newRateLimiter(l RateLimit) *rateLimiter {
+	if l.Burst < 1 {
+		l.Burst = 1
+	}
+	return &rateLimiter{limit: l, buckets: map[string]*bucket{}, lastCleanup: time.Now()}
+}
+
+// allow takes a token from the bucket of `key`, returning false if it's empty.
+func (l *rateLimiter) allow(key string, now time.Time) bool {
+	if l == nil || l.limit.Rate <= 0 {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastCleanup) > l.fillTime() {
+		l.cleanup(now)
+	}
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(l.limit.Burst), last: now}
+		l.buckets[key] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * l.limit.Rate
+	if b.tokens > float64(l.limit.Burst) {
+		b.tokens = float64(l.limit.Burst)
+	}
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// fillTime is how long it takes for an empty bucket to refill.
+func (l *rateLimiter) fillTime() time.Duration {
+	return time.Duration(float64(l.limit.Burst) / l.limit.Rate * float64(time.Second))
+}
+
+func (l *rateLimiter) cleanup(now time.Time) {
+	for k, b := range l.buckets {
+		if now.Sub(b.last) >= l.fillTime() {
+			delete(l.buckets, k)
+		}
+	}
+	l.lastCleanup = now
+}
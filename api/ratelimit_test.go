@@ -0,0 +1,73 @@
+package api
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/valyala/fasthttp"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Now()
+	l := newRateLimiter(RateLimit{Rate: 2, Burst: 3})
+
+	for i := 0; i < 3; i++ {
+		assert.True(t, l.allow("1.1.1.1", now))
+	}
+	assert.False(t, l.allow("1.1.1.1", now))
+	assert.True(t, l.allow("2.2.2.2", now))
+
+	assert.False(t, l.allow("1.1.1.1", now.Add(400*time.Millisecond)))
+	assert.True(t, l.allow("1.1.1.1", now.Add(600*time.Millisecond)))
+	assert.False(t, l.allow("1.1.1.1", now.Add(600*time.Millisecond)))
+
+	// Idle buckets are dropped once refilled.
+	assert.True(t, l.allow("3.3.3.3", now.Add(time.Minute)))
+	assert.Len(t, l.buckets, 1)
+
+	var disabled *rateLimiter
+	assert.True(t, disabled.allow("1.1.1.1", now))
+	assert.True(t, newRateLimiter(RateLimit{}).allow("1.1.1.1", now))
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	cfg := Configure().RateLimit(RateLimit{Rate: 1, Burst: 3}, RateLimit{Rate: 0.5, Burst: 2}).TrustForwardedFor(2)
+	h := &APIServer{Configuration: cfg, ipLimiter: newRateLimiter(cfg.ipLimit), claimLimiter: newRateLimiter(cfg.claimLimit)}
+	handler := h.rateLimitMiddleware(func(ctx *fasthttp.RequestCtx) { ctx.SetStatusCode(http.StatusOK) })
+
+	request := func(ip, url string) *fasthttp.RequestCtx {
+		ctx := &fasthttp.RequestCtx{}
+		ctx.Request.Header.Set("X-Forwarded-For", ip+", 10.0.0.1")
+		ctx.SetUserValue("url", url)
+		handler(ctx)
+		return ctx
+	}
+
+	assert.Equal(t, http.StatusOK, request("1.1.1.1", "lbry%3A%2F%2Fvideo%231").Response.StatusCode())
+	assert.Equal(t, http.StatusOK, request("2.2.2.2", "video#1").Response.StatusCode())
+	ctx := request("3.3.3.3", "Video#1")
+	assert.Equal(t, http.StatusTooManyRequests, ctx.Response.StatusCode())
+	assert.Equal(t, "2", string(ctx.Response.Header.Peek("Retry-After")))
+
+	assert.Equal(t, http.StatusOK, request("1.1.1.1", "other").Response.StatusCode())
+	assert.Equal(t, http.StatusOK, request("1.1.1.1", "another").Response.StatusCode())
+	ctx = request("1.1.1.1", "yetanother")
+	assert.Equal(t, http.StatusTooManyRequests, ctx.Response.StatusCode())
+	assert.Equal(t, "1", string(ctx.Response.Header.Peek("Retry-After")))
+}
+
+func TestClientIP(t *testing.T) {
+	ctx := &fasthttp.RequestCtx{}
+	ctx.Request.Header.Set("X-Forwarded-For", "6.6.6.6, 1.1.1.1, 10.0.0.1")
+
+	h := &APIServer{Configuration: Configure()}
+	assert.Equal(t, "0.0.0.0", h.clientIP(ctx))
+	h = &APIServer{Configuration: Configure().TrustForwardedFor(1)}
+	assert.Equal(t, "10.0.0.1", h.clientIP(ctx))
+	h = &APIServer{Configuration: Configure().TrustForwardedFor(2)}
+	assert.Equal(t, "1.1.1.1", h.clientIP(ctx))
+	h = &APIServer{Configuration: Configure().TrustForwardedFor(5)}
+	assert.Equal(t, "6.6.6.6", h.clientIP(ctx))
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/valyala/fasthttp"
+)
+
+const defaultDeliveriesLimit = 100
+
+var errInvalidCallbackURL = errors.New("callback URL must be an absolute http(s) URL")
+
+type hookRequest struct {
+	URL     string `json:"url"`
+	Channel string `json:"channel"`
+}
+
+// validateCallbackURL checks that `u` can be used as a webhook URL.
+func validateCallbackURL(u string) error {
+	pu, err := url.Parse(u)
+	if err != nil || (pu.Scheme != "http" && pu.Scheme != "https") || pu.Host == "" {
+		return errInvalidCallbackURL
+	}
+	return nil
+}
+
+func writeJSON(ctx *fasthttp.RequestCtx, status int, v interface{}) {
+	ctx.SetStatusCode(status)
+	ctx.SetContentType("application/json")
+	if err := json.NewEncoder(ctx).Encode(v); err != nil {
+		logger.Errorw("response serialization failed", "error", err)
+	}
+}
+
+func writeError(ctx *fasthttp.RequestCtx, status int, err error) {
+	writeJSON(ctx, status, map[string]string{"error": err.Error()})
+}
+
+func (h *APIServer) handleListHooks(ctx *fasthttp.RequestCtx) {
+	hooks, err := h.webhooks.ListHooks()
+	if err != nil {
+		writeError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(ctx, http.StatusOK, hooks)
+}
+
+func (h *APIServer) handleAddHook(ctx *fasthttp.RequestCtx) {
+	var req hookRequest
+	if err := json.Unmarshal(ctx.PostBody(), &req); err != nil {
+		writeError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	if err := validateCallbackURL(req.URL); err != nil {
+		writeError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	hook, err := h.webhooks.AddHook(req.URL, req.Channel)
+	if err != nil {
+		writeError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(ctx, http.StatusCreated, hook)
+}
+
+func (h *APIServer) handleDeleteHook(ctx *fasthttp.RequestCtx) {
+	id, err := strconv.ParseInt(ctx.UserValue("id").(string), 10, 64)
+	if err != nil {
+		writeError(ctx, http.StatusBadRequest, err)
+		return
+	}
+	err = h.webhooks.DeleteHook(id)
+	if err == sql.ErrNoRows {
+		ctx.SetStatusCode(http.StatusNotFound)
+		return
+	} else if err != nil {
+		writeError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	ctx.SetStatusCode(http.StatusNoContent)
+}
+
+func (h *APIServer) handleListDeliveries(ctx *fasthttp.RequestCtx) {
+	limit := defaultDeliveriesLimit
+	if l, err := strconv.Atoi(string(ctx.QueryArgs().Peek("limit"))); err == nil && l > 0 {
+		limit = l
+	}
+	ds, err := h.webhooks.Deliveries(string(ctx.QueryArgs().Peek("sd_hash")), limit)
+	if err != nil {
+		writeError(ctx, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(ctx, http.StatusOK, ds)
+}
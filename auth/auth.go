@@ -0,0 +1,191 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lbryio/transcoder/db"
+)
+
+const tokenPrefix = "tk_"
+
+var (
+	ErrInvalidToken  = errors.New("invalid API key")
+	ErrInvalidScope  = errors.New("invalid scope")
+	ErrKeyNotFound   = errors.New("API key not found")
+	ErrNoKeyStorage  = errors.New("API key storage is not configured")
+	ErrEmptyKeyName  = errors.New("API key name is required")
+	ErrNoScopesGiven = errors.New("at least one scope is required")
+)
+
+type staticKey struct {
+	token string
+	key   *Key
+}
+
+type Config struct {
+	db     *db.DB
+	static []staticKey
+	public []string
+}
+
+func Configure() *Config {
+	return &Config{public: []string{}}
+}
+
+// DB enables keeping API keys and the audit log in the database.
+func (c *Config) DB(db *db.DB) *Config {
+	c.db = db
+	return c
+}
+
+// StaticKey adds a key that is not stored in the database, useful for bootstrapping access
+// before any keys are created via the API.
+func (c *Config) StaticKey(name, token string, scopes ...string) *Config {
+	c.static = append(c.static, staticKey{token: token, key: &Key{Name: name, Scopes: scopes}})
+	return c
+}
+
+// Public sets scopes granted to requests without an API key.
+func (c *Config) Public(scopes ...string) *Config {
+	c.public = scopes
+	return c
+}
+
+// Manager issues API keys and authenticates requests.
+type Manager struct {
+	*Config
+	queries Queries
+}
+
+func NewManager(cfg *Config) *Manager {
+	return &Manager{Config: cfg, queries: Queries{cfg.db}}
+}
+
+// Anonymous returns a pseudo-key carrying scopes granted to requests without an API key.
+func (m Manager) Anonymous() *Key {
+	return &Key{Name: "anonymous", Scopes: m.public}
+}
+
+// Authenticate returns the key matching `token`.
+func (m Manager) Authenticate(token string) (*Key, error) {
+	if token == "" {
+		return nil, ErrInvalidToken
+	}
+	for _, s := range m.static {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) == 1 {
+			return s.key, nil
+		}
+	}
+	if m.db == nil {
+		return nil, ErrInvalidToken
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	k, err := m.queries.GetKey(ctx, hashToken(token))
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidToken
+	} else if err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// CreateKey issues a new API key with `scopes`. The returned token is not stored and cannot be retrieved later.
+func (m Manager) CreateKey(name string, scopes []string) (*Key, string, error) {
+	if m.db == nil {
+		return nil, "", ErrNoKeyStorage
+	}
+	if name == "" {
+		return nil, "", ErrEmptyKeyName
+	}
+	if len(scopes) == 0 {
+		return nil, "", ErrNoScopesGiven
+	}
+	for _, s := range scopes {
+		if !ValidScope(s) {
+			return nil, "", fmt.Errorf("%w: %v", ErrInvalidScope, s)
+		}
+	}
+
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return nil, "", err
+	}
+	token := tokenPrefix + hex.EncodeToString(b)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	k, err := m.queries.AddKey(ctx, name, hashToken(token), scopes)
+	if err != nil {
+		return nil, "", err
+	}
+	logger.Infow("API key created", "id", k.ID, "name", k.Name, "scopes", k.Scopes)
+	return k, token, nil
+}
+
+func (m Manager) ListKeys() ([]*Key, error) {
+	if m.db == nil {
+		return []*Key{}, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	return m.queries.ListKeys(ctx)
+}
+
+// RevokeKey makes key `id` unusable for authentication.
+func (m Manager) RevokeKey(id int64) error {
+	if m.db == nil {
+		return ErrKeyNotFound
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	err := m.queries.RevokeKey(ctx, id)
+	if err == sql.ErrNoRows {
+		return ErrKeyNotFound
+	}
+	if err == nil {
+		logger.Infow("API key revoked", "id", id)
+	}
+	return err
+}
+
+// Audit records an action performed with an API key.
+func (m Manager) Audit(e AuditEntry) {
+	logger.Infow(
+		"audit",
+		"key_id", e.KeyID, "key_name", e.KeyName, "action", e.Action, "target", e.Target,
+		"status_code", e.StatusCode, "remote_addr", e.RemoteAddr,
+	)
+	if m.db == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := m.queries.AddAuditEntry(ctx, e); err != nil {
+		logger.Errorw("failed to write audit log entry", "err", err)
+	}
+}
+
+// AuditLog returns up to `limit` latest audit entries for key `keyID`, or for all keys if it's zero.
+func (m Manager) AuditLog(keyID int64, limit int) ([]*AuditEntry, error) {
+	if m.db == nil {
+		return []*AuditEntry{}, nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	return m.queries.ListAuditEntries(ctx, keyID, limit)
+}
+
+func hashToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
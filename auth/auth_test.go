@@ -0,0 +1,83 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/lbryio/transcoder/db"
+	"github.com/stretchr/testify/suite"
+)
+
+type AuthSuite struct {
+	suite.Suite
+	db *db.DB
+}
+
+func TestAuthSuite(t *testing.T) {
+	suite.Run(t, new(AuthSuite))
+}
+
+func (s *AuthSuite) SetupTest() {
+	s.db = db.OpenTestDB()
+	s.Require().NoError(s.db.Migrate(Migrations...))
+}
+
+func (s *AuthSuite) TestKeys() {
+	m := NewManager(Configure().DB(s.db).StaticKey("bootstrap", "s3cret", ScopeAdmin).Public(ScopePlayback))
+
+	_, _, err := m.CreateKey("player", []string{"superuser"})
+	s.True(errors.Is(err, ErrInvalidScope))
+	_, _, err = m.CreateKey("player", nil)
+	s.Equal(ErrNoScopesGiven, err)
+
+	k, token, err := m.CreateKey("player", []string{ScopePlayback, ScopeMetrics})
+	s.Require().NoError(err)
+	s.NotEmpty(token)
+	s.Equal([]string{ScopePlayback, ScopeMetrics}, k.Scopes)
+
+	ak, err := m.Authenticate(token)
+	s.Require().NoError(err)
+	s.Equal(k.ID, ak.ID)
+	s.True(ak.Allows(ScopeMetrics))
+	s.False(ak.Allows(ScopeAdmin))
+	s.False(ak.Allows(ScopeEnqueue))
+
+	sk, err := m.Authenticate("s3cret")
+	s.Require().NoError(err)
+	s.Equal("bootstrap", sk.Name)
+	s.True(sk.Allows(ScopeEnqueue))
+
+	_, err = m.Authenticate("wrong")
+	s.Equal(ErrInvalidToken, err)
+	s.True(m.Anonymous().Allows(ScopePlayback))
+	s.False(m.Anonymous().Allows(ScopeMetrics))
+
+	s.Require().NoError(m.RevokeKey(k.ID))
+	_, err = m.Authenticate(token)
+	s.Equal(ErrInvalidToken, err)
+	s.Equal(ErrKeyNotFound, m.RevokeKey(k.ID))
+
+	keys, err := m.ListKeys()
+	s.Require().NoError(err)
+	s.Require().Len(keys, 1)
+	s.NotEmpty(keys[0].RevokedAt)
+}
+
+func (s *AuthSuite) TestAudit() {
+	m := NewManager(Configure().DB(s.db))
+	k, _, err := m.CreateKey("ops", []string{ScopeAdmin})
+	s.Require().NoError(err)
+
+	m.Audit(AuditEntry{KeyID: k.ID, KeyName: k.Name, Action: "POST", Target: "/api/v1/admin/tasks/1/cancel", StatusCode: 200})
+	m.Audit(AuditEntry{KeyName: "bootstrap", Action: "DELETE", Target: "/api/v1/webhooks/1", StatusCode: 204})
+
+	entries, err := m.AuditLog(0, 10)
+	s.Require().NoError(err)
+	s.Require().Len(entries, 2)
+	s.Equal("bootstrap", entries[0].KeyName)
+
+	entries, err = m.AuditLog(k.ID, 10)
+	s.Require().NoError(err)
+	s.Require().Len(entries, 1)
+	s.Equal("/api/v1/admin/tasks/1/cancel", entries[0].Target)
+}
@@ -0,0 +1,72 @@
+package auth
+
+import "strings"
+
+// Scopes limit API actions a key can be used for.
+const (
+	// ScopePlayback allows requesting and streaming videos.
+	ScopePlayback = "playback"
+	// ScopeEnqueue allows submitting videos for transcoding in bulk.
+	ScopeEnqueue = "enqueue"
+	// ScopeAdmin allows managing the queue, the library, webhooks and keys. It implies all other scopes.
+	ScopeAdmin = "admin"
+	// ScopeMetrics allows scraping prometheus metrics.
+	ScopeMetrics = "metrics"
+	// ScopeDecrypt allows receiving key tokens in playlists of encrypted streams.
+	ScopeDecrypt = "decrypt"
+)
+
+// Scopes lists all valid scopes.
+var Scopes = []string{ScopePlayback, ScopeEnqueue, ScopeAdmin, ScopeMetrics, ScopeDecrypt}
+
+// Key is an API key. Its token is only known at creation time, only a hash of it is stored.
+type Key struct {
+	ID        int64    `json:"id"`
+	Name      string   `json:"name"`
+	Scopes    []string `json:"scopes"`
+	CreatedAt string   `json:"created_at,omitempty"`
+	RevokedAt string   `json:"revoked_at,omitempty"`
+}
+
+// Allows checks if key grants `scope`.
+func (k Key) Allows(scope string) bool {
+	for _, s := range k.Scopes {
+		if s == scope || s == ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
+
+// AuditEntry records a single action performed with an API key.
+type AuditEntry struct {
+	ID         int64  `json:"id"`
+	KeyID      int64  `json:"key_id"`
+	KeyName    string `json:"key_name"`
+	Action     string `json:"action"`
+	Target     string `json:"target"`
+	StatusCode int    `json:"status_code"`
+	RemoteAddr string `json:"remote_addr"`
+	CreatedAt  string `json:"created_at"`
+}
+
+// ValidScope checks if `scope` is one of the known scopes.
+func ValidScope(scope string) bool {
+	for _, s := range Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func joinScopes(scopes []string) string {
+	return strings.Join(scopes, ",")
+}
+
+func splitScopes(scopes string) []string {
+	if scopes == "" {
+		return []string{}
+	}
+	return strings.Split(scopes, ",")
+}
@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+)
+
+var (
+	queryKeyAdd = `insert into keys (name, hash, scopes, created_at) values ($1, $2, $3, datetime('now'))`
+	queryKeyGet = `
+		select id, name, scopes, created_at, coalesce(revoked_at, "") from keys
+		where hash = $1 and revoked_at is null`
+	queryKeyGetByID = `select id, name, scopes, created_at, coalesce(revoked_at, "") from keys where id = $1`
+	queryKeyList    = `select id, name, scopes, created_at, coalesce(revoked_at, "") from keys order by id`
+	queryKeyRevoke  = `update keys set revoked_at = datetime('now') where id = $1 and revoked_at is null`
+
+	queryAuditAdd = `
+		insert into audit_log (
+			key_id, key_name, action, target, status_code, remote_addr, created_at
+		) values (
+			$1, $2, $3, $4, $5, $6, datetime('now')
+		)`
+	queryAuditList = `
+		select id, key_id, key_name, action, target, status_code, remote_addr, created_at from audit_log
+		where ($1 = 0 or key_id = $1) order by id desc limit $2`
+)
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanKey(r rowScanner) (*Key, error) {
+	var (
+		k      Key
+		scopes string
+	)
+	if err := r.Scan(&k.ID, &k.Name, &scopes, &k.CreatedAt, &k.RevokedAt); err != nil {
+		return nil, err
+	}
+	k.Scopes = splitScopes(scopes)
+	return &k, nil
+}
+
+func (q *Queries) AddKey(ctx context.Context, name, hash string, scopes []string) (*Key, error) {
+	res, err := q.db.ExecContext(ctx, queryKeyAdd, name, hash, joinScopes(scopes))
+	if err != nil {
+		return nil, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	return q.GetKeyByID(ctx, id)
+}
+
+// GetKey returns a non-revoked key by its token hash.
+func (q *Queries) GetKey(ctx context.Context, hash string) (*Key, error) {
+	return scanKey(q.db.QueryRowContext(ctx, queryKeyGet, hash))
+}
+
+func (q *Queries) GetKeyByID(ctx context.Context, id int64) (*Key, error) {
+	return scanKey(q.db.QueryRowContext(ctx, queryKeyGetByID, id))
+}
+
+func (q *Queries) ListKeys(ctx context.Context) ([]*Key, error) {
+	keys := []*Key{}
+	rows, err := q.db.QueryContext(ctx, queryKeyList)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		k, err := scanKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+func (q *Queries) RevokeKey(ctx context.Context, id int64) error {
+	res, err := q.db.ExecContext(ctx, queryKeyRevoke, id)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (q *Queries) AddAuditEntry(ctx context.Context, e AuditEntry) error {
+	_, err := q.db.ExecContext(
+		ctx, queryAuditAdd,
+		e.KeyID, e.KeyName, e.Action, e.Target, e.StatusCode, e.RemoteAddr,
+	)
+	return err
+}
+
+// ListAuditEntries returns up to `limit` latest entries for key `keyID`, or for all keys if it's zero.
+func (q *Queries) ListAuditEntries(ctx context.Context, keyID int64, limit int) ([]*AuditEntry, error) {
+	entries := []*AuditEntry{}
+	rows, err := q.db.QueryContext(ctx, queryAuditList, keyID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var e AuditEntry
+		if err := rows.Scan(
+			&e.ID, &e.KeyID, &e.KeyName, &e.Action, &e.Target, &e.StatusCode, &e.RemoteAddr, &e.CreatedAt,
+		); err != nil {
+			return nil, err
+		}
+		entries = append(entries, &e)
+	}
+	return entries, rows.Err()
+}
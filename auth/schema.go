@@ -0,0 +1,53 @@
+package auth
+
+import "github.com/lbryio/transcoder/db"
+
+// Migrations contains all auth schema changes in the order they should be applied.
+var Migrations = []db.Migration{
+	{Name: "keys", SQL: KeysMigration},
+	{Name: "audit", SQL: AuditMigration},
+}
+
+var KeysMigration = `
+-- +migrate Up
+
+-- +migrate StatementBegin
+CREATE TABLE IF NOT EXISTS keys (
+    "id" integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+    "name" TEXT NOT NULL,
+    "hash" TEXT NOT NULL UNIQUE,
+    "scopes" TEXT NOT NULL,
+    "created_at" TEXT NOT NULL,
+    "revoked_at" TEXT
+);
+-- +migrate StatementEnd
+
+-- +migrate Down
+
+-- +migrate StatementBegin
+DROP TABLE keys;
+-- +migrate StatementEnd
+`
+
+var AuditMigration = `
+-- +migrate Up
+
+-- +migrate StatementBegin
+CREATE TABLE IF NOT EXISTS audit_log (
+    "id" integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+    "key_id" INTEGER NOT NULL,
+    "key_name" TEXT NOT NULL,
+    "action" TEXT NOT NULL,
+    "target" TEXT NOT NULL,
+    "status_code" INTEGER NOT NULL,
+    "remote_addr" TEXT NOT NULL,
+    "created_at" TEXT NOT NULL
+);
+-- +migrate StatementEnd
+
+-- +migrate Down
+
+-- +migrate StatementBegin
+DROP TABLE audit_log;
+-- +migrate StatementEnd
+`
@@ -1,8 +1,10 @@
 package client
 
 import (
+	"bytes"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"math"
 	"net"
 	"net/http"
@@ -16,7 +18,6 @@ import (
 
 	"github.com/lbryio/transcoder/pkg/logging"
 	"github.com/lbryio/transcoder/pkg/timer"
-	"github.com/lbryio/transcoder/video"
 
 	"github.com/karlseguin/ccache/v2"
 	"github.com/karrick/godirwalk"
@@ -41,6 +42,12 @@ const (
 	Prod
 )
 
+const (
+	prefetchSegments    = 3
+	prefetchConcurrency = 10
+	healthCheckInterval = 15 * time.Second
+)
+
 var (
 	ErrNotOK = errors.New("http response not OK")
 
@@ -57,16 +64,32 @@ type Client struct {
 	logger *zap.SugaredLogger
 
 	cache      *ccache.Cache
+	lookups    *ccache.Cache
 	streamURLs *sync.Map
+	prefetch   *prefetcher
+	servers    *serverPool
+	stop       chan struct{}
+	closeOnce  *sync.Once
+}
+
+// streamLocation is where stream fragments are retrieved from and the transcoder node that pointed to it.
+type streamLocation struct {
+	url    string
+	server *server
 }
 
 type Configuration struct {
 	cacheSize    int64
 	itemsToPrune uint32
-	server       string
+	servers      []string
 	videoPath    string
 	httpClient   HTTPRequester
 	logLevel     int
+
+	prefetchSegments    int
+	prefetchConcurrency int
+	healthCheckInterval time.Duration
+	negativeCacheTTL    time.Duration
 }
 
 type Fragment struct {
@@ -92,7 +115,11 @@ func Configure() *Configuration {
 				ResponseHeaderTimeout: 15 * time.Second,
 			},
 		},
-		logLevel: Prod,
+		logLevel:            Prod,
+		prefetchSegments:    prefetchSegments,
+		prefetchConcurrency: prefetchConcurrency,
+		healthCheckInterval: healthCheckInterval,
+		negativeCacheTTL:    negativeCacheTTL,
 	}
 }
 
@@ -110,7 +137,28 @@ func (c *Configuration) ItemsToPrune(i uint32) *Configuration {
 
 // Server sets transcoder server API address.
 func (c *Configuration) Server(server string) *Configuration {
-	c.server = server
+	c.servers = []string{server}
+	return c
+}
+
+// Servers sets API addresses of multiple transcoder nodes. Streams are routed to them by sd hash,
+// failing over to other nodes when one is unavailable.
+func (c *Configuration) Servers(servers ...string) *Configuration {
+	c.servers = servers
+	return c
+}
+
+// HealthCheckInterval sets how often transcoder nodes are checked when there are multiple of them.
+// Zero interval disables health checks.
+func (c *Configuration) HealthCheckInterval(i time.Duration) *Configuration {
+	c.healthCheckInterval = i
+	return c
+}
+
+// NegativeCacheTTL sets for how long streams found to be forbidden or missing are not looked up again.
+// Zero TTL disables caching of such results.
+func (c *Configuration) NegativeCacheTTL(ttl time.Duration) *Configuration {
+	c.negativeCacheTTL = ttl
 	return c
 }
 
@@ -131,6 +179,14 @@ func (c *Configuration) LogLevel(l int) *Configuration {
 	return c
 }
 
+// Prefetch sets how many segments following the requested one are fetched into cache in advance,
+// with up to `concurrency` segments being fetched at once. Zero `segments` disables prefetching.
+func (c *Configuration) Prefetch(segments, concurrency int) *Configuration {
+	c.prefetchSegments = segments
+	c.prefetchConcurrency = concurrency
+	return c
+}
+
 func (f Fragment) Size() int64 {
 	return f.size
 }
@@ -143,12 +199,18 @@ func New(cfg *Configuration) Client {
 	c := Client{
 		Configuration: cfg,
 		streamURLs:    &sync.Map{},
+		servers:       newServerPool(cfg.servers),
+		stop:          make(chan struct{}),
+		closeOnce:     &sync.Once{},
 	}
 	if c.logLevel == Dev {
 		c.logger = logging.Create("client", logging.Dev)
 	} else {
 		c.logger = logging.Create("client", logging.Prod)
 	}
+	if c.prefetchSegments > 0 && c.prefetchConcurrency > 0 {
+		c.prefetch = newPrefetcher(c.prefetchConcurrency)
+	}
 
 	c.cache = ccache.New(ccache.
 		Configure().
@@ -156,10 +218,23 @@ func New(cfg *Configuration) Client {
 		ItemsToPrune(c.itemsToPrune).
 		OnDelete(c.deleteCachedFragment),
 	)
-	c.logger.Infow("transcoder client configured", "cache_size", c.cacheSize, "server", c.server, "video_path", c.videoPath)
+	c.lookups = ccache.New(ccache.Configure().MaxSize(lookupCacheSize))
+	c.logger.Infow(
+		"transcoder client configured",
+		"cache_size", c.cacheSize, "servers", c.servers.servers, "video_path", c.videoPath, "prefetch_segments", c.prefetchSegments,
+	)
+	if len(c.servers.servers) > 1 && c.healthCheckInterval > 0 {
+		c.servers.checked = true
+		go c.checkHealthEvery(c.healthCheckInterval)
+	}
 	return c
 }
 
+// Close stops background activity of the client. It's safe to call it more than once.
+func (c Client) Close() {
+	c.closeOnce.Do(func() { close(c.stop) })
+}
+
 func newFragment(sdHash, name string, size int64) *Fragment {
 	return &Fragment{
 		path: path.Join(sdHash, name),
@@ -171,11 +246,22 @@ func newFragment(sdHash, name string, size int64) *Fragment {
 func (c Client) PlayFragment(lurl, sdHash, fragment string, w http.ResponseWriter, r *http.Request) error {
 	var ch string
 	ll := c.logger.With("lurl", lurl, "sd_hash", sdHash, "fragment", fragment)
+	segment := isSegment(fragment)
+	prefetched := false
+	if segment {
+		prefetched = c.waitPrefetch(cacheFragmentKey(sdHash, fragment))
+	}
 	fg, hit, err := c.getCachedFragment(lurl, sdHash, fragment)
 	if err != nil {
 		ll.Warnf("failed to serve fragment: %v", err)
 		return err
 	}
+	if segment {
+		if hit && prefetched {
+			PrefetchHits.Inc()
+		}
+		c.prefetchAfter(lurl, sdHash, fragment)
+	}
 
 	c.logger.Infow("serving fragment", "path", c.fullFragmentPath(fg), "cache_hit", hit)
 	if hit {
@@ -199,7 +285,7 @@ func (c Client) GetPlaybackPath(lurl, sdHash string) string {
 		return ""
 	}
 	c.logger.Debugw("playback path found", "lurl", lurl, "sd_hash", sdHash)
-	return fmt.Sprintf("%v/%v/%v", strings.Replace(lurl, "#", "/", 1), sdHash, MasterPlaylistName)
+	return fmt.Sprintf("%v/%v/%v", url.PathEscape(lurl), sdHash, MasterPlaylistName)
 }
 
 func cacheFragmentKey(sdHash, name string) string {
@@ -208,6 +294,10 @@ func cacheFragmentKey(sdHash, name string) string {
 
 func (c Client) deleteCachedFragment(i *ccache.Item) {
 	fg := i.Value().(*Fragment)
+	c.forgetPrefetched(cacheFragmentKey(path.Dir(fg.path), path.Base(fg.path)))
+	if path.Ext(fg.path) == ".m3u8" {
+		c.forgetSegments(path.Dir(fg.path))
+	}
 	path := path.Join(c.videoPath, fg.path)
 	err := os.RemoveAll(path)
 	if err != nil {
@@ -225,48 +315,97 @@ func (c Client) discardFragmentURL(sdHash string) {
 	c.streamURLs.Delete(sdHash)
 }
 
+// failStream discards location of the stream after its fragment could not be retrieved from a transcoder node,
+// so the stream is looked up again, on another node if that one is down.
+func (c Client) failStream(sdHash string, err error) bool {
+	d, ok := c.streamURLs.Load(sdHash)
+	if !ok {
+		return false
+	}
+	loc := d.(*streamLocation)
+	if loc.server == nil || !strings.HasPrefix(loc.url, loc.server.addr) {
+		return false
+	}
+	c.markDown(loc.server, err)
+	c.discardFragmentURL(sdHash)
+	return true
+}
+
 func (c Client) fragmentURL(lurl, sdHash, name string) (string, error) {
-	if d, ok := c.streamURLs.Load(sdHash); !ok {
-		// Getting root playlist location from transcoder.
-		res, err := c.fetch(c.server + fmt.Sprintf(hlsURLTemplate, url.PathEscape(lurl)))
-		if err != nil {
+	if d, ok := c.streamURLs.Load(sdHash); ok {
+		return d.(*streamLocation).url + name, nil
+	}
+	if err := c.cachedLookup(sdHash); err != nil {
+		return "", err
+	}
+
+	var err error
+	for i, srv := range c.servers.pick(sdHash) {
+		var streamURL string
+		streamURL, err = c.locateStream(srv, lurl, sdHash)
+		if err == nil {
+			c.streamURLs.Store(sdHash, &streamLocation{url: streamURL, server: srv})
+			return streamURL + name, nil
+		}
+		if _, ok := err.(serverError); !ok {
+			c.cacheLookup(sdHash, err)
 			return "", err
 		}
+		c.markDown(srv, err)
+		if i < len(c.servers.servers)-1 {
+			c.logger.Infow("transcoder server failed, trying another", "server", srv.addr, "sd_hash", sdHash, "err", err)
+			ServerFailovers.Inc()
+		}
+	}
+	if err == nil {
+		err = errors.New("no transcoder servers configured")
+	}
+	return "", err
+}
 
-		switch res.StatusCode {
-		case http.StatusForbidden:
-			TranscodedResult.WithLabelValues(resultForbidden).Inc()
-			return "", video.ErrChannelNotEnabled
-		case http.StatusNotFound:
-			TranscodedResult.WithLabelValues(resultNotFound).Inc()
-			return "", errors.New("stream not found")
-		case http.StatusAccepted:
-			TranscodedResult.WithLabelValues(resultUnderway).Inc()
-			c.logger.Debugw("stream encoding underway")
-			return "", errors.New("encoding underway")
-		case http.StatusSeeOther:
-			TranscodedResult.WithLabelValues(resultFound).Inc()
-			loc, err := res.Location()
-			if err != nil {
-				return "", err
-			}
-			streamURL := strings.TrimSuffix(loc.String(), MasterPlaylistName)
-			c.logger.Debugw("got stream URL", "stream_url", streamURL)
-			rsdHash := sdHashRe.FindStringSubmatch(streamURL)
-			if len(rsdHash) != 2 {
-				return "", fmt.Errorf("malformed remote sd hash in URL: %v", streamURL)
-			} else if rsdHash[1] != sdHash {
-				return "", fmt.Errorf("remote sd hash mismatch: %v != %v", sdHash, rsdHash[1])
-			}
-			c.streamURLs.Store(sdHash, streamURL)
-			return streamURL + name, nil
-		default:
-			c.logger.Warnw("unknown http status", "status_code", res.StatusCode)
-			return "", fmt.Errorf("unknown http status: %v", res.StatusCode)
+// locateStream retrieves stream location from transcoder node `srv`. Errors that should be retried
+// on another node are returned as serverError.
+func (c Client) locateStream(srv *server, lurl, sdHash string) (string, error) {
+	// Getting root playlist location from transcoder.
+	res, err := c.fetch(srv.addr + fmt.Sprintf(hlsURLTemplate, url.PathEscape(lurl)))
+	if err != nil {
+		return "", serverError{srv.addr, err}
+	}
+	defer res.Body.Close()
+
+	switch res.StatusCode {
+	case http.StatusForbidden:
+		TranscodedResult.WithLabelValues(resultForbidden).Inc()
+		return "", ErrForbidden
+	case http.StatusNotFound:
+		TranscodedResult.WithLabelValues(resultNotFound).Inc()
+		return "", ErrStreamNotFound
+	case http.StatusAccepted:
+		TranscodedResult.WithLabelValues(resultUnderway).Inc()
+		c.logger.Debugw("stream encoding underway")
+		return "", &UnderwayError{RetryAfter: parseRetryAfter(res.Header.Get("Retry-After"))}
+	case http.StatusSeeOther:
+		TranscodedResult.WithLabelValues(resultFound).Inc()
+		loc, err := res.Location()
+		if err != nil {
+			return "", err
 		}
-	} else {
-		streamURL, _ := d.(string)
-		return streamURL + name, nil
+		streamURL := strings.TrimSuffix(loc.String(), MasterPlaylistName)
+		c.logger.Debugw("got stream URL", "stream_url", streamURL, "server", srv.addr)
+		rsdHash := sdHashRe.FindStringSubmatch(streamURL)
+		if len(rsdHash) != 2 {
+			return "", fmt.Errorf("malformed remote sd hash in URL: %v", streamURL)
+		} else if rsdHash[1] != sdHash {
+			return "", fmt.Errorf("remote sd hash mismatch: %v != %v", sdHash, rsdHash[1])
+		}
+		return streamURL, nil
+	default:
+		c.logger.Warnw("unknown http status", "status_code", res.StatusCode, "server", srv.addr)
+		err := fmt.Errorf("unknown http status: %v", res.StatusCode)
+		if res.StatusCode >= http.StatusInternalServerError {
+			return "", serverError{srv.addr, err}
+		}
+		return "", err
 	}
 }
 
@@ -323,6 +462,9 @@ func (c Client) getCachedFragment(lurl, sdHash, name string) (*Fragment, bool, e
 			FetchCount.WithLabelValues(src).Inc()
 			if err != nil {
 				FetchFailureCount.WithLabelValues(src, "unknown").Inc()
+				if c.failStream(sdHash, err) {
+					return nil, errRefetch
+				}
 				return nil, err
 			}
 
@@ -334,6 +476,9 @@ func (c Client) getCachedFragment(lurl, sdHash, name string) (*Fragment, bool, e
 					c.discardFragmentURL(sdHash)
 					return nil, errRefetch
 				}
+				if r.StatusCode >= http.StatusInternalServerError && c.failStream(sdHash, fmt.Errorf("http status: %v", r.StatusCode)) {
+					return nil, errRefetch
+				}
 				return r, ErrNotOK
 			}
 
@@ -344,11 +489,24 @@ func (c Client) getCachedFragment(lurl, sdHash, name string) (*Fragment, bool, e
 				return nil, err
 			}
 			defer f.Close()
-			size, err := io.Copy(f, r.Body)
+			var body io.Reader = r.Body
+			var playlist []byte
+			if path.Ext(name) == ".m3u8" {
+				data, err := ioutil.ReadAll(r.Body)
+				if err != nil {
+					return nil, err
+				}
+				playlist = rewritePlaylist(data, strings.TrimSuffix(url, name))
+				body = bytes.NewReader(playlist)
+			}
+			size, err := io.Copy(f, body)
 
 			if err != nil {
 				return nil, err
 			}
+			if playlist != nil && name != MasterPlaylistName {
+				c.indexPlaylist(sdHash, playlist)
+			}
 
 			FetchSizeBytes.WithLabelValues(src).Add(float64(size))
 			c.logger.Debugw("saved fragment", "url", url, "size", size, "path", fpath)
@@ -367,6 +525,9 @@ func (c Client) getCachedFragment(lurl, sdHash, name string) (*Fragment, bool, e
 		}
 		break
 	}
+	if err != nil {
+		return nil, false, err
+	}
 
 	fg, _ := item.Value().(*Fragment)
 	if fg == nil {
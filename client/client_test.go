@@ -1,16 +1,28 @@
 package client
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/karrick/godirwalk"
 	"github.com/lbryio/transcoder/api"
 	"github.com/lbryio/transcoder/db"
+	"github.com/lbryio/transcoder/formats"
+	"github.com/lbryio/transcoder/pkg/claim"
 	"github.com/lbryio/transcoder/queue"
 	"github.com/lbryio/transcoder/storage"
 	"github.com/lbryio/transcoder/video"
@@ -18,12 +30,15 @@ import (
 )
 
 var streamURL = "lbry://@specialoperationstest#3/fear-of-death-inspirational#a"
+var apiAddr = "127.0.0.1:50808"
 var streamSDHash = "f12fb044f5805334a473bf9a81363d89bd1cb54c4065ac05be71a599a6c51efc6c6afb257208326af304324094105774"
 
 type ClientSuite struct {
 	suite.Suite
 	assetsPath string
 	apiServer  *api.APIServer
+	lib        *video.Library
+	resolver   *claim.MemoryResolver
 }
 
 func TestClientSuite(t *testing.T) {
@@ -38,9 +53,9 @@ func (s *ClientSuite) SetupTest() {
 	s.Require().NoError(os.MkdirAll(path.Join(s.assetsPath, "client"), os.ModePerm))
 
 	vdb := db.OpenDB(path.Join(s.assetsPath, "sqlite", "video.sqlite"))
-	vdb.MigrateUp(video.InitialMigration)
+	vdb.Migrate(video.Migrations...)
 	qdb := db.OpenDB(path.Join(s.assetsPath, "sqlite", "queue.sqlite"))
-	qdb.MigrateUp(queue.InitialMigration)
+	qdb.Migrate(queue.Migrations...)
 
 	lib := video.NewLibrary(
 		video.Configure().
@@ -48,17 +63,27 @@ func (s *ClientSuite) SetupTest() {
 			DB(vdb),
 	)
 	q := queue.NewQueue(qdb)
+	s.lib = lib
+	s.resolver = claim.NewMemoryResolver()
 
 	poller := q.StartPoller(1)
-	go video.SpawnProcessing(q, lib, poller)
+	go video.SpawnProcessing(q, lib, poller, video.ProcessingOpts{Resolver: s.resolver, Fetcher: claim.NewFileFetcher()})
 	s.apiServer = api.NewServer(
 		api.Configure().
 			Debug(true).
-			Addr("127.0.0.1:50808").
+			Addr(apiAddr).
 			VideoPath(path.Join(s.assetsPath, "videos")).
-			VideoManager(api.NewManager(q, lib)),
+			VideoManager(api.NewManager(q, lib).Resolver(s.resolver)),
 	)
 	go s.apiServer.Start()
+	s.Require().Eventually(func() bool {
+		conn, err := net.Dial("tcp", apiAddr)
+		if err != nil {
+			return false
+		}
+		conn.Close()
+		return true
+	}, 5*time.Second, 10*time.Millisecond)
 
 	video.LoadEnabledChannels(
 		[]string{
@@ -67,7 +92,7 @@ func (s *ClientSuite) SetupTest() {
 }
 
 func (s *ClientSuite) TearDownTest() {
-	go s.apiServer.Shutdown()
+	s.Require().NoError(s.apiServer.Shutdown())
 	s.Require().NoError(os.RemoveAll(s.assetsPath))
 }
 
@@ -104,6 +129,302 @@ func (s *ClientSuite) TestRestoreCache() {
 	}
 }
 
+// stubRequester serves streams from testdata, redirecting HLS requests to them like the transcoder does.
+type stubRequester struct {
+	sync.Mutex
+	requested map[string]int
+}
+
+func (r *stubRequester) Do(req *http.Request) (*http.Response, error) {
+	r.Lock()
+	r.requested[path.Base(req.URL.Path)]++
+	r.Unlock()
+	res := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Request: req, Body: ioutil.NopCloser(bytes.NewReader(nil))}
+	if strings.HasPrefix(req.URL.Path, "/api/v1/video/hls/") {
+		res.StatusCode = http.StatusSeeOther
+		res.Header.Set("Location", fmt.Sprintf("http://stub/streams/%v/%v", streamSDHash, MasterPlaylistName))
+		return res, nil
+	}
+	name := path.Base(req.URL.Path)
+	data := make([]byte, 10000)
+	if path.Ext(name) == ".m3u8" {
+		var err error
+		data, err = ioutil.ReadFile(path.Join("testdata", "dummystream", name))
+		if err != nil {
+			res.StatusCode = http.StatusNotFound
+			return res, nil
+		}
+	}
+	res.Body = ioutil.NopCloser(bytes.NewReader(data))
+	return res, nil
+}
+
+func (r *stubRequester) count(name string) int {
+	r.Lock()
+	defer r.Unlock()
+	return r.requested[name]
+}
+
+func (s *ClientSuite) TestPrefetch() {
+	dstPath := path.Join(s.assetsPath, "TestPrefetch")
+	stub := &stubRequester{requested: map[string]int{}}
+	c := New(Configure().Server("http://stub").VideoPath(dstPath).HTTPClient(stub).Prefetch(2, 5))
+
+	play := func(name string) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		err := c.PlayFragment(streamURL, streamSDHash, name, rr, httptest.NewRequest(http.MethodGet, "/"+name, nil))
+		s.Require().NoError(err)
+		return rr
+	}
+
+	play(MasterPlaylistName)
+	play("stream_0.m3u8")
+	s.Equal(cacheHeaderMiss, play("seg_0_000000.ts").Header().Get(cacheHeader))
+
+	s.Eventually(func() bool {
+		return stub.count("seg_0_000001.ts") == 1 && stub.count("seg_0_000002.ts") == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	s.Equal(0, stub.count("seg_0_000003.ts"))
+
+	s.Equal(cacheHeaderHit, play("seg_0_000001.ts").Header().Get(cacheHeader))
+	s.Eventually(func() bool {
+		return stub.count("seg_0_000003.ts") == 1
+	}, 5*time.Second, 10*time.Millisecond)
+	s.Equal(1, stub.count("seg_0_000001.ts"))
+	s.Equal(1, stub.count("seg_0_000002.ts"))
+
+	s.Equal([]string{"seg_0_000077.ts"}, c.nextSegments(streamSDHash, "seg_0_000076.ts", 3))
+	s.Empty(c.nextSegments(streamSDHash, "seg_0_000077.ts", 3))
+
+	// Playlists cached after the stream was indexed are added to its index.
+	s.Empty(c.nextSegments(streamSDHash, "seg_1_000000.ts", 3))
+	play("stream_1.m3u8")
+	s.Equal([]string{"seg_1_000001.ts", "seg_1_000002.ts"}, c.nextSegments(streamSDHash, "seg_1_000000.ts", 2))
+
+	c.cache.Delete(cacheFragmentKey(streamSDHash, "stream_1.m3u8"))
+	s.Eventually(func() bool {
+		_, ok := c.prefetch.segments.Load(streamSDHash)
+		return !ok
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+type requesterFunc func(*http.Request) (*http.Response, error)
+
+func (f requesterFunc) Do(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func (s *ClientSuite) TestServerFailover() {
+	var failing sync.Map
+	stub := requesterFunc(func(req *http.Request) (*http.Response, error) {
+		if _, ok := failing.Load(req.URL.Host); ok {
+			return nil, errors.New("connection refused")
+		}
+		res := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}, Request: req, Body: ioutil.NopCloser(bytes.NewReader(nil))}
+		if strings.HasPrefix(req.URL.Path, "/api/v1/video/hls/") {
+			res.StatusCode = http.StatusSeeOther
+			res.Header.Set("Location", fmt.Sprintf("http://%v/streams/%v/%v", req.URL.Host, streamSDHash, MasterPlaylistName))
+		}
+		return res, nil
+	})
+	c := New(Configure().
+		Servers("http://node1", "http://node2", "http://node3").
+		HealthCheckInterval(0).
+		VideoPath(path.Join(s.assetsPath, "TestServerFailover")).
+		HTTPClient(stub))
+	// Health is tracked without the background checks to keep the test deterministic.
+	c.servers.checked = true
+
+	order := c.servers.pick(streamSDHash)
+	s.Require().Len(order, 3)
+	s.Equal(order, c.servers.pick(streamSDHash))
+	first := map[string]int{}
+	for range [300]int{} {
+		first[c.servers.pick(randomString(96))[0].addr]++
+	}
+	s.Len(first, 3)
+
+	failing.Store(strings.TrimPrefix(order[0].addr, "http://"), true)
+	u, err := c.fragmentURL(streamURL, streamSDHash, MasterPlaylistName)
+	s.Require().NoError(err)
+	s.Equal(fmt.Sprintf("%v/streams/%v/%v", order[1].addr, streamSDHash, MasterPlaylistName), u)
+	s.False(order[0].healthy())
+	s.Equal([]*server{order[1], order[2], order[0]}, c.servers.pick(streamSDHash))
+
+	d, ok := c.streamURLs.Load(streamSDHash)
+	s.Require().True(ok)
+	s.Equal(order[1], d.(*streamLocation).server)
+
+	s.True(c.failStream(streamSDHash, errors.New("timeout")))
+	s.False(order[1].healthy())
+	u, err = c.fragmentURL(streamURL, streamSDHash, MasterPlaylistName)
+	s.Require().NoError(err)
+	s.Equal(fmt.Sprintf("%v/streams/%v/%v", order[2].addr, streamSDHash, MasterPlaylistName), u)
+
+	failing.Delete(strings.TrimPrefix(order[0].addr, "http://"))
+	c.checkHealth()
+	s.True(order[0].healthy())
+	s.True(order[1].healthy())
+
+	s.NotPanics(func() {
+		c.Close()
+		c.Close()
+	})
+}
+
+func (s *ClientSuite) TestHandler() {
+	stub := &stubRequester{requested: map[string]int{}}
+	statuses := map[string]int{"underway": http.StatusAccepted, "blocked": http.StatusForbidden, "missing": http.StatusNotFound}
+	c := New(Configure().
+		Server("http://stub").
+		VideoPath(path.Join(s.assetsPath, "TestHandler")).
+		Prefetch(0, 0).
+		HTTPClient(requesterFunc(func(req *http.Request) (*http.Response, error) {
+			for claim, status := range statuses {
+				if strings.Contains(req.URL.Path, claim) {
+					return &http.Response{StatusCode: status, Header: http.Header{}, Body: ioutil.NopCloser(bytes.NewReader(nil))}, nil
+				}
+			}
+			return stub.Do(req)
+		})))
+	h := c.Handler()
+	serve := func(method, p string, header http.Header) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		req := httptest.NewRequest(method, p, nil)
+		for k, v := range header {
+			req.Header[k] = v
+		}
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+
+	claimPath := "/vanquish-trailer/b7b150d1bbca4650ad4ab921dd8d424bf77c1141/" + streamSDHash
+	rr := serve(http.MethodGet, claimPath+"/"+MasterPlaylistName, nil)
+	s.Equal(http.StatusOK, rr.Code)
+	s.Contains(rr.Body.String(), "stream_0.m3u8")
+	s.Equal(1, stub.count("vanquish-trailer#b7b150d1bbca4650ad4ab921dd8d424bf77c1141"))
+
+	rr = serve(http.MethodHead, claimPath+"/"+MasterPlaylistName, nil)
+	s.Equal(http.StatusOK, rr.Code)
+	s.Equal(cacheHeaderHit, rr.Header().Get(cacheHeader))
+	s.Empty(rr.Body.Bytes())
+
+	rr = serve(http.MethodGet, claimPath+"/seg_0_000000.ts", http.Header{"Range": {"bytes=0-99"}})
+	s.Equal(http.StatusPartialContent, rr.Code)
+	s.Len(rr.Body.Bytes(), 100)
+
+	s.Equal(http.StatusMethodNotAllowed, serve(http.MethodPost, claimPath+"/seg_0_000000.ts", nil).Code)
+	s.Equal(http.StatusNotFound, serve(http.MethodGet, "/vanquish-trailer/abc/master.m3u8", nil).Code)
+	s.Equal(http.StatusNotFound, serve(http.MethodGet, claimPath+"/..", nil).Code)
+
+	// Paths of stream URLs with channels go through the handler intact.
+	pp := c.GetPlaybackPath(streamURL, streamSDHash)
+	s.Require().NotEmpty(pp)
+	lurl, sdHash, name, ok := parsePlaybackPath("/" + pp)
+	s.Require().True(ok)
+	s.Equal([]string{streamURL, streamSDHash, MasterPlaylistName}, []string{lurl, sdHash, name})
+	rr = serve(http.MethodGet, "/"+pp, nil)
+	s.Equal(http.StatusOK, rr.Code)
+	s.Contains(rr.Body.String(), "stream_0.m3u8")
+	s.Equal(http.StatusOK, serve(http.MethodGet, "/"+path.Dir(pp)+"/stream_0.m3u8", nil).Code)
+
+	s.Equal(http.StatusAccepted, serve(http.MethodGet, "/underway/1/"+strings.Repeat("a", 96)+"/master.m3u8", nil).Code)
+	s.Equal(http.StatusForbidden, serve(http.MethodGet, "/blocked/1/"+strings.Repeat("b", 96)+"/master.m3u8", nil).Code)
+	s.Equal(http.StatusNotFound, serve(http.MethodGet, "/missing/1/"+strings.Repeat("c", 96)+"/master.m3u8", nil).Code)
+}
+
+func (s *ClientSuite) TestNegativeCache() {
+	var lookups sync.Map
+	stub := requesterFunc(func(req *http.Request) (*http.Response, error) {
+		claim := path.Base(req.URL.Path)
+		n, _ := lookups.LoadOrStore(claim, new(int32))
+		atomic.AddInt32(n.(*int32), 1)
+		res := &http.Response{Header: http.Header{}, Body: ioutil.NopCloser(bytes.NewReader(nil))}
+		switch claim {
+		case "missing":
+			res.StatusCode = http.StatusNotFound
+		case "blocked":
+			res.StatusCode = http.StatusForbidden
+		case "underway":
+			res.StatusCode = http.StatusAccepted
+			res.Header.Set("Retry-After", "1")
+		}
+		return res, nil
+	})
+	count := func(claim string) int32 {
+		n, ok := lookups.Load(claim)
+		if !ok {
+			return 0
+		}
+		return atomic.LoadInt32(n.(*int32))
+	}
+	c := New(Configure().Server("http://stub").VideoPath(path.Join(s.assetsPath, "TestNegativeCache")).HTTPClient(stub))
+
+	for range [3]int{} {
+		_, err := c.fragmentURL("missing", strings.Repeat("a", 96), MasterPlaylistName)
+		s.Equal(ErrStreamNotFound, err)
+		_, err = c.fragmentURL("blocked", strings.Repeat("b", 96), MasterPlaylistName)
+		s.Equal(ErrForbidden, err)
+	}
+	s.EqualValues(1, count("missing"))
+	s.EqualValues(1, count("blocked"))
+
+	_, err := c.fragmentURL("underway", strings.Repeat("c", 96), MasterPlaylistName)
+	var ue *UnderwayError
+	s.Require().True(errors.As(err, &ue))
+	s.Equal(time.Second, ue.RetryAfter)
+	_, err = c.fragmentURL("underway", strings.Repeat("c", 96), MasterPlaylistName)
+	s.True(errors.Is(err, ErrEncodingUnderway))
+	s.EqualValues(1, count("underway"))
+	time.Sleep(1100 * time.Millisecond)
+	_, err = c.fragmentURL("underway", strings.Repeat("c", 96), MasterPlaylistName)
+	s.True(errors.Is(err, ErrEncodingUnderway))
+	s.EqualValues(2, count("underway"))
+
+	c = New(Configure().Server("http://stub").NegativeCacheTTL(0).VideoPath(path.Join(s.assetsPath, "TestNegativeCache")).HTTPClient(stub))
+	c.fragmentURL("missing", strings.Repeat("a", 96), MasterPlaylistName)
+	c.fragmentURL("missing", strings.Repeat("a", 96), MasterPlaylistName)
+	s.EqualValues(3, count("missing"))
+}
+
+func (s *ClientSuite) TestParseRetryAfter() {
+	s.Equal(2*time.Minute, parseRetryAfter("120"))
+	s.Equal(maxRetryAfter, parseRetryAfter("100000"))
+	s.Equal(time.Duration(0), parseRetryAfter("soon"))
+	s.Equal(time.Duration(0), parseRetryAfter(""))
+	d := parseRetryAfter(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+	s.True(d > 58*time.Second && d <= time.Minute, d)
+}
+
+func (s *ClientSuite) TestRewritePlaylist() {
+	base := "https://cdn.example.com/videos/" + streamSDHash + "/"
+	in := strings.Join([]string{
+		"#EXTM3U",
+		`#EXT-X-KEY:METHOD=AES-128,URI="https://api.example.com/api/v1/key/abc"`,
+		`#EXT-X-MAP:URI="` + base + `init.mp4"`,
+		"#EXTINF:10.0,",
+		base + "seg_0_000000.ts",
+		"#EXTINF:10.0,",
+		"seg_0_000001.ts",
+		"#EXTINF:10.0,",
+		"https://elsewhere.example.com/seg_0_000002.ts",
+		"",
+	}, "\n")
+	out := strings.Join([]string{
+		"#EXTM3U",
+		`#EXT-X-KEY:METHOD=AES-128,URI="https://api.example.com/api/v1/key/abc"`,
+		`#EXT-X-MAP:URI="init.mp4"`,
+		"#EXTINF:10.0,",
+		"seg_0_000000.ts",
+		"#EXTINF:10.0,",
+		"seg_0_000001.ts",
+		"#EXTINF:10.0,",
+		"https://elsewhere.example.com/seg_0_000002.ts",
+		"",
+	}, "\n")
+	s.Equal(out, string(rewritePlaylist([]byte(in), base)))
+}
+
 func (s *ClientSuite) Test_sdHashRe() {
 	m := sdHashRe.FindStringSubmatch("http://t0.lbry.tv:18081/streams/85e8ad21f40550ebf0f30f7a0f6f092e8c62c7c697138e977087ac7b7f29554f8e0270447922493ff564457b60f45b18/master.m3u8")
 	s.Equal("85e8ad21f40550ebf0f30f7a0f6f092e8c62c7c697138e977087ac7b7f29554f8e0270447922493ff564457b60f45b18", m[1])
@@ -111,7 +432,25 @@ func (s *ClientSuite) Test_sdHashRe() {
 
 func (s *ClientSuite) Test_fragmentURL() {
 	dstPath := path.Join(s.assetsPath, "Test_fragmentURL")
-	c := New(Configure().Server("http://t0.lbry.tv:18081").VideoPath(dstPath).LogLevel(Dev))
+	// Keep-alive connections would hold up server shutdown in TearDownTest.
+	httpClient := &http.Client{
+		Transport: &http.Transport{DisableKeepAlives: true},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	c := New(Configure().Server("http://" + apiAddr).VideoPath(dstPath).LogLevel(Dev).HTTPClient(httpClient))
+
+	morganSDHash := "9a6de9ce8f0c2c08e4e0f69ba1b1a3f40e8c9e4ab0a7e3d94fe6a1d1b6e1e1c0b3f5e0ac9f5c1e7a2d6c8f4e2b1a7d3c"
+	vanquishURL := "vanquish-trailer-(2021)-morgan-freeman,#b7b150d1bbca4650ad4ab921dd8d424bf77c1141"
+	vanquishSDHash := "bec50ab288153ed03b0eb8dafd814daf19a187e07f8da4ad91cf778f5c39ac74d9d92ad6e3ebf2ddb6b7acea3cb8893a"
+	for uri, sdHash := range map[string]string{"morgan": morganSDHash, vanquishURL: vanquishSDHash} {
+		cl, err := claim.NewFakeClaim("lbry://"+uri, randomString(40), sdHash, "")
+		s.Require().NoError(err)
+		s.resolver.Add(uri, cl)
+		_, err = s.lib.Add(video.AddParams{SDHash: sdHash, URL: "lbry://" + uri, Path: sdHash, Type: formats.TypeHLS})
+		s.Require().NoError(err)
+	}
 
 	u, err := c.fragmentURL("morgan", "0b8dfc049b2165fad5829aca24f2ddfae3acef8d73bc5e04ff8b932fce9fc463dc6cf3e638413f04536638d2e7218427", "master.m3u8")
 	s.Require().Error(err)
@@ -123,17 +462,14 @@ func (s *ClientSuite) Test_fragmentURL() {
 	s.Regexp("remote sd hash mismatch", err.Error())
 	s.Equal("", u)
 
-	u, err = c.fragmentURL("vanquish-trailer-(2021)-morgan-freeman,#b7b150d1bbca4650ad4ab921dd8d424bf77c1141", "azazaz", "master.m3u8")
+	u, err = c.fragmentURL(vanquishURL, "azazaz", "master.m3u8")
 	s.Require().Error(err)
 	s.Regexp("remote sd hash mismatch", err.Error())
 	s.Equal("", u)
 
-	u, err = c.fragmentURL(
-		"vanquish-trailer-(2021)-morgan-freeman,#b7b150d1bbca4650ad4ab921dd8d424bf77c1141",
-		"bec50ab288153ed03b0eb8dafd814daf19a187e07f8da4ad91cf778f5c39ac74d9d92ad6e3ebf2ddb6b7acea3cb8893a",
-		"master.m3u8")
+	u, err = c.fragmentURL(vanquishURL, vanquishSDHash, "master.m3u8")
 	s.Require().NoError(err)
-	s.Equal("http://t0.lbry.tv:18081/streams/bec50ab288153ed03b0eb8dafd814daf19a187e07f8da4ad91cf778f5c39ac74d9d92ad6e3ebf2ddb6b7acea3cb8893a/master.m3u8", u)
+	s.Equal(fmt.Sprintf("http://%v/streams/%v/master.m3u8", apiAddr, vanquishSDHash), u)
 }
 
 func randomString(n int) string {
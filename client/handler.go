@@ -0,0 +1,118 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"math"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+var (
+	fragmentNameRe = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9_.-]*$`)
+	uriAttrRe      = regexp.MustCompile(`URI="([^"]*)"`)
+)
+
+// Handler serves streams through the client cache at paths returned by GetPlaybackPath:
+// `/{claim}/{sdHash}/{file}`, where `{claim}` is the path-escaped stream URL.
+// Paths with `{claim}` being the stream URL with `#` replaced by `/`, which older clients produced, are accepted too.
+// Use http.StripPrefix to mount it under a path prefix.
+func (c Client) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+			return
+		}
+		lurl, sdHash, name, ok := parsePlaybackPath(r.URL.EscapedPath())
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		if err := c.PlayFragment(lurl, sdHash, name, w, r); err != nil {
+			var ue *UnderwayError
+			if errors.As(err, &ue) && ue.RetryAfter > 0 {
+				w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(ue.RetryAfter.Seconds()))))
+			}
+			http.Error(w, err.Error(), errorStatus(err))
+		}
+	})
+}
+
+// parsePlaybackPath splits escaped path produced by GetPlaybackPath into stream URL, sd hash and fragment name.
+func parsePlaybackPath(p string) (string, string, string, bool) {
+	parts := strings.Split(strings.TrimPrefix(p, "/"), "/")
+	if len(parts) < 3 {
+		return "", "", "", false
+	}
+	name := parts[len(parts)-1]
+	sdHash := parts[len(parts)-2]
+	lurl := strings.Join(parts[:len(parts)-2], "/")
+	if lurl == "" || !fragmentNameRe.MatchString(name) {
+		return "", "", "", false
+	}
+	if m := sdHashRe.FindStringSubmatch("/" + sdHash + "/"); len(m) != 2 || m[1] != sdHash {
+		return "", "", "", false
+	}
+	if len(parts) > 3 {
+		lurl = strings.Replace(lurl, "/", "#", 1)
+	}
+	lurl, err := url.PathUnescape(lurl)
+	if err != nil {
+		return "", "", "", false
+	}
+	return lurl, sdHash, name, true
+}
+
+// errorStatus maps errors returned by PlayFragment to HTTP status codes.
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, ErrEncodingUnderway):
+		return http.StatusAccepted
+	case errors.Is(err, ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, ErrStreamNotFound):
+		return http.StatusNotFound
+	}
+	return http.StatusBadGateway
+}
+
+// rewritePlaylist makes URIs located under `base` relative, so playlists retrieved from a remote location
+// refer to fragments through the client instead of fetching them directly.
+func rewritePlaylist(data []byte, base string) []byte {
+	if base == "" {
+		return data
+	}
+	relative := func(uri string) string {
+		if strings.HasPrefix(uri, base) && fragmentNameRe.MatchString(uri[len(base):]) {
+			return uri[len(base):]
+		}
+		return uri
+	}
+
+	out := &bytes.Buffer{}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		l := sc.Text()
+		switch {
+		case strings.HasPrefix(l, "#EXT-X-MAP:"), strings.HasPrefix(l, "#EXT-X-MEDIA:"),
+			strings.HasPrefix(l, "#EXT-X-I-FRAME-STREAM-INF:"):
+			l = uriAttrRe.ReplaceAllStringFunc(l, func(attr string) string {
+				return `URI="` + relative(uriAttrRe.FindStringSubmatch(attr)[1]) + `"`
+			})
+		case l != "" && !strings.HasPrefix(l, "#"):
+			l = relative(strings.TrimSpace(l))
+		}
+		out.WriteString(l)
+		out.WriteByte('\n')
+	}
+	if sc.Err() != nil {
+		return data
+	}
+	return out.Bytes()
+}
@@ -0,0 +1,98 @@
+package client
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/lbryio/transcoder/video"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	negativeCacheTTL = 5 * time.Minute
+	// maxRetryAfter caps Retry-After hints for underway streams, so they are not postponed indefinitely.
+	maxRetryAfter = 5 * time.Minute
+	// lookupCacheSize is the number of stream lookup results kept in memory.
+	lookupCacheSize = 10000
+)
+
+var (
+	// ErrForbidden means that transcoder refuses to transcode or serve the stream.
+	// It's the same as video.ErrChannelNotEnabled which is returned for such streams historically.
+	ErrForbidden = video.ErrChannelNotEnabled
+	// ErrStreamNotFound means that the stream does not exist.
+	ErrStreamNotFound = errors.New("stream not found")
+	// ErrEncodingUnderway means that the stream is being transcoded and is not available yet.
+	// Returned errors are UnderwayError, use errors.Is to check for it.
+	ErrEncodingUnderway = errors.New("encoding underway")
+)
+
+// UnderwayError is returned for streams being transcoded. RetryAfter is the time to wait until
+// the stream is checked again as suggested by transcoder, zero if it has no suggestion.
+type UnderwayError struct {
+	RetryAfter time.Duration
+}
+
+func (e *UnderwayError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("%v, retry after %v", ErrEncodingUnderway, e.RetryAfter)
+	}
+	return ErrEncodingUnderway.Error()
+}
+
+func (e *UnderwayError) Is(target error) bool {
+	return target == ErrEncodingUnderway
+}
+
+// parseRetryAfter reads Retry-After header value given in seconds or as HTTP date.
+func parseRetryAfter(v string) time.Duration {
+	var d time.Duration
+	if s, err := strconv.Atoi(v); err == nil {
+		d = time.Duration(s) * time.Second
+	} else if t, err := http.ParseTime(v); err == nil {
+		d = time.Until(t)
+	}
+	if d < 0 {
+		return 0
+	}
+	if d > maxRetryAfter {
+		return maxRetryAfter
+	}
+	return d
+}
+
+// cachedLookup returns an unexpired failed stream lookup result.
+func (c Client) cachedLookup(sdHash string) error {
+	item := c.lookups.Get(sdHash)
+	if item == nil || item.Expired() {
+		return nil
+	}
+	err := item.Value().(error)
+	if errors.Is(err, ErrEncodingUnderway) {
+		NegativeCacheHits.WithLabelValues(resultUnderway).Inc()
+		return &UnderwayError{RetryAfter: item.TTL().Round(time.Second)}
+	}
+	if err == ErrForbidden {
+		NegativeCacheHits.WithLabelValues(resultForbidden).Inc()
+	} else {
+		NegativeCacheHits.WithLabelValues(resultNotFound).Inc()
+	}
+	return err
+}
+
+// cacheLookup remembers a failed stream lookup, so transcoder isn't asked about the stream again for a while.
+// Forbidden and not found streams are kept for NegativeCacheTTL, underway ones until their Retry-After.
+func (c Client) cacheLookup(sdHash string, err error) {
+	var ttl time.Duration
+	if ue, ok := err.(*UnderwayError); ok {
+		ttl = ue.RetryAfter
+	} else if err == ErrForbidden || err == ErrStreamNotFound {
+		ttl = c.negativeCacheTTL
+	}
+	if ttl > 0 {
+		c.lookups.Set(sdHash, err, ttl)
+	}
+}
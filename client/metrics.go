@@ -13,6 +13,11 @@ const (
 
 	fetchSourceRemote = "remote"
 	fetchSourceLocal  = "local"
+
+	prefetchFetched = "fetched"
+	prefetchFailed  = "failed"
+	prefetchSkipped = "skipped"
+	prefetchWasted  = "wasted"
 )
 
 var (
@@ -45,4 +50,28 @@ var (
 	FetchFailureCount = promauto.NewCounterVec(prometheus.CounterOpts{
 		Name: "fetch_failure_count",
 	}, []string{"source", "http_code"})
+
+	// NegativeCacheHits counts stream lookups answered from cache of forbidden, missing and underway streams.
+	NegativeCacheHits = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "negative_cache_hits",
+	}, []string{"type"})
+
+	// ServerHealthy reports transcoder nodes availability: 1 if healthy, 0 if down.
+	ServerHealthy = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "transcoder_server_healthy",
+	}, []string{"server"})
+	// ServerFailovers counts stream lookups retried on another transcoder node.
+	ServerFailovers = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "transcoder_server_failovers",
+	})
+
+	// PrefetchResult counts outcomes of segment prefetches: fetched, failed, skipped for lack of free slots
+	// and wasted, i.e. evicted from cache without being requested.
+	PrefetchResult = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "prefetch_result",
+	}, []string{"result"})
+	// PrefetchHits counts segment requests served from cache thanks to prefetching.
+	PrefetchHits = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "prefetch_hits",
+	})
 )
@@ -0,0 +1,214 @@
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"sync"
+)
+
+// prefetcher keeps track of segments being fetched ahead of playback.
+type prefetcher struct {
+	// slots bounds the number of segments prefetched concurrently.
+	slots chan struct{}
+	// inflight maps cache keys of segments being prefetched to channels closed when they're done.
+	inflight *sync.Map
+	// fetched holds cache keys of prefetched segments that haven't been requested yet.
+	fetched *sync.Map
+	// segments maps sd hashes of streams to their segmentIndex.
+	segments *sync.Map
+	// indexLock serializes building and updating of segment indexes.
+	indexLock sync.Mutex
+}
+
+// segmentIndex maps segment names of a stream to segments following them in their media playlists.
+type segmentIndex map[string][]string
+
+func newPrefetcher(concurrency int) *prefetcher {
+	return &prefetcher{
+		slots:    make(chan struct{}, concurrency),
+		inflight: &sync.Map{},
+		fetched:  &sync.Map{},
+		segments: &sync.Map{},
+	}
+}
+
+func (idx segmentIndex) add(segments []string) {
+	for i, s := range segments {
+		idx[s] = segments[i+1:]
+	}
+}
+
+// isSegment checks if fragment `name` is a media segment rather than a playlist.
+func isSegment(name string) bool {
+	return path.Ext(name) != ".m3u8"
+}
+
+// waitPrefetch blocks until the segment under `key` is prefetched, if that's underway,
+// and reports if it was prefetched before being requested.
+func (c Client) waitPrefetch(key string) bool {
+	if c.prefetch == nil {
+		return false
+	}
+	if ch, ok := c.prefetch.inflight.Load(key); ok {
+		<-ch.(chan struct{})
+	}
+	_, ok := c.prefetch.fetched.LoadAndDelete(key)
+	return ok
+}
+
+// prefetchAfter starts fetching segments following `name` in its media playlist into the cache.
+// Segments are skipped when all prefetch slots are busy.
+func (c Client) prefetchAfter(lurl, sdHash, name string) {
+	if c.prefetch == nil {
+		return
+	}
+	for _, next := range c.nextSegments(sdHash, name, c.prefetchSegments) {
+		key := cacheFragmentKey(sdHash, next)
+		if c.cache.Get(key) != nil {
+			continue
+		}
+		done := make(chan struct{})
+		if _, loaded := c.prefetch.inflight.LoadOrStore(key, done); loaded {
+			continue
+		}
+		select {
+		case c.prefetch.slots <- struct{}{}:
+		default:
+			c.prefetch.inflight.Delete(key)
+			close(done)
+			PrefetchResult.WithLabelValues(prefetchSkipped).Inc()
+			continue
+		}
+		go func(next, key string, done chan struct{}) {
+			defer func() {
+				c.prefetch.inflight.Delete(key)
+				close(done)
+				<-c.prefetch.slots
+			}()
+			_, hit, err := c.getCachedFragment(lurl, sdHash, next)
+			switch {
+			case err != nil:
+				c.logger.Debugw("segment prefetch failed", "sd_hash", sdHash, "fragment", next, "err", err)
+				PrefetchResult.WithLabelValues(prefetchFailed).Inc()
+			case !hit:
+				c.prefetch.fetched.Store(key, struct{}{})
+				PrefetchResult.WithLabelValues(prefetchFetched).Inc()
+			}
+		}(next, key, done)
+	}
+}
+
+// forgetPrefetched is called when a fragment is removed from the cache, counting prefetched segments
+// that were never requested.
+func (c Client) forgetPrefetched(key string) {
+	if c.prefetch == nil {
+		return
+	}
+	if _, ok := c.prefetch.fetched.LoadAndDelete(key); ok {
+		PrefetchResult.WithLabelValues(prefetchWasted).Inc()
+	}
+}
+
+// nextSegments returns up to `k` segments following `name` in the cached media playlist that lists it.
+func (c Client) nextSegments(sdHash, name string, k int) []string {
+	if k < 1 {
+		return nil
+	}
+	next := c.segmentIndex(sdHash)[name]
+	if len(next) > k {
+		next = next[:k]
+	}
+	return next
+}
+
+// segmentIndex returns segment index of the stream, parsing its cached media playlists on first use.
+// Playlists cached later are added to the index by indexPlaylist.
+func (c Client) segmentIndex(sdHash string) segmentIndex {
+	if idx, ok := c.prefetch.segments.Load(sdHash); ok {
+		return idx.(segmentIndex)
+	}
+	c.prefetch.indexLock.Lock()
+	defer c.prefetch.indexLock.Unlock()
+	if idx, ok := c.prefetch.segments.Load(sdHash); ok {
+		return idx.(segmentIndex)
+	}
+
+	idx := segmentIndex{}
+	dir := path.Join(c.videoPath, sdHash)
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return idx
+	}
+	for _, e := range entries {
+		if e.IsDir() || e.Name() == MasterPlaylistName || path.Ext(e.Name()) != ".m3u8" {
+			continue
+		}
+		segments, err := playlistSegments(path.Join(dir, e.Name()))
+		if err != nil {
+			continue
+		}
+		idx.add(segments)
+	}
+	c.prefetch.segments.Store(sdHash, idx)
+	return idx
+}
+
+// indexPlaylist adds segments of a freshly cached media playlist to the segment index of the stream, if it's built.
+func (c Client) indexPlaylist(sdHash string, data []byte) {
+	if c.prefetch == nil {
+		return
+	}
+	c.prefetch.indexLock.Lock()
+	defer c.prefetch.indexLock.Unlock()
+	cur, ok := c.prefetch.segments.Load(sdHash)
+	if !ok {
+		return
+	}
+	segments, err := readSegments(bytes.NewReader(data))
+	if err != nil {
+		return
+	}
+	// Indexes are read without locking, so a copy is updated.
+	idx := segmentIndex{}
+	for s, next := range cur.(segmentIndex) {
+		idx[s] = next
+	}
+	idx.add(segments)
+	c.prefetch.segments.Store(sdHash, idx)
+}
+
+// forgetSegments drops segment index of the stream once its media playlists are removed from the cache.
+func (c Client) forgetSegments(sdHash string) {
+	if c.prefetch == nil {
+		return
+	}
+	c.prefetch.segments.Delete(sdHash)
+}
+
+// playlistSegments lists segment URIs of a media playlist.
+func playlistSegments(file string) ([]string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return readSegments(f)
+}
+
+func readSegments(r io.Reader) ([]string, error) {
+	segments := []string{}
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		l := strings.TrimSpace(sc.Text())
+		if l == "" || strings.HasPrefix(l, "#") {
+			continue
+		}
+		segments = append(segments, l)
+	}
+	return segments, sc.Err()
+}
@@ -0,0 +1,147 @@
+package client
+
+import (
+	"fmt"
+	"hash/crc32"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	healthURLPath = "/api/v1/health"
+	// serverReplicas is the number of points each server occupies on the hash ring.
+	serverReplicas = 64
+)
+
+// server is a transcoder node known to the client.
+type server struct {
+	addr string
+	down int32
+}
+
+func (s *server) healthy() bool {
+	return atomic.LoadInt32(&s.down) == 0
+}
+
+// serverError means that a transcoder node could not be reached or failed to process the request,
+// so it should be retried on another node.
+type serverError struct {
+	server string
+	err    error
+}
+
+func (e serverError) Error() string {
+	return fmt.Sprintf("server %v: %v", e.server, e.err)
+}
+
+type ringPoint struct {
+	hash   uint32
+	server *server
+}
+
+// serverPool routes streams to transcoder nodes by consistent hashing on sd hash.
+type serverPool struct {
+	servers []*server
+	ring    []ringPoint
+	// checked is true when node health is being checked periodically, otherwise nodes are never considered down.
+	checked bool
+}
+
+func newServerPool(addrs []string) *serverPool {
+	p := &serverPool{}
+	for _, a := range addrs {
+		s := &server{addr: strings.TrimSuffix(a, "/")}
+		p.servers = append(p.servers, s)
+		for i := 0; i < serverReplicas; i++ {
+			p.ring = append(p.ring, ringPoint{crc32.ChecksumIEEE([]byte(fmt.Sprintf("%v#%v", s.addr, i))), s})
+		}
+	}
+	sort.Slice(p.ring, func(i, j int) bool { return p.ring[i].hash < p.ring[j].hash })
+	return p
+}
+
+// pick returns servers in the order they should be tried for stream `sdHash`,
+// healthy ones first, each following its successor on the hash ring.
+func (p *serverPool) pick(sdHash string) []*server {
+	if len(p.ring) == 0 {
+		return nil
+	}
+	h := crc32.ChecksumIEEE([]byte(sdHash))
+	start := sort.Search(len(p.ring), func(i int) bool { return p.ring[i].hash >= h })
+
+	seen := map[*server]bool{}
+	healthy, down := []*server{}, []*server{}
+	for i := 0; i < len(p.ring) && len(seen) < len(p.servers); i++ {
+		s := p.ring[(start+i)%len(p.ring)].server
+		if seen[s] {
+			continue
+		}
+		seen[s] = true
+		if !p.checked || s.healthy() {
+			healthy = append(healthy, s)
+		} else {
+			down = append(down, s)
+		}
+	}
+	return append(healthy, down...)
+}
+
+func (p *serverPool) setHealth(s *server, healthy bool) bool {
+	var down int32
+	if !healthy {
+		down = 1
+	}
+	changed := atomic.SwapInt32(&s.down, down) != down
+	if healthy {
+		ServerHealthy.WithLabelValues(s.addr).Set(1)
+	} else {
+		ServerHealthy.WithLabelValues(s.addr).Set(0)
+	}
+	return changed
+}
+
+// markDown takes server out of rotation until it passes a health check.
+func (c Client) markDown(s *server, err error) {
+	if !c.servers.checked {
+		return
+	}
+	if c.servers.setHealth(s, false) {
+		c.logger.Warnw("transcoder server is down", "server", s.addr, "err", err)
+	}
+}
+
+// checkHealth queries health endpoints of all servers, updating their state.
+func (c Client) checkHealth() {
+	for _, s := range c.servers.servers {
+		res, err := c.fetch(s.addr + healthURLPath)
+		if err == nil {
+			res.Body.Close()
+			if res.StatusCode != http.StatusOK {
+				err = fmt.Errorf("health check status: %v", res.StatusCode)
+			}
+		}
+		if err != nil {
+			c.markDown(s, err)
+		} else if c.servers.setHealth(s, true) {
+			c.logger.Infow("transcoder server is up", "server", s.addr)
+		}
+	}
+}
+
+// checkHealthEvery runs server health checks until the client is closed.
+func (c Client) checkHealthEvery(interval time.Duration) {
+	c.checkHealth()
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-t.C:
+			c.checkHealth()
+		case <-c.stop:
+			return
+		}
+	}
+}
@@ -16,6 +16,23 @@ import (
 
 const defaultDBFile = "db.sqlite"
 
+var (
+	queryMigrationsCreate = `
+		CREATE TABLE IF NOT EXISTS migrations (
+			"name" TEXT PRIMARY KEY,
+			"applied_at" TIMESTAMP NOT NULL
+		)`
+	queryMigrationApplied = `select count(*) from migrations where name = $1`
+	queryMigrationRecord  = `insert into migrations (name, applied_at) values ($1, datetime('now'))`
+)
+
+// Migration is a named schema change which is applied to a database only once.
+// Its SQL follows the same format as accepted by `MigrateUp`.
+type Migration struct {
+	Name string
+	SQL  string
+}
+
 type DB struct {
 	*sql.DB
 	file string
@@ -68,6 +85,31 @@ func (db *DB) MigrateUp(s string) error {
 	return err
 }
 
+// Migrate applies supplied migrations in order, skipping those that have already been applied.
+// Unlike `MigrateUp`, it is safe to use with non-idempotent statements like ALTER TABLE.
+func (db *DB) Migrate(migrations ...Migration) error {
+	if _, err := db.Exec(queryMigrationsCreate); err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		var applied int
+		if err := db.QueryRow(queryMigrationApplied, m.Name).Scan(&applied); err != nil {
+			return err
+		}
+		if applied > 0 {
+			continue
+		}
+		logger.Infow("applying migration", "db", db.file, "name", m.Name)
+		if err := db.MigrateUp(m.SQL); err != nil {
+			return fmt.Errorf("migration %v failed: %w", m.Name, err)
+		}
+		if _, err := db.Exec(queryMigrationRecord, m.Name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (db *DB) MigrateUpFromFile(file string) error {
 	s, err := ioutil.ReadFile(file)
 	if err != nil {
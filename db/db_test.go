@@ -0,0 +1,29 @@
+package db
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMigrate(t *testing.T) {
+	d := OpenTestDB()
+	migrations := []Migration{
+		{Name: "create", SQL: `CREATE TABLE items ("id" integer NOT NULL PRIMARY KEY)`},
+		{Name: "alter", SQL: `ALTER TABLE items ADD COLUMN "name" TEXT NOT NULL DEFAULT ""`},
+	}
+	require.NoError(t, d.Migrate(migrations...))
+	// Re-applying would fail on a duplicate column if migrations were not tracked.
+	require.NoError(t, d.Migrate(migrations...))
+
+	_, err := d.Exec(`insert into items (id, name) values (1, "abc")`)
+	require.NoError(t, err)
+
+	var n int
+	require.NoError(t, d.QueryRow(`select count(*) from migrations`).Scan(&n))
+	assert.Equal(t, 2, n)
+
+	err = d.Migrate(Migration{Name: "broken", SQL: `ALTER TABLE nonexistent ADD COLUMN "x" TEXT`})
+	assert.Error(t, err)
+}
@@ -15,6 +15,7 @@ const (
 	preset             = "veryfast"
 	keyint             = "100"
 	videoCodec         = "libx264"
+	videoCodecHEVC     = "libx265"
 	constantRateFactor = "21"
 	hlsTime            = "10"
 )
@@ -24,6 +25,8 @@ type Arguments struct {
 	formats     []formats.Format
 	out         string
 	fps         int
+	keyInfoFile string
+	profile     string
 }
 
 // HLSArguments creates a default set of arguments for ffmpeg HLS encoding.
@@ -55,6 +58,25 @@ func HLSArguments() Arguments {
 	}
 }
 
+// SetProfile switches the video codec to the one of encoding `profile`.
+// HEVC streams are segmented into fragmented MP4 as players don't support HEVC in MPEG-TS.
+func (a *Arguments) SetProfile(profile string) {
+	a.profile = profile
+	if profile == formats.ProfileHEVC {
+		a.set("c:v", videoCodecHEVC)
+	}
+}
+
+// set replaces value of the default argument `key`.
+func (a *Arguments) set(key, value string) {
+	for i, arg := range a.defaultArgs {
+		if arg[0] == key {
+			a.defaultArgs[i][1] = value
+			return
+		}
+	}
+}
+
 func NewArguments(out string, formats []formats.Format, fps int) (Arguments, error) {
 	a := HLSArguments()
 	if len(formats) == 0 {
@@ -91,8 +113,18 @@ func (a Arguments) GetStrArguments() []string {
 	}
 
 	opts = append(opts[:6], append(formatOpts, opts[6:]...)...)
-	opts = append(opts, Argument{"hls_segment_filename", "seg_%v_%06d.ts"})
+	if a.profile == formats.ProfileHEVC {
+		opts = append(opts, Argument{"tag:v", "hvc1"})
+		opts = append(opts, Argument{"hls_segment_type", "fmp4"})
+		opts = append(opts, Argument{"hls_fmp4_init_filename", "init_%v.mp4"})
+		opts = append(opts, Argument{"hls_segment_filename", "seg_%v_%06d.m4s"})
+	} else {
+		opts = append(opts, Argument{"hls_segment_filename", "seg_%v_%06d.ts"})
+	}
 	opts = append(opts, Argument{"var_stream_map", strings.Join(varStream, " ")})
+	if a.keyInfoFile != "" {
+		opts = append(opts, Argument{"hls_key_info_file", a.keyInfoFile})
+	}
 
 	for _, v := range opts {
 		if v[1] != "" {
@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"strconv"
@@ -27,6 +28,12 @@ var ffmpegConf = ffmpeg.Config{
 type Encoder struct {
 	in, out string
 	Meta    *ffmpeg.Metadata
+
+	key    []byte
+	keyURI string
+
+	profile   string
+	maxHeight int
 }
 
 func init() {
@@ -65,6 +72,54 @@ func NewEncoder(in, out string) (*Encoder, error) {
 	return e, nil
 }
 
+// Encrypt enables AES-128 encryption of HLS segments with `key`, `keyURI` is where players will be
+// directed to in EXT-X-KEY tags for retrieving the key.
+func (e *Encoder) Encrypt(key []byte, keyURI string) {
+	e.key = key
+	e.keyURI = keyURI
+}
+
+// Profile selects encoding profile (one of `formats.Profiles`, H264 if empty)
+// and limits output renditions to `maxHeight` if it's above zero.
+func (e *Encoder) Profile(profile string, maxHeight int) error {
+	if _, ok := formats.Profiles[profile]; profile != "" && !ok {
+		return fmt.Errorf("unknown encoding profile: %v", profile)
+	}
+	e.profile = profile
+	e.maxHeight = maxHeight
+	return nil
+}
+
+// Cleanup removes auxiliary files created for the encoding process. Should be called after encoding has finished.
+func (e *Encoder) Cleanup() error {
+	if e.key == nil {
+		return nil
+	}
+	for _, f := range []string{e.keyFile(), e.keyInfoFile()} {
+		if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// keyFile is kept next to the input file so it never ends up in the output directory, which is publicly accessible.
+func (e *Encoder) keyFile() string {
+	return e.in + ".key"
+}
+
+func (e *Encoder) keyInfoFile() string {
+	return e.in + ".keyinfo"
+}
+
+func (e *Encoder) writeKeyInfo() error {
+	if err := ioutil.WriteFile(e.keyFile(), e.key, 0600); err != nil {
+		return err
+	}
+	keyInfo := fmt.Sprintf("%v\n%v\n", e.keyURI, e.keyFile())
+	return ioutil.WriteFile(e.keyInfoFile(), []byte(keyInfo), 0600)
+}
+
 // Encode does transcoding of specified video file into a series of HLS streams.
 func (e *Encoder) Encode() (<-chan ffmpegt.Progress, error) {
 	ll := logger.With("in", e.in)
@@ -75,10 +130,15 @@ func (e *Encoder) Encode() (<-chan ffmpegt.Progress, error) {
 		return nil, err
 	}
 
-	targetFormats, err := formats.TargetFormats(formats.H264, e.Meta)
+	codec := formats.H264
+	if e.profile != "" {
+		codec = formats.Profiles[e.profile]
+	}
+	targetFormats, err := formats.TargetFormats(codec, e.Meta)
 	if err != nil {
 		return nil, err
 	}
+	targetFormats = formats.CapHeight(codec, targetFormats, e.maxHeight)
 
 	fps, err := formats.DetectFPS(e.Meta)
 	if err != nil {
@@ -89,6 +149,13 @@ func (e *Encoder) Encode() (<-chan ffmpegt.Progress, error) {
 	if err != nil {
 		return nil, err
 	}
+	args.SetProfile(e.profile)
+	if e.key != nil {
+		if err := e.writeKeyInfo(); err != nil {
+			return nil, err
+		}
+		args.keyInfoFile = e.keyInfoFile()
+	}
 
 	vs := formats.GetVideoStream(e.Meta)
 	ll.Infow(
@@ -98,6 +165,8 @@ func (e *Encoder) Encode() (<-chan ffmpegt.Progress, error) {
 		"media_bitrate", e.Meta.GetFormat().GetBitRate(),
 		"media_width", vs.GetWidth(),
 		"media_height", vs.GetHeight(),
+		"encrypted", e.key != nil,
+		"profile", e.profile,
 	)
 
 	dur, _ := strconv.ParseFloat(e.Meta.GetFormat().GetDuration(), 64)
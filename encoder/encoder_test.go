@@ -1,48 +1,64 @@
 package encoder
 
 import (
+	"fmt"
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"path"
 	"path/filepath"
 	"strings"
 	"testing"
 
 	"github.com/lbryio/transcoder/formats"
-	"github.com/lbryio/transcoder/pkg/claim"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 )
 
 type EncoderSuite struct {
 	suite.Suite
-	file *os.File
-	out  string
+	in  string
+	out string
 }
 
 func TestEncoderSuite(t *testing.T) {
 	suite.Run(t, new(EncoderSuite))
 }
 
-func (s *EncoderSuite) SetupSuite() {
-	s.out = path.Join(os.TempDir(), "EncoderSuite_out")
-
-	url := "lbry://@specialoperationstest#3/fear-of-death-inspirational#a"
-	c, err := claim.Resolve(url)
+// writeSample encodes a test pattern video with ffmpeg so encoding can be tested without downloading streams.
+func writeSample(file string, width, height, seconds int) error {
+	if err := os.MkdirAll(path.Dir(file), os.ModePerm); err != nil {
+		return err
+	}
+	args := []string{
+		"-y",
+		"-f", "lavfi", "-i", fmt.Sprintf("testsrc2=size=%vx%v:rate=30:duration=%v", width, height, seconds),
+		"-f", "lavfi", "-i", fmt.Sprintf("sine=frequency=440:duration=%v", seconds),
+		"-c:v", "libx264", "-pix_fmt", "yuv420p", "-b:v", "8M", "-minrate", "8M", "-maxrate", "8M", "-bufsize", "16M",
+		"-c:a", "aac",
+		file,
+	}
+	out, err := exec.Command(ffmpegConf.FfmpegBinPath, args...).CombinedOutput()
 	if err != nil {
-		panic(err)
+		return fmt.Errorf("generating sample failed: %v: %s", err, out)
 	}
-	s.file, _, err = c.Download(path.Join(os.TempDir(), "EncoderSuite_in"))
-	s.file.Close()
-	s.Require().NoError(err)
+	return nil
+}
+
+func (s *EncoderSuite) SetupSuite() {
+	s.out = path.Join(os.TempDir(), "EncoderSuite_out")
+	s.in = path.Join(os.TempDir(), "EncoderSuite_in", "sample.mp4")
+	s.Require().NoError(writeSample(s.in, 1920, 1080, 10))
 }
 
 func (s *EncoderSuite) TearDownSuite() {
-	os.Remove(s.file.Name())
+	os.RemoveAll(path.Dir(s.in))
 	os.RemoveAll(s.out)
 }
 
 func (s *EncoderSuite) TestEncode() {
-	absPath, _ := filepath.Abs(s.file.Name())
+	absPath, _ := filepath.Abs(s.in)
 	e, err := NewEncoder(absPath, s.out)
 	s.Require().NoError(err)
 	ch, err := e.Encode()
@@ -89,9 +105,18 @@ stream_2.m3u8
 }
 
 func (s *EncoderSuite) Test_GetMetadata() {
-	meta, err := GetMetadata(s.file.Name())
+	meta, err := GetMetadata(s.in)
 	s.Require().NoError(err)
 	vs := formats.GetVideoStream(meta)
 	s.Equal(1920, vs.GetWidth())
 	s.Equal(1080, vs.GetHeight())
 }
+
+func TestArgumentsProfile(t *testing.T) {
+	a, err := NewArguments("out", formats.HEVC[:1], 30)
+	require.NoError(t, err)
+	a.SetProfile(formats.ProfileHEVC)
+	args := a.GetStrArguments()
+	assert.Contains(t, strings.Join(args, " "), "-c:v "+videoCodecHEVC)
+	assert.NotContains(t, args, videoCodec)
+}
@@ -17,6 +17,9 @@ const (
 
 	FPS30 = 30
 	FPS60 = 60
+
+	ProfileH264 = "h264"
+	ProfileHEVC = "hevc"
 )
 
 type Format struct {
@@ -61,6 +64,21 @@ var H264 = Codec{
 	// Format{SD240, Bitrate{FPS30: 250, FPS60: 380}},
 }
 
+// HEVC codec with its suggested bitrates, about 40% lower than H264 at the same quality.
+var HEVC = Codec{
+	Format{UHD4K, Bitrate{FPS30: 11000, FPS60: 17000}},
+	Format{QHD2K, Bitrate{FPS30: 6000, FPS60: 9600}},
+	Format{HD1080, Bitrate{FPS30: 1200, FPS60: 1900}},
+	Format{HD720, Bitrate{FPS30: 720, FPS60: 1200}},
+	Format{SD360, Bitrate{FPS30: 240, FPS60: 380}},
+}
+
+// Profiles are encoding profiles that can be selected for a channel.
+var Profiles = map[string]Codec{
+	ProfileH264: H264,
+	ProfileHEVC: HEVC,
+}
+
 // brResolutionFactor is a quality factor for non-standard resolution videos. The higher it is
 var brResolutionFactor = .11
 var fpsPattern = regexp.MustCompile(`^(\d+)?.+`)
@@ -147,6 +165,30 @@ func TargetFormats(codec Codec, meta *ffmpeg.Metadata) ([]Format, error) {
 	return formatsFinal, nil
 }
 
+// CapHeight removes formats taller than `maxHeight` from a list returned by TargetFormats.
+// If none of the formats fit, the tallest standard codec format within `maxHeight` is returned instead,
+// or a 16:9 custom format of `maxHeight` when the codec has none that low.
+func CapHeight(codec Codec, fs []Format, maxHeight int) []Format {
+	if maxHeight <= 0 {
+		return fs
+	}
+	capped := []Format{}
+	for _, f := range fs {
+		if f.Resolution.Height <= maxHeight {
+			capped = append(capped, f)
+		}
+	}
+	if len(capped) > 0 {
+		return capped
+	}
+	for _, f := range codec {
+		if f.Resolution.Height <= maxHeight {
+			return []Format{f}
+		}
+	}
+	return []Format{codec.CustomFormat(Resolution{Width: maxHeight * 16 / 9 &^ 1, Height: maxHeight})}
+}
+
 // CustomFormat generates a Format for non-standard resolutions, calculating optimal bitrates (note: it should be calculated better).
 func (c Codec) CustomFormat(r Resolution) Format {
 	for _, f := range c {
@@ -74,3 +74,14 @@ func generateMeta(w, h, br, fr int) ffmpeg.Metadata {
 	}
 	return meta
 }
+
+func TestCapHeight(t *testing.T) {
+	meta := generateMeta(1920, 1080, 8000, FPS30)
+	tf, err := TargetFormats(H264, &meta)
+	require.NoError(t, err)
+
+	assert.Equal(t, tf, CapHeight(H264, tf, 0))
+	assert.Equal(t, []Format{H264.CustomFormat(HD720), H264.CustomFormat(SD360)}, CapHeight(H264, tf, 720))
+	assert.Equal(t, []Format{H264.CustomFormat(SD360)}, CapHeight(H264, tf, 480))
+	assert.Equal(t, []Format{H264.CustomFormat(Resolution{Width: 426, Height: 240})}, CapHeight(H264, tf, 240))
+}
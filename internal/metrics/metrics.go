@@ -24,9 +24,33 @@ var (
 	TranscodedCount = promauto.NewCounter(prometheus.CounterOpts{
 		Name: "transcoded_count",
 	})
+	TranscodingDeduplicatedCount = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "transcoding_deduplicated_count",
+	})
 	DownloadedSizeMB = promauto.NewCounter(prometheus.CounterOpts{
 		Name: "downloaded_size_mb",
 	})
+	PipelineDownloading = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "pipeline_downloading",
+	})
+	PipelineBuffered = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "pipeline_buffered",
+	})
+	DownloadsResumed = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "downloads_resumed_count",
+	})
+	DownloadVerificationFailures = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "download_verification_failures",
+	})
+	DownloadSpaceWaitSeconds = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "download_space_wait_seconds",
+	})
+	BlobsRetrieved = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "blobs_retrieved_count",
+	})
+	SourceFallbacks = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "source_fallbacks_count",
+	})
 	S3UploadedSizeMB = promauto.NewCounter(prometheus.CounterOpts{
 		Name: "s3_uploaded_size_mb",
 	})
@@ -42,10 +66,34 @@ var (
 		[]string{"resolution"},
 	)
 
+	StorageTierSizeBytes = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "storage_tier_size_bytes",
+	}, []string{"tier"})
+	StoragePolicyEvictedCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "storage_policy_evicted_count",
+	}, []string{"tier", "strategy", "simulated"})
+	StoragePolicyEvictedBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "storage_policy_evicted_bytes",
+	}, []string{"tier", "strategy", "simulated"})
+	StoragePolicyFailures = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "storage_policy_failures",
+	}, []string{"tier"})
+
 	StreamsRequestedCount = promauto.NewCounterVec(prometheus.CounterOpts{
 		Name: "streams_requested_count",
 	}, []string{"storage"})
 
+	ResolveCacheResults = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "resolve_cache_results",
+	}, []string{"result"})
+
+	HTTPRateLimited = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "http_rate_limited_count",
+	}, []string{"limit"})
+	SweeperDuplicateViews = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "sweeper_duplicate_views_count",
+	})
+
 	HTTPAPIRequests = promauto.NewHistogramVec(
 		prometheus.HistogramOpts{
 			Name:    "http_api_requests",
@@ -1,26 +1,34 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"math/rand"
 	"os"
 	"os/signal"
 	"path"
+	"runtime"
 	"runtime/pprof"
 	"strconv"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/lbryio/transcoder/api"
+	"github.com/lbryio/transcoder/auth"
 	"github.com/lbryio/transcoder/db"
 	"github.com/lbryio/transcoder/encoder"
 	"github.com/lbryio/transcoder/formats"
 	"github.com/lbryio/transcoder/pkg/claim"
 	"github.com/lbryio/transcoder/pkg/config"
+	"github.com/lbryio/transcoder/pkg/events"
 	"github.com/lbryio/transcoder/pkg/logging"
 	"github.com/lbryio/transcoder/queue"
 	"github.com/lbryio/transcoder/storage"
 	"github.com/lbryio/transcoder/video"
+	"github.com/lbryio/transcoder/webhooks"
 	"github.com/pkg/profile"
+	"github.com/spf13/viper"
 
 	"github.com/alecthomas/kong"
 )
@@ -80,6 +88,9 @@ func main() {
 			claim.SetLogger(logging.Create("claim", logging.Prod))
 			storage.SetLogger(logging.Create("storage", logging.Prod))
 			formats.SetLogger(logging.Create("formats", logging.Prod))
+			events.SetLogger(logging.Create("events", logging.Prod))
+			webhooks.SetLogger(logging.Create("webhooks", logging.Prod))
+			auth.SetLogger(logging.Create("auth", logging.Prod))
 		}
 
 		if CLI.Serve.CDN != "" {
@@ -87,39 +98,97 @@ func main() {
 		} else {
 			claim.SetCDNServer(cfg.GetString("CDNServer"))
 		}
+		if err := initResolveCache(cfg); err != nil {
+			logger.Fatal(err)
+		}
+		if s := cfg.GetString("downloads.maxsize"); s != "" {
+			claim.SetDownloadDirLimit(int64(video.StringToSize(s)))
+		}
+		if s := cfg.GetString("downloads.rate"); s != "" {
+			claim.SetDownloadRate(int64(video.StringToSize(s)))
+		}
 
 		vdb := db.OpenDB(path.Join(CLI.Serve.DataPath, "video.sqlite"))
-		err := vdb.MigrateUp(video.InitialMigration)
+		err := vdb.Migrate(video.Migrations...)
 		if err != nil {
 			logger.Fatal(err)
 		}
 
 		qdb := db.OpenDB(path.Join(CLI.Serve.DataPath, "queue.sqlite"))
-		err = qdb.MigrateUp(queue.InitialMigration)
+		err = qdb.Migrate(queue.Migrations...)
 		if err != nil {
 			logger.Fatal(err)
 		}
 
 		wasabi := cfg.GetStringMapString("wasabi")
 		local := cfg.GetStringMapString("local")
+		encryption := cfg.GetStringMapString("encryption")
+		// Without a secret key tokens could not be verified, leaving encrypted streams unplayable.
+		if encryption["keyurl"] != "" && encryption["secret"] == "" {
+			logger.Fatal("encryption.secret must be set along with encryption.keyurl")
+		}
 
 		libCfg := video.Configure().
 			LocalStorage(storage.Local(CLI.Serve.VideoPath)).
 			MaxLocalSize(local["maxsize"]).
 			MaxRemoteSize(wasabi["maxsize"]).
+			KeyServerURL(encryption["keyurl"]).
 			DB(vdb)
 
 		if wasabi["bucket"] != "" {
-			s3d, err := storage.InitS3Driver(
-				storage.S3ConfigureWasabiEU().
-					Credentials(wasabi["key"], wasabi["secret"]).
-					Bucket(wasabi["bucket"]))
+			s3cfg := storage.S3ConfigureWasabiEU().
+				Credentials(wasabi["key"], wasabi["secret"]).
+				Bucket(wasabi["bucket"])
+			if wasabi["private"] == "true" {
+				ttl, err := time.ParseDuration(wasabi["signedurlttl"])
+				if err != nil {
+					ttl = time.Hour
+					logger.Warnf("invalid signed URL TTL: %v, setting to 1h", wasabi["signedurlttl"])
+				}
+				s3cfg.Private(ttl)
+			}
+			s3d, err := storage.InitS3Driver(s3cfg)
 			if err != nil {
 				logger.Fatalw("wasabi driver initialization failed", "err", err)
 			}
 			libCfg.RemoteStorage(s3d)
-			logger.Infow("wasabi storage configured", "bucket", wasabi["bucket"])
+			logger.Infow("wasabi storage configured", "bucket", wasabi["bucket"], "private", s3d.IsPrivate())
+		}
+		archive := cfg.GetStringMapString("archive")
+		if archive["bucket"] != "" {
+			// Archive may live with another provider or in another region, Wasabi EU is assumed if they're not set.
+			s3cfg := storage.S3ConfigureWasabiEU().
+				Credentials(archive["key"], archive["secret"]).
+				Bucket(archive["bucket"])
+			if archive["endpoint"] != "" {
+				s3cfg.Endpoint(archive["endpoint"])
+			}
+			if archive["region"] != "" {
+				s3cfg.Region(archive["region"])
+			}
+			s3d, err := storage.InitS3Driver(s3cfg)
+			if err != nil {
+				logger.Fatalw("archive driver initialization failed", "err", err)
+			}
+			libCfg.ArchiveStorage(s3d)
+			logger.Infow("archive storage configured", "bucket", archive["bucket"], "endpoint", archive["endpoint"], "region", archive["region"])
 		}
+
+		policies, err := readTierPolicies(cfg)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		for _, p := range policies {
+			libCfg.TierPolicy(p)
+		}
+		libCfg.SimulatePolicies(cfg.GetBool("storagepolicysimulation"))
+
+		hooks, err := initWebhooks(cfg)
+		if err != nil {
+			logger.Fatal(err)
+		}
+		libCfg.Webhooks(hooks)
+
 		lib := video.NewLibrary(libCfg)
 
 		if wasabi["bucket"] != "" {
@@ -128,11 +197,26 @@ func main() {
 
 		q := queue.NewQueue(qdb)
 
-		video.LoadEnabledChannels(cfg.GetStringSlice("enabledchannels"))
+		if err := lib.ImportEnabledChannels(cfg.GetStringSlice("enabledchannels")); err != nil {
+			logger.Fatal(err)
+		}
+		if err := lib.LoadChannelSettings(); err != nil {
+			logger.Fatal(err)
+		}
 
+		ingest := ingestFetcher(cfg)
+		popts := pipelineOpts(cfg)
+		popts.DirectFetcher = ingest
+		popts.Fetcher, err = sourceFetcher(cfg)
+		if err != nil {
+			logger.Fatal(err)
+		}
 		poller := q.StartPoller(CLI.Serve.Workers)
-		for i := 0; i < CLI.Serve.Workers; i++ {
-			go video.SpawnProcessing(q, lib, poller)
+		go video.SpawnPipeline(q, lib, poller, popts)
+
+		authManager, err := initAuth(cfg)
+		if err != nil {
+			logger.Fatal(err)
 		}
 
 		video.SpawnLibraryCleaning(lib)
@@ -157,6 +241,16 @@ func main() {
 				Debug(CLI.Serve.Debug).
 				Addr(CLI.Serve.Bind).
 				VideoPath(CLI.Serve.VideoPath).
+				KeyTokenSecret(encryption["secret"]).
+				Webhooks(hooks).
+				Auth(authManager).
+				RateLimit(
+					api.RateLimit{Rate: cfg.GetFloat64("ratelimit.ip.rate"), Burst: cfg.GetInt("ratelimit.ip.burst")},
+					api.RateLimit{Rate: cfg.GetFloat64("ratelimit.claim.rate"), Burst: cfg.GetInt("ratelimit.claim.burst")},
+				).
+				// The number of reverse proxies in front of the server, `true` counts as one.
+				TrustForwardedFor(cfg.GetInt("ratelimit.trustforwardedfor")).
+				Ingest(ingest, int(video.StringToSize(cfg.GetString("ingest.maxuploadsize")))).
 				VideoManager(api.NewManager(q, lib)),
 		)
 		logger.Infow("configured api server", "addr", CLI.Serve.Bind)
@@ -178,3 +272,187 @@ func main() {
 		logger.Fatal(ctx.Command())
 	}
 }
+
+// initAuth opens API keys database and configures access from `auth` config section:
+// `public` lists scopes granted to requests without an API key (playback by default),
+// `keys` maps names of static keys to their `token` and `scopes`.
+// Legacy `admintoken` option is accepted as a static admin key.
+func initAuth(cfg *viper.Viper) (*auth.Manager, error) {
+	adb := db.OpenDB(path.Join(CLI.Serve.DataPath, "auth.sqlite"))
+	if err := adb.Migrate(auth.Migrations...); err != nil {
+		return nil, err
+	}
+	acfg := auth.Configure().DB(adb).Public(auth.ScopePlayback)
+
+	if t := cfg.GetString("admintoken"); t != "" {
+		acfg.StaticKey("admintoken", t, auth.ScopeAdmin)
+	}
+	if sub := cfg.Sub("auth"); sub != nil {
+		if sub.IsSet("public") {
+			acfg.Public(sub.GetStringSlice("public")...)
+		}
+		for name := range sub.GetStringMap("keys") {
+			k := sub.Sub("keys." + name)
+			token, scopes := k.GetString("token"), k.GetStringSlice("scopes")
+			if token == "" {
+				return nil, fmt.Errorf("static API key %v has no token", name)
+			}
+			for _, sc := range scopes {
+				if !auth.ValidScope(sc) {
+					return nil, fmt.Errorf("static API key %v: %w: %v", name, auth.ErrInvalidScope, sc)
+				}
+			}
+			acfg.StaticKey(name, token, scopes...)
+		}
+	}
+	return auth.NewManager(acfg), nil
+}
+
+// initResolveCache enables caching of resolved claims, which are also saved to the database
+// if `resolvecache.persist` is set, so that playback keeps working when the resolver is down.
+func initResolveCache(cfg *viper.Viper) error {
+	cfg.SetDefault("resolvecache.positivettl", 5*time.Minute)
+	cfg.SetDefault("resolvecache.negativettl", time.Minute)
+	if cfg.IsSet("resolvecache.enabled") && !cfg.GetBool("resolvecache.enabled") {
+		return nil
+	}
+	c := claim.NewCache(cfg.GetDuration("resolvecache.positivettl"), cfg.GetDuration("resolvecache.negativettl"))
+	if cfg.GetBool("resolvecache.persist") {
+		cdb := db.OpenDB(path.Join(CLI.Serve.DataPath, "claims.sqlite"))
+		if err := cdb.Migrate(claim.Migrations...); err != nil {
+			return err
+		}
+		c.Persist(claim.NewDBStore(cdb))
+	}
+	claim.SetCache(c)
+	return nil
+}
+
+// pipelineOpts reads processing stage sizes from `pipeline` config section: `downloaders` default to
+// the number of workers, `encoders` to the number of CPU cores and `buffer` to the number of encoders.
+func pipelineOpts(cfg *viper.Viper) video.PipelineOpts {
+	cfg.SetDefault("pipeline.downloaders", CLI.Serve.Workers)
+	cfg.SetDefault("pipeline.encoders", runtime.NumCPU())
+	opts := video.PipelineOpts{
+		Downloaders: cfg.GetInt("pipeline.downloaders"),
+		Encoders:    cfg.GetInt("pipeline.encoders"),
+	}
+	cfg.SetDefault("pipeline.buffer", opts.Encoders)
+	opts.Buffer = cfg.GetInt("pipeline.buffer")
+	return opts
+}
+
+// sourceFetcher reads `sources` config section: `order` lists places streams are retrieved from
+// until one succeeds, which are `cdn`, `blobserver` (at `blobserver` URL) and `blobdir` (blob files in `blobdir`).
+func sourceFetcher(cfg *viper.Viper) (claim.SourceFetcher, error) {
+	cfg.SetDefault("sources.order", []string{"cdn"})
+	var ff claim.FallbackFetcher
+	for _, s := range cfg.GetStringSlice("sources.order") {
+		switch s {
+		case "cdn":
+			ff = append(ff, claim.DefaultFetcher)
+		case "blobserver":
+			if cfg.GetString("sources.blobserver") == "" {
+				return nil, errors.New("sources.blobserver is not set")
+			}
+			ff = append(ff, claim.BlobFetcher{Source: claim.BlobServer(cfg.GetString("sources.blobserver"))})
+		case "blobdir":
+			if cfg.GetString("sources.blobdir") == "" {
+				return nil, errors.New("sources.blobdir is not set")
+			}
+			ff = append(ff, claim.BlobFetcher{Source: claim.BlobDir(cfg.GetString("sources.blobdir"))})
+		default:
+			return nil, fmt.Errorf("unknown stream source: %v", s)
+		}
+	}
+	if len(ff) == 0 {
+		return nil, errors.New("sources.order is empty")
+	}
+	return ff, nil
+}
+
+// ingestFetcher reads `ingest` config section: `localroot` is the directory local files can be transcoded from
+// (disabled if not set), `uploadpath` is where uploads are kept until they're transcoded.
+func ingestFetcher(cfg *viper.Viper) claim.DirectFetcher {
+	cfg.SetDefault("ingest.uploadpath", path.Join(CLI.Serve.DataPath, "uploads"))
+	cfg.SetDefault("ingest.maxuploadsize", "512MB")
+	return claim.DirectFetcher{
+		LocalRoot:  cfg.GetString("ingest.localroot"),
+		UploadPath: cfg.GetString("ingest.uploadpath"),
+	}
+}
+
+// initWebhooks opens webhooks database, registers hooks from `webhooks` config section
+// and starts delivering notifications. Returns nil if webhooks are not configured.
+func initWebhooks(cfg *viper.Viper) (*webhooks.Manager, error) {
+	wcfg := cfg.Sub("webhooks")
+	if wcfg == nil {
+		return nil, nil
+	}
+
+	wdb := db.OpenDB(path.Join(CLI.Serve.DataPath, "webhooks.sqlite"))
+	if err := wdb.Migrate(webhooks.Migrations...); err != nil {
+		return nil, err
+	}
+	hooks := webhooks.NewManager(webhooks.Configure().DB(wdb).Secret(wcfg.GetString("secret")))
+
+	for _, u := range wcfg.GetStringSlice("urls") {
+		if _, err := hooks.AddHook(u, ""); err != nil {
+			return nil, err
+		}
+	}
+	for channel, urls := range wcfg.GetStringMapStringSlice("channels") {
+		for _, u := range urls {
+			if _, err := hooks.AddHook(u, "lbry://"+strings.TrimPrefix(channel, "lbry://")); err != nil {
+				return nil, err
+			}
+		}
+	}
+	hooks.StartSender(5 * time.Second)
+	logger.Infow("webhooks configured")
+	return hooks, nil
+}
+
+// readTierPolicies parses `storagepolicies` config section, which is a list of
+// `{tier, maxsize, strategy, minresidency, interval, keepheight}` items.
+func readTierPolicies(cfg *viper.Viper) ([]video.TierPolicy, error) {
+	var raw []map[string]string
+	policies := []video.TierPolicy{}
+	if err := cfg.UnmarshalKey("storagepolicies", &raw); err != nil {
+		return nil, err
+	}
+	for _, r := range raw {
+		p := video.TierPolicy{
+			Tier:     r["tier"],
+			MaxSize:  video.StringToSize(r["maxsize"]),
+			Strategy: r["strategy"],
+		}
+		if p.Strategy == "" {
+			p.Strategy = video.StrategyLRU
+		}
+		if r["minresidency"] != "" {
+			d, err := time.ParseDuration(r["minresidency"])
+			if err != nil {
+				return nil, fmt.Errorf("invalid minresidency for %v tier: %w", p.Tier, err)
+			}
+			p.MinResidency = d
+		}
+		if r["keepheight"] != "" {
+			h, err := strconv.Atoi(r["keepheight"])
+			if err != nil {
+				return nil, fmt.Errorf("invalid keepheight for %v tier: %w", p.Tier, err)
+			}
+			p.KeepHeight = h
+		}
+		d, err := time.ParseDuration(r["interval"])
+		if err != nil {
+			return nil, fmt.Errorf("invalid interval for %v tier: %w", p.Tier, err)
+		}
+		p.Interval = d
+		if err := p.Validate(); err != nil {
+			return nil, err
+		}
+		policies = append(policies, p)
+	}
+	return policies, nil
+}
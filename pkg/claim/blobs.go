@@ -0,0 +1,169 @@
+package claim
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/lbryio/lbry.go/v2/stream"
+	"github.com/lbryio/transcoder/internal/metrics"
+)
+
+var (
+	// ErrBlobNotFound means that blob source doesn't have the requested blob.
+	ErrBlobNotFound = errors.New("blob not found")
+	// ErrBlobMismatch means that retrieved blob content does not match its hash.
+	ErrBlobMismatch = errors.New("blob does not match its hash")
+
+	blobClient = &http.Client{Timeout: 60 * time.Second}
+)
+
+// BlobSource retrieves LBRY blobs by their hex-encoded hashes.
+type BlobSource interface {
+	Blob(hash string) ([]byte, error)
+}
+
+// BlobDir is a directory of blobs stored in files named by their hashes.
+type BlobDir string
+
+func (d BlobDir) Blob(hash string) ([]byte, error) {
+	b, err := ioutil.ReadFile(path.Join(string(d), hash))
+	if os.IsNotExist(err) {
+		return nil, ErrBlobNotFound
+	}
+	return b, err
+}
+
+// BlobServer retrieves blobs over HTTP. `{hash}` in the URL is replaced with blob hash,
+// which is appended to the URL as path otherwise.
+type BlobServer string
+
+func (s BlobServer) url(hash string) string {
+	u := string(s)
+	if strings.Contains(u, "{hash}") {
+		return strings.Replace(u, "{hash}", hash, -1)
+	}
+	return strings.TrimSuffix(u, "/") + "/" + hash
+}
+
+func (s BlobServer) Blob(hash string) ([]byte, error) {
+	resp, err := blobClient.Get(s.url(hash))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotFound:
+		return nil, ErrBlobNotFound
+	default:
+		return nil, statusError(resp.StatusCode)
+	}
+	return ioutil.ReadAll(throttledReader{resp.Body, bandwidth})
+}
+
+// BlobFetcher reconstructs streams from LBRY blobs: the stream descriptor blob identified by claim SD hash
+// lists content blobs, which are retrieved from `Source`, decrypted and joined together.
+type BlobFetcher struct {
+	Source BlobSource
+}
+
+func (f BlobFetcher) Fetch(c *Claim, dest string) (*os.File, int64, error) {
+	return retrieve(c, fmt.Sprintf("%v", f.Source), dest, func(partFile string, _ int64) (int64, error) {
+		sd, err := f.sdBlob(c.SDHash)
+		if err != nil {
+			return 0, err
+		}
+		out, err := os.Create(partFile)
+		if err != nil {
+			return 0, err
+		}
+		defer out.Close()
+
+		var n int64
+		for _, bi := range sd.BlobInfos {
+			if bi.Length == 0 {
+				break
+			}
+			hash := hex.EncodeToString(bi.BlobHash)
+			b, err := f.blob(hash)
+			if err != nil {
+				return n, fmt.Errorf("blob %v: %w", bi.BlobNum, err)
+			}
+			data, err := stream.Blob(b).Plaintext(sd.Key, bi.IV)
+			if err != nil {
+				return n, fmt.Errorf("decrypting blob %v: %w", bi.BlobNum, err)
+			}
+			w, err := out.Write(data)
+			n += int64(w)
+			if err != nil {
+				return n, err
+			}
+			metrics.BlobsRetrieved.Inc()
+		}
+		return n, nil
+	})
+}
+
+func (f BlobFetcher) sdBlob(sdHash string) (*stream.SDBlob, error) {
+	b, err := f.blob(sdHash)
+	if err != nil {
+		return nil, fmt.Errorf("sd blob: %w", err)
+	}
+	sd := &stream.SDBlob{}
+	if err := sd.FromBlob(b); err != nil {
+		return nil, fmt.Errorf("sd blob: %w", err)
+	}
+	if len(sd.BlobInfos) == 0 || sd.BlobInfos[len(sd.BlobInfos)-1].Length != 0 {
+		return nil, errors.New("sd blob is missing the terminating blob")
+	}
+	return sd, nil
+}
+
+// blob retrieves a blob from the source, making sure its content matches `hash`.
+func (f BlobFetcher) blob(hash string) ([]byte, error) {
+	b, err := f.Source.Blob(hash)
+	if err != nil {
+		return nil, err
+	}
+	expected, err := hex.DecodeString(hash)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(stream.Blob(b).Hash(), expected) {
+		return nil, ErrBlobMismatch
+	}
+	return b, nil
+}
+
+// FallbackFetcher tries fetchers in order, returning the first stream retrieved successfully.
+type FallbackFetcher []SourceFetcher
+
+func (ff FallbackFetcher) Fetch(c *Claim, dest string) (*os.File, int64, error) {
+	var err error
+	for i, f := range ff {
+		var (
+			fh *os.File
+			n  int64
+		)
+		fh, n, err = f.Fetch(c, dest)
+		if err == nil {
+			return fh, n, nil
+		}
+		if i < len(ff)-1 {
+			logger.Warnw("stream retrieval failed, falling back", "sd_hash", c.SDHash, "fetcher", i, "err", err)
+			metrics.SourceFallbacks.Inc()
+		}
+	}
+	if err == nil {
+		err = errors.New("no fetchers configured")
+	}
+	return nil, 0, err
+}
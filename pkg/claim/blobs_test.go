@@ -0,0 +1,64 @@
+package claim
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path"
+	"testing"
+
+	"github.com/lbryio/lbry.go/v2/stream"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBlobFetcher(t *testing.T) {
+	// Spans two content blobs.
+	data := bytes.Repeat([]byte("0123456789abcdef"), stream.MaxBlobSize/16+100)
+	s, err := stream.New(data)
+	require.NoError(t, err)
+	require.Len(t, s, 3)
+
+	blobs := t.TempDir()
+	for _, b := range s {
+		require.NoError(t, ioutil.WriteFile(path.Join(blobs, b.HashHex()), b, 0644))
+	}
+	c, err := NewFakeClaim("lbry://video#a", "a1", s[0].HashHex(), "")
+	require.NoError(t, err)
+	src := c.Value.GetStream().GetSource()
+	hash := sha512.Sum384(data)
+	src.Size, src.Hash = uint64(len(data)), hash[:]
+
+	dest := t.TempDir()
+	fh, n, err := BlobFetcher{Source: BlobDir(blobs)}.Fetch(c, dest)
+	require.NoError(t, err)
+	fh.Close()
+	assert.EqualValues(t, len(data), n)
+	stored, err := ioutil.ReadFile(fh.Name())
+	require.NoError(t, err)
+	assert.True(t, bytes.Equal(data, stored))
+
+	ts := httptest.NewServer(http.StripPrefix("/blob/", http.FileServer(http.Dir(blobs))))
+	defer ts.Close()
+	fh, _, err = BlobFetcher{Source: BlobServer(ts.URL + "/blob/{hash}")}.Fetch(c, dest)
+	require.NoError(t, err)
+	fh.Close()
+
+	// CDN is down, blobs are used instead.
+	cdn := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer cdn.Close()
+	fh, _, err = FallbackFetcher{CDNFetcher{Server: cdn.URL}, BlobFetcher{Source: BlobServer(ts.URL + "/blob")}}.Fetch(c, dest)
+	require.NoError(t, err)
+	fh.Close()
+
+	require.NoError(t, ioutil.WriteFile(path.Join(blobs, s[2].HashHex()), []byte("corrupted"), 0644))
+	_, _, err = BlobFetcher{Source: BlobDir(blobs)}.Fetch(c, dest)
+	assert.True(t, errors.Is(err, ErrBlobMismatch))
+	_, _, err = FallbackFetcher{CDNFetcher{Server: cdn.URL}, BlobFetcher{Source: BlobDir(t.TempDir())}}.Fetch(c, dest)
+	assert.True(t, errors.Is(err, ErrBlobNotFound))
+}
@@ -0,0 +1,155 @@
+package claim
+
+import (
+	"sync"
+	"time"
+
+	"github.com/lbryio/transcoder/internal/metrics"
+)
+
+// Resolve cache lookup results reported in metrics.
+const (
+	cacheHit         = "hit"
+	cacheNegativeHit = "negative_hit"
+	cacheMiss        = "miss"
+	cacheShared      = "shared"
+	cacheStored      = "stored"
+)
+
+// Store persists resolved claims so they can still be served when the resolver is not available.
+type Store interface {
+	Get(uri string) (*Claim, error)
+	Put(uri string, c *Claim) error
+}
+
+// Cache keeps claim resolution results in memory: resolved claims for `positiveTTL` and
+// unresolvable URIs for `negativeTTL`. Concurrent lookups of the same URI are collapsed into one.
+type Cache struct {
+	positiveTTL, negativeTTL time.Duration
+	store                    Store
+
+	mu          sync.Mutex
+	entries     map[string]*cacheEntry
+	calls       map[string]*resolveCall
+	lastCleanup time.Time
+}
+
+type cacheEntry struct {
+	claim   *Claim
+	err     error
+	expires time.Time
+}
+
+type resolveCall struct {
+	wg    sync.WaitGroup
+	claim *Claim
+	err   error
+}
+
+var cache *Cache
+
+// NewCache creates a resolve cache, it needs to be enabled with `SetCache`.
+func NewCache(positiveTTL, negativeTTL time.Duration) *Cache {
+	return &Cache{
+		positiveTTL: positiveTTL,
+		negativeTTL: negativeTTL,
+		entries:     map[string]*cacheEntry{},
+		calls:       map[string]*resolveCall{},
+		lastCleanup: time.Now(),
+	}
+}
+
+// Persist makes resolved claims to be saved in `s` and retrieved from it when the resolver fails.
+func (c *Cache) Persist(s Store) *Cache {
+	c.store = s
+	return c
+}
+
+// SetCache makes `Resolve` go through the cache `c`, nil disables caching.
+func SetCache(c *Cache) {
+	cache = c
+}
+
+// Resolve returns a cached claim for `uri` or resolves it with `resolve`.
+func (c *Cache) Resolve(uri string, resolve func(string) (*Claim, error)) (*Claim, error) {
+	now := time.Now()
+	c.mu.Lock()
+	if now.Sub(c.lastCleanup) > c.positiveTTL {
+		c.cleanup(now)
+	}
+	if e, ok := c.entries[uri]; ok && now.Before(e.expires) {
+		c.mu.Unlock()
+		if e.err != nil {
+			metrics.ResolveCacheResults.WithLabelValues(cacheNegativeHit).Inc()
+		} else {
+			metrics.ResolveCacheResults.WithLabelValues(cacheHit).Inc()
+		}
+		return e.claim, e.err
+	}
+	if call, ok := c.calls[uri]; ok {
+		c.mu.Unlock()
+		call.wg.Wait()
+		metrics.ResolveCacheResults.WithLabelValues(cacheShared).Inc()
+		return call.claim, call.err
+	}
+	call := &resolveCall{}
+	call.wg.Add(1)
+	c.calls[uri] = call
+	c.mu.Unlock()
+
+	metrics.ResolveCacheResults.WithLabelValues(cacheMiss).Inc()
+	call.claim, call.err = c.resolve(uri, resolve)
+
+	c.mu.Lock()
+	delete(c.calls, uri)
+	switch {
+	case call.err == nil && c.positiveTTL > 0:
+		c.entries[uri] = &cacheEntry{claim: call.claim, expires: time.Now().Add(c.positiveTTL)}
+	case call.err == ErrStreamNotFound && c.negativeTTL > 0:
+		c.entries[uri] = &cacheEntry{err: call.err, expires: time.Now().Add(c.negativeTTL)}
+	}
+	c.mu.Unlock()
+	call.wg.Done()
+
+	return call.claim, call.err
+}
+
+// resolve calls the resolver, falling back to the store if it fails for reasons other than claim not existing.
+func (c *Cache) resolve(uri string, resolve func(string) (*Claim, error)) (*Claim, error) {
+	claim, err := resolve(uri)
+	if c.store == nil {
+		return claim, err
+	}
+	if err == nil {
+		if err := c.store.Put(uri, claim); err != nil {
+			logger.Warnw("saving resolved claim failed", "uri", uri, "err", err)
+		}
+		return claim, nil
+	}
+	if err == ErrStreamNotFound {
+		return nil, err
+	}
+	stored, serr := c.store.Get(uri)
+	if serr != nil || stored == nil {
+		return nil, err
+	}
+	logger.Infow("resolve failed, using stored claim", "uri", uri, "err", err)
+	metrics.ResolveCacheResults.WithLabelValues(cacheStored).Inc()
+	return stored, nil
+}
+
+// Forget removes `uri` from the cache.
+func (c *Cache) Forget(uri string) {
+	c.mu.Lock()
+	delete(c.entries, uri)
+	c.mu.Unlock()
+}
+
+func (c *Cache) cleanup(now time.Time) {
+	for uri, e := range c.entries {
+		if !now.Before(e.expires) {
+			delete(c.entries, uri)
+		}
+	}
+	c.lastCleanup = now
+}
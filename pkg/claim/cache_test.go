@@ -0,0 +1,103 @@
+package claim
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	ljsonrpc "github.com/lbryio/lbry.go/v2/extras/jsonrpc"
+	"github.com/lbryio/transcoder/db"
+	pb "github.com/lbryio/types/v2/go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testClaim() *Claim {
+	c := &Claim{Claim: &ljsonrpc.Claim{ClaimID: "aaaa", Name: "video", CanonicalURL: "lbry://@chan#1/video#a"}, SDHash: "abcdef"}
+	c.Value = pb.Claim{Tags: []string{"c:unlisted"}}
+	c.SigningChannel = &ljsonrpc.Claim{ClaimID: "1111", CanonicalURL: "lbry://@chan#1"}
+	return c
+}
+
+func TestCacheResolve(t *testing.T) {
+	var calls int32
+	resolveErr := ErrStreamNotFound
+	resolve := func(uri string) (*Claim, error) {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(50 * time.Millisecond)
+		if uri == "lbry://video" {
+			return testClaim(), nil
+		}
+		return nil, resolveErr
+	}
+	c := NewCache(time.Minute, time.Minute)
+
+	var wg sync.WaitGroup
+	for range [10]int{} {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			cl, err := c.Resolve("lbry://video", resolve)
+			assert.NoError(t, err)
+			assert.Equal(t, "abcdef", cl.SDHash)
+		}()
+	}
+	wg.Wait()
+	assert.EqualValues(t, 1, calls)
+
+	_, err := c.Resolve("lbry://missing", resolve)
+	assert.Equal(t, ErrStreamNotFound, err)
+	_, err = c.Resolve("lbry://missing", resolve)
+	assert.Equal(t, ErrStreamNotFound, err)
+	assert.EqualValues(t, 2, calls)
+
+	// Resolver failures are not cached.
+	resolveErr = errors.New("resolver is down")
+	_, err = c.Resolve("lbry://other", resolve)
+	assert.Equal(t, resolveErr, err)
+	_, err = c.Resolve("lbry://other", resolve)
+	assert.Equal(t, resolveErr, err)
+	assert.EqualValues(t, 4, calls)
+
+	c.Forget("lbry://video")
+	_, err = c.Resolve("lbry://video", resolve)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 5, calls)
+}
+
+func TestCachePersist(t *testing.T) {
+	d := db.OpenTestDB()
+	require.NoError(t, d.Migrate(Migrations...))
+	store := NewDBStore(d)
+	c := NewCache(0, 0).Persist(store)
+
+	var resolveErr error
+	resolve := func(uri string) (*Claim, error) {
+		if resolveErr != nil {
+			return nil, resolveErr
+		}
+		return testClaim(), nil
+	}
+
+	_, err := c.Resolve("lbry://video", resolve)
+	require.NoError(t, err)
+
+	resolveErr = errors.New("resolver is down")
+	cl, err := c.Resolve("lbry://video", resolve)
+	require.NoError(t, err)
+	orig := testClaim()
+	assert.Equal(t, orig.SDHash, cl.SDHash)
+	assert.Equal(t, orig.ClaimID, cl.ClaimID)
+	assert.Equal(t, orig.CanonicalURL, cl.CanonicalURL)
+	assert.Equal(t, orig.SigningChannel.CanonicalURL, cl.SigningChannel.CanonicalURL)
+	assert.Equal(t, orig.Value.GetTags(), cl.Value.GetTags())
+
+	_, err = c.Resolve("lbry://unknown", resolve)
+	assert.Equal(t, resolveErr, err)
+
+	resolveErr = ErrStreamNotFound
+	_, err = c.Resolve("lbry://video", resolve)
+	assert.Equal(t, ErrStreamNotFound, err)
+}
@@ -3,16 +3,12 @@ package claim
 import (
 	"encoding/hex"
 	"errors"
-	"fmt"
-	"io"
-	"io/ioutil"
 	"net"
 	"net/http"
 	"os"
 	"time"
 
 	ljsonrpc "github.com/lbryio/lbry.go/v2/extras/jsonrpc"
-	"github.com/lbryio/transcoder/pkg/timer"
 )
 
 var (
@@ -65,7 +61,15 @@ type Claim struct {
 	SDHash string
 }
 
+// Resolve retrieves claim for `uri` from the lbrytv API, going through the resolve cache if it's set.
 func Resolve(uri string) (*Claim, error) {
+	if cache != nil {
+		return cache.Resolve(uri, resolve)
+	}
+	return resolve(uri)
+}
+
+func resolve(uri string) (*Claim, error) {
 	resolved, err := lbrytvClient.Resolve(uri)
 	if err != nil {
 		return nil, err
@@ -92,52 +96,9 @@ func wrapClaim(lc *ljsonrpc.Claim) (*Claim, error) {
 	return c, err
 }
 
-// Download retrieves a video stream from the lbrytv CDN and saves it to a temporary file.
+// Download retrieves a verified video stream from the lbrytv CDN and saves it into `dest` directory.
 func (c *Claim) Download(dest string) (*os.File, int64, error) {
-	var readLen int64
-
-	req, err := http.NewRequest("GET", c.cdnURL(), nil)
-	logger.Infow("downloading stream", "url", c.cdnURL())
-
-	tmr := timer.Start()
-
-	resp, err := downloadClient.Do(req)
-	if err != nil {
-		return nil, readLen, err
-	}
-	if resp.StatusCode != http.StatusOK {
-		return nil, readLen, fmt.Errorf("http response not ok: %v", resp.StatusCode)
-	}
-	defer resp.Body.Close()
-
-	if err = os.MkdirAll(dest, os.ModePerm); err != nil {
-		return nil, readLen, err
-	}
-
-	out, err := ioutil.TempFile(dest, c.streamFileName())
-	if err != nil {
-		return nil, readLen, err
-	}
-
-	wc := &WriteCounter{
-		Size:           uint64(resp.ContentLength),
-		Started:        time.Now(),
-		URL:            c.cdnURL(),
-		progressLogged: map[int]bool{},
-	}
-	if readLen, err = io.Copy(out, io.TeeReader(resp.Body, wc)); err != nil {
-		out.Close()
-		os.Remove(out.Name())
-		return nil, readLen, err
-	}
-	tmr.Stop()
-	rate := int64(float64(readLen) / tmr.Duration())
-	logger.Infow("stream downloaded", "url", c.cdnURL(), "rate", rate, "size", readLen, "seconds_spent", tmr.DurationInt())
-	return out, readLen, nil
-}
-
-func (c *Claim) cdnURL() string {
-	return fmt.Sprintf("%s/free/%s/%s/%s", cdnServer, c.Name, c.ClaimID, c.SDHash[:6])
+	return CDNFetcher{Server: cdnServer}.Fetch(c, dest)
 }
 
 func (c *Claim) getSDHash() (string, error) {
@@ -1,28 +1,66 @@
 package claim
 
 import (
+	"crypto/sha512"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
 
+// newTestResolver returns a resolver knowing `url` which resolves to a claim of `data` stream.
+func newTestResolver(t *testing.T, url string, data []byte) *MemoryResolver {
+	c, err := NewFakeClaim(url, "fear-of-death-inspirational", strings.Repeat("ab", 48), "@specialoperationstest#3")
+	require.NoError(t, err)
+	c.NormalizedName = c.Name
+	hash := sha512.Sum384(data)
+	src := c.Value.GetStream().GetSource()
+	src.Size = uint64(len(data))
+	src.Hash = hash[:]
+	r := NewMemoryResolver()
+	r.Add(url, c)
+	return r
+}
+
 func TestClaimResolve(t *testing.T) {
 	url := "lbry://@specialoperationstest#3/fear-of-death-inspirational#a"
-	c, err := Resolve(url)
+	r := newTestResolver(t, url, []byte("stream"))
+	c, err := r.Resolve(url)
 	require.NoError(t, err)
 	assert.Equal(t, "fear-of-death-inspirational", c.NormalizedName)
+	assert.Equal(t, strings.Repeat("ab", 48), c.SDHash)
+
+	_, err = r.Resolve("lbry://@specialoperationstest#3/missing#a")
+	assert.Equal(t, ErrStreamNotFound, err)
 }
 
 func TestClaimDownload(t *testing.T) {
 	url := "lbry://@specialoperationstest#3/fear-of-death-inspirational#a"
-	c, err := Resolve(url)
+	data := []byte(strings.Repeat("0123456789", 1000))
+	r := newTestResolver(t, url, data)
+	c, err := r.Resolve(url)
 	require.NoError(t, err)
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != fmt.Sprintf("/free/%s/%s/%s", c.Name, c.ClaimID, c.SDHash[:6]) {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write(data)
+	}))
+	defer ts.Close()
+	defer SetCDNServer(cdnServer)
+	SetCDNServer(ts.URL)
+
 	f, n, err := c.Download(path.Join(os.TempDir(), "transcoder_test"))
-	f.Close()
 	require.NoError(t, err)
+	f.Close()
 
 	fi, err := os.Stat(f.Name())
 	require.NoError(t, err)
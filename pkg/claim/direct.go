@@ -0,0 +1,161 @@
+package claim
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	ljsonrpc "github.com/lbryio/lbry.go/v2/extras/jsonrpc"
+)
+
+// Schemes of directly ingested sources, which are not LBRY claims.
+const (
+	SchemeFile   = "file"
+	SchemeHTTP   = "http"
+	SchemeHTTPS  = "https"
+	SchemeUpload = "upload"
+)
+
+var (
+	ErrInvalidDirectID  = errors.New("id should be 1 to 128 characters long and only contain letters, digits, '-' and '_'")
+	ErrInvalidDirectURL = errors.New("source should be a file://, http(s):// or upload:// URL")
+	ErrLocalNotAllowed  = errors.New("local path is outside of the ingestion directory")
+
+	directIDPattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,128}$`)
+)
+
+// IsDirect checks if `uri` points to a local file, HTTP(S) resource or an upload rather than a LBRY claim.
+func IsDirect(uri string) bool {
+	u, err := url.Parse(uri)
+	if err != nil {
+		return false
+	}
+	switch u.Scheme {
+	case SchemeFile, SchemeHTTP, SchemeHTTPS, SchemeUpload:
+		return true
+	}
+	return false
+}
+
+// ValidDirectID checks if `id` can identify directly ingested content, which also names its storage directory.
+func ValidDirectID(id string) bool {
+	return directIDPattern.MatchString(id)
+}
+
+// UploadURL returns the URL an upload saved under `id` is ingested from.
+func UploadURL(id string) string {
+	return fmt.Sprintf("%v://%v", SchemeUpload, id)
+}
+
+// NewDirectClaim makes a stand-in claim for directly ingested source at `uri`, identified by `id`
+// in place of the stream SD hash. It has no signing channel and no metadata.
+func NewDirectClaim(uri, id string) (*Claim, error) {
+	if !IsDirect(uri) {
+		return nil, ErrInvalidDirectURL
+	}
+	if !ValidDirectID(id) {
+		return nil, ErrInvalidDirectID
+	}
+	return &Claim{
+		Claim:  &ljsonrpc.Claim{Name: id, CanonicalURL: uri, PermanentURL: uri},
+		SDHash: id,
+	}, nil
+}
+
+// DirectFetcher retrieves directly ingested sources: local files under `LocalRoot`,
+// uploads saved in `UploadPath` and HTTP(S) URLs. Local files are not ingested when `LocalRoot` is empty.
+type DirectFetcher struct {
+	LocalRoot  string
+	UploadPath string
+}
+
+// LocalPath returns the file `uri` points to, if it's within `LocalRoot`.
+func (f DirectFetcher) LocalPath(uri string) (string, error) {
+	u, err := url.Parse(uri)
+	if err != nil || u.Scheme != SchemeFile {
+		return "", ErrInvalidDirectURL
+	}
+	if f.LocalRoot == "" {
+		return "", ErrLocalNotAllowed
+	}
+	root, err := filepath.Abs(f.LocalRoot)
+	if err != nil {
+		return "", err
+	}
+	p := filepath.Clean(u.Path)
+	if !strings.HasPrefix(p, root+string(filepath.Separator)) {
+		return "", ErrLocalNotAllowed
+	}
+	return p, nil
+}
+
+// UploadFile returns where upload of `id` is saved.
+func (f DirectFetcher) UploadFile(id string) string {
+	return path.Join(f.UploadPath, id)
+}
+
+func (f DirectFetcher) Fetch(c *Claim, dest string) (*os.File, int64, error) {
+	u, err := url.Parse(c.CanonicalURL)
+	if err != nil {
+		return nil, 0, err
+	}
+	switch u.Scheme {
+	case SchemeHTTP, SchemeHTTPS:
+		return download(c, c.CanonicalURL, dest)
+	case SchemeFile:
+		p, err := f.LocalPath(c.CanonicalURL)
+		if err != nil {
+			return nil, 0, err
+		}
+		return copyFile(p, dest, c.streamFileName())
+	case SchemeUpload:
+		if f.UploadPath == "" {
+			return nil, 0, errors.New("uploads are not enabled")
+		}
+		return copyFile(f.UploadFile(c.SDHash), dest, c.streamFileName())
+	}
+	return nil, 0, ErrInvalidDirectURL
+}
+
+// Release removes the upload `c` was ingested from, other sources are left in place.
+func (f DirectFetcher) Release(c *Claim) error {
+	u, err := url.Parse(c.CanonicalURL)
+	if err != nil || u.Scheme != SchemeUpload || f.UploadPath == "" {
+		return err
+	}
+	if err := os.Remove(f.UploadFile(c.SDHash)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// copyFile copies `src` into a temporary file in `dest` directory.
+func copyFile(src, dest, name string) (*os.File, int64, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer in.Close()
+
+	if err := os.MkdirAll(dest, os.ModePerm); err != nil {
+		return nil, 0, err
+	}
+	out, err := ioutil.TempFile(dest, name)
+	if err != nil {
+		return nil, 0, err
+	}
+	n, err := io.Copy(out, in)
+	if err != nil {
+		out.Close()
+		os.Remove(out.Name())
+		return nil, n, err
+	}
+	return out, n, nil
+}
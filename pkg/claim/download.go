@@ -0,0 +1,304 @@
+package claim
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lbryio/transcoder/internal/metrics"
+	"github.com/lbryio/transcoder/pkg/timer"
+)
+
+const partSuffix = ".part"
+
+var (
+	// ErrSourceMismatch means that downloaded stream does not match size or hash in the claim source metadata.
+	ErrSourceMismatch = errors.New("downloaded stream does not match claim source")
+	// ErrNoDownloadSpace means that download directory did not free up enough space in time.
+	ErrNoDownloadSpace = errors.New("not enough space in download directory")
+)
+
+var (
+	downloadAttempts   = 3
+	downloadRetryDelay = 2 * time.Second
+	// Partial downloads not touched for this long are considered abandoned and removed.
+	partMaxAge        = 6 * time.Hour
+	spacePollInterval = 2 * time.Second
+	spaceWaitTimeout  = 30 * time.Minute
+
+	downloadDirMaxSize int64
+	space              = &downloadSpace{reserved: map[string]int64{}}
+)
+
+// statusError is returned for CDN responses other than 200 and 206.
+type statusError int
+
+func (e statusError) Error() string {
+	return fmt.Sprintf("http response not ok: %v", int(e))
+}
+
+// SetDownloadDirLimit bounds total size of files in download directories, downloads wait until
+// there is enough space for them. Zero means no limit.
+func SetDownloadDirLimit(maxSize int64) {
+	downloadDirMaxSize = maxSize
+}
+
+// downloadSpace tracks space reserved by downloads in progress.
+type downloadSpace struct {
+	mu       sync.Mutex
+	reserved map[string]int64
+}
+
+// reserve waits until files in `dir` along with other reservations leave room for `size` bytes of `file`.
+// A download that doesn't fit into `maxSize` on its own is let through once nothing else occupies the directory.
+func (s *downloadSpace) reserve(dir, file string, size, maxSize int64, timeout time.Duration) error {
+	if maxSize <= 0 {
+		return nil
+	}
+	started := time.Now()
+	deadline := started.Add(timeout)
+	waiting := false
+	for {
+		s.mu.Lock()
+		used, err := s.usage(dir, file)
+		if err != nil {
+			s.mu.Unlock()
+			return err
+		}
+		if used+size <= maxSize || used == 0 {
+			s.reserved[file] = size
+			s.mu.Unlock()
+			if waiting {
+				metrics.DownloadSpaceWaitSeconds.Add(time.Since(started).Seconds())
+				logger.Infow("download space freed up", "file", file, "size", size, "used", used)
+			}
+			return nil
+		}
+		s.mu.Unlock()
+		if time.Now().After(deadline) {
+			metrics.DownloadSpaceWaitSeconds.Add(time.Since(started).Seconds())
+			return ErrNoDownloadSpace
+		}
+		if !waiting {
+			logger.Infow("waiting for download space", "file", file, "size", size, "used", used, "max_size", maxSize)
+			waiting = true
+		}
+		time.Sleep(spacePollInterval)
+	}
+}
+
+func (s *downloadSpace) release(file string) {
+	s.mu.Lock()
+	delete(s.reserved, file)
+	s.mu.Unlock()
+}
+
+// usage sums up sizes of files in `dir` other than `except`, counting reservations for files still being downloaded.
+// Abandoned partial downloads are removed along the way.
+func (s *downloadSpace) usage(dir, except string) (int64, error) {
+	var used int64
+	counted := map[string]bool{}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+	for _, fi := range entries {
+		p := path.Join(dir, fi.Name())
+		if fi.IsDir() || p == except {
+			continue
+		}
+		r, reserved := s.reserved[p]
+		if !reserved && strings.HasSuffix(p, partSuffix) && time.Since(fi.ModTime()) > partMaxAge {
+			logger.Infow("removing abandoned partial download", "file", p)
+			if err := os.Remove(p); err == nil {
+				continue
+			}
+		}
+		counted[p] = true
+		if r > fi.Size() {
+			used += r
+		} else {
+			used += fi.Size()
+		}
+	}
+	for p, r := range s.reserved {
+		if !counted[p] && p != except && path.Dir(p) == dir {
+			used += r
+		}
+	}
+	return used, nil
+}
+
+// download retrieves `url` into `dest` directory, resuming partial download left by a previous attempt if there is one.
+// The file is checked against claim source size and hash before it's handed over.
+func download(c *Claim, url, dest string) (*os.File, int64, error) {
+	return retrieve(c, url, dest, func(partFile string, size int64) (int64, error) {
+		var (
+			readLen int64
+			err     error
+		)
+		for attempt := 1; attempt <= downloadAttempts; attempt++ {
+			readLen, err = downloadPart(url, partFile, size)
+			if err == nil {
+				break
+			}
+			logger.Warnw("download attempt failed", "url", url, "attempt", attempt, "size", readLen, "err", err)
+			if se, ok := err.(statusError); ok && se < http.StatusInternalServerError {
+				break
+			}
+			if attempt < downloadAttempts {
+				time.Sleep(downloadRetryDelay * time.Duration(attempt))
+			}
+		}
+		return readLen, err
+	})
+}
+
+// retrieve reserves space in `dest` directory for the claim stream, which is then written by `write` into a partial file.
+// The file is checked against claim source size and hash before it's renamed and handed over.
+func retrieve(c *Claim, from, dest string, write func(partFile string, size int64) (int64, error)) (*os.File, int64, error) {
+	if err := os.MkdirAll(dest, os.ModePerm); err != nil {
+		return nil, 0, err
+	}
+	partFile := path.Join(dest, c.streamFileName()+partSuffix)
+	src := c.Value.GetStream().GetSource()
+	size := int64(src.GetSize())
+
+	if err := space.reserve(dest, partFile, size, downloadDirMaxSize, spaceWaitTimeout); err != nil {
+		return nil, 0, err
+	}
+	defer space.release(partFile)
+
+	tmr := timer.Start()
+	readLen, err := write(partFile, size)
+	if err != nil {
+		return nil, readLen, err
+	}
+	tmr.Stop()
+
+	if err := verifySource(partFile, src.GetSize(), src.GetHash()); err != nil {
+		if errors.Is(err, ErrSourceMismatch) {
+			metrics.DownloadVerificationFailures.Inc()
+		}
+		os.Remove(partFile)
+		return nil, 0, err
+	}
+
+	file := path.Join(dest, c.streamFileName())
+	if err := os.Rename(partFile, file); err != nil {
+		return nil, 0, err
+	}
+	out, err := os.OpenFile(file, os.O_RDWR, 0644)
+	if err != nil {
+		return nil, 0, err
+	}
+	fi, err := out.Stat()
+	if err != nil {
+		out.Close()
+		return nil, 0, err
+	}
+	rate := int64(float64(readLen) / tmr.Duration())
+	logger.Infow("stream downloaded", "from", from, "rate", rate, "size", fi.Size(), "seconds_spent", tmr.DurationInt())
+	return out, fi.Size(), nil
+}
+
+// downloadPart appends the rest of `url` content to `partFile`, returning the number of bytes read.
+func downloadPart(url, partFile string, size int64) (int64, error) {
+	var offset int64
+	if fi, err := os.Stat(partFile); err == nil {
+		offset = fi.Size()
+	}
+	if size > 0 && offset == size {
+		return 0, nil
+	}
+	if size > 0 && offset > size {
+		if err := os.Remove(partFile); err != nil {
+			return 0, err
+		}
+		offset = 0
+	}
+
+	req, err := http.NewRequest(http.MethodGet, url, nil)
+	if err != nil {
+		return 0, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%v-", offset))
+	}
+	logger.Infow("downloading stream", "url", url, "offset", offset)
+
+	resp, err := downloadClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY
+	switch resp.StatusCode {
+	case http.StatusPartialContent:
+		flags |= os.O_APPEND
+		metrics.DownloadsResumed.Inc()
+	case http.StatusOK:
+		// Server ignored the range, starting over.
+		flags |= os.O_TRUNC
+		offset = 0
+	case http.StatusRequestedRangeNotSatisfiable:
+		// Nothing left to download, verification will tell if the file is complete.
+		return 0, nil
+	default:
+		return 0, statusError(resp.StatusCode)
+	}
+
+	out, err := os.OpenFile(partFile, flags, 0644)
+	if err != nil {
+		return 0, err
+	}
+	defer out.Close()
+
+	wc := &WriteCounter{
+		Loaded:         uint64(offset),
+		Size:           uint64(offset + resp.ContentLength),
+		Started:        time.Now(),
+		URL:            url,
+		progressLogged: map[int]bool{},
+	}
+	return io.Copy(out, io.TeeReader(throttledReader{resp.Body, bandwidth}, wc))
+}
+
+// verifySource checks file against claim source `size` and sha384 `hash`, skipping checks for missing values.
+func verifySource(file string, size uint64, hash []byte) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if size > 0 && uint64(fi.Size()) != size {
+		logger.Warnw("downloaded stream size mismatch", "file", file, "size", fi.Size(), "expected", size)
+		return fmt.Errorf("%w: size is %v, expected %v", ErrSourceMismatch, fi.Size(), size)
+	}
+	if len(hash) != sha512.Size384 {
+		return nil
+	}
+	h := sha512.New384()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	if !bytes.Equal(h.Sum(nil), hash) {
+		logger.Warnw("downloaded stream hash mismatch", "file", file)
+		return fmt.Errorf("%w: hash does not match", ErrSourceMismatch)
+	}
+	return nil
+}
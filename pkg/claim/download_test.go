@@ -0,0 +1,117 @@
+package claim
+
+import (
+	"crypto/sha512"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDownloadResumeAndVerify(t *testing.T) {
+	downloadRetryDelay = 0
+	data := []byte(strings.Repeat("0123456789", 100))
+	hash := sha512.Sum384(data)
+	sdHash := strings.Repeat("cd", 48)
+	c, err := NewFakeClaim("lbry://video#a", "a1", sdHash, "")
+	require.NoError(t, err)
+	src := c.Value.GetStream().GetSource()
+	src.Size = uint64(len(data))
+	src.Hash = hash[:]
+
+	dest, err := ioutil.TempDir("", "transcoder_test_download")
+	require.NoError(t, err)
+	defer os.RemoveAll(dest)
+
+	var ranges []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ranges = append(ranges, r.Header.Get("Range"))
+		if len(ranges) == 1 {
+			// Drop the connection halfway through the first response.
+			w.Header().Set("Content-Length", "1000")
+			w.Write(data[:500])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		http.ServeContent(w, r, "", time.Time{}, strings.NewReader(string(data)))
+	}))
+	defer ts.Close()
+
+	fh, n, err := CDNFetcher{Server: ts.URL}.Fetch(c, dest)
+	require.NoError(t, err)
+	fh.Close()
+	assert.EqualValues(t, len(data), n)
+	assert.Equal(t, []string{"", "bytes=500-"}, ranges)
+	assert.Equal(t, path.Join(dest, sdHash), fh.Name())
+	stored, err := ioutil.ReadFile(fh.Name())
+	require.NoError(t, err)
+	assert.Equal(t, data, stored)
+	os.Remove(fh.Name())
+
+	src.Hash = make([]byte, sha512.Size384)
+	_, _, err = CDNFetcher{Server: ts.URL}.Fetch(c, dest)
+	assert.True(t, errors.Is(err, ErrSourceMismatch))
+	_, err = os.Stat(path.Join(dest, sdHash+partSuffix))
+	assert.True(t, os.IsNotExist(err))
+
+	src.Hash = hash[:]
+	src.Size = 10
+	_, _, err = CDNFetcher{Server: ts.URL}.Fetch(c, dest)
+	assert.True(t, errors.Is(err, ErrSourceMismatch))
+}
+
+func TestDownloadSpace(t *testing.T) {
+	spacePollInterval = 10 * time.Millisecond
+	s := &downloadSpace{reserved: map[string]int64{}}
+	dir, err := ioutil.TempDir("", "transcoder_test_space")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	a, b := path.Join(dir, "a.part"), path.Join(dir, "b.part")
+	require.NoError(t, s.reserve(dir, a, 80, 100, time.Second))
+	// Doesn't fit alongside the first one.
+	assert.Equal(t, ErrNoDownloadSpace, s.reserve(dir, b, 30, 100, 50*time.Millisecond))
+
+	done := make(chan error)
+	go func() { done <- s.reserve(dir, b, 30, 100, time.Second) }()
+	time.Sleep(30 * time.Millisecond)
+	s.release(a)
+	assert.NoError(t, <-done)
+	s.release(b)
+
+	// Files on disk count too, oversized downloads go through when the directory is empty.
+	require.NoError(t, ioutil.WriteFile(path.Join(dir, "c"), make([]byte, 50), 0644))
+	assert.Equal(t, ErrNoDownloadSpace, s.reserve(dir, a, 200, 100, 0))
+	os.Remove(path.Join(dir, "c"))
+	assert.NoError(t, s.reserve(dir, a, 200, 100, 0))
+	s.release(a)
+
+	// Abandoned partial downloads are cleaned up.
+	stale := path.Join(dir, "stale.part")
+	require.NoError(t, ioutil.WriteFile(stale, make([]byte, 90), 0644))
+	old := time.Now().Add(-partMaxAge - time.Hour)
+	require.NoError(t, os.Chtimes(stale, old, old))
+	assert.NoError(t, s.reserve(dir, a, 50, 100, 0))
+	_, err = os.Stat(stale)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestThrottle(t *testing.T) {
+	th := &throttle{rate: 100 * 1024}
+	r := throttledReader{strings.NewReader(strings.Repeat("a", 50*1024)), th}
+	start := time.Now()
+	n, err := io.Copy(ioutil.Discard, r)
+	require.NoError(t, err)
+	assert.EqualValues(t, 50*1024, n)
+	// The first read goes through right away, the rest is paced.
+	assert.GreaterOrEqual(t, time.Since(start).Seconds(), 0.35)
+}
@@ -0,0 +1,66 @@
+package claim
+
+import (
+	"crypto/sha256"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"math/big"
+
+	"github.com/btcsuite/btcd/btcec"
+)
+
+var (
+	ErrNoChannelKey     = errors.New("signing channel has no public key")
+	ErrInvalidSignature = errors.New("invalid channel signature")
+)
+
+// publicKeyInfo is a DER-encoded SubjectPublicKeyInfo structure channel public keys are stored in.
+type publicKeyInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	PublicKey asn1.BitString
+}
+
+// ChannelPublicKey returns public key of the channel that signed the claim.
+func (c *Claim) ChannelPublicKey() (*btcec.PublicKey, error) {
+	if c.SigningChannel == nil {
+		return nil, ErrNoChannelKey
+	}
+	der := c.SigningChannel.Value.GetChannel().GetPublicKey()
+	if len(der) == 0 {
+		return nil, ErrNoChannelKey
+	}
+	var pki publicKeyInfo
+	if _, err := asn1.Unmarshal(der, &pki); err != nil {
+		return nil, err
+	}
+	return btcec.ParsePubKey(pki.PublicKey.Bytes, btcec.S256())
+}
+
+// VerifyChannelSignature checks that `data` was signed with the private key of the claim's signing channel
+// at `signingTS`, as done by `lbrynet channel_sign`: the signature is a hex-encoded 64-byte `r || s` pair
+// (DER encoding is accepted as well) of SHA-256 digest of `signingTS` followed by `data`.
+func (c *Claim) VerifyChannelSignature(data []byte, signature, signingTS string) error {
+	pub, err := c.ChannelPublicKey()
+	if err != nil {
+		return err
+	}
+	raw, err := hex.DecodeString(signature)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	var sig *btcec.Signature
+	if len(raw) == 64 {
+		sig = &btcec.Signature{R: new(big.Int).SetBytes(raw[:32]), S: new(big.Int).SetBytes(raw[32:])}
+	} else if sig, err = btcec.ParseDERSignature(raw, btcec.S256()); err != nil {
+		return ErrInvalidSignature
+	}
+
+	digest := sha256.Sum256(append([]byte(signingTS), data...))
+	if !sig.Verify(digest[:], pub) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
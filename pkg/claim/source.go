@@ -0,0 +1,56 @@
+package claim
+
+import (
+	"fmt"
+	"os"
+)
+
+// Resolver looks up claims by their URIs.
+type Resolver interface {
+	Resolve(uri string) (*Claim, error)
+}
+
+// SourceFetcher retrieves original stream files of claims, saving them into temporary files in `dest` directory.
+type SourceFetcher interface {
+	Fetch(c *Claim, dest string) (*os.File, int64, error)
+}
+
+// SourceReleaser is implemented by fetchers which keep original sources until they are processed,
+// Release is called once the claim video is stored and its source is no longer needed.
+type SourceReleaser interface {
+	Release(c *Claim) error
+}
+
+// ResolverFunc is an adapter to allow the use of ordinary functions as resolvers.
+type ResolverFunc func(uri string) (*Claim, error)
+
+func (f ResolverFunc) Resolve(uri string) (*Claim, error) {
+	return f(uri)
+}
+
+// DefaultResolver resolves claims using the lbrytv API and the resolve cache if it's set.
+var DefaultResolver Resolver = ResolverFunc(Resolve)
+
+// DefaultFetcher downloads streams from the CDN server set with SetCDNServer.
+var DefaultFetcher SourceFetcher = cdnFetcher{}
+
+type cdnFetcher struct{}
+
+func (cdnFetcher) Fetch(c *Claim, dest string) (*os.File, int64, error) {
+	return CDNFetcher{Server: cdnServer}.Fetch(c, dest)
+}
+
+// CDNFetcher downloads streams from a lbrytv CDN compatible `Server`.
+type CDNFetcher struct {
+	Server string
+}
+
+func (f CDNFetcher) url(c *Claim) string {
+	return fmt.Sprintf("%s/free/%s/%s/%s", f.Server, c.Name, c.ClaimID, c.SDHash[:6])
+}
+
+// Fetch downloads the claim stream into `dest`, resuming a partial download of it if one is found there.
+// The stream is verified against claim source metadata, ErrSourceMismatch is returned if it doesn't match.
+func (f CDNFetcher) Fetch(c *Claim, dest string) (*os.File, int64, error) {
+	return download(c, f.url(c), dest)
+}
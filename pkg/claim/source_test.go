@@ -0,0 +1,122 @@
+package claim
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFakeSources(t *testing.T) {
+	sdHash := strings.Repeat("ab", 48)
+	c, err := NewFakeClaim("lbry://@chan#1/video#a", "a1", sdHash, "lbry://@chan#1")
+	require.NoError(t, err)
+	assert.Equal(t, sdHash, c.SDHash)
+	assert.Equal(t, "lbry://@chan#1", c.SigningChannel.CanonicalURL)
+
+	r := NewMemoryResolver()
+	r.Add("lbry://video", c)
+	rc, err := r.Resolve("lbry://video")
+	require.NoError(t, err)
+	assert.Equal(t, c, rc)
+	_, err = r.Resolve("lbry://other")
+	assert.Equal(t, ErrStreamNotFound, err)
+
+	dest := path.Join(os.TempDir(), "transcoder_test_fetch")
+	defer os.RemoveAll(dest)
+	src := path.Join(os.TempDir(), "transcoder_test_source")
+	require.NoError(t, ioutil.WriteFile(src, []byte("video data"), 0644))
+	defer os.Remove(src)
+
+	f := NewFileFetcher()
+	_, _, err = f.Fetch(c, dest)
+	assert.Error(t, err)
+	f.Add(sdHash, src)
+	fh, n, err := f.Fetch(c, dest)
+	require.NoError(t, err)
+	fh.Close()
+	assert.EqualValues(t, 10, n)
+	data, err := ioutil.ReadFile(fh.Name())
+	require.NoError(t, err)
+	assert.Equal(t, "video data", string(data))
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/free/a1/a1/"+sdHash[:6] {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Write([]byte("cdn video data"))
+	}))
+	defer ts.Close()
+
+	fh, n, err = CDNFetcher{Server: ts.URL}.Fetch(c, dest)
+	require.NoError(t, err)
+	fh.Close()
+	assert.EqualValues(t, 14, n)
+
+	c.ClaimID = "b2"
+	_, _, err = CDNFetcher{Server: ts.URL}.Fetch(c, dest)
+	assert.EqualError(t, err, "http response not ok: 404")
+}
+
+func TestDirectSources(t *testing.T) {
+	assert.True(t, IsDirect("file:///srv/ads/ad.mp4"))
+	assert.True(t, IsDirect("https://cdn.example.com/trailer.mp4"))
+	assert.True(t, IsDirect(UploadURL("internal")))
+	assert.False(t, IsDirect("lbry://video#a"))
+
+	_, err := NewDirectClaim("lbry://video#a", "id")
+	assert.Equal(t, ErrInvalidDirectURL, err)
+	_, err = NewDirectClaim("https://cdn.example.com/trailer.mp4", "../id")
+	assert.Equal(t, ErrInvalidDirectID, err)
+
+	root := t.TempDir()
+	f := DirectFetcher{LocalRoot: root, UploadPath: t.TempDir()}
+	dest := t.TempDir()
+	_, err = f.LocalPath("file://" + root + "/../etc/passwd")
+	assert.Equal(t, ErrLocalNotAllowed, err)
+	_, err = DirectFetcher{}.LocalPath("file://" + root + "/ad.mp4")
+	assert.Equal(t, ErrLocalNotAllowed, err)
+
+	require.NoError(t, ioutil.WriteFile(path.Join(root, "ad.mp4"), []byte("ad"), 0644))
+	c, err := NewDirectClaim("file://"+root+"/ad.mp4", "ad")
+	require.NoError(t, err)
+	assert.Nil(t, c.SigningChannel)
+	fh, n, err := f.Fetch(c, dest)
+	require.NoError(t, err)
+	fh.Close()
+	assert.EqualValues(t, 2, n)
+	_, err = os.Stat(path.Join(root, "ad.mp4"))
+	assert.NoError(t, err, "local files should be left in place")
+
+	require.NoError(t, ioutil.WriteFile(f.UploadFile("internal"), []byte("internal"), 0644))
+	c, err = NewDirectClaim(UploadURL("internal"), "internal")
+	require.NoError(t, err)
+	fh, n, err = f.Fetch(c, dest)
+	require.NoError(t, err)
+	fh.Close()
+	assert.EqualValues(t, 8, n)
+	_, err = os.Stat(f.UploadFile("internal"))
+	assert.NoError(t, err, "uploads should be kept until released")
+	require.NoError(t, f.Release(c))
+	_, err = os.Stat(f.UploadFile("internal"))
+	assert.True(t, os.IsNotExist(err), "released uploads should be removed")
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("trailer"))
+	}))
+	defer ts.Close()
+	c, err = NewDirectClaim(ts.URL+"/trailer.mp4", "trailer")
+	require.NoError(t, err)
+	fh, n, err = f.Fetch(c, dest)
+	require.NoError(t, err)
+	fh.Close()
+	assert.EqualValues(t, 7, n)
+	assert.Equal(t, path.Join(dest, "trailer"), fh.Name())
+}
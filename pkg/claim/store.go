@@ -0,0 +1,122 @@
+package claim
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	ljsonrpc "github.com/lbryio/lbry.go/v2/extras/jsonrpc"
+	"github.com/lbryio/transcoder/db"
+)
+
+// Migrations contains all resolved claims store schema changes in the order they should be applied.
+var Migrations = []db.Migration{
+	{Name: "claims", SQL: ClaimsMigration},
+}
+
+var ClaimsMigration = `
+-- +migrate Up
+
+-- +migrate StatementBegin
+CREATE TABLE IF NOT EXISTS claims (
+    "uri" TEXT PRIMARY KEY,
+    "claim_id" TEXT NOT NULL,
+    "name" TEXT NOT NULL,
+    "sd_hash" TEXT NOT NULL,
+    "permanent_url" TEXT NOT NULL,
+    "canonical_url" TEXT NOT NULL,
+    "value" BLOB NOT NULL,
+    "channel_claim_id" TEXT NOT NULL DEFAULT "",
+    "channel_canonical_url" TEXT NOT NULL DEFAULT "",
+    "channel_value" BLOB,
+    "resolved_at" TIMESTAMP NOT NULL
+);
+-- +migrate StatementEnd
+
+-- +migrate Down
+
+-- +migrate StatementBegin
+DROP TABLE claims;
+-- +migrate StatementEnd
+`
+
+var (
+	queryClaimPut = `
+		insert or replace into claims (
+			uri, claim_id, name, sd_hash, permanent_url, canonical_url, value,
+			channel_claim_id, channel_canonical_url, channel_value, resolved_at
+		) values (
+			$1, $2, $3, $4, $5, $6, $7, $8, $9, $10, datetime('now')
+		)`
+	queryClaimGet = `
+		select claim_id, name, sd_hash, permanent_url, canonical_url, value,
+			channel_claim_id, channel_canonical_url, channel_value
+		from claims where uri = $1`
+)
+
+// DBStore keeps resolved claims in a SQLite database.
+type DBStore struct {
+	db *db.DB
+}
+
+// NewDBStore creates a claim store, `d` should have `Migrations` applied.
+func NewDBStore(d *db.DB) *DBStore {
+	return &DBStore{db: d}
+}
+
+// Put saves claim resolved for `uri`.
+func (s *DBStore) Put(uri string, c *Claim) error {
+	value, err := proto.Marshal(&c.Value)
+	if err != nil {
+		return err
+	}
+	var (
+		channelID, channelURL string
+		channelValue          []byte
+	)
+	if c.SigningChannel != nil {
+		channelID, channelURL = c.SigningChannel.ClaimID, c.SigningChannel.CanonicalURL
+		if channelValue, err = proto.Marshal(&c.SigningChannel.Value); err != nil {
+			return err
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = s.db.ExecContext(
+		ctx, queryClaimPut,
+		uri, c.ClaimID, c.Name, c.SDHash, c.PermanentURL, c.CanonicalURL, value,
+		channelID, channelURL, channelValue,
+	)
+	return err
+}
+
+// Get returns claim last resolved for `uri` or nil if there is none.
+func (s *DBStore) Get(uri string) (*Claim, error) {
+	var (
+		value, channelValue   []byte
+		channelID, channelURL string
+	)
+	c := &Claim{Claim: &ljsonrpc.Claim{}}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	err := s.db.QueryRowContext(ctx, queryClaimGet, uri).Scan(
+		&c.ClaimID, &c.Name, &c.SDHash, &c.PermanentURL, &c.CanonicalURL, &value,
+		&channelID, &channelURL, &channelValue,
+	)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := proto.Unmarshal(value, &c.Value); err != nil {
+		return nil, err
+	}
+	if channelURL != "" {
+		c.SigningChannel = &ljsonrpc.Claim{ClaimID: channelID, CanonicalURL: channelURL}
+		if err := proto.Unmarshal(channelValue, &c.SigningChannel.Value); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
@@ -0,0 +1,59 @@
+package claim
+
+import (
+	"io"
+	"sync"
+	"time"
+)
+
+// throttleChunk is the largest read let through at once by a throttled reader.
+const throttleChunk = 32 * 1024
+
+var bandwidth = &throttle{}
+
+// SetDownloadRate limits combined speed of all stream downloads to `bytesPerSec`. Zero means no limit.
+func SetDownloadRate(bytesPerSec int64) {
+	bandwidth.mu.Lock()
+	bandwidth.rate = bytesPerSec
+	bandwidth.mu.Unlock()
+}
+
+// throttle paces reads shared by multiple readers so they don't exceed `rate` bytes per second together.
+type throttle struct {
+	mu   sync.Mutex
+	rate int64
+	next time.Time
+}
+
+// wait blocks until `n` more bytes can be read.
+func (t *throttle) wait(n int) {
+	t.mu.Lock()
+	if t.rate <= 0 {
+		t.mu.Unlock()
+		return
+	}
+	now := time.Now()
+	at := t.next
+	if at.Before(now) {
+		at = now
+	}
+	t.next = at.Add(time.Duration(float64(n) / float64(t.rate) * float64(time.Second)))
+	t.mu.Unlock()
+	time.Sleep(at.Sub(now))
+}
+
+type throttledReader struct {
+	r io.Reader
+	t *throttle
+}
+
+func (r throttledReader) Read(p []byte) (int, error) {
+	if len(p) > throttleChunk {
+		p = p[:throttleChunk]
+	}
+	n, err := r.r.Read(p)
+	if n > 0 {
+		r.t.wait(n)
+	}
+	return n, err
+}
@@ -0,0 +1,98 @@
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// Task stages reported through the bus.
+const (
+	StageQueued      = "queued"
+	StageDownloading = "downloading"
+	StageEncoding    = "encoding"
+	StageDone        = "done"
+	StageUploading   = "uploading"
+	StageUploaded    = "uploaded"
+	StageFailed      = "failed"
+)
+
+const subscriptionBuffer = 100
+
+// Event describes a transition of video processing task.
+type Event struct {
+	Stage    string    `json:"stage"`
+	SDHash   string    `json:"sd_hash"`
+	URL      string    `json:"url,omitempty"`
+	Channel  string    `json:"channel,omitempty"`
+	TaskID   uint32    `json:"task_id,omitempty"`
+	Progress float64   `json:"progress,omitempty"`
+	Error    string    `json:"error,omitempty"`
+	Time     time.Time `json:"time"`
+}
+
+// Bus delivers events to subscribers in-process. Publishing never blocks,
+// events are dropped for subscribers that cannot keep up.
+type Bus struct {
+	mu   sync.RWMutex
+	subs map[*Subscription]bool
+}
+
+// Subscription receives events published on the bus until closed.
+type Subscription struct {
+	C      <-chan Event
+	c      chan Event
+	sdHash string
+	bus    *Bus
+	once   sync.Once
+}
+
+func NewBus() *Bus {
+	return &Bus{subs: map[*Subscription]bool{}}
+}
+
+// Subscribe starts receiving events for stream `sdHash`, or for all streams if it's empty.
+func (b *Bus) Subscribe(sdHash string) *Subscription {
+	c := make(chan Event, subscriptionBuffer)
+	s := &Subscription{C: c, c: c, sdHash: sdHash, bus: b}
+	b.mu.Lock()
+	b.subs[s] = true
+	b.mu.Unlock()
+	SubscribersCount.Inc()
+	return s
+}
+
+// Publish sends event `e` to all interested subscribers.
+func (b *Bus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	PublishedCount.WithLabelValues(e.Stage).Inc()
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for s := range b.subs {
+		if s.sdHash != "" && s.sdHash != e.SDHash {
+			continue
+		}
+		select {
+		case s.c <- e:
+		default:
+			DroppedCount.Inc()
+			logger.Debugw("subscriber is lagging, event dropped", "sd_hash", e.SDHash, "stage", e.Stage)
+		}
+	}
+}
+
+// Close stops event delivery and closes subscription channel.
+func (s *Subscription) Close() {
+	s.once.Do(func() {
+		s.bus.mu.Lock()
+		delete(s.bus.subs, s)
+		s.bus.mu.Unlock()
+		close(s.c)
+		SubscribersCount.Dec()
+	})
+}
@@ -0,0 +1,41 @@
+package events
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBus(t *testing.T) {
+	b := NewBus()
+	all := b.Subscribe("")
+	one := b.Subscribe("abc")
+
+	b.Publish(Event{Stage: StageQueued, SDHash: "abc"})
+	b.Publish(Event{Stage: StageEncoding, SDHash: "def", Progress: 10})
+
+	e := <-all.C
+	assert.Equal(t, StageQueued, e.Stage)
+	assert.False(t, e.Time.IsZero())
+	e = <-all.C
+	assert.Equal(t, "def", e.SDHash)
+
+	e = <-one.C
+	assert.Equal(t, "abc", e.SDHash)
+	assert.Len(t, one.C, 0)
+
+	one.Close()
+	one.Close()
+	_, ok := <-one.C
+	assert.False(t, ok)
+
+	for range [subscriptionBuffer + 10]int{} {
+		b.Publish(Event{Stage: StageEncoding, SDHash: "abc"})
+	}
+	require.Len(t, all.C, subscriptionBuffer)
+	all.Close()
+
+	var nb *Bus
+	nb.Publish(Event{Stage: StageDone})
+}
@@ -0,0 +1,12 @@
+package events
+
+import (
+	"github.com/lbryio/transcoder/pkg/logging"
+	"go.uber.org/zap"
+)
+
+var logger = logging.Create("events", logging.Dev)
+
+func SetLogger(l *zap.SugaredLogger) {
+	logger = l
+}
@@ -0,0 +1,18 @@
+package events
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var (
+	SubscribersCount = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "events_subscribers_count",
+	})
+	PublishedCount = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "events_published_count",
+	}, []string{"stage"})
+	DroppedCount = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "events_dropped_count",
+	})
+)
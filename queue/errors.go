@@ -0,0 +1,8 @@
+package queue
+
+import "errors"
+
+var (
+	ErrTaskNotFound      = errors.New("task not found")
+	ErrInvalidTransition = errors.New("task status does not allow this operation")
+)
@@ -11,6 +11,15 @@ const (
 	StatusRejected  = "rejected"
 	StatusReleased  = "released"
 	StatusCompleted = "completed"
+	StatusCanceled  = "canceled"
+)
+
+// Processing stages of a started task.
+const (
+	StageDownloading = "downloading"
+	// StageReady means that source is downloaded and waiting for an encoder.
+	StageReady    = "ready"
+	StageEncoding = "encoding"
 )
 
 type Task struct {
@@ -19,7 +28,11 @@ type Task struct {
 	CreatedAt string
 	URL       string
 	Progress  sql.NullFloat64
+	Speed     sql.NullFloat64
 	StartedAt sql.NullString
 	Status    string
 	Type      string
+	Priority  int
+	// Stage is set for started tasks only.
+	Stage sql.NullString
 }
@@ -47,18 +47,28 @@ func (p Poller) StartTask(t *Task) error {
 	return p.queue.Start(t.ID)
 }
 
-func (p Poller) ProgressTask(t *Task, progress float64) error {
-	return p.queue.UpdateProgress(t.ID, progress)
+func (p Poller) StageTask(t *Task, stage string) error {
+	return p.queue.SetStage(t.ID, stage)
+}
+
+func (p Poller) ProgressTask(t *Task, progress, speed float64) error {
+	return p.queue.UpdateProgress(t.ID, progress, speed)
 }
 
 func (p Poller) RejectTask(t *Task) error {
-	return p.queue.Reject(t.ID)
+	_, err := p.queue.Fail(t.ID)
+	return err
 }
 
 func (p Poller) ReleaseTask(t *Task) error {
 	return p.queue.Release(t.ID)
 }
 
-func (p Poller) CompleteTask(t *Task) {
-	p.queue.Complete(t.ID)
+func (p Poller) CompleteTask(t *Task) error {
+	return p.queue.Complete(t.ID)
+}
+
+// GetTask returns the current state of task `t`.
+func (p Poller) GetTask(t *Task) (*Task, error) {
+	return p.queue.Get(t.ID)
 }
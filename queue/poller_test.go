@@ -21,7 +21,7 @@ type PollerSuite struct {
 
 func (s *PollerSuite) SetupTest() {
 	s.db = db.OpenTestDB()
-	s.db.MigrateUp(InitialMigration)
+	s.db.Migrate(Migrations...)
 }
 
 func (s *PollerSuite) StartPollerWorker(p *Poller, q *Queue, wf func(*Task)) {
@@ -7,9 +7,11 @@ import (
 )
 
 var (
-	queryTaskGet         = `select id, sd_hash, created_at, url, progress, started_at, type, status from tasks where id = $1`
-	queryTaskGetBySDHash = `select id, sd_hash, created_at, url, progress, started_at, type, status from tasks where sd_hash = $1`
-	queryList            = `select id, sd_hash, created_at, url, progress, started_at, type, status from tasks`
+	allTaskColumns = `id, sd_hash, created_at, url, progress, speed, started_at, type, status, priority, stage`
+
+	queryTaskGet         = fmt.Sprintf(`select %v from tasks where id = $1`, allTaskColumns)
+	queryTaskGetBySDHash = fmt.Sprintf(`select %v from tasks where sd_hash = $1`, allTaskColumns)
+	queryList            = fmt.Sprintf(`select %v from tasks`, allTaskColumns)
 	queryTaskAdd         = `
 		insert into tasks (
 			url, sd_hash, type, status, created_at
@@ -17,18 +19,43 @@ var (
 			$1, $2, $3, "new", datetime('now')
 		);
 	`
-	queryTaskPoll = `
-		select id, sd_hash, created_at, url, progress, started_at, type, status from tasks
-		where status in ("new", "released") order by created_at asc limit 1
-	`
+	queryTaskPoll = fmt.Sprintf(`
+		select %v from tasks
+		where status in ("new", "released") order by priority desc, created_at asc limit 1
+	`, allTaskColumns)
+	queryTaskListPage = fmt.Sprintf(`
+		select %v from tasks where ($1 = "" or status = $1) order by id desc limit $2 offset $3
+	`, allTaskColumns)
+	queryTaskCount       = `select count(*) from tasks where ($1 = "" or status = $1)`
 	queryTaskMarkStarted = fmt.Sprintf(
-		`update tasks set started_at = datetime('now'), progress = 0, status = "%v" where id = $1`,
-		StatusStarted)
+		`update tasks set started_at = datetime('now'), progress = 0, speed = null, stage = null, status = "%v" where id = $1 and status = "%v"`,
+		StatusStarted, StatusPending)
 	queryTaskMarkReleased = fmt.Sprintf(
-		`update tasks set started_at = null, progress = null, status = "%v" where id = $1`,
+		`update tasks set started_at = null, progress = null, speed = null, stage = null, status = "%v" where id = $1`,
 		StatusReleased)
-	queryUpdateProgress = `update tasks set progress = $1 where id = $2`
-	queryUpdateStatus   = `update tasks set status = $1 where id = $2`
+	queryUpdateProgress = `update tasks set progress = $1, speed = $2 where id = $3`
+	queryUpdateStatus   = `update tasks set status = $1, stage = null where id = $2`
+	queryTaskPosition   = `
+		select count(*) from tasks
+		where status in ("new", "released") and (
+			priority > $1 or
+			(priority = $1 and (created_at < $2 or (created_at = $2 and id < $3)))
+		)
+	`
+	queryTaskRequeue = fmt.Sprintf(
+		`update tasks set started_at = null, progress = null, speed = null, stage = null, status = "%v" where id = $1`,
+		StatusNew)
+	queryTaskCancel = fmt.Sprintf(
+		`update tasks set status = "%v" where id = $1`,
+		StatusCanceled)
+	queryTaskComplete = fmt.Sprintf(
+		`update tasks set status = "%v", stage = null where id = $1 and status = "%v"`,
+		StatusCompleted, StatusStarted)
+	queryTaskReject = fmt.Sprintf(
+		`update tasks set status = "%v", stage = null where id = $1`,
+		StatusRejected)
+	queryTaskUpdatePriority = `update tasks set priority = $1 where id = $2`
+	queryTaskUpdateStage    = fmt.Sprintf(`update tasks set stage = $1 where id = $2 and status = "%v"`, StatusStarted)
 )
 
 type rowScanner interface {
@@ -116,7 +143,7 @@ func (q *Queries) Release(ctx context.Context, id uint32) error {
 	return nil
 }
 
-func (q *Queries) updateProgress(ctx context.Context, id uint32, progress float64) error {
+func (q *Queries) updateProgress(ctx context.Context, id uint32, progress, speed float64) error {
 	tx, err := q.db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
@@ -127,7 +154,7 @@ func (q *Queries) updateProgress(ctx context.Context, id uint32, progress float6
 		tx.Rollback()
 		return fmt.Errorf("wrong status for progressing task: %v", i.Status)
 	}
-	_, err = tx.ExecContext(ctx, queryUpdateProgress, progress, id)
+	_, err = tx.ExecContext(ctx, queryUpdateProgress, progress, speed, id)
 	if err != nil {
 		tx.Rollback()
 		return err
@@ -156,6 +183,141 @@ func (q *Queries) updateStatus(ctx context.Context, id uint32, status string) er
 	return nil
 }
 
+type ListParams struct {
+	// Status filters tasks by status, all tasks are listed if it's empty.
+	Status string
+	Limit  int
+	Offset int
+}
+
+// ListPage returns tasks matching `arg`, newest first, along with the total number of matching tasks.
+func (q *Queries) ListPage(ctx context.Context, arg ListParams) ([]*Task, int, error) {
+	var total int
+	if err := q.db.QueryRowContext(ctx, queryTaskCount, arg.Status).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	tasks := []*Task{}
+	rows, err := q.db.QueryContext(ctx, queryTaskListPage, arg.Status, arg.Limit, arg.Offset)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		i, err := scan(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		tasks = append(tasks, &i)
+	}
+	return tasks, total, rows.Err()
+}
+
+// transition changes status of task `id` using `query` if its current status is one of `from`.
+func (q *Queries) transition(ctx context.Context, id uint32, query string, from ...string) (*Task, error) {
+	tx, err := q.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	i, err := scan(tx.QueryRowContext(ctx, queryTaskGet, id))
+	if err == sql.ErrNoRows {
+		tx.Rollback()
+		return nil, ErrTaskNotFound
+	} else if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	allowed := false
+	for _, s := range from {
+		if i.Status == s {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		tx.Rollback()
+		return nil, ErrInvalidTransition
+	}
+	if _, err := tx.ExecContext(ctx, query, id); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return q.Get(ctx, id)
+}
+
+func (q *Queries) updatePriority(ctx context.Context, id uint32, priority int) (*Task, error) {
+	r, err := q.db.ExecContext(ctx, queryTaskUpdatePriority, priority, id)
+	if err != nil {
+		return nil, err
+	}
+	n, err := r.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, ErrTaskNotFound
+	}
+	return q.Get(ctx, id)
+}
+
+func (q *Queries) start(ctx context.Context, id uint32) error {
+	r, err := q.db.ExecContext(ctx, queryTaskMarkStarted, id)
+	if err != nil {
+		return err
+	}
+	n, err := r.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("task %v not found or not pending", id)
+	}
+	return nil
+}
+
+func (q *Queries) complete(ctx context.Context, id uint32) error {
+	r, err := q.db.ExecContext(ctx, queryTaskComplete, id)
+	if err != nil {
+		return err
+	}
+	n, err := r.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("task %v not found or not started", id)
+	}
+	return nil
+}
+
+func (q *Queries) updateStage(ctx context.Context, id uint32, stage string) error {
+	r, err := q.db.ExecContext(ctx, queryTaskUpdateStage, stage, id)
+	if err != nil {
+		return err
+	}
+	n, err := r.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return fmt.Errorf("task %v not found or not started", id)
+	}
+	return nil
+}
+
+// position returns the number of tasks waiting to be picked up ahead of task `t`.
+func (q *Queries) position(ctx context.Context, t *Task) (int, error) {
+	var n int
+	row := q.db.QueryRowContext(ctx, queryTaskPosition, t.Priority, t.CreatedAt, t.ID)
+	if err := row.Scan(&n); err != nil {
+		return 0, err
+	}
+	return n, nil
+}
+
 func (q *Queries) updateStatusTx(tx *sql.Tx, ctx context.Context, id uint32, status string) error {
 	r, err := tx.ExecContext(ctx, queryUpdateStatus, status, id)
 	if err != nil {
@@ -221,9 +383,12 @@ func scan(r rowScanner) (Task, error) {
 		&i.CreatedAt,
 		&i.URL,
 		&i.Progress,
+		&i.Speed,
 		&i.StartedAt,
 		&i.Type,
 		&i.Status,
+		&i.Priority,
+		&i.Stage,
 	); err != nil {
 		return i, err
 	}
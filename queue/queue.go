@@ -56,28 +56,83 @@ func (q Queue) List() ([]*Task, error) {
 	return q.queries.List(ctx)
 }
 
-func (q Queue) Reject(id uint32) error {
+// Reject marks a task that has not been started yet as rejected, so it won't be picked up again.
+func (q Queue) Reject(id uint32) (*Task, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	return q.queries.updateStatus(ctx, id, StatusRejected)
+	return q.queries.transition(ctx, id, queryTaskReject, StatusNew, StatusPending, StatusReleased)
+}
+
+// Fail marks a task picked up for processing as rejected after processing it has failed.
+func (q Queue) Fail(id uint32) (*Task, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return q.queries.transition(ctx, id, queryTaskReject, StatusPending, StatusStarted)
 }
 
 func (q Queue) Start(id uint32) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	return q.queries.updateStatus(ctx, id, StatusStarted)
+	return q.queries.start(ctx, id)
 }
 
+// Complete marks a started task as completed.
 func (q Queue) Complete(id uint32) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	return q.queries.updateStatus(ctx, id, StatusCompleted)
+	return q.queries.complete(ctx, id)
+}
+
+// ListPage returns a page of tasks filtered by status, newest first, along with the total number of matching tasks.
+func (q Queue) ListPage(arg ListParams) ([]*Task, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return q.queries.ListPage(ctx, arg)
 }
 
-func (q Queue) UpdateProgress(id uint32, progress float64) error {
+// Requeue puts a rejected, released or canceled task back into the queue.
+func (q Queue) Requeue(id uint32) (*Task, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return q.queries.transition(ctx, id, queryTaskRequeue, StatusRejected, StatusReleased, StatusCanceled)
+}
+
+// Cancel removes a task from the queue if it has not been picked up for processing yet.
+func (q Queue) Cancel(id uint32) (*Task, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return q.queries.transition(ctx, id, queryTaskCancel, StatusNew, StatusReleased)
+}
+
+// SetPriority changes task priority, tasks with higher priority are picked up for processing first.
+func (q Queue) SetPriority(id uint32, priority int) (*Task, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return q.queries.updatePriority(ctx, id, priority)
+}
+
+// SetStage records processing stage of a started task.
+func (q Queue) SetStage(id uint32, stage string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return q.queries.updateStage(ctx, id, stage)
+}
+
+// UpdateProgress records encoding progress percentage and speed (relative to playback rate) of a started task.
+func (q Queue) UpdateProgress(id uint32, progress, speed float64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	return q.queries.updateProgress(ctx, id, progress, speed)
+}
+
+// Position returns the number of tasks waiting ahead of task `t`, zero for tasks already being processed.
+func (q Queue) Position(t *Task) (int, error) {
+	if t.Status != StatusNew && t.Status != StatusReleased {
+		return 0, nil
+	}
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
-	return q.queries.updateProgress(ctx, id, progress)
+	return q.queries.position(ctx, t)
 }
 
 func (q *Queue) StartPoller(workers int) *Poller {
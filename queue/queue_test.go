@@ -27,7 +27,7 @@ func (s *QueueSuite) SetupSuite() {
 
 func (s *QueueSuite) SetupTest() {
 	s.db = db.OpenTestDB()
-	s.db.MigrateUp(InitialMigration)
+	s.db.Migrate(Migrations...)
 }
 
 func (s *QueueSuite) TestQueueAdd() {
@@ -91,7 +91,7 @@ func (s *QueueSuite) TestQueuePoll() {
 		s.Require().NoError(err)
 		t, err := q.Get(t.ID)
 		s.Require().NoError(err)
-		s.Require().EqualValues(sql.NullFloat64{Float64: 0, Valid: false}, t.Progress)
+		s.Require().EqualValues(sql.NullFloat64{Float64: 0, Valid: true}, t.Progress)
 		s.Require().Equal(StatusStarted, t.Status)
 	}
 
@@ -130,7 +130,7 @@ func (s *QueueSuite) TestQueueReject() {
 
 	pTask, err := q.Poll()
 	s.Require().NoError(err)
-	err = q.Reject(pTask.ID)
+	_, err = q.Reject(pTask.ID)
 	s.Require().NoError(err)
 
 	pTask, err = q.Get(pTask.ID)
@@ -140,6 +140,21 @@ func (s *QueueSuite) TestQueueReject() {
 
 	_, err = q.Poll()
 	s.Require().Equal(sql.ErrNoRows, err)
+	s.Error(q.Start(pTask.ID))
+	s.Error(q.Complete(pTask.ID))
+
+	// Started tasks can only be failed by their worker.
+	_, err = q.Add(db.RandomString(32), db.RandomString(96), formats.TypeHLS)
+	s.Require().NoError(err)
+	pTask, err = q.Poll()
+	s.Require().NoError(err)
+	s.Require().NoError(q.Start(pTask.ID))
+	_, err = q.Reject(pTask.ID)
+	s.Equal(ErrInvalidTransition, err)
+	pTask, err = q.Fail(pTask.ID)
+	s.Require().NoError(err)
+	s.Equal(StatusRejected, pTask.Status)
+	s.Error(q.Complete(pTask.ID))
 }
 
 func (s *QueueSuite) TestQueueUpdateProgress() {
@@ -150,18 +165,130 @@ func (s *QueueSuite) TestQueueUpdateProgress() {
 	pTask, err := q.Poll()
 	s.Require().NoError(err)
 
-	err = q.UpdateProgress(pTask.ID, 55.4)
+	err = q.UpdateProgress(pTask.ID, 55.4, 1.2)
 	s.EqualError(err, "wrong status for progressing task: pending")
 
 	err = q.Start(pTask.ID)
 	s.Require().NoError(err)
 
 	pTask, err = q.Get(pTask.ID)
-	err = q.UpdateProgress(pTask.ID, 12.4)
+	err = q.UpdateProgress(pTask.ID, 12.4, 1.5)
 	s.Require().NoError(err, pTask.Status)
 
 	pTask, err = q.Get(pTask.ID)
 
 	s.EqualValues(12.4, pTask.Progress.Float64)
+	s.EqualValues(1.5, pTask.Speed.Float64)
+	s.True(pTask.StartedAt.Valid)
 	s.Require().NotNil(pTask)
 }
+
+func (s *QueueSuite) TestQueueStage() {
+	q := NewQueue(s.db)
+	_, err := q.Add(fmt.Sprintf("lbry://%v", db.RandomString(32)), db.RandomString(96), formats.TypeHLS)
+	s.Require().NoError(err)
+
+	pTask, err := q.Poll()
+	s.Require().NoError(err)
+	s.Error(q.SetStage(pTask.ID, StageDownloading))
+
+	s.Require().NoError(q.Start(pTask.ID))
+	s.Require().NoError(q.SetStage(pTask.ID, StageReady))
+	pTask, err = q.Get(pTask.ID)
+	s.Require().NoError(err)
+	s.Equal(StageReady, pTask.Stage.String)
+
+	s.Require().NoError(q.Release(pTask.ID))
+	pTask, err = q.Get(pTask.ID)
+	s.Require().NoError(err)
+	s.False(pTask.Stage.Valid)
+}
+
+func (s *QueueSuite) TestQueuePosition() {
+	q := NewQueue(s.db)
+	tasks := []*Task{}
+	for range [5]int{} {
+		t, err := q.Add(fmt.Sprintf("lbry://%v", db.RandomString(32)), db.RandomString(96), formats.TypeHLS)
+		s.Require().NoError(err)
+		tasks = append(tasks, t)
+	}
+
+	for i, t := range tasks {
+		n, err := q.Position(t)
+		s.Require().NoError(err)
+		s.Equal(i, n)
+	}
+
+	pTask, err := q.Poll()
+	s.Require().NoError(err)
+	n, err := q.Position(pTask)
+	s.Require().NoError(err)
+	s.Equal(0, n)
+
+	n, err = q.Position(tasks[4])
+	s.Require().NoError(err)
+	s.Equal(3, n)
+}
+
+func (s *QueueSuite) TestQueueAdmin() {
+	q := NewQueue(s.db)
+	tasks := []*Task{}
+	for range [5]int{} {
+		t, err := q.Add(fmt.Sprintf("lbry://%v", db.RandomString(32)), db.RandomString(96), formats.TypeHLS)
+		s.Require().NoError(err)
+		tasks = append(tasks, t)
+	}
+
+	t, err := q.SetPriority(tasks[3].ID, 10)
+	s.Require().NoError(err)
+	s.Equal(10, t.Priority)
+	n, err := q.Position(t)
+	s.Require().NoError(err)
+	s.Equal(0, n)
+	_, err = q.SetPriority(1000, 10)
+	s.Equal(ErrTaskNotFound, err)
+
+	t, err = q.Cancel(tasks[0].ID)
+	s.Require().NoError(err)
+	s.Equal(StatusCanceled, t.Status)
+
+	pt, err := q.Poll()
+	s.Require().NoError(err)
+	s.Equal(tasks[3].ID, pt.ID)
+	pt, err = q.Poll()
+	s.Require().NoError(err)
+	s.Equal(tasks[1].ID, pt.ID)
+	_, err = q.Cancel(pt.ID)
+	s.Equal(ErrInvalidTransition, err)
+	t, err = q.Reject(pt.ID)
+	s.Require().NoError(err)
+	s.Equal(StatusRejected, t.Status)
+	_, err = q.Reject(pt.ID)
+	s.Equal(ErrInvalidTransition, err)
+
+	ts, total, err := q.ListPage(ListParams{Limit: 2})
+	s.Require().NoError(err)
+	s.Equal(5, total)
+	s.Require().Len(ts, 2)
+	s.Equal(tasks[4].ID, ts[0].ID)
+	ts, total, err = q.ListPage(ListParams{Limit: 2, Offset: 4})
+	s.Require().NoError(err)
+	s.Equal(5, total)
+	s.Require().Len(ts, 1)
+	s.Equal(tasks[0].ID, ts[0].ID)
+	ts, total, err = q.ListPage(ListParams{Status: StatusPending, Limit: 10})
+	s.Require().NoError(err)
+	s.Equal(1, total)
+	s.Equal(tasks[3].ID, ts[0].ID)
+
+	for _, id := range []uint32{tasks[0].ID, tasks[1].ID} {
+		t, err = q.Requeue(id)
+		s.Require().NoError(err)
+		s.Equal(StatusNew, t.Status)
+		s.False(t.Progress.Valid)
+	}
+	_, err = q.Requeue(tasks[3].ID)
+	s.Equal(ErrInvalidTransition, err)
+	_, err = q.Requeue(1000)
+	s.Equal(ErrTaskNotFound, err)
+}
@@ -1,5 +1,15 @@
 package queue
 
+import "github.com/lbryio/transcoder/db"
+
+// Migrations contains all queue schema changes in the order they should be applied.
+var Migrations = []db.Migration{
+	{Name: "initial", SQL: InitialMigration},
+	{Name: "speed", SQL: SpeedMigration},
+	{Name: "priority", SQL: PriorityMigration},
+	{Name: "stage", SQL: StageMigration},
+}
+
 var InitialMigration = `
 -- +migrate Up
 
@@ -24,3 +34,45 @@ CREATE TABLE IF NOT EXISTS tasks  (
 DROP TABLE tasks;
 -- +migrate StatementEnd
 `
+
+var SpeedMigration = `
+-- +migrate Up
+
+-- +migrate StatementBegin
+ALTER TABLE tasks ADD COLUMN "speed" FLOAT;
+-- +migrate StatementEnd
+
+-- +migrate Down
+
+-- +migrate StatementBegin
+ALTER TABLE tasks DROP COLUMN "speed";
+-- +migrate StatementEnd
+`
+
+var PriorityMigration = `
+-- +migrate Up
+
+-- +migrate StatementBegin
+ALTER TABLE tasks ADD COLUMN "priority" INTEGER NOT NULL DEFAULT 0;
+-- +migrate StatementEnd
+
+-- +migrate Down
+
+-- +migrate StatementBegin
+ALTER TABLE tasks DROP COLUMN "priority";
+-- +migrate StatementEnd
+`
+
+var StageMigration = `
+-- +migrate Up
+
+-- +migrate StatementBegin
+ALTER TABLE tasks ADD COLUMN "stage" TEXT;
+-- +migrate StatementEnd
+
+-- +migrate Down
+
+-- +migrate StatementBegin
+ALTER TABLE tasks DROP COLUMN "stage";
+-- +migrate StatementEnd
+`
@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"io/ioutil"
 	"os"
 	"path"
 )
@@ -26,3 +27,29 @@ func (s LocalStorage) Open(sdHash string) (*LocalStream, error) {
 func (s LocalStorage) Delete(sdHash string) error {
 	return os.RemoveAll(path.Join(s.path, sdHash))
 }
+
+// Pull copies stream files from remote storage into local storage.
+func (s LocalStorage) Pull(d RemoteDriver, sdHash string) (*LocalStream, error) {
+	ls := s.New(sdHash)
+	if err := os.MkdirAll(ls.FullPath(), os.ModePerm); err != nil {
+		return nil, err
+	}
+	err := ls.Dive(
+		func(rootPath ...string) ([]byte, error) {
+			f, err := d.GetFragment(sdHash, rootPath[len(rootPath)-1])
+			if err != nil {
+				return nil, err
+			}
+			defer f.Close()
+			return ioutil.ReadAll(f)
+		},
+		func(data []byte, name string) error {
+			return ioutil.WriteFile(path.Join(ls.FullPath(), name), data, os.ModePerm)
+		},
+	)
+	if err != nil {
+		s.Delete(sdHash)
+		return nil, err
+	}
+	return ls, nil
+}
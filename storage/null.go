@@ -16,3 +16,13 @@ func (d NullDriver) GetFragment(s, n string) (StreamFragment, error) {
 	logger.Warn("storage driver not configured")
 	return nil, nil
 }
+
+func (d NullDriver) PutFragment(sdHash, name string, data []byte) error {
+	logger.Warn("storage driver not configured")
+	return nil
+}
+
+func (d NullDriver) DeleteFragments(sdHash string, names ...string) error {
+	logger.Warn("storage driver not configured")
+	return nil
+}
@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"regexp"
+	"strings"
+)
+
+var (
+	uriAttrRe = regexp.MustCompile(`URI="([^"]*)"`)
+	// fragmentNameRe matches names of playlists, media segments and initialization sections of stored streams.
+	fragmentNameRe = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9_.-]*\.(m3u8|ts|m4s|mp4)$`)
+)
+
+// IsFragmentName checks if `name` can be a file of a stored stream, which is what is allowed to be requested from it.
+func IsFragmentName(name string) bool {
+	return fragmentNameRe.MatchString(name)
+}
+
+// PlaylistURIRewriter receives a URI referenced in a playlist and returns its replacement.
+type PlaylistURIRewriter func(uri string) (string, error)
+
+// RewritePlaylist replaces every URI line and URI attribute of tags (like EXT-X-MAP or EXT-X-KEY)
+// in a HLS playlist with what `rewrite` returns for it, leaving the rest intact.
+func RewritePlaylist(data []byte, rewrite PlaylistURIRewriter) ([]byte, error) {
+	out := &bytes.Buffer{}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		line := sc.Text()
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "#EXT") {
+			var err error
+			if line, err = rewriteURIAttr(line, rewrite); err != nil {
+				return nil, err
+			}
+		} else if trimmed != "" && !strings.HasPrefix(trimmed, "#") {
+			uri, err := rewrite(trimmed)
+			if err != nil {
+				return nil, err
+			}
+			line = uri
+		}
+		out.WriteString(line)
+		out.WriteByte('\n')
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// RewriteKeyURIs replaces URI attributes of EXT-X-KEY tags in a HLS playlist with what `rewrite` returns for them.
+func RewriteKeyURIs(data []byte, rewrite PlaylistURIRewriter) ([]byte, error) {
+	out := &bytes.Buffer{}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		line := sc.Text()
+		if strings.HasPrefix(strings.TrimSpace(line), "#EXT-X-KEY:") {
+			var err error
+			if line, err = rewriteURIAttr(line, rewrite); err != nil {
+				return nil, err
+			}
+		}
+		out.WriteString(line)
+		out.WriteByte('\n')
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// rewriteURIAttr replaces URI attribute value in a playlist tag line.
+func rewriteURIAttr(line string, rewrite PlaylistURIRewriter) (string, error) {
+	m := uriAttrRe.FindStringSubmatchIndex(line)
+	if m == nil {
+		return line, nil
+	}
+	uri, err := rewrite(line[m[2]:m[3]])
+	if err != nil {
+		return "", err
+	}
+	return line[:m[2]] + uri + line[m[3]:], nil
+}
+
+// PlaylistURIs returns distinct relative URIs referenced in a HLS playlist in the order of appearance,
+// that is files stored along with it, including fMP4 initialization sections. Absolute URIs (like those of encryption keys) are skipped.
+func PlaylistURIs(data []byte) ([]string, error) {
+	uris := []string{}
+	seen := map[string]bool{}
+	_, err := RewritePlaylist(data, func(uri string) (string, error) {
+		// Initialization sections (EXT-X-MAP) may be repeated after discontinuities.
+		if !IsAbsoluteURI(uri) && !seen[uri] {
+			seen[uri] = true
+			uris = append(uris, uri)
+		}
+		return uri, nil
+	})
+	return uris, err
+}
+
+// IsAbsoluteURI checks if a playlist URI points to a location of its own rather than relative to the playlist.
+func IsAbsoluteURI(uri string) bool {
+	return strings.Contains(uri, "://") || strings.HasPrefix(uri, "/")
+}
+
+// FilterMasterPlaylist removes variant streams listed in `drop` from a HLS master playlist.
+func FilterMasterPlaylist(data []byte, drop map[string]bool) ([]byte, error) {
+	var streamInf string
+	out := &bytes.Buffer{}
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	for sc.Scan() {
+		line := sc.Text()
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "#EXT-X-STREAM-INF") {
+			// Variant tag is only written out together with the URI following it.
+			streamInf = line
+			continue
+		}
+		if trimmed != "" && !strings.HasPrefix(trimmed, "#") {
+			if drop[trimmed] {
+				streamInf = ""
+				continue
+			}
+			if streamInf != "" {
+				out.WriteString(streamInf)
+				out.WriteByte('\n')
+				streamInf = ""
+			}
+		}
+		out.WriteString(line)
+		out.WriteByte('\n')
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
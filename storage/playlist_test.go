@@ -0,0 +1,91 @@
+package storage
+
+import (
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRewritePlaylist(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/stream_0.m3u8")
+	require.NoError(t, err)
+
+	rewritten, err := RewritePlaylist(data, func(uri string) (string, error) {
+		return "https://cdn/" + uri + "?sig=abc", nil
+	})
+	require.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSpace(string(rewritten)), "\n")
+	origLines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, len(origLines))
+	for i, l := range origLines {
+		if strings.HasPrefix(l, "#") {
+			assert.Equal(t, l, lines[i])
+		} else {
+			assert.Equal(t, "https://cdn/"+l+"?sig=abc", lines[i])
+		}
+	}
+}
+
+func TestRewritePlaylistAttributes(t *testing.T) {
+	data := []byte("#EXTM3U\n#EXT-X-KEY:METHOD=AES-128,URI=\"https://keys/abc\"\n#EXT-X-MAP:URI=\"init_0.mp4\"\n#EXTINF:10.0,\nseg_0_000000.m4s\n")
+	rewritten, err := RewritePlaylist(data, func(uri string) (string, error) {
+		if IsAbsoluteURI(uri) {
+			return uri, nil
+		}
+		return "https://cdn/" + uri, nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t,
+		"#EXTM3U\n#EXT-X-KEY:METHOD=AES-128,URI=\"https://keys/abc\"\n#EXT-X-MAP:URI=\"https://cdn/init_0.mp4\"\n#EXTINF:10.0,\nhttps://cdn/seg_0_000000.m4s\n",
+		string(rewritten))
+
+	uris, err := PlaylistURIs(data)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"init_0.mp4", "seg_0_000000.m4s"}, uris)
+}
+
+func TestIsFragmentName(t *testing.T) {
+	for _, n := range []string{"master.m3u8", "stream_0.m3u8", "seg_0_000001.ts", "seg_1_000001.m4s", "init_0.mp4"} {
+		assert.True(t, IsFragmentName(n), n)
+	}
+	for _, n := range []string{"", "..", ".m3u8", "../master.m3u8", "key", "stream.m3u8.key", "a/b.ts"} {
+		assert.False(t, IsFragmentName(n), n)
+	}
+}
+
+func TestRewriteKeyURIs(t *testing.T) {
+	data := []byte("#EXTM3U\n#EXT-X-KEY:METHOD=AES-128,URI=\"https://keys/abc\",IV=0x01\n#EXTINF:10.0,\nseg_0_000000.ts\n")
+	rewritten, err := RewriteKeyURIs(data, func(uri string) (string, error) {
+		return uri + "?token=t", nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t,
+		"#EXTM3U\n#EXT-X-KEY:METHOD=AES-128,URI=\"https://keys/abc?token=t\",IV=0x01\n#EXTINF:10.0,\nseg_0_000000.ts\n",
+		string(rewritten))
+}
+
+func TestFilterMasterPlaylist(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/master.m3u8")
+	require.NoError(t, err)
+
+	filtered, err := FilterMasterPlaylist(data, map[string]bool{"stream_0.m3u8": true, "stream_1.m3u8": true})
+	require.NoError(t, err)
+
+	uris, err := PlaylistURIs(filtered)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"stream_2.m3u8", "stream_3.m3u8"}, uris)
+	assert.NotContains(t, string(filtered), "RESOLUTION=1920x1080")
+	assert.NotContains(t, string(filtered), "RESOLUTION=1280x720")
+	assert.Contains(t, string(filtered), "#EXT-X-STREAM-INF:BANDWIDTH=2010800,RESOLUTION=854x480")
+	assert.True(t, strings.HasPrefix(string(filtered), "#EXTM3U\n#EXT-X-VERSION:6\n"))
+}
+
+func TestResolutionHeight(t *testing.T) {
+	assert.Equal(t, 1080, ResolutionHeight("1920x1080"))
+	assert.Equal(t, 0, ResolutionHeight("1920"))
+	assert.Equal(t, 0, ResolutionHeight(""))
+}
@@ -0,0 +1,82 @@
+package storage
+
+import (
+	"bytes"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/grafov/m3u8"
+	"github.com/pkg/errors"
+)
+
+// Rendition is a single variant stream of a HLS stream.
+type Rendition struct {
+	// Name is the variant playlist file name.
+	Name      string
+	Height    int
+	Bandwidth int
+	// Size is the total size of variant playlist and all of its segments.
+	Size int64
+}
+
+// Renditions lists variant streams of a local HLS stream along with their sizes.
+func (s LocalStream) Renditions() ([]Rendition, error) {
+	data, err := readFile(s.FullPath(), MasterPlaylistName)
+	if err != nil {
+		return nil, err
+	}
+	renditions, err := MasterRenditions(data)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range renditions {
+		r := &renditions[i]
+		data, err := readFile(s.FullPath(), r.Name)
+		if err != nil {
+			return nil, err
+		}
+		r.Size += int64(len(data))
+		uris, err := PlaylistURIs(data)
+		if err != nil {
+			return nil, err
+		}
+		for _, u := range uris {
+			fi, err := os.Stat(path.Join(s.FullPath(), u))
+			if err != nil {
+				return nil, err
+			}
+			r.Size += fi.Size()
+		}
+	}
+	return renditions, nil
+}
+
+// MasterRenditions lists variant streams referenced by master playlist `data`, leaving their sizes unset.
+func MasterRenditions(data []byte) ([]Rendition, error) {
+	pl, _, err := m3u8.DecodeFrom(bytes.NewReader(data), true)
+	if err != nil {
+		return nil, err
+	}
+	masterpl, ok := pl.(*m3u8.MasterPlaylist)
+	if !ok {
+		return nil, errors.New("not a master playlist")
+	}
+	renditions := []Rendition{}
+	for _, v := range masterpl.Variants {
+		renditions = append(renditions, Rendition{Name: v.URI, Bandwidth: int(v.Bandwidth), Height: ResolutionHeight(v.Resolution)})
+	}
+	return renditions, nil
+}
+
+// ResolutionHeight extracts height from `WIDTHxHEIGHT` resolution string, returning 0 if it's malformed.
+func ResolutionHeight(resolution string) int {
+	parts := strings.Split(resolution, "x")
+	if len(parts) != 2 {
+		return 0
+	}
+	h, _ := strconv.Atoi(parts[1])
+	return h
+}
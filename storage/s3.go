@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"path"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
@@ -13,9 +14,16 @@ import (
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
 )
 
+const (
+	aclPublicRead = "public-read"
+	aclPrivate    = "private"
+)
+
 type S3Configuration struct {
 	endpoint, region, accessKey, secretKey, bucket string
 	disableSSL                                     bool
+	private                                        bool
+	signedURLTTL                                   time.Duration
 }
 
 func S3Configure() *S3Configuration {
@@ -46,6 +54,14 @@ func (c *S3Configuration) DisableSSL() *S3Configuration {
 	return c
 }
 
+// Private makes bucket and uploaded objects private, access to stream files is then granted
+// via presigned URLs valid for `ttl`.
+func (c *S3Configuration) Private(ttl time.Duration) *S3Configuration {
+	c.private = true
+	c.signedURLTTL = ttl
+	return c
+}
+
 // Credentials set access key and secret key for accessing S3 bucket.
 func (c *S3Configuration) Credentials(accessKey, secretKey string) *S3Configuration {
 	c.accessKey = accessKey
@@ -79,7 +95,7 @@ func InitS3Driver(cfg *S3Configuration) (*S3Driver, error) {
 
 	_, err := client.CreateBucket(&s3.CreateBucketInput{
 		Bucket: aws.String(s.bucket),
-		ACL:    aws.String("public-read"),
+		ACL:    aws.String(s.acl()),
 	})
 	if err != nil {
 		if awsErr, ok := err.(awserr.Error); ok {
@@ -104,18 +120,7 @@ func (s *S3Driver) Put(lstream *LocalStream) (*RemoteStream, error) {
 	err := lstream.Dive(
 		readFile,
 		func(data []byte, name string) error {
-			ctype := FragmentContentType
-			if path.Ext(name) == PlaylistExt {
-				ctype = PlaylistContentType
-			}
-			logger.Debugw("preparing upload", "key", s3Key(lstream.sdHash, name), "ctype", ctype, "size", len(data), "bucket", s.bucket)
-			out, err := svc.Upload(&s3manager.UploadInput{
-				Bucket:      aws.String(s.bucket),
-				Key:         aws.String(s3Key(lstream.sdHash, name)),
-				ContentType: aws.String(ctype),
-				Body:        bytes.NewReader(data),
-				ACL:         aws.String("public-read"),
-			})
+			out, err := s.upload(svc, lstream.sdHash, name, data)
 			if err != nil {
 				return err
 			}
@@ -129,6 +134,27 @@ func (s *S3Driver) Put(lstream *LocalStream) (*RemoteStream, error) {
 	return &RemoteStream{url: url}, err
 }
 
+// PutFragment uploads a single stream file, overwriting it if it exists.
+func (s *S3Driver) PutFragment(sdHash, name string, data []byte) error {
+	_, err := s.upload(s3manager.NewUploader(s.session), sdHash, name, data)
+	return err
+}
+
+func (s *S3Driver) upload(svc *s3manager.Uploader, sdHash, name string, data []byte) (*s3manager.UploadOutput, error) {
+	ctype := FragmentContentType
+	if path.Ext(name) == PlaylistExt {
+		ctype = PlaylistContentType
+	}
+	logger.Debugw("preparing upload", "key", s3Key(sdHash, name), "ctype", ctype, "size", len(data), "bucket", s.bucket)
+	return svc.Upload(&s3manager.UploadInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(s3Key(sdHash, name)),
+		ContentType: aws.String(ctype),
+		Body:        bytes.NewReader(data),
+		ACL:         aws.String(s.acl()),
+	})
+}
+
 func (s *S3Driver) Delete(sdHash string) error {
 	client := s3.New(s.session)
 	bucket := aws.String(s.bucket)
@@ -161,6 +187,33 @@ func (s *S3Driver) Delete(sdHash string) error {
 	return nil
 }
 
+// DeleteFragments deletes individual stream files.
+func (s *S3Driver) DeleteFragments(sdHash string, names ...string) error {
+	client := s3.New(s.session)
+	// DeleteObjects accepts up to 1000 keys per request.
+	for len(names) > 0 {
+		n := len(names)
+		if n > 1000 {
+			n = 1000
+		}
+		input := &s3.DeleteObjectsInput{
+			Bucket: aws.String(s.bucket),
+			Delete: &s3.Delete{
+				Objects: []*s3.ObjectIdentifier{},
+				Quiet:   aws.Bool(true),
+			},
+		}
+		for _, name := range names[:n] {
+			input.Delete.Objects = append(input.Delete.Objects, &s3.ObjectIdentifier{Key: aws.String(s3Key(sdHash, name))})
+		}
+		if _, err := client.DeleteObjects(input); err != nil {
+			return err
+		}
+		names = names[n:]
+	}
+	return nil
+}
+
 func (s *S3Driver) Get(sdHash string) (*LocalStream, error) {
 	return nil, nil
 }
@@ -177,6 +230,28 @@ func (s *S3Driver) GetFragment(sdHash, name string) (StreamFragment, error) {
 	return obj.Body, nil
 }
 
+// IsPrivate returns true if stream files are not publicly accessible and should be served via signed URLs.
+func (s *S3Driver) IsPrivate() bool {
+	return s.private
+}
+
+// SignFragmentURL returns a presigned URL granting temporary access to a single stream file.
+func (s *S3Driver) SignFragmentURL(sdHash, name string) (string, error) {
+	client := s3.New(s.session)
+	req, _ := client.GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s3Key(sdHash, name)),
+	})
+	return req.Presign(s.signedURLTTL)
+}
+
+func (s *S3Driver) acl() string {
+	if s.private {
+		return aclPrivate
+	}
+	return aclPublicRead
+}
+
 func s3Key(sdHash, name string) string {
 	return fmt.Sprintf("%v/%v", sdHash, name)
 }
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"math/rand"
+	"net/http"
 	"os"
 	"path"
 	"testing"
@@ -64,6 +65,12 @@ func (s *S3Suite) TestPutDelete() {
 	s.Require().NoError(err)
 	s.Require().NotNil(p)
 
+	pulled, err := Local(path.Join(s.local.path, "pulled")).Pull(s3drv, s.sdHash)
+	s.Require().NoError(err)
+	s.Require().NoError(pulled.ReadMeta())
+	s.Require().NoError(stream.ReadMeta())
+	s.Equal(stream.Checksum(), pulled.Checksum())
+
 	err = s3drv.Delete(s.sdHash)
 	s.Require().NoError(err)
 
@@ -76,6 +83,87 @@ func (s *S3Suite) TestPutDelete() {
 	}
 }
 
+func (s *S3Suite) TestPrivateSignFragmentURL() {
+	s3drv, err := InitS3Driver(
+		S3Configure().
+			Endpoint(s.addr).
+			Region("us-east-1").
+			Credentials("minioadmin", "minioadmin").
+			Bucket("storage-s3-test-private").
+			DisableSSL().
+			Private(time.Minute),
+	)
+	s.Require().NoError(err)
+	s.True(s3drv.IsPrivate())
+
+	stream, err := s.local.Open(s.sdHash)
+	s.Require().NoError(err)
+
+	rstream, err := s3drv.Put(stream)
+	s.Require().NoError(err)
+
+	r, err := http.Get(rstream.URL())
+	s.Require().NoError(err)
+	r.Body.Close()
+	s.Equal(http.StatusForbidden, r.StatusCode)
+
+	signedURL, err := s3drv.SignFragmentURL(s.sdHash, MasterPlaylistName)
+	s.Require().NoError(err)
+	r, err = http.Get(signedURL)
+	s.Require().NoError(err)
+	r.Body.Close()
+	s.Equal(http.StatusOK, r.StatusCode)
+
+	s.Require().NoError(s3drv.Delete(s.sdHash))
+}
+
+func (s *S3Suite) TestRenditionsFragments() {
+	s3drv, err := InitS3Driver(
+		S3Configure().
+			Endpoint(s.addr).
+			Region("us-east-1").
+			Credentials("minioadmin", "minioadmin").
+			Bucket("storage-s3-test").
+			DisableSSL(),
+	)
+	s.Require().NoError(err)
+
+	stream, err := s.local.Open(s.sdHash)
+	s.Require().NoError(err)
+	renditions, err := stream.Renditions()
+	s.Require().NoError(err)
+	s.Require().Len(renditions, 4)
+	s.Equal([]int{1080, 720, 480, 360}, []int{renditions[0].Height, renditions[1].Height, renditions[2].Height, renditions[3].Height})
+	s.Equal("stream_0.m3u8", renditions[0].Name)
+	s.EqualValues(3990800, renditions[0].Bandwidth)
+	s.Require().NoError(stream.ReadMeta())
+	var total int64
+	for _, r := range renditions {
+		total += r.Size
+	}
+	master, err := ioutil.ReadFile(path.Join(stream.FullPath(), MasterPlaylistName))
+	s.Require().NoError(err)
+	s.Equal(stream.Size(), total+int64(len(master)))
+
+	_, err = s3drv.Put(stream)
+	s.Require().NoError(err)
+
+	s.Require().NoError(s3drv.PutFragment(s.sdHash, MasterPlaylistName, []byte("#EXTM3U\n")))
+	p, err := s3drv.GetFragment(s.sdHash, MasterPlaylistName)
+	s.Require().NoError(err)
+	data, err := ioutil.ReadAll(p)
+	s.Require().NoError(err)
+	s.Equal("#EXTM3U\n", string(data))
+
+	s.Require().NoError(s3drv.DeleteFragments(s.sdHash, "stream_0.m3u8", "stream_1.m3u8"))
+	_, err = s3drv.GetFragment(s.sdHash, "stream_0.m3u8")
+	s.Equal("NoSuchKey", err.(awserr.Error).Code())
+	_, err = s3drv.GetFragment(s.sdHash, "stream_2.m3u8")
+	s.NoError(err)
+
+	s.Require().NoError(s3drv.Delete(s.sdHash))
+}
+
 func (s *S3Suite) TearDownSuite() {
 	s.NoError(s.cleanup())
 	s.NoError(os.RemoveAll(s.local.path))
@@ -12,6 +12,16 @@ type RemoteDriver interface {
 	Put(stream *LocalStream) (*RemoteStream, error)
 	Delete(sdHash string) error
 	GetFragment(sdHash, name string) (StreamFragment, error)
+	PutFragment(sdHash, name string, data []byte) error
+	DeleteFragments(sdHash string, names ...string) error
+}
+
+// SigningDriver is a remote driver that is able to keep stream files private,
+// granting temporary access to them via signed URLs.
+type SigningDriver interface {
+	RemoteDriver
+	IsPrivate() bool
+	SignFragmentURL(sdHash, name string) (string, error)
 }
 
 type LocalDriver interface {
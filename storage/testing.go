@@ -1,9 +1,17 @@
 package storage
 
+import (
+	"bytes"
+	"io/ioutil"
+	"path"
+)
+
 const (
 	OpDelete = iota
 	OpGetFragment
 	OpPut
+	OpPutFragment
+	OpDeleteFragments
 )
 
 type StorageOp struct {
@@ -14,10 +22,12 @@ type StorageOp struct {
 type DummyStorage struct {
 	LocalStorage
 	Ops []StorageOp
+	// Fragments holds stream files put into the storage, keyed by `{sdHash}/{name}`.
+	Fragments map[string][]byte
 }
 
 func Dummy() *DummyStorage {
-	return &DummyStorage{LocalStorage: LocalStorage{"/tmp/dummy_storage"}, Ops: []StorageOp{}}
+	return &DummyStorage{LocalStorage: LocalStorage{"/tmp/dummy_storage"}, Ops: []StorageOp{}, Fragments: map[string][]byte{}}
 }
 
 func (s *DummyStorage) Delete(sdHash string) error {
@@ -27,6 +37,9 @@ func (s *DummyStorage) Delete(sdHash string) error {
 
 func (s *DummyStorage) GetFragment(sdHash, name string) (StreamFragment, error) {
 	s.Ops = append(s.Ops, StorageOp{OpGetFragment, sdHash})
+	if data, ok := s.Fragments[path.Join(sdHash, name)]; ok {
+		return ioutil.NopCloser(bytes.NewReader(data)), nil
+	}
 	return nil, nil
 }
 
@@ -34,3 +47,17 @@ func (s *DummyStorage) Put(lstream *LocalStream) (*RemoteStream, error) {
 	s.Ops = append(s.Ops, StorageOp{OpGetFragment, lstream.sdHash})
 	return &RemoteStream{url: "http://dummy/url"}, nil
 }
+
+func (s *DummyStorage) PutFragment(sdHash, name string, data []byte) error {
+	s.Ops = append(s.Ops, StorageOp{OpPutFragment, sdHash})
+	s.Fragments[path.Join(sdHash, name)] = data
+	return nil
+}
+
+func (s *DummyStorage) DeleteFragments(sdHash string, names ...string) error {
+	s.Ops = append(s.Ops, StorageOp{OpDeleteFragments, sdHash})
+	for _, n := range names {
+		delete(s.Fragments, path.Join(sdHash, n))
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package video
+
+import (
+	"context"
+	"database/sql"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Access rule kinds.
+const (
+	// RuleChannel rules apply to all claims signed by a channel, their subject is a channel URL like `@name#id`.
+	RuleChannel = "channel"
+	// RuleClaim rules apply to a single claim, their subject is a claim ID. They override channel rules.
+	RuleClaim = "claim"
+)
+
+// Access rule policies.
+const (
+	PolicyAllow = "allow"
+	PolicyBlock = "block"
+)
+
+// importedRuleReason marks rules imported from `enabledchannels` config option.
+const importedRuleReason = "enabledchannels"
+
+// AccessRule allows or blocks transcoding of claims of a channel or of a single claim.
+type AccessRule struct {
+	Kind      string
+	Subject   string
+	Policy    string
+	Reason    string
+	CreatedAt string
+}
+
+// accessList is an in-memory snapshot of access rules consulted when validating claims.
+type accessList struct {
+	sync.RWMutex
+	rules map[string]string
+	// blocks is true when any of the rules is a block.
+	blocks bool
+}
+
+var access = &accessList{rules: map[string]string{}}
+
+func (l *accessList) replace(rules []*AccessRule) {
+	m := map[string]string{}
+	blocks := false
+	for _, r := range rules {
+		m[r.Kind+":"+r.Subject] = r.Policy
+		blocks = blocks || r.Policy == PolicyBlock
+	}
+	l.Lock()
+	l.rules = m
+	l.blocks = blocks
+	l.Unlock()
+}
+
+func (l *accessList) hasBlocks() bool {
+	l.RLock()
+	defer l.RUnlock()
+	return l.blocks
+}
+
+func (l *accessList) policy(kind, subject string) string {
+	l.RLock()
+	defer l.RUnlock()
+	return l.rules[kind+":"+normalizeSubject(kind, subject)]
+}
+
+// normalizeSubject converts channel URLs to lowercase without `lbry://` scheme.
+func normalizeSubject(kind, subject string) string {
+	subject = strings.TrimSpace(subject)
+	if kind == RuleChannel {
+		return strings.TrimPrefix(strings.ToLower(subject), "lbry://")
+	}
+	return subject
+}
+
+// LoadAccessRules replaces access rules in effect with the ones stored in the library.
+func (q Library) LoadAccessRules() error {
+	rules, err := q.AccessRules("")
+	if err != nil {
+		return err
+	}
+	access.replace(rules)
+	logger.Infow("loaded access rules", "count", len(rules))
+	return nil
+}
+
+// ImportEnabledChannels stores allow rules for `channels` unless there are rules for them already.
+// Previously imported rules for channels no longer in `channels` are removed.
+func (q Library) ImportEnabledChannels(channels []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	imported := map[string]bool{}
+	for _, cn := range channels {
+		r := AccessRule{Kind: RuleChannel, Subject: normalizeSubject(RuleChannel, cn), Policy: PolicyAllow, Reason: importedRuleReason}
+		if _, err := q.queries.SetAccessRule(ctx, r, true); err != nil {
+			return err
+		}
+		imported[r.Subject] = true
+	}
+
+	rules, err := q.queries.ListAccessRules(ctx, RuleChannel)
+	if err != nil {
+		return err
+	}
+	for _, r := range rules {
+		if r.Reason != importedRuleReason || imported[r.Subject] {
+			continue
+		}
+		if err := q.queries.DeleteAccessRule(ctx, r.Kind, r.Subject); err != nil {
+			return err
+		}
+		logger.Infow("imported access rule removed", "kind", r.Kind, "subject", r.Subject)
+	}
+	return q.LoadAccessRules()
+}
+
+// SetAccessRule adds or replaces an access rule, which takes effect immediately.
+func (q Library) SetAccessRule(r AccessRule) (*AccessRule, error) {
+	r.Subject = normalizeSubject(r.Kind, r.Subject)
+	if r.Subject == "" || (r.Kind != RuleChannel && r.Kind != RuleClaim) || (r.Policy != PolicyAllow && r.Policy != PolicyBlock) {
+		return nil, ErrInvalidAccessRule
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	nr, err := q.queries.SetAccessRule(ctx, r, false)
+	if err != nil {
+		return nil, err
+	}
+	logger.Infow("access rule set", "kind", nr.Kind, "subject", nr.Subject, "policy", nr.Policy, "reason", nr.Reason)
+	return nr, q.LoadAccessRules()
+}
+
+// DeleteAccessRule removes an access rule, which takes effect immediately.
+func (q Library) DeleteAccessRule(kind, subject string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	err := q.queries.DeleteAccessRule(ctx, kind, normalizeSubject(kind, subject))
+	if err == sql.ErrNoRows {
+		return ErrAccessRuleMissing
+	} else if err != nil {
+		return err
+	}
+	logger.Infow("access rule deleted", "kind", kind, "subject", subject)
+	return q.LoadAccessRules()
+}
+
+// AccessRules returns stored rules of `kind`, or all rules if it's empty.
+func (q Library) AccessRules(kind string) ([]*AccessRule, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return q.queries.ListAccessRules(ctx, kind)
+}
@@ -0,0 +1,236 @@
+package video
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/lbryio/transcoder/formats"
+	"github.com/lbryio/transcoder/pkg/claim"
+)
+
+// ChannelSettings customize how videos of a single channel are processed and stored.
+// Zero values mean that defaults apply and there's no limit.
+type ChannelSettings struct {
+	// Channel is a channel URL like `@name#id`.
+	Channel string
+	// Profile is one of encoding profiles in `formats.Profiles`.
+	Profile string
+	// MaxHeight is the height of the tallest rendition to be encoded.
+	MaxHeight int
+	// KeepTier protects channel videos from being evicted from this storage tier or any colder one.
+	KeepTier string
+	// Priority is the queue priority channel videos get when they are queued, unless a priority is requested explicitly.
+	Priority int
+	// MonthlyMinutes is the number of minutes of video that can be encoded per calendar month (UTC).
+	MonthlyMinutes int
+	// MaxStoredBytes is the total size of channel videos the local storage may hold.
+	MaxStoredBytes int64
+	UpdatedAt      string
+}
+
+// ChannelUsage is what a channel has consumed in terms of its quotas.
+type ChannelUsage struct {
+	Channel     string
+	Month       string
+	Minutes     float64
+	StoredBytes int64
+}
+
+// channelRegistry is an in-memory snapshot of channel settings and usage consulted when validating claims.
+type channelRegistry struct {
+	sync.RWMutex
+	settings map[string]*ChannelSettings
+	usage    map[string]*ChannelUsage
+}
+
+var channels = &channelRegistry{settings: map[string]*ChannelSettings{}, usage: map[string]*ChannelUsage{}}
+
+func (r *channelRegistry) replace(settings []*ChannelSettings, usage map[string]*ChannelUsage) {
+	m := map[string]*ChannelSettings{}
+	for _, cs := range settings {
+		m[cs.Channel] = cs
+	}
+	r.Lock()
+	r.settings = m
+	r.usage = usage
+	r.Unlock()
+}
+
+func (r *channelRegistry) setUsage(u *ChannelUsage) {
+	r.Lock()
+	r.usage[u.Channel] = u
+	r.Unlock()
+}
+
+// usageMonth returns calendar month quotas are counted for at `t`.
+func usageMonth(t time.Time) string {
+	return t.UTC().Format("2006-01")
+}
+
+// claimChannel returns normalized URL of the claim signing channel or an empty string if it's not signed.
+func claimChannel(c *claim.Claim) string {
+	if c.SigningChannel == nil {
+		return ""
+	}
+	return normalizeSubject(RuleChannel, c.SigningChannel.CanonicalURL)
+}
+
+// claimChannelURL returns canonical URL of the claim signing channel as it's stored in the library,
+// empty for unsigned claims and directly ingested content.
+func claimChannelURL(c *claim.Claim) string {
+	if c.SigningChannel == nil {
+		return ""
+	}
+	return c.SigningChannel.CanonicalURL
+}
+
+// GetChannelSettings returns settings in effect for `channel`, which are empty when nothing was configured for it.
+func GetChannelSettings(channel string) ChannelSettings {
+	channel = normalizeSubject(RuleChannel, channel)
+	channels.RLock()
+	defer channels.RUnlock()
+	if cs, ok := channels.settings[channel]; ok {
+		return *cs
+	}
+	return ChannelSettings{Channel: channel}
+}
+
+// CheckQuota returns an error if `channel` has used up its monthly encoding minutes or storage.
+func CheckQuota(channel string, now time.Time) error {
+	if channel == "" {
+		return nil
+	}
+	channel = normalizeSubject(RuleChannel, channel)
+	channels.RLock()
+	defer channels.RUnlock()
+	cs, ok := channels.settings[channel]
+	if !ok {
+		return nil
+	}
+	u, ok := channels.usage[channel]
+	if !ok {
+		return nil
+	}
+	if cs.MonthlyMinutes > 0 && u.Month == usageMonth(now) && u.Minutes >= float64(cs.MonthlyMinutes) {
+		logger.Debugw("channel encoding quota exceeded", "channel", channel, "minutes", u.Minutes)
+		return ErrMinutesQuotaExceeded
+	}
+	if cs.MaxStoredBytes > 0 && u.StoredBytes >= cs.MaxStoredBytes {
+		logger.Debugw("channel storage quota exceeded", "channel", channel, "stored_bytes", u.StoredBytes)
+		return ErrStorageQuotaExceeded
+	}
+	return nil
+}
+
+// LoadChannelSettings replaces channel settings and usage in effect with the ones stored in the library.
+func (q Library) LoadChannelSettings() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	settings, err := q.queries.ListChannelSettings(ctx)
+	if err != nil {
+		return err
+	}
+	usage, err := q.queries.ListChannelUsage(ctx, usageMonth(time.Now()))
+	if err != nil {
+		return err
+	}
+	channels.replace(settings, usage)
+	logger.Debugw("loaded channel settings", "count", len(settings))
+	return nil
+}
+
+// SetChannelSettings adds or replaces settings of `cs.Channel`, which take effect immediately.
+func (q Library) SetChannelSettings(cs ChannelSettings) (*ChannelSettings, error) {
+	cs.Channel = normalizeSubject(RuleChannel, cs.Channel)
+	if cs.Channel == "" || cs.MaxHeight < 0 || cs.MonthlyMinutes < 0 || cs.MaxStoredBytes < 0 {
+		return nil, ErrInvalidChannelSettings
+	}
+	if _, ok := formats.Profiles[cs.Profile]; cs.Profile != "" && !ok {
+		return nil, ErrInvalidChannelSettings
+	}
+	switch cs.KeepTier {
+	case "", TierLocal, TierRemote, TierArchive:
+	default:
+		return nil, ErrInvalidChannelSettings
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	ncs, err := q.queries.SetChannelSettings(ctx, cs)
+	if err != nil {
+		return nil, err
+	}
+	logger.Infow("channel settings set", "channel", ncs.Channel, "profile", ncs.Profile, "max_height", ncs.MaxHeight,
+		"keep_tier", ncs.KeepTier, "priority", ncs.Priority,
+		"monthly_minutes", ncs.MonthlyMinutes, "max_stored_bytes", ncs.MaxStoredBytes)
+	return ncs, q.LoadChannelSettings()
+}
+
+// DeleteChannelSettings resets channel settings to defaults, which takes effect immediately.
+func (q Library) DeleteChannelSettings(channel string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	err := q.queries.DeleteChannelSettings(ctx, normalizeSubject(RuleChannel, channel))
+	if err == sql.ErrNoRows {
+		return ErrChannelSettingsMissing
+	} else if err != nil {
+		return err
+	}
+	logger.Infow("channel settings deleted", "channel", channel)
+	return q.LoadChannelSettings()
+}
+
+// ChannelSettings returns settings of all channels that have them configured.
+func (q Library) ChannelSettings() ([]*ChannelSettings, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return q.queries.ListChannelSettings(ctx)
+}
+
+// ChannelUsage returns minutes encoded this month and bytes stored locally for `channel`.
+func (q Library) ChannelUsage(channel string) (*ChannelUsage, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return q.queries.GetChannelUsage(ctx, normalizeSubject(RuleChannel, channel), usageMonth(time.Now()))
+}
+
+// RecordEncoded counts `minutes` of video encoded towards the channel monthly quota
+// and refreshes its usage in effect.
+func (q Library) RecordEncoded(channel string, minutes float64) error {
+	if channel == "" {
+		return nil
+	}
+	channel = normalizeSubject(RuleChannel, channel)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	month := usageMonth(time.Now())
+	if err := q.queries.AddChannelUsage(ctx, channel, month, minutes); err != nil {
+		return err
+	}
+	u, err := q.queries.GetChannelUsage(ctx, channel, month)
+	if err != nil {
+		return err
+	}
+	channels.setUsage(u)
+	return nil
+}
+
+// keptInTier checks if `v` is protected from eviction from `tier` by its channel settings.
+func keptInTier(v *Video, tier string) bool {
+	keep := GetChannelSettings(v.Channel).KeepTier
+	if keep == "" {
+		return false
+	}
+	return tierRank(tier) >= tierRank(keep)
+}
+
+func tierRank(tier string) int {
+	switch tier {
+	case TierLocal:
+		return 0
+	case TierRemote:
+		return 1
+	}
+	return 2
+}
@@ -3,7 +3,20 @@ package video
 import "errors"
 
 var (
-	ErrTranscodingUnderway = errors.New("transcoding in progress")
-	ErrChannelNotEnabled   = errors.New("transcoding was not enabled for this channel")
-	ErrNoSigningChannel    = errors.New("no signing channel for stream")
+	ErrTranscodingUnderway    = errors.New("transcoding in progress")
+	ErrChannelNotEnabled      = errors.New("transcoding was not enabled for this channel")
+	ErrNoSigningChannel       = errors.New("no signing channel for stream")
+	ErrChannelBlocked         = errors.New("transcoding was blocked for this channel")
+	ErrClaimBlocked           = errors.New("transcoding was blocked for this claim")
+	ErrInvalidAccessRule      = errors.New("access rule needs a subject, kind of `channel` or `claim` and policy of `allow` or `block`")
+	ErrAccessRuleMissing      = errors.New("access rule not found")
+	ErrInvalidSignature       = errors.New("request signature does not match signing channel key")
+	ErrSignatureExpired       = errors.New("request signature has expired")
+	ErrMinutesQuotaExceeded   = errors.New("channel has used up its monthly encoding quota")
+	ErrStorageQuotaExceeded   = errors.New("channel has used up its storage quota")
+	ErrInvalidChannelSettings = errors.New("channel settings need a channel, known encoding profile and storage tier and non-negative limits")
+	ErrChannelSettingsMissing = errors.New("channel settings not found")
+	ErrNoLocalCopy            = errors.New("video has no local copy")
+	ErrNoRemoteCopy           = errors.New("video has no remote copy")
+	ErrLocalCopyPresent       = errors.New("video has a local copy")
 )
@@ -1,28 +1,16 @@
 package video
 
-import (
-	"sort"
-)
+import "time"
 
 func tailVideos(items []*Video, maxSize uint64, call func(v *Video) error) (totalSize uint64, furloughedSize uint64, err error) {
-	for _, v := range items {
-		totalSize += uint64(v.GetSize())
-	}
-	if maxSize >= totalSize {
-		return
-	}
-
-	sort.Slice(items, func(i, j int) bool { return items[i].GetWeight() < items[j].GetWeight() })
-	for _, s := range items {
+	plan, totalSize := planEviction(items, TierPolicy{MaxSize: maxSize, Strategy: StrategyLRU}, time.Now(), nil)
+	for _, s := range plan {
 		err := call(s)
 		if err != nil {
 			return totalSize, furloughedSize, err
 		}
 		furloughedSize += uint64(s.GetSize())
 		logger.Debugf("furloughed: %v, left: %v", furloughedSize, totalSize-furloughedSize)
-		if maxSize >= totalSize-furloughedSize {
-			break
-		}
 	}
 
 	return
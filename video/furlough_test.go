@@ -51,7 +51,7 @@ func (s *FurloughSuite) SetupSuite() {
 
 func (s *FurloughSuite) SetupTest() {
 	s.db = db.OpenTestDB()
-	s.Require().NoError(s.db.MigrateUp(InitialMigration))
+	s.Require().NoError(s.db.Migrate(Migrations...))
 }
 
 func (s FurloughSuite) TestFurloughVideos() {
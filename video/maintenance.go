@@ -6,67 +6,96 @@ import (
 
 	"github.com/c2h5oh/datasize"
 	"github.com/lbryio/transcoder/formats"
+	"github.com/lbryio/transcoder/pkg/events"
 	"github.com/lbryio/transcoder/queue"
 )
 
+// channelUsageInterval is how often channel usage is reloaded, so stored bytes reflect videos evicted by tier policies.
+var channelUsageInterval = 5 * time.Minute
+
 func toGB(s uint64) string {
 	return fmt.Sprintf("%.2fGB", datasize.ByteSize(s).GBytes())
 }
 
+// SpawnLibraryCleaning starts enforcing library storage tier policies, each one at its own interval.
 func SpawnLibraryCleaning(lib *Library) chan<- bool {
-	mls := toGB(lib.maxLocalSize)
-	mrs := toGB(lib.maxRemoteSize)
-	logger.Infow(
-		"starting library maintenance",
-		"max_local_size", mls,
-		"max_remote_size", mrs,
-	)
-	furloughTicker := time.NewTicker(5 * time.Minute)
-	retireTicker := time.NewTicker(24 * time.Hour)
 	stopChan := make(chan bool)
+	done := make(chan struct{})
+	policies := lib.tierPolicies()
 
-	go func() {
-		for {
-			select {
-			case <-furloughTicker.C:
-				if lib.maxLocalSize == 0 {
-					continue
-				}
-				logger.Infow("starting furloughing procedure", "max_local_size", mls)
+	for _, p := range policies {
+		if err := p.Validate(); err != nil {
+			logger.Errorw("skipping invalid storage policy", "tier", p.Tier, "err", err)
+			continue
+		}
+		logger.Infow(
+			"starting library maintenance",
+			"tier", p.Tier,
+			"max_size", toGB(p.MaxSize),
+			"strategy", p.Strategy,
+			"min_residency", p.MinResidency,
+			"interval", p.Interval,
+			"simulate", lib.simulatePolicies,
+		)
+		go runTierPolicy(lib, p, done)
+	}
+	go runChannelUsageRefresh(lib, done)
 
-				totalSize, freedSize, err := FurloughVideos(lib, lib.maxLocalSize)
-				ll := logger.With("total_size", toGB(totalSize), "freed_size", toGB(freedSize))
+	go func() {
+		<-stopChan
+		logger.Info("stopping library maintenance")
+		close(done)
+	}()
 
-				if err != nil {
-					ll.Infow("error furloughing videos", "err", err)
-				} else if freedSize > 0 {
-					ll.Infow("furloughed some videos")
-				} else {
-					ll.Infow("failed to furlough any videos")
-				}
-			case <-retireTicker.C:
-				if lib.maxRemoteSize == 0 {
-					continue
-				}
+	return stopChan
+}
 
-				logger.Infow("starting retirement procedure", "max_remote_size", mrs)
-				totalSize, freedSize, err := RetireVideos(lib, lib.maxRemoteSize)
-				ll := logger.With("total_size", toGB(totalSize), "freed_size", toGB(freedSize))
-				if err != nil {
-					ll.Infow("error retiring videos", "err", err)
-				} else if freedSize > 0 {
-					ll.Infow("retired some videos")
-				} else {
-					ll.Infow("failed to retire any videos")
+func runTierPolicy(lib *Library, p TierPolicy, done <-chan struct{}) {
+	ticker := time.NewTicker(p.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			logger.Infow("enforcing storage policy", "tier", p.Tier, "max_size", toGB(p.MaxSize))
+			r, err := EnforcePolicy(lib, p, lib.simulatePolicies)
+			ll := logger.With(
+				"tier", p.Tier,
+				"total_size", toGB(r.TotalSize),
+				"freed_size", toGB(r.EvictedSize),
+				"evicted_count", len(r.Evicted),
+			)
+			if err != nil {
+				ll.Infow("error evicting videos", "err", err)
+			} else if r.Simulated {
+				evicted := []string{}
+				for _, v := range r.Evicted {
+					evicted = append(evicted, fmt.Sprintf("{%v}%v", toGB(uint64(v.GetSize())), v.URL))
 				}
-			case <-stopChan:
-				logger.Info("stopping library maintenance")
-				return
+				ll.Infow("videos that would be evicted", "videos", evicted)
+			} else if r.EvictedSize > 0 {
+				ll.Infow("evicted some videos")
+			} else {
+				ll.Infow("failed to evict any videos")
 			}
+		case <-done:
+			return
 		}
-	}()
+	}
+}
 
-	return stopChan
+func runChannelUsageRefresh(lib *Library, done <-chan struct{}) {
+	ticker := time.NewTicker(channelUsageInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := lib.LoadChannelSettings(); err != nil {
+				logger.Errorw("refreshing channel usage failed", "err", err)
+			}
+		case <-done:
+			return
+		}
+	}
 }
 
 // PopularSweeperOpts sets additional options for SpawnPopularSweeper routine.
@@ -81,7 +110,7 @@ type PopularSweeperOpts struct {
 
 // SpawnPopularSweeper will tally up the count of rejected videos and pick top N of them
 // to be added to the queue.
-// For it to work, `lib.IncViews(url, sdHash)` should be called somewhere for every video that is requested but rejected.
+// For it to work, `lib.IncViews(url, sdHash)` or `lib.IncClientViews(url, sdHash, client)` should be called somewhere for every video that is requested but rejected.
 func SpawnPopularSweeper(lib *Library, q *queue.Queue, opts PopularSweeperOpts) chan<- bool {
 	sweepTicker := time.NewTicker(opts.Interval)
 	stopChan := make(chan bool)
@@ -97,7 +126,9 @@ func SpawnPopularSweeper(lib *Library, q *queue.Queue, opts PopularSweeperOpts)
 				items := lib.sweeper.Top(opts.TopNumber, opts.LowerBound)
 				added := []string{}
 				for _, i := range items {
-					q.Add(i.URL, i.SDHash, formats.TypeHLS)
+					if t, err := q.Add(i.URL, i.SDHash, formats.TypeHLS); err == nil {
+						lib.events.Publish(events.Event{Stage: events.StageQueued, SDHash: t.SDHash, URL: t.URL, TaskID: t.ID})
+					}
 					added = append(added, fmt.Sprintf("{%v}%v", i.Count, i.URL))
 				}
 				lib.sweeper.Sweep(items)
@@ -13,10 +13,10 @@ import (
 
 func TestSpawnPopularSweeper(t *testing.T) {
 	vdb := db.OpenTestDB()
-	vdb.MigrateUp(InitialMigration)
+	vdb.Migrate(Migrations...)
 
 	qdb := db.OpenTestDB()
-	qdb.MigrateUp(queue.InitialMigration)
+	qdb.Migrate(queue.Migrations...)
 
 	lib := NewLibrary(Configure().LocalStorage(storage.Local("/tmp/test")).DB(vdb))
 	q := queue.NewQueue(qdb)
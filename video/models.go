@@ -3,24 +3,41 @@ package video
 import (
 	"database/sql"
 	"fmt"
+	"time"
 
 	"github.com/lbryio/transcoder/storage"
 )
 
+const sqliteTimeLayout = "2006-01-02 15:04:05"
+
+// Video statuses for library listings.
+const (
+	// StatusStored marks videos holding their own stream output in any of the tiers.
+	StatusStored = "stored"
+	// StatusLinked marks videos served from another video's output.
+	StatusLinked = "linked"
+)
+
 type Video struct {
-	SDHash     string
-	CreatedAt  string
-	URL        string
-	Path       string
-	RemotePath string
-	Type       string
-	Channel    string
+	SDHash      string
+	CreatedAt   string
+	URL         string
+	Path        string
+	RemotePath  string
+	ArchivePath string
+	Type        string
+	Channel     string
+	ClaimID     string
 
 	LastAccessed sql.NullTime
 	AccessCount  int64
 
 	Size     int64
 	Checksum string
+
+	// Origin is the sd_hash under which stream output of this video is stored
+	// when it is shared with other videos transcoded from an identical source.
+	Origin string
 }
 
 // GetLocation returns a video location suitable for using in HTTP redirect response.
@@ -29,9 +46,29 @@ func (v Video) GetLocation() (string, bool) {
 	if v.Path != "" {
 		return fmt.Sprintf("%v/%v", v.Path, storage.MasterPlaylistName), false
 	}
+	if v.RemotePath == "" && v.ArchivePath != "" {
+		return v.ArchivePath, true
+	}
 	return v.RemotePath, true
 }
 
+func (v Video) GetSDHash() string {
+	return v.SDHash
+}
+
+// GetStorageKey returns the key under which video stream is kept in storage.
+func (v Video) GetStorageKey() string {
+	if v.Origin != "" {
+		return v.Origin
+	}
+	return v.SDHash
+}
+
+// IsLink returns true for videos that hold no stream of their own and are served from another video's output.
+func (v Video) IsLink() bool {
+	return v.Origin != "" && v.Path == "" && v.RemotePath == "" && v.ArchivePath == ""
+}
+
 func (v Video) GetSize() int64 {
 	return v.Size
 }
@@ -39,3 +76,58 @@ func (v Video) GetSize() int64 {
 func (v Video) GetWeight() int64 {
 	return v.LastAccessed.Time.Unix()
 }
+
+// GetCreatedAt returns the time when video was added to the library.
+func (v Video) GetCreatedAt() time.Time {
+	for _, layout := range []string{time.RFC3339, sqliteTimeLayout} {
+		if t, err := time.Parse(layout, v.CreatedAt); err == nil {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+// Total is the number and the total size of a group of videos.
+type Total struct {
+	Count int64
+	Size  int64
+}
+
+// Totals summarizes library contents per storage tier and per channel.
+type Totals struct {
+	Tiers    map[string]Total
+	Channels map[string]Total
+}
+
+// Rendition is a single variant stream of a video along with its presence in storage tiers.
+type Rendition struct {
+	SDHash    string
+	Name      string
+	Height    int
+	Bandwidth int
+	Size      int64
+
+	Local  bool
+	Remote bool
+}
+
+// trimmableRenditions returns remote renditions taller than `maxHeight`, always leaving at least one rendition intact.
+func trimmableRenditions(rs []*Rendition, maxHeight int) []*Rendition {
+	var lowest *Rendition
+	remote, trimmable := []*Rendition{}, []*Rendition{}
+	for _, r := range rs {
+		if !r.Remote {
+			continue
+		}
+		remote = append(remote, r)
+		if lowest == nil || r.Height < lowest.Height {
+			lowest = r
+		}
+	}
+	for _, r := range remote {
+		if r.Height > maxHeight && r != lowest {
+			trimmable = append(trimmable, r)
+		}
+	}
+	return trimmable
+}
@@ -26,3 +26,16 @@ func TestVideo(t *testing.T) {
 	assert.True(t, remote)
 	assert.Equal(t, v.RemotePath, url)
 }
+
+func TestTrimmableRenditions(t *testing.T) {
+	rs := []*Rendition{
+		{Name: "stream_0.m3u8", Height: 1080, Remote: true},
+		{Name: "stream_1.m3u8", Height: 720, Remote: true},
+		{Name: "stream_2.m3u8", Height: 480, Remote: false},
+		{Name: "stream_3.m3u8", Height: 360, Remote: true},
+	}
+	assert.Equal(t, []*Rendition{rs[0], rs[1]}, trimmableRenditions(rs, 480))
+	assert.Equal(t, []*Rendition{rs[0]}, trimmableRenditions(rs, 720))
+	assert.Equal(t, []*Rendition{rs[0], rs[1]}, trimmableRenditions(rs, 144))
+	assert.Empty(t, trimmableRenditions(rs[:1], 144))
+}
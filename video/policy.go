@@ -0,0 +1,234 @@
+package video
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/lbryio/transcoder/internal/metrics"
+)
+
+// Storage tiers videos can reside in, from the hottest to the coldest.
+const (
+	TierLocal   = "local"
+	TierRemote  = "remote"
+	TierArchive = "archive"
+)
+
+// Eviction strategies define which videos leave the tier first.
+const (
+	// StrategyLRU evicts least recently accessed videos first.
+	StrategyLRU = "lru"
+	// StrategyLFU evicts least frequently accessed videos first.
+	StrategyLFU = "lfu"
+	// StrategySize evicts videos with the largest size per access first.
+	StrategySize = "size"
+	// StrategyAge evicts videos that were added to the library earliest first.
+	StrategyAge = "age"
+)
+
+// TierPolicy declares how videos should be evicted from a storage tier.
+// Videos evicted from local storage are furloughed, from remote storage they are moved to archive
+// if it is configured or retired otherwise, archived videos are retired.
+type TierPolicy struct {
+	Tier string
+	// MaxSize is the total size of videos the tier may hold before eviction kicks in.
+	MaxSize uint64
+	// MinResidency protects videos that were added to the library recently from being evicted.
+	// It is counted from the time a video was added to the library, not from when it entered the tier,
+	// so videos moved to a lower tier long after being added are not protected by it.
+	MinResidency time.Duration
+	// Strategy is one of StrategyLRU, StrategyLFU, StrategySize or StrategyAge.
+	Strategy string
+	// Interval is the interval at which the policy is enforced.
+	Interval time.Duration
+	// KeepHeight makes eviction from remote tier only remove renditions taller than it
+	// instead of removing videos entirely.
+	KeepHeight int
+}
+
+// PolicyReport describes the outcome of a single policy enforcement.
+type PolicyReport struct {
+	Policy      TierPolicy
+	Simulated   bool
+	TotalSize   uint64
+	EvictedSize uint64
+	Evicted     []*Video
+}
+
+var strategies = map[string]func(a, b *Video) bool{
+	StrategyLRU: func(a, b *Video) bool {
+		return a.GetWeight() < b.GetWeight()
+	},
+	StrategyLFU: func(a, b *Video) bool {
+		if a.AccessCount == b.AccessCount {
+			return a.GetWeight() < b.GetWeight()
+		}
+		return a.AccessCount < b.AccessCount
+	},
+	StrategySize: func(a, b *Video) bool {
+		return a.GetSize()/(a.AccessCount+1) > b.GetSize()/(b.AccessCount+1)
+	},
+	StrategyAge: func(a, b *Video) bool {
+		return a.GetCreatedAt().Before(b.GetCreatedAt())
+	},
+}
+
+// Validate checks that policy is well-formed.
+func (p TierPolicy) Validate() error {
+	switch p.Tier {
+	case TierLocal, TierRemote, TierArchive:
+	default:
+		return fmt.Errorf("unknown storage tier: %v", p.Tier)
+	}
+	if _, ok := strategies[p.Strategy]; !ok {
+		return fmt.Errorf("unknown eviction strategy: %v", p.Strategy)
+	}
+	if p.Interval <= 0 {
+		return fmt.Errorf("invalid policy interval: %v", p.Interval)
+	}
+	if p.KeepHeight > 0 && p.Tier != TierRemote {
+		return fmt.Errorf("renditions can only be trimmed in %v tier", TierRemote)
+	}
+	return nil
+}
+
+// planEviction picks videos to evict so that total size of `items` fits into policy size cap.
+// `freed` returns how much space evicting a video would free, videos that would free nothing are skipped,
+// as are videos kept in the tier by their channel settings.
+func planEviction(items []*Video, p TierPolicy, now time.Time, freed func(v *Video) uint64) (plan []*Video, totalSize uint64) {
+	if freed == nil {
+		freed = func(v *Video) uint64 { return uint64(v.GetSize()) }
+	}
+	for _, v := range items {
+		totalSize += uint64(v.GetSize())
+	}
+	if p.MaxSize >= totalSize {
+		return nil, totalSize
+	}
+
+	less, ok := strategies[p.Strategy]
+	if !ok {
+		less = strategies[StrategyLRU]
+	}
+	candidates := make([]*Video, len(items))
+	copy(candidates, items)
+	sort.SliceStable(candidates, func(i, j int) bool { return less(candidates[i], candidates[j]) })
+
+	var plannedSize uint64
+	for _, v := range candidates {
+		if p.MinResidency > 0 && now.Sub(v.GetCreatedAt()) < p.MinResidency {
+			continue
+		}
+		if keptInTier(v, p.Tier) {
+			continue
+		}
+		size := freed(v)
+		if size == 0 {
+			continue
+		}
+		plan = append(plan, v)
+		plannedSize += size
+		if p.MaxSize >= totalSize-plannedSize {
+			break
+		}
+	}
+	return plan, totalSize
+}
+
+// tierPolicies returns configured storage policies or the ones derived from maximum storage sizes.
+func (q Library) tierPolicies() []TierPolicy {
+	if len(q.policies) > 0 {
+		return q.policies
+	}
+	policies := []TierPolicy{}
+	if q.maxLocalSize > 0 {
+		policies = append(policies, TierPolicy{
+			Tier: TierLocal, MaxSize: q.maxLocalSize, Strategy: StrategyLRU, Interval: 5 * time.Minute,
+		})
+	}
+	if q.maxRemoteSize > 0 {
+		policies = append(policies, TierPolicy{
+			Tier: TierRemote, MaxSize: q.maxRemoteSize, Strategy: StrategyLRU, Interval: 24 * time.Hour,
+		})
+	}
+	return policies
+}
+
+func (q Library) tierVideos(tier string) ([]*Video, error) {
+	switch tier {
+	case TierLocal:
+		return q.ListLocal()
+	case TierRemote:
+		return q.ListRemoteOnly()
+	case TierArchive:
+		return q.ListArchived()
+	}
+	return nil, fmt.Errorf("unknown storage tier: %v", tier)
+}
+
+func (q Library) tierEvictor(tier string) func(v *Video) error {
+	switch tier {
+	case TierLocal:
+		return q.Furlough
+	case TierRemote:
+		if q.archive != nil {
+			return q.Archive
+		}
+		return q.Retire
+	}
+	return q.Retire
+}
+
+// EnforcePolicy evicts videos from the policy tier according to its rules.
+// With `simulate` set, videos are only reported but not evicted.
+func EnforcePolicy(lib *Library, p TierPolicy, simulate bool) (*PolicyReport, error) {
+	r := &PolicyReport{Policy: p, Simulated: simulate, Evicted: []*Video{}}
+	items, err := lib.tierVideos(p.Tier)
+	if err != nil {
+		return r, err
+	}
+
+	var freed func(v *Video) uint64
+	evict := lib.tierEvictor(p.Tier)
+	if p.KeepHeight > 0 {
+		trimmableSizes := map[string]uint64{}
+		for _, v := range items {
+			rs, err := lib.videoRenditions(v)
+			if err != nil {
+				return r, err
+			}
+			for _, tr := range trimmableRenditions(rs, p.KeepHeight) {
+				trimmableSizes[v.SDHash] += uint64(tr.Size)
+			}
+		}
+		freed = func(v *Video) uint64 { return trimmableSizes[v.SDHash] }
+		evict = func(v *Video) error { return lib.TrimRenditions(v, p.KeepHeight) }
+	}
+
+	plan, totalSize := planEviction(items, p, time.Now(), freed)
+	r.TotalSize = totalSize
+	metrics.StorageTierSizeBytes.WithLabelValues(p.Tier).Set(float64(totalSize))
+
+	simulated := fmt.Sprintf("%v", simulate)
+	for _, v := range plan {
+		size := uint64(v.GetSize())
+		if freed != nil {
+			size = freed(v)
+		}
+		if !simulate {
+			if err := evict(v); err != nil {
+				metrics.StoragePolicyFailures.WithLabelValues(p.Tier).Inc()
+				return r, err
+			}
+		}
+		r.Evicted = append(r.Evicted, v)
+		r.EvictedSize += size
+		metrics.StoragePolicyEvictedCount.WithLabelValues(p.Tier, p.Strategy, simulated).Inc()
+		metrics.StoragePolicyEvictedBytes.WithLabelValues(p.Tier, p.Strategy, simulated).Add(float64(size))
+	}
+	if !simulate {
+		metrics.StorageTierSizeBytes.WithLabelValues(p.Tier).Set(float64(totalSize - r.EvictedSize))
+	}
+	return r, nil
+}
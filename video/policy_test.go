@@ -0,0 +1,127 @@
+package video
+
+import (
+	"context"
+	"database/sql"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/lbryio/transcoder/db"
+	"github.com/lbryio/transcoder/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+func TestPlanEviction(t *testing.T) {
+	now := time.Now()
+	created := func(ago time.Duration) string { return now.Add(-ago).UTC().Format(time.RFC3339) }
+	vs := []*Video{
+		{Size: 10000, AccessCount: 50, CreatedAt: created(48 * time.Hour), LastAccessed: sql.NullTime{Time: now.Add(-25 * time.Hour)}},
+		{Size: 20000, AccessCount: 1, CreatedAt: created(47 * time.Hour), LastAccessed: sql.NullTime{Time: now.Add(-24 * time.Hour)}},
+		{Size: 50000, AccessCount: 5, CreatedAt: created(1 * time.Hour), LastAccessed: sql.NullTime{Time: now.Add(-1 * time.Hour)}},
+		{Size: 30000, AccessCount: 10, CreatedAt: created(46 * time.Hour), LastAccessed: sql.NullTime{Time: now.Add(-30 * time.Hour)}},
+		{Size: 20000, AccessCount: 3, CreatedAt: created(72 * time.Hour), LastAccessed: sql.NullTime{Time: now.Add(-23 * time.Hour)}},
+	}
+
+	cases := []struct {
+		policy   TierPolicy
+		expected []*Video
+	}{
+		{TierPolicy{MaxSize: 75000, Strategy: StrategyLRU}, []*Video{vs[3], vs[0], vs[1]}},
+		{TierPolicy{MaxSize: 75000, Strategy: StrategyLFU}, []*Video{vs[1], vs[4], vs[2]}},
+		{TierPolicy{MaxSize: 75000, Strategy: StrategySize}, []*Video{vs[1], vs[2]}},
+		{TierPolicy{MaxSize: 75000, Strategy: StrategyAge}, []*Video{vs[4], vs[0], vs[1], vs[3]}},
+		{TierPolicy{MaxSize: 75000, Strategy: StrategyLFU, MinResidency: 2 * time.Hour}, []*Video{vs[1], vs[4], vs[3]}},
+		{TierPolicy{MaxSize: 200000, Strategy: StrategyLRU}, nil},
+	}
+	for _, c := range cases {
+		plan, totalSize := planEviction(vs, c.policy, now, nil)
+		assert.EqualValues(t, 130000, totalSize)
+		assert.Equal(t, c.expected, plan, "strategy %v", c.policy.Strategy)
+	}
+}
+
+func TestTierPolicyValidate(t *testing.T) {
+	assert.NoError(t, TierPolicy{Tier: TierLocal, Strategy: StrategyLRU, Interval: time.Minute}.Validate())
+	assert.Error(t, TierPolicy{Tier: "cloud", Strategy: StrategyLRU, Interval: time.Minute}.Validate())
+	assert.Error(t, TierPolicy{Tier: TierArchive, Strategy: "random", Interval: time.Minute}.Validate())
+	assert.Error(t, TierPolicy{Tier: TierRemote, Strategy: StrategyAge}.Validate())
+}
+
+type PolicySuite struct {
+	suite.Suite
+	db *db.DB
+}
+
+func TestPolicySuite(t *testing.T) {
+	suite.Run(t, new(PolicySuite))
+}
+
+func (s *PolicySuite) SetupTest() {
+	s.db = db.OpenTestDB()
+	s.Require().NoError(s.db.Migrate(Migrations...))
+}
+
+func (s *PolicySuite) TestEnforcePolicy() {
+	dummyls := storage.Dummy()
+	lib := NewLibrary(Configure().
+		LocalStorage(dummyls).
+		RemoteStorage(storage.Dummy()).
+		DB(s.db),
+	)
+
+	for range [20]int{} {
+		v, err := lib.Add(AddParams{
+			SDHash: randomString(96),
+			URL:    "lbry://" + randomString(32),
+			Path:   randomString(96),
+			Size:   int64(1000000 + rand.Intn(1000000)),
+		})
+		s.Require().NoError(err)
+		s.Require().NoError(lib.UpdateRemotePath(v.SDHash, "https://s3.wasabi.com/"+v.SDHash))
+		_, err = lib.queries.db.ExecContext(
+			context.Background(),
+			"update videos set access_count = $2 where sd_hash = $1",
+			v.SDHash, rand.Intn(100),
+		)
+		s.Require().NoError(err)
+	}
+
+	p := TierPolicy{Tier: TierLocal, MaxSize: 10000000, Strategy: StrategyLFU, Interval: time.Minute}
+	r, err := EnforcePolicy(lib, p, true)
+	s.Require().NoError(err)
+	s.True(r.Simulated)
+	s.NotEmpty(r.Evicted)
+	s.InDelta(p.MaxSize, r.TotalSize-r.EvictedSize, 2000000)
+	s.Empty(dummyls.Ops)
+
+	local, err := lib.ListLocal()
+	s.Require().NoError(err)
+	s.Len(local, 20)
+
+	r2, err := EnforcePolicy(lib, p, false)
+	s.Require().NoError(err)
+	s.Equal(len(r.Evicted), len(r2.Evicted))
+	s.Equal(r.EvictedSize, r2.EvictedSize)
+	s.Len(dummyls.Ops, len(r2.Evicted))
+
+	local, err = lib.ListLocal()
+	s.Require().NoError(err)
+	s.Len(local, 20-len(r2.Evicted))
+
+	p = TierPolicy{Tier: TierLocal, MaxSize: 10000000, Strategy: StrategyLFU, MinResidency: time.Hour, Interval: time.Minute}
+	r3, err := EnforcePolicy(lib, p, true)
+	s.Require().NoError(err)
+	s.Empty(r3.Evicted)
+}
+
+func TestGetCreatedAt(t *testing.T) {
+	vdb := db.OpenTestDB()
+	require.NoError(t, vdb.Migrate(Migrations...))
+	lib := NewLibrary(Configure().LocalStorage(storage.Local("/tmp/test")).DB(vdb))
+	v, err := lib.Add(AddParams{SDHash: randomString(96), URL: "lbry://" + randomString(32)})
+	require.NoError(t, err)
+	assert.WithinDuration(t, time.Now(), v.GetCreatedAt(), 5*time.Second)
+}
@@ -2,31 +2,128 @@ package video
 
 import (
 	"context"
+	"database/sql"
 	"fmt"
+
+	"github.com/lbryio/transcoder/storage"
 )
 
 var (
-	allVideoColumns = `url, sd_hash, type, path, remote_path,
+	allVideoColumns = `url, sd_hash, type, path, remote_path, archive_path,
 		created_at, channel,
 		last_accessed, access_count,
-		size, checksum`
+		size, checksum, origin, claim_id`
 	queryVideoGet = fmt.Sprintf(`select %v from videos where sd_hash = $1 limit 1`, allVideoColumns)
 	queryVideoAdd = `
 		insert into videos (
-			url, sd_hash, type, path, channel, size, checksum, created_at
+			url, sd_hash, type, path, channel, size, checksum, claim_id, created_at
 		) values (
-			$1, $2, $3, $4, $5, $6, $7, datetime('now')
+			$1, $2, $3, $4, $5, $6, $7, $8, datetime('now')
 		)`
-	queryVideoUpdateAccess     = `update videos set last_accessed = datetime('now'), access_count = access_count + 1 where sd_hash = $2`
-	queryVideoUpdateRemotePath = `update videos set remote_path = $1 where sd_hash = $2`
-	queryVideoUpdatePath       = `update videos set path = $1 where sd_hash = $2`
-	queryVideoLeastAccessed    = `
+	queryVideoAddLink = `
+		insert into videos (
+			url, sd_hash, type, path, channel, size, checksum, origin, claim_id, created_at
+		) values (
+			$1, $2, $3, "", $4, 0, "", $5, $6, datetime('now')
+		)`
+	queryVideoUpdateAccess      = `update videos set last_accessed = datetime('now'), access_count = access_count + 1 where sd_hash = $2`
+	queryVideoUpdateRemotePath  = `update videos set remote_path = $1 where sd_hash = $2`
+	queryVideoUpdatePath        = `update videos set path = $1 where sd_hash = $2`
+	queryVideoUpdateArchivePath = `update videos set archive_path = $1 where sd_hash = $2`
+	queryVideoUpdateClaimID     = `update videos set claim_id = $1 where sd_hash = $2`
+	queryVideoLeastAccessed     = `
 		select strftime('%s', 'now') - strftime('%s', last_accessed) las from videos
 		where las > 3600 * 24 * 2 order by -las`
 	queryVideoDelete         = `delete from videos where sd_hash = $1`
 	queryVideoListLocalOnly  = fmt.Sprintf(`select %s from videos where path != "" and remote_path = ""`, allVideoColumns)
 	queryVideoListLocal      = fmt.Sprintf(`select %s from videos where path != "" and remote_path != ""`, allVideoColumns)
 	queryVideoListRemoteOnly = fmt.Sprintf(`select %s from videos where path = "" and remote_path != ""`, allVideoColumns)
+	queryVideoListArchived   = fmt.Sprintf(`select %s from videos where path = "" and remote_path = "" and archive_path != ""`, allVideoColumns)
+
+	queryVideoUpdateSize = `update videos set size = $1 where sd_hash = $2`
+
+	videoFilter = `
+		($1 = "" or channel = $1) and
+		($2 = "" or url like $2 or sd_hash like $2) and
+		($3 = "" or
+			($3 = "local" and path != "") or
+			($3 = "remote" and remote_path != "") or
+			($3 = "archive" and archive_path != "")) and
+		($4 = "" or
+			($4 = "linked" and origin != "" and path = "" and remote_path = "" and archive_path = "") or
+			($4 = "stored" and (path != "" or remote_path != "" or archive_path != "")))`
+	queryVideoListPage = fmt.Sprintf(
+		`select %v from videos where %v order by created_at desc, sd_hash limit $5 offset $6`,
+		allVideoColumns, videoFilter)
+	queryVideoCount      = fmt.Sprintf(`select count(*) from videos where %v`, videoFilter)
+	queryVideoTierTotals = `
+		select "local", count(*), coalesce(sum(size), 0) from videos where path != ""
+		union all
+		select "remote", count(*), coalesce(sum(size), 0) from videos where remote_path != ""
+		union all
+		select "archive", count(*), coalesce(sum(size), 0) from videos where archive_path != ""`
+	queryVideoChannelTotals = `select channel, count(*), coalesce(sum(size), 0) from videos group by channel`
+
+	queryRenditionAdd = `
+		insert or replace into renditions (
+			sd_hash, name, height, bandwidth, size, local, remote
+		) values (
+			$1, $2, $3, $4, $5, 1, 0
+		)`
+	queryRenditionList        = `select sd_hash, name, height, bandwidth, size, local, remote from renditions where sd_hash = $1 order by height desc`
+	queryRenditionsMarkLocal  = `update renditions set local = $1 where sd_hash = $2`
+	queryRenditionsMarkRemote = `update renditions set remote = $1 where sd_hash = $2`
+	queryRenditionMarkRemote  = `update renditions set remote = $1 where sd_hash = $2 and name = $3`
+	queryRenditionsDelete     = `delete from renditions where sd_hash = $1`
+
+	queryVideoHolder = fmt.Sprintf(`
+		select %v from videos where (sd_hash = $1 or origin = $1)
+		and (path != "" or remote_path != "" or archive_path != "") limit 1`, allVideoColumns)
+	queryVideoStorageKey = `select case when origin != "" then origin else sd_hash end from videos where sd_hash = $1`
+	queryVideoLinks      = fmt.Sprintf(`
+		select %v from videos where origin = $1 and path = "" and remote_path = "" and archive_path = ""
+		order by last_accessed desc`, allVideoColumns)
+	queryVideoPromote = `
+		update videos set path = $1, remote_path = $2, archive_path = $3, size = $4, checksum = $5,
+		access_count = access_count + $6
+		where sd_hash = $7`
+
+	querySourceAdd    = `insert or ignore into sources (source_hash, sd_hash, created_at) values ($1, $2, datetime('now'))`
+	querySourceGet    = `select sd_hash from sources where source_hash = $1`
+	querySourceDelete = `delete from sources where sd_hash = $1`
+
+	queryAccessRuleSet = `
+		insert or replace into access_rules (kind, subject, policy, reason, created_at)
+		values ($1, $2, $3, $4, datetime('now'))`
+	queryAccessRuleImport = `
+		insert or ignore into access_rules (kind, subject, policy, reason, created_at)
+		values ($1, $2, $3, $4, datetime('now'))`
+	queryAccessRuleGet    = `select kind, subject, policy, reason, created_at from access_rules where kind = $1 and subject = $2`
+	queryAccessRuleList   = `select kind, subject, policy, reason, created_at from access_rules where ($1 = "" or kind = $1) order by kind, subject`
+	queryAccessRuleDelete = `delete from access_rules where kind = $1 and subject = $2`
+
+	queryChannelSettingsSet = `
+		insert or replace into channel_settings
+			(channel, profile, max_height, keep_tier, priority, monthly_minutes, max_stored_bytes, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7, datetime('now'))`
+	queryChannelSettingsColumns = `channel, profile, max_height, keep_tier, priority, monthly_minutes, max_stored_bytes, updated_at`
+	queryChannelSettingsGet     = `select ` + queryChannelSettingsColumns + ` from channel_settings where channel = $1`
+	queryChannelSettingsList    = `select ` + queryChannelSettingsColumns + ` from channel_settings order by channel`
+	queryChannelSettingsDelete  = `delete from channel_settings where channel = $1`
+	queryChannelUsageAdd        = `
+		insert into channel_usage (channel, month, minutes) values ($1, $2, $3)
+		on conflict (channel, month) do update set minutes = minutes + excluded.minutes`
+	queryChannelUsageMinutes = `select coalesce(sum(minutes), 0) from channel_usage where channel = $1 and month = $2`
+	queryChannelUsageList    = `select channel, minutes from channel_usage where month = $1`
+	queryChannelStoredBytes  = `select coalesce(sum(size), 0) from videos where lower(replace(channel, 'lbry://', '')) = $1 and path != ""`
+	queryChannelsStoredBytes = `
+		select lower(replace(channel, 'lbry://', '')) as cn, sum(size) from videos
+		where channel != "" and path != ""
+		group by cn`
+
+	queryKeyAdd    = `insert or replace into encryption_keys (sd_hash, key, created_at) values ($1, $2, datetime('now'))`
+	queryKeyGet    = `select key from encryption_keys where sd_hash = $1`
+	queryKeyDelete = `delete from encryption_keys where sd_hash = $1`
 )
 
 type AddParams struct {
@@ -37,12 +134,26 @@ type AddParams struct {
 	Channel  string
 	Size     int64
 	Checksum string
+	ClaimID  string
+}
+
+// ListParams filters videos for listing. Empty fields match all videos.
+type ListParams struct {
+	Channel string
+	// Search matches videos which URL or sd_hash contain it.
+	Search string
+	// Location is one of TierLocal, TierRemote, TierArchive.
+	Location string
+	// Status is one of StatusStored, StatusLinked.
+	Status string
+	Limit  int
+	Offset int
 }
 
 func (q *Queries) Add(ctx context.Context, arg AddParams) (*Video, error) {
 	res, err := q.db.ExecContext(
 		ctx, queryVideoAdd,
-		arg.URL, arg.SDHash, arg.Type, arg.Path, arg.Channel, arg.Size, arg.Checksum,
+		arg.URL, arg.SDHash, arg.Type, arg.Path, arg.Channel, arg.Size, arg.Checksum, arg.ClaimID,
 	)
 	if err != nil {
 		return nil, err
@@ -140,6 +251,90 @@ func (q *Queries) ListRemoteOnly(ctx context.Context) ([]*Video, error) {
 	return list, nil
 }
 
+func (q *Queries) ListArchived(ctx context.Context) ([]*Video, error) {
+	var (
+		err  error
+		list []*Video
+	)
+
+	rows, err := q.db.QueryContext(ctx, queryVideoListArchived)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var i Video
+		if i, err = scan(rows); err != nil {
+			return nil, err
+		}
+		list = append(list, &i)
+	}
+
+	return list, nil
+}
+
+// ListPage returns videos matching `arg`, newest first, along with the total number of matching videos.
+func (q *Queries) ListPage(ctx context.Context, arg ListParams) ([]*Video, int, error) {
+	var total int
+	search := ""
+	if arg.Search != "" {
+		search = "%" + arg.Search + "%"
+	}
+
+	row := q.db.QueryRowContext(ctx, queryVideoCount, arg.Channel, search, arg.Location, arg.Status)
+	if err := row.Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	list := []*Video{}
+	rows, err := q.db.QueryContext(
+		ctx, queryVideoListPage,
+		arg.Channel, search, arg.Location, arg.Status, arg.Limit, arg.Offset,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		i, err := scan(rows)
+		if err != nil {
+			return nil, 0, err
+		}
+		list = append(list, &i)
+	}
+	return list, total, rows.Err()
+}
+
+// Totals returns the number and size of videos in each storage tier and for each channel.
+func (q *Queries) Totals(ctx context.Context) (*Totals, error) {
+	t := &Totals{Tiers: map[string]Total{}, Channels: map[string]Total{}}
+	for query, target := range map[string]map[string]Total{
+		queryVideoTierTotals:    t.Tiers,
+		queryVideoChannelTotals: t.Channels,
+	} {
+		rows, err := q.db.QueryContext(ctx, query)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var (
+				key string
+				i   Total
+			)
+			if err := rows.Scan(&key, &i.Count, &i.Size); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			target[key] = i
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return t, nil
+}
+
 func (q *Queries) UpdateRemotePath(ctx context.Context, sdHash, url string) error {
 	tx, err := q.db.BeginTx(ctx, nil)
 	if err != nil {
@@ -190,6 +385,31 @@ func (q *Queries) UpdatePath(ctx context.Context, sdHash, path string) error {
 	return nil
 }
 
+func (q *Queries) UpdateArchivePath(ctx context.Context, sdHash, url string) error {
+	tx, err := q.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	r, err := tx.ExecContext(ctx, queryVideoUpdateArchivePath, url, sdHash)
+	if err != nil {
+		return err
+	}
+	n, err := r.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		tx.Rollback()
+		return fmt.Errorf("video %v not found", sdHash)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
 func (q *Queries) Delete(ctx context.Context, sdHash string) error {
 	tx, err := q.db.BeginTx(ctx, nil)
 	if err != nil {
@@ -215,6 +435,389 @@ func (q *Queries) Delete(ctx context.Context, sdHash string) error {
 	return nil
 }
 
+func (q *Queries) UpdateSize(ctx context.Context, sdHash string, size int64) error {
+	_, err := q.db.ExecContext(ctx, queryVideoUpdateSize, size, sdHash)
+	return err
+}
+
+func (q *Queries) AddRenditions(ctx context.Context, sdHash string, rs []storage.Rendition) error {
+	tx, err := q.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for _, r := range rs {
+		_, err := tx.ExecContext(ctx, queryRenditionAdd, sdHash, r.Name, r.Height, r.Bandwidth, r.Size)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (q *Queries) ListRenditions(ctx context.Context, sdHash string) ([]*Rendition, error) {
+	list := []*Rendition{}
+	rows, err := q.db.QueryContext(ctx, queryRenditionList, sdHash)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var r Rendition
+		if err := rows.Scan(&r.SDHash, &r.Name, &r.Height, &r.Bandwidth, &r.Size, &r.Local, &r.Remote); err != nil {
+			return nil, err
+		}
+		list = append(list, &r)
+	}
+	return list, rows.Err()
+}
+
+func (q *Queries) MarkRenditionsLocal(ctx context.Context, sdHash string, present bool) error {
+	_, err := q.db.ExecContext(ctx, queryRenditionsMarkLocal, present, sdHash)
+	return err
+}
+
+func (q *Queries) MarkRenditionsRemote(ctx context.Context, sdHash string, present bool) error {
+	_, err := q.db.ExecContext(ctx, queryRenditionsMarkRemote, present, sdHash)
+	return err
+}
+
+func (q *Queries) MarkRenditionRemote(ctx context.Context, sdHash, name string, present bool) error {
+	_, err := q.db.ExecContext(ctx, queryRenditionMarkRemote, present, sdHash, name)
+	return err
+}
+
+func (q *Queries) DeleteRenditions(ctx context.Context, sdHash string) error {
+	_, err := q.db.ExecContext(ctx, queryRenditionsDelete, sdHash)
+	return err
+}
+
+// Peek returns video by its sd_hash without counting it as accessed.
+func (q *Queries) Peek(ctx context.Context, sdHash string) (*Video, error) {
+	row := q.db.QueryRowContext(ctx, queryVideoGet, sdHash)
+	i, err := scan(row)
+	if err != nil {
+		return nil, err
+	}
+	return &i, nil
+}
+
+// UpdateClaimID sets ID of the claim video `sdHash` was transcoded for.
+func (q *Queries) UpdateClaimID(ctx context.Context, sdHash, claimID string) error {
+	_, err := q.db.ExecContext(ctx, queryVideoUpdateClaimID, claimID, sdHash)
+	return err
+}
+
+// AddLink records a video which has no stream of its own and is served from the output stored under `origin`.
+func (q *Queries) AddLink(ctx context.Context, arg AddParams, origin string) (*Video, error) {
+	_, err := q.db.ExecContext(
+		ctx, queryVideoAddLink,
+		arg.URL, arg.SDHash, arg.Type, arg.Channel, origin, arg.ClaimID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return q.Get(ctx, arg.SDHash)
+}
+
+// GetHolder returns the video holding stream output stored under `storageKey`,
+// counting it as accessed if `touch` is set.
+func (q *Queries) GetHolder(ctx context.Context, storageKey string, touch bool) (*Video, error) {
+	var (
+		i   Video
+		err error
+	)
+
+	row := q.db.QueryRowContext(ctx, queryVideoHolder, storageKey)
+	if i, err = scan(row); err != nil {
+		return nil, err
+	}
+
+	if touch {
+		_, err = q.db.ExecContext(ctx, queryVideoUpdateAccess, i.SDHash)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &i, nil
+}
+
+// ListLinks returns videos served from the output stored under `storageKey`, most recently accessed first.
+func (q *Queries) ListLinks(ctx context.Context, storageKey string) ([]*Video, error) {
+	var (
+		err  error
+		list []*Video
+	)
+
+	rows, err := q.db.QueryContext(ctx, queryVideoLinks, storageKey)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var i Video
+		if i, err = scan(rows); err != nil {
+			return nil, err
+		}
+		list = append(list, &i)
+	}
+
+	return list, nil
+}
+
+// Promote deletes `holder` record and transfers its stream locations to the linked video `sdHash`.
+func (q *Queries) Promote(ctx context.Context, holder *Video, sdHash string) error {
+	tx, err := q.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, queryVideoDelete, holder.SDHash)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	r, err := tx.ExecContext(
+		ctx, queryVideoPromote,
+		holder.Path, holder.RemotePath, holder.ArchivePath, holder.Size, holder.Checksum, holder.AccessCount, sdHash,
+	)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	n, err := r.RowsAffected()
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if n == 0 {
+		tx.Rollback()
+		return fmt.Errorf("video %v not found", sdHash)
+	}
+	return tx.Commit()
+}
+
+func (q *Queries) GetStorageKey(ctx context.Context, sdHash string) (string, error) {
+	var storageKey string
+	row := q.db.QueryRowContext(ctx, queryVideoStorageKey, sdHash)
+	if err := row.Scan(&storageKey); err != nil {
+		return "", err
+	}
+	return storageKey, nil
+}
+
+func (q *Queries) AddSource(ctx context.Context, sourceHash, storageKey string) error {
+	_, err := q.db.ExecContext(ctx, querySourceAdd, sourceHash, storageKey)
+	return err
+}
+
+func (q *Queries) GetSource(ctx context.Context, sourceHash string) (string, error) {
+	var storageKey string
+	row := q.db.QueryRowContext(ctx, querySourceGet, sourceHash)
+	if err := row.Scan(&storageKey); err != nil {
+		return "", err
+	}
+	return storageKey, nil
+}
+
+func (q *Queries) DeleteSources(ctx context.Context, storageKey string) error {
+	_, err := q.db.ExecContext(ctx, querySourceDelete, storageKey)
+	return err
+}
+
+func (q *Queries) AddKey(ctx context.Context, sdHash string, key []byte) error {
+	_, err := q.db.ExecContext(ctx, queryKeyAdd, sdHash, key)
+	return err
+}
+
+func (q *Queries) GetKey(ctx context.Context, sdHash string) ([]byte, error) {
+	var key []byte
+	row := q.db.QueryRowContext(ctx, queryKeyGet, sdHash)
+	if err := row.Scan(&key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (q *Queries) DeleteKey(ctx context.Context, sdHash string) error {
+	_, err := q.db.ExecContext(ctx, queryKeyDelete, sdHash)
+	return err
+}
+
+// SetAccessRule adds or replaces rule for `r.Kind` and `r.Subject`. With `keep` set, an existing rule is left intact.
+func (q *Queries) SetAccessRule(ctx context.Context, r AccessRule, keep bool) (*AccessRule, error) {
+	query := queryAccessRuleSet
+	if keep {
+		query = queryAccessRuleImport
+	}
+	if _, err := q.db.ExecContext(ctx, query, r.Kind, r.Subject, r.Policy, r.Reason); err != nil {
+		return nil, err
+	}
+	return scanAccessRule(q.db.QueryRowContext(ctx, queryAccessRuleGet, r.Kind, r.Subject))
+}
+
+// ListAccessRules returns rules of `kind`, or all rules if it's empty.
+func (q *Queries) ListAccessRules(ctx context.Context, kind string) ([]*AccessRule, error) {
+	rules := []*AccessRule{}
+	rows, err := q.db.QueryContext(ctx, queryAccessRuleList, kind)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		r, err := scanAccessRule(rows)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, r)
+	}
+	return rules, rows.Err()
+}
+
+func (q *Queries) DeleteAccessRule(ctx context.Context, kind, subject string) error {
+	res, err := q.db.ExecContext(ctx, queryAccessRuleDelete, kind, subject)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func scanAccessRule(r rowScanner) (*AccessRule, error) {
+	var i AccessRule
+	if err := r.Scan(&i.Kind, &i.Subject, &i.Policy, &i.Reason, &i.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &i, nil
+}
+
+func (q *Queries) SetChannelSettings(ctx context.Context, cs ChannelSettings) (*ChannelSettings, error) {
+	_, err := q.db.ExecContext(
+		ctx, queryChannelSettingsSet,
+		cs.Channel, cs.Profile, cs.MaxHeight, cs.KeepTier, cs.Priority, cs.MonthlyMinutes, cs.MaxStoredBytes,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanChannelSettings(q.db.QueryRowContext(ctx, queryChannelSettingsGet, cs.Channel))
+}
+
+func (q *Queries) GetChannelSettings(ctx context.Context, channel string) (*ChannelSettings, error) {
+	return scanChannelSettings(q.db.QueryRowContext(ctx, queryChannelSettingsGet, channel))
+}
+
+func (q *Queries) ListChannelSettings(ctx context.Context) ([]*ChannelSettings, error) {
+	items := []*ChannelSettings{}
+	rows, err := q.db.QueryContext(ctx, queryChannelSettingsList)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		cs, err := scanChannelSettings(rows)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, cs)
+	}
+	return items, rows.Err()
+}
+
+func (q *Queries) DeleteChannelSettings(ctx context.Context, channel string) error {
+	res, err := q.db.ExecContext(ctx, queryChannelSettingsDelete, channel)
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// AddChannelUsage adds `minutes` encoded to the channel usage for `month`.
+func (q *Queries) AddChannelUsage(ctx context.Context, channel, month string, minutes float64) error {
+	_, err := q.db.ExecContext(ctx, queryChannelUsageAdd, channel, month, minutes)
+	return err
+}
+
+// GetChannelUsage returns minutes encoded for the channel in `month` and total size of its videos stored locally.
+func (q *Queries) GetChannelUsage(ctx context.Context, channel, month string) (*ChannelUsage, error) {
+	u := &ChannelUsage{Channel: channel, Month: month}
+	if err := q.db.QueryRowContext(ctx, queryChannelUsageMinutes, channel, month).Scan(&u.Minutes); err != nil {
+		return nil, err
+	}
+	if err := q.db.QueryRowContext(ctx, queryChannelStoredBytes, channel).Scan(&u.StoredBytes); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// ListChannelUsage returns usage of all channels that have either encoded something in `month` or have videos stored.
+func (q *Queries) ListChannelUsage(ctx context.Context, month string) (map[string]*ChannelUsage, error) {
+	usage := map[string]*ChannelUsage{}
+	get := func(channel string) *ChannelUsage {
+		if _, ok := usage[channel]; !ok {
+			usage[channel] = &ChannelUsage{Channel: channel, Month: month}
+		}
+		return usage[channel]
+	}
+
+	rows, err := q.db.QueryContext(ctx, queryChannelUsageList, month)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			channel string
+			minutes float64
+		)
+		if err := rows.Scan(&channel, &minutes); err != nil {
+			return nil, err
+		}
+		get(channel).Minutes = minutes
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = q.db.QueryContext(ctx, queryChannelsStoredBytes)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			channel string
+			size    int64
+		)
+		if err := rows.Scan(&channel, &size); err != nil {
+			return nil, err
+		}
+		get(channel).StoredBytes = size
+	}
+	return usage, rows.Err()
+}
+
+func scanChannelSettings(r rowScanner) (*ChannelSettings, error) {
+	var i ChannelSettings
+	err := r.Scan(&i.Channel, &i.Profile, &i.MaxHeight, &i.KeepTier, &i.Priority, &i.MonthlyMinutes, &i.MaxStoredBytes, &i.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &i, nil
+}
+
 type rowScanner interface {
 	Scan(dest ...interface{}) error
 }
@@ -227,12 +830,15 @@ func scan(r rowScanner) (Video, error) {
 		&i.Type,
 		&i.Path,
 		&i.RemotePath,
+		&i.ArchivePath,
 		&i.CreatedAt,
 		&i.Channel,
 		&i.LastAccessed,
 		&i.AccessCount,
 		&i.Size,
 		&i.Checksum,
+		&i.Origin,
+		&i.ClaimID,
 	); err != nil {
 		return i, err
 	}
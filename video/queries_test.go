@@ -1,13 +1,16 @@
 package video
 
 import (
+	"context"
 	"database/sql"
 	"math/rand"
 	"testing"
 	"time"
 
+	ljsonrpc "github.com/lbryio/lbry.go/v2/extras/jsonrpc"
 	"github.com/lbryio/transcoder/db"
 	"github.com/lbryio/transcoder/formats"
+	"github.com/lbryio/transcoder/pkg/claim"
 	"github.com/lbryio/transcoder/storage"
 	"github.com/stretchr/testify/suite"
 )
@@ -33,7 +36,7 @@ func (s *LibrarySuite) SetupSuite() {
 
 func (s *LibrarySuite) SetupTest() {
 	s.db = db.OpenTestDB()
-	s.Require().NoError(s.db.MigrateUp(InitialMigration))
+	s.Require().NoError(s.db.Migrate(Migrations...))
 }
 
 func (s *LibrarySuite) TestVideoAdd() {
@@ -88,3 +91,397 @@ func (s *LibrarySuite) TestVideoGet() {
 	s.Require().NoError(err)
 	s.EqualValues(2, video.AccessCount)
 }
+
+func (s *LibrarySuite) TestKeys() {
+	lib := NewLibrary(Configure().LocalStorage(storage.Local("/tmp/test")).DB(s.db).KeyServerURL("https://t.lbry.tv/api/v1/key/"))
+	sdHash := randomString(96)
+
+	s.True(lib.EncryptionEnabled())
+	s.Equal("https://t.lbry.tv/api/v1/key/"+sdHash, lib.KeyURI(sdHash))
+
+	_, err := lib.GetKey(sdHash)
+	s.Equal(sql.ErrNoRows, err)
+
+	key, err := lib.GenerateKey(sdHash)
+	s.Require().NoError(err)
+	s.Len(key, 16)
+
+	storedKey, err := lib.GetKey(sdHash)
+	s.Require().NoError(err)
+	s.Equal(key, storedKey)
+}
+
+func (s *LibrarySuite) TestRenditions() {
+	lib := NewLibrary(Configure().LocalStorage(storage.Local("/tmp/test")).DB(s.db))
+	params := AddParams{SDHash: randomString(96), URL: "lbry://" + randomString(32), Path: randomString(96), Size: 1000}
+	_, err := lib.Add(params)
+	s.Require().NoError(err)
+
+	s.Require().NoError(lib.AddRenditions(params.SDHash, []storage.Rendition{
+		{Name: "stream_1.m3u8", Height: 360, Bandwidth: 500000, Size: 200},
+		{Name: "stream_0.m3u8", Height: 720, Bandwidth: 2000000, Size: 700},
+	}))
+	rs, err := lib.Renditions(params.SDHash)
+	s.Require().NoError(err)
+	s.Require().Len(rs, 2)
+	s.Equal("stream_0.m3u8", rs[0].Name)
+	s.Equal(720, rs[0].Height)
+	s.True(rs[0].Local)
+	s.False(rs[0].Remote)
+
+	s.Require().NoError(lib.UpdateRemotePath(params.SDHash, "remote"))
+	rs, err = lib.Renditions(params.SDHash)
+	s.Require().NoError(err)
+	s.True(rs[0].Remote)
+	s.True(rs[1].Remote)
+	s.Equal([]*Rendition{rs[0]}, trimmableRenditions(rs, 360))
+}
+
+func (s *LibrarySuite) TestTrimFMP4Renditions() {
+	remote := storage.Dummy()
+	lib := NewLibrary(Configure().LocalStorage(storage.Local("/tmp/test")).RemoteStorage(remote).DB(s.db))
+	params := AddParams{SDHash: randomString(96), URL: "lbry://" + randomString(32), Size: 1000}
+	_, err := lib.Add(params)
+	s.Require().NoError(err)
+	s.Require().NoError(lib.AddRenditions(params.SDHash, []storage.Rendition{
+		{Name: "stream_1.m3u8", Height: 360, Bandwidth: 500000, Size: 300},
+		{Name: "stream_0.m3u8", Height: 720, Bandwidth: 2000000, Size: 700},
+	}))
+	s.Require().NoError(lib.UpdateRemotePath(params.SDHash, "remote"))
+
+	put := func(name, data string) {
+		s.Require().NoError(remote.PutFragment(params.SDHash, name, []byte(data)))
+	}
+	put(storage.MasterPlaylistName, "#EXTM3U\n#EXT-X-VERSION:7\n"+
+		"#EXT-X-STREAM-INF:BANDWIDTH=2000000,RESOLUTION=1280x720,CODECS=\"hvc1\"\nstream_0.m3u8\n"+
+		"#EXT-X-STREAM-INF:BANDWIDTH=500000,RESOLUTION=640x360,CODECS=\"hvc1\"\nstream_1.m3u8\n")
+	for _, v := range []string{"0", "1"} {
+		put("stream_"+v+".m3u8", "#EXTM3U\n#EXT-X-MAP:URI=\"init_"+v+".mp4\"\n#EXTINF:10.0,\nseg_"+v+"_000000.m4s\n#EXT-X-ENDLIST\n")
+		put("init_"+v+".mp4", "init")
+		put("seg_"+v+"_000000.m4s", "segment")
+	}
+
+	v, err := lib.Lookup(params.SDHash)
+	s.Require().NoError(err)
+	s.Require().NoError(lib.TrimRenditions(v, 360))
+
+	for _, n := range []string{"stream_0.m3u8", "init_0.mp4", "seg_0_000000.m4s"} {
+		s.NotContains(remote.Fragments, params.SDHash+"/"+n)
+	}
+	for _, n := range []string{"stream_1.m3u8", "init_1.mp4", "seg_1_000000.m4s"} {
+		s.Contains(remote.Fragments, params.SDHash+"/"+n)
+	}
+	master := string(remote.Fragments[params.SDHash+"/"+storage.MasterPlaylistName])
+	s.NotContains(master, "stream_0.m3u8")
+	s.Contains(master, "stream_1.m3u8")
+}
+
+func (s *LibrarySuite) TestTrimBackfilledRenditions() {
+	remote := storage.Dummy()
+	lib := NewLibrary(Configure().LocalStorage(storage.Local("/tmp/test")).RemoteStorage(remote).DB(s.db))
+	params := AddParams{SDHash: randomString(96), URL: "lbry://" + randomString(32), Size: 1000}
+	_, err := lib.Add(params)
+	s.Require().NoError(err)
+	s.Require().NoError(lib.UpdateRemotePath(params.SDHash, "remote"))
+
+	put := func(name, data string) {
+		s.Require().NoError(remote.PutFragment(params.SDHash, name, []byte(data)))
+	}
+	put(storage.MasterPlaylistName, "#EXTM3U\n#EXT-X-VERSION:6\n"+
+		"#EXT-X-STREAM-INF:BANDWIDTH=1500000,RESOLUTION=1280x720\nstream_0.m3u8\n"+
+		"#EXT-X-STREAM-INF:BANDWIDTH=500000,RESOLUTION=640x360\nstream_1.m3u8\n")
+	for _, v := range []string{"0", "1"} {
+		put("stream_"+v+".m3u8", "#EXTM3U\n#EXTINF:10.0,\nseg_"+v+"_000000.ts\n#EXT-X-ENDLIST\n")
+		put("seg_"+v+"_000000.ts", "segment")
+	}
+
+	v, err := lib.Lookup(params.SDHash)
+	s.Require().NoError(err)
+	s.Require().NoError(lib.TrimRenditions(v, 360))
+
+	rs, err := lib.Renditions(params.SDHash)
+	s.Require().NoError(err)
+	s.Require().Len(rs, 2)
+	s.Equal("stream_0.m3u8", rs[0].Name)
+	s.Equal(720, rs[0].Height)
+	s.EqualValues(750, rs[0].Size)
+	s.False(rs[0].Remote)
+	s.False(rs[0].Local)
+	s.True(rs[1].Remote)
+	s.NotContains(remote.Fragments, params.SDHash+"/seg_0_000000.ts")
+	s.Contains(remote.Fragments, params.SDHash+"/seg_1_000000.ts")
+
+	v, err = lib.Lookup(params.SDHash)
+	s.Require().NoError(err)
+	s.EqualValues(250, v.Size)
+}
+
+func (s *LibrarySuite) TestSourceLinks() {
+	remote := storage.Dummy()
+	lib := NewLibrary(Configure().LocalStorage(storage.Local("/tmp/test")).RemoteStorage(remote).DB(s.db))
+	sourceHash := randomString(64)
+
+	_, err := lib.FindBySource(sourceHash)
+	s.Equal(sql.ErrNoRows, err)
+
+	orig, err := lib.Add(AddParams{SDHash: randomString(96), URL: "lbry://" + randomString(32), Path: randomString(96), Size: 1000, Type: formats.TypeHLS})
+	s.Require().NoError(err)
+	s.Require().NoError(lib.UpdateRemotePath(orig.SDHash, "https://s3.wasabi.com/"+orig.SDHash))
+	s.Require().NoError(lib.AddSource(sourceHash, orig.SDHash))
+
+	holder, err := lib.FindBySource(sourceHash)
+	s.Require().NoError(err)
+	s.Equal(orig.SDHash, holder.SDHash)
+
+	links := []*Video{}
+	for range [2]int{} {
+		l, err := lib.Link(AddParams{SDHash: randomString(96), URL: "lbry://" + randomString(32)}, holder)
+		s.Require().NoError(err)
+		s.True(l.IsLink())
+		s.Equal(orig.SDHash, l.GetStorageKey())
+		links = append(links, l)
+
+		v, err := lib.Get(l.SDHash)
+		s.Require().NoError(err)
+		s.Equal(orig.SDHash, v.SDHash)
+	}
+
+	// Retiring the original hands its stream over to one of the links.
+	holder, err = lib.Get(orig.SDHash)
+	s.Require().NoError(err)
+	s.Require().NoError(lib.Retire(holder))
+	s.Empty(remote.Ops)
+	_, err = lib.queries.Get(context.Background(), orig.SDHash)
+	s.Equal(sql.ErrNoRows, err)
+
+	holder, err = lib.FindBySource(sourceHash)
+	s.Require().NoError(err)
+	s.NotEqual(orig.SDHash, holder.SDHash)
+	s.False(holder.IsLink())
+	s.Equal(orig.SDHash, holder.GetStorageKey())
+	s.Equal("https://s3.wasabi.com/"+orig.SDHash, holder.RemotePath)
+	s.EqualValues(1000, holder.Size)
+
+	var remaining *Video
+	for _, l := range links {
+		if l.SDHash != holder.SDHash {
+			remaining = l
+		}
+	}
+	v, err := lib.Get(remaining.SDHash)
+	s.Require().NoError(err)
+	s.Equal(holder.SDHash, v.SDHash)
+
+	// Stream output is only deleted when the last reference is retired.
+	s.Require().NoError(lib.Retire(holder))
+	s.Empty(remote.Ops)
+	v, err = lib.Get(remaining.SDHash)
+	s.Require().NoError(err)
+	s.Require().NoError(lib.Retire(v))
+	s.Require().Len(remote.Ops, 1)
+	s.Equal(storage.StorageOp{Op: storage.OpDelete, SDHash: orig.SDHash}, remote.Ops[0])
+
+	_, err = lib.FindBySource(sourceHash)
+	s.Equal(sql.ErrNoRows, err)
+}
+
+func (s *LibrarySuite) TestRetireArchivedWithoutArchive() {
+	lib := NewLibrary(Configure().LocalStorage(storage.Local("/tmp/test")).DB(s.db))
+	v, err := lib.Add(AddParams{URL: "lbry://archived", SDHash: randomString(96), Type: formats.TypeHLS})
+	s.Require().NoError(err)
+	s.Require().NoError(lib.queries.UpdateArchivePath(context.Background(), v.SDHash, "https://archive/"+v.SDHash))
+	v, err = lib.Get(v.SDHash)
+	s.Require().NoError(err)
+
+	s.Error(lib.Retire(v))
+	_, err = lib.Get(v.SDHash)
+	s.NoError(err, "record of archived video should be kept")
+}
+
+func (s *LibrarySuite) TestListAndTotals() {
+	lib := NewLibrary(Configure().LocalStorage(storage.Local("/tmp/test")).DB(s.db))
+	channel := "@specialoperationstest#3"
+
+	local, err := lib.Add(AddParams{SDHash: randomString(96), URL: "lbry://local-" + randomString(8), Path: randomString(96), Size: 1000, Type: formats.TypeHLS, Channel: channel})
+	s.Require().NoError(err)
+	remote, err := lib.Add(AddParams{SDHash: randomString(96), URL: "lbry://remote-" + randomString(8), Path: randomString(96), Size: 500, Type: formats.TypeHLS})
+	s.Require().NoError(err)
+	s.Require().NoError(lib.UpdateRemotePath(remote.SDHash, "https://s3.wasabi.com/"+remote.SDHash))
+	_, err = lib.Link(AddParams{SDHash: randomString(96), URL: "lbry://link-" + randomString(8), Channel: channel}, remote)
+	s.Require().NoError(err)
+
+	videos, total, err := lib.List(ListParams{Limit: 10})
+	s.Require().NoError(err)
+	s.Equal(3, total)
+	s.Len(videos, 3)
+
+	videos, total, err = lib.List(ListParams{Channel: channel, Status: StatusStored, Limit: 10})
+	s.Require().NoError(err)
+	s.Equal(1, total)
+	s.Require().Len(videos, 1)
+	s.Equal(local.SDHash, videos[0].SDHash)
+
+	videos, total, err = lib.List(ListParams{Status: StatusLinked, Search: "link-", Limit: 10})
+	s.Require().NoError(err)
+	s.Equal(1, total)
+	s.Require().Len(videos, 1)
+	s.Equal(remote.SDHash, videos[0].Origin)
+
+	videos, total, err = lib.List(ListParams{Location: TierRemote, Limit: 10})
+	s.Require().NoError(err)
+	s.Equal(1, total)
+	s.Require().Len(videos, 1)
+	s.Equal(remote.SDHash, videos[0].SDHash)
+
+	videos, total, err = lib.List(ListParams{Limit: 1, Offset: 2})
+	s.Require().NoError(err)
+	s.Equal(3, total)
+	s.Len(videos, 1)
+
+	totals, err := lib.Totals()
+	s.Require().NoError(err)
+	s.Equal(Total{Count: 2, Size: 1500}, totals.Tiers[TierLocal])
+	s.Equal(Total{Count: 1, Size: 500}, totals.Tiers[TierRemote])
+	s.Equal(Total{}, totals.Tiers[TierArchive])
+	s.Equal(Total{Count: 2, Size: 1000}, totals.Channels[channel])
+	s.Equal(Total{Count: 1, Size: 500}, totals.Channels[""])
+}
+
+func (s *LibrarySuite) TestAccessRules() {
+	lib := NewLibrary(Configure().LocalStorage(storage.Local("/tmp/test")).DB(s.db))
+	defer LoadEnabledChannels(nil)
+
+	newClaim := func(claimID, channel string) *claim.Claim {
+		c := &claim.Claim{Claim: &ljsonrpc.Claim{ClaimID: claimID}}
+		if channel != "" {
+			c.SigningChannel = &ljsonrpc.Claim{CanonicalURL: channel}
+		}
+		return c
+	}
+	allowed := newClaim("aaaa", "lbry://@Enabled#1")
+	other := newClaim("bbbb", "lbry://@Other#2")
+	orphan := newClaim("cccc", "")
+
+	s.Require().NoError(lib.ImportEnabledChannels([]string{"@enabled#1"}))
+	s.NoError(ValidateByClaim(allowed))
+	s.Equal(ErrChannelNotEnabled, ValidateByClaim(other))
+	s.Equal(ErrNoSigningChannel, ValidateByClaim(orphan))
+
+	_, err := lib.SetAccessRule(AccessRule{Kind: "user", Subject: "x", Policy: PolicyAllow})
+	s.Equal(ErrInvalidAccessRule, err)
+
+	// Claim rules override channel ones.
+	_, err = lib.SetAccessRule(AccessRule{Kind: RuleClaim, Subject: "aaaa", Policy: PolicyBlock, Reason: "DMCA"})
+	s.Require().NoError(err)
+	s.Equal(ErrClaimBlocked, ValidateByClaim(allowed))
+	_, err = lib.SetAccessRule(AccessRule{Kind: RuleClaim, Subject: "cccc", Policy: PolicyAllow})
+	s.Require().NoError(err)
+	s.NoError(ValidateByClaim(orphan))
+
+	r, err := lib.SetAccessRule(AccessRule{Kind: RuleChannel, Subject: "lbry://@OTHER#2", Policy: PolicyBlock})
+	s.Require().NoError(err)
+	s.Equal("@other#2", r.Subject)
+	s.Equal(ErrChannelBlocked, ValidateByClaim(other))
+	s.Equal(ErrChannelBlocked, CheckBlocked(other))
+
+	// Videos in the library are checked against the claim and channel recorded for them.
+	v, err := lib.Add(AddParams{
+		URL: "lbry://other", SDHash: db.RandomString(96), Type: formats.TypeHLS, Path: db.RandomString(96),
+		Channel: "lbry://@Other#2",
+	})
+	s.Require().NoError(err)
+	s.Equal(ErrChannelBlocked, CheckVideoBlocked(v))
+	s.Require().NoError(lib.SetClaimID(v.SDHash, "cccc"))
+	v, err = lib.Lookup(v.SDHash)
+	s.Require().NoError(err)
+	s.Equal("cccc", v.ClaimID)
+	s.NoError(CheckVideoBlocked(v))
+
+	// Importing enabled channels does not override existing rules.
+	s.Require().NoError(lib.ImportEnabledChannels([]string{"@enabled#1", "@other#2"}))
+	s.Equal(ErrChannelBlocked, ValidateByClaim(other))
+
+	rules, err := lib.AccessRules(RuleChannel)
+	s.Require().NoError(err)
+	s.Len(rules, 2)
+
+	s.Require().NoError(lib.DeleteAccessRule(RuleClaim, "aaaa"))
+	s.NoError(ValidateByClaim(allowed))
+	s.Equal(ErrAccessRuleMissing, lib.DeleteAccessRule(RuleClaim, "aaaa"))
+
+	// Rules are kept in the database.
+	LoadEnabledChannels(nil)
+	s.Equal(ErrChannelNotEnabled, ValidateByClaim(allowed))
+	s.Require().NoError(lib.LoadAccessRules())
+	s.NoError(ValidateByClaim(allowed))
+
+	// Imported rules go away with their channels, rules set otherwise are kept.
+	s.Require().NoError(lib.ImportEnabledChannels([]string{"@other#2"}))
+	s.Equal(ErrChannelNotEnabled, ValidateByClaim(allowed))
+	s.Equal(ErrChannelBlocked, ValidateByClaim(other))
+}
+
+func (s *LibrarySuite) TestChannelSettings() {
+	lib := NewLibrary(Configure().LocalStorage(storage.Local("/tmp/test")).DB(s.db))
+	defer LoadEnabledChannels(nil)
+	defer channels.replace(nil, map[string]*ChannelUsage{})
+
+	c := &claim.Claim{Claim: &ljsonrpc.Claim{ClaimID: "aaaa"}}
+	c.SigningChannel = &ljsonrpc.Claim{CanonicalURL: "lbry://@Premium#1"}
+	s.Require().NoError(lib.ImportEnabledChannels([]string{"@premium#1"}))
+
+	for _, cs := range []ChannelSettings{
+		{Channel: ""},
+		{Channel: "@premium#1", Profile: "vp9"},
+		{Channel: "@premium#1", KeepTier: "ssd"},
+		{Channel: "@premium#1", MonthlyMinutes: -1},
+	} {
+		_, err := lib.SetChannelSettings(cs)
+		s.Equal(ErrInvalidChannelSettings, err)
+	}
+
+	s.Equal(ChannelSettings{Channel: "@premium#1"}, GetChannelSettings("lbry://@Premium#1"))
+	cs, err := lib.SetChannelSettings(ChannelSettings{
+		Channel: "lbry://@Premium#1", Profile: formats.ProfileHEVC, MaxHeight: 2160, KeepTier: TierRemote,
+		Priority: 10, MonthlyMinutes: 60, MaxStoredBytes: 1000,
+	})
+	s.Require().NoError(err)
+	s.Equal("@premium#1", cs.Channel)
+	s.Equal(*cs, GetChannelSettings("lbry://@Premium#1"))
+	s.NoError(ValidateByClaim(c))
+
+	// Quotas are checked against usage in effect.
+	s.Require().NoError(lib.RecordEncoded(c.SigningChannel.CanonicalURL, 45.5))
+	s.NoError(ValidateByClaim(c))
+	s.Require().NoError(lib.RecordEncoded(c.SigningChannel.CanonicalURL, 15))
+	s.Equal(ErrMinutesQuotaExceeded, ValidateByClaim(c))
+	s.NoError(CheckQuota("@premium#1", time.Now().AddDate(0, 1, 0)))
+
+	cs.MonthlyMinutes = 0
+	_, err = lib.SetChannelSettings(*cs)
+	s.Require().NoError(err)
+	s.NoError(ValidateByClaim(c))
+
+	_, err = lib.Add(AddParams{URL: "lbry://v1", SDHash: "sd1", Type: formats.TypeHLS, Path: "sd1", Channel: "lbry://@Premium#1", Size: 1200})
+	s.Require().NoError(err)
+	s.Require().NoError(lib.LoadChannelSettings())
+	s.Equal(ErrStorageQuotaExceeded, ValidateByClaim(c))
+
+	u, err := lib.ChannelUsage("@premium#1")
+	s.Require().NoError(err)
+	s.InDelta(60.5, u.Minutes, 0.01)
+	s.EqualValues(1200, u.StoredBytes)
+
+	// Channel videos are not evicted from the kept tier.
+	v := &Video{Channel: "lbry://@Premium#1", Size: 1200}
+	s.False(keptInTier(v, TierLocal))
+	s.True(keptInTier(v, TierRemote))
+	s.True(keptInTier(v, TierArchive))
+	plan, _ := planEviction([]*Video{v}, TierPolicy{Tier: TierRemote, MaxSize: 100}, time.Now(), nil)
+	s.Empty(plan)
+
+	s.Require().NoError(lib.DeleteChannelSettings("@premium#1"))
+	s.Equal(ErrChannelSettingsMissing, lib.DeleteChannelSettings("@premium#1"))
+	s.NoError(ValidateByClaim(c))
+	s.False(keptInTier(v, TierRemote))
+}
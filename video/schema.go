@@ -1,7 +1,23 @@
 package video
 
+import "github.com/lbryio/transcoder/db"
+
 const dbFile = "videos.db"
 
+// Migrations contains all video library schema changes in the order they should be applied.
+var Migrations = []db.Migration{
+	{Name: "initial", SQL: InitialMigration},
+	{Name: "encryption_keys", SQL: EncryptionKeysMigration},
+	{Name: "archive_path", SQL: ArchivePathMigration},
+	{Name: "renditions", SQL: RenditionsMigration},
+	{Name: "sources", SQL: SourcesMigration},
+	{Name: "origin", SQL: OriginMigration},
+	{Name: "access_rules", SQL: AccessRulesMigration},
+	{Name: "channel_settings", SQL: ChannelSettingsMigration},
+	{Name: "channel_usage", SQL: ChannelUsageMigration},
+	{Name: "claim_id", SQL: ClaimIDMigration},
+}
+
 var InitialMigration = `
 -- +migrate Up
 
@@ -32,3 +48,170 @@ CREATE TABLE IF NOT EXISTS videos (
 DROP TABLE videos;
 -- +migrate StatementEnd
 `
+
+var EncryptionKeysMigration = `
+-- +migrate Up
+
+-- +migrate StatementBegin
+CREATE TABLE IF NOT EXISTS encryption_keys (
+    "sd_hash" TEXT PRIMARY KEY,
+    "key" BLOB NOT NULL,
+    "created_at" TIMESTAMP NOT NULL
+);
+-- +migrate StatementEnd
+
+-- +migrate Down
+
+-- +migrate StatementBegin
+DROP TABLE encryption_keys;
+-- +migrate StatementEnd
+`
+
+var ArchivePathMigration = `
+-- +migrate Up
+
+-- +migrate StatementBegin
+ALTER TABLE videos ADD COLUMN "archive_path" TEXT NOT NULL DEFAULT "";
+-- +migrate StatementEnd
+
+-- +migrate Down
+
+-- +migrate StatementBegin
+ALTER TABLE videos DROP COLUMN "archive_path";
+-- +migrate StatementEnd
+`
+
+var RenditionsMigration = `
+-- +migrate Up
+
+-- +migrate StatementBegin
+CREATE TABLE IF NOT EXISTS renditions (
+    "sd_hash" TEXT NOT NULL,
+    "name" TEXT NOT NULL,
+
+    "height" INTEGER NOT NULL DEFAULT 0,
+    "bandwidth" INTEGER NOT NULL DEFAULT 0,
+    "size" INTEGER NOT NULL DEFAULT 0,
+
+    "local" BOOLEAN NOT NULL DEFAULT 1,
+    "remote" BOOLEAN NOT NULL DEFAULT 0,
+
+    PRIMARY KEY (sd_hash, name)
+);
+-- +migrate StatementEnd
+
+-- +migrate Down
+
+-- +migrate StatementBegin
+DROP TABLE renditions;
+-- +migrate StatementEnd
+`
+
+var SourcesMigration = `
+-- +migrate Up
+
+-- +migrate StatementBegin
+CREATE TABLE IF NOT EXISTS sources (
+    "source_hash" TEXT PRIMARY KEY,
+    "sd_hash" TEXT NOT NULL,
+    "created_at" TIMESTAMP NOT NULL
+);
+-- +migrate StatementEnd
+
+-- +migrate Down
+
+-- +migrate StatementBegin
+DROP TABLE sources;
+-- +migrate StatementEnd
+`
+
+var OriginMigration = `
+-- +migrate Up
+
+-- +migrate StatementBegin
+ALTER TABLE videos ADD COLUMN "origin" TEXT NOT NULL DEFAULT "";
+-- +migrate StatementEnd
+
+-- +migrate Down
+
+-- +migrate StatementBegin
+ALTER TABLE videos DROP COLUMN "origin";
+-- +migrate StatementEnd
+`
+
+var AccessRulesMigration = `
+-- +migrate Up
+
+-- +migrate StatementBegin
+CREATE TABLE IF NOT EXISTS access_rules (
+    "kind" TEXT NOT NULL,
+    "subject" TEXT NOT NULL,
+    "policy" TEXT NOT NULL,
+    "reason" TEXT NOT NULL DEFAULT "",
+    "created_at" TIMESTAMP NOT NULL,
+    PRIMARY KEY (kind, subject)
+);
+-- +migrate StatementEnd
+
+-- +migrate Down
+
+-- +migrate StatementBegin
+DROP TABLE access_rules;
+-- +migrate StatementEnd
+`
+
+var ChannelSettingsMigration = `
+-- +migrate Up
+
+-- +migrate StatementBegin
+CREATE TABLE IF NOT EXISTS channel_settings (
+    "channel" TEXT PRIMARY KEY,
+    "profile" TEXT NOT NULL DEFAULT "",
+    "max_height" INTEGER NOT NULL DEFAULT 0,
+    "keep_tier" TEXT NOT NULL DEFAULT "",
+    "priority" INTEGER NOT NULL DEFAULT 0,
+    "monthly_minutes" INTEGER NOT NULL DEFAULT 0,
+    "max_stored_bytes" INTEGER NOT NULL DEFAULT 0,
+    "updated_at" TIMESTAMP NOT NULL
+);
+-- +migrate StatementEnd
+
+-- +migrate Down
+
+-- +migrate StatementBegin
+DROP TABLE channel_settings;
+-- +migrate StatementEnd
+`
+
+var ChannelUsageMigration = `
+-- +migrate Up
+
+-- +migrate StatementBegin
+CREATE TABLE IF NOT EXISTS channel_usage (
+    "channel" TEXT NOT NULL,
+    "month" TEXT NOT NULL,
+    "minutes" REAL NOT NULL DEFAULT 0,
+    PRIMARY KEY (channel, month)
+);
+-- +migrate StatementEnd
+
+-- +migrate Down
+
+-- +migrate StatementBegin
+DROP TABLE channel_usage;
+-- +migrate StatementEnd
+`
+
+var ClaimIDMigration = `
+-- +migrate Up
+
+-- +migrate StatementBegin
+ALTER TABLE videos ADD COLUMN "claim_id" TEXT NOT NULL DEFAULT "";
+-- +migrate StatementEnd
+
+-- +migrate Down
+
+-- +migrate StatementBegin
+ALTER TABLE videos DROP COLUMN "claim_id";
+-- +migrate StatementEnd
+`
@@ -0,0 +1,57 @@
+package video
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"io"
+	"os"
+
+	"github.com/lbryio/transcoder/pkg/claim"
+)
+
+// HashSource calculates content hash of a downloaded source file, used for finding identical sources across claims.
+func HashSource(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// LinkDuplicate checks if a source with `sourceHash` has already been transcoded and, if so,
+// adds video for claim `c` to the library as a link to the existing stream output.
+// Returns nil video when no suitable output exists.
+func LinkDuplicate(lib *Library, c *claim.Claim, url, sourceHash string) (*Video, error) {
+	holder, err := lib.FindBySource(sourceHash)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	// Streams that need protection should not be served from unencrypted output and vice versa.
+	if lib.EncryptionEnabled() {
+		_, err := lib.GetKey(holder.GetStorageKey())
+		if err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+		if NeedsEncryption(c) != (err == nil) {
+			return nil, nil
+		}
+	}
+
+	return lib.Link(AddParams{
+		URL:     url,
+		SDHash:  c.SDHash,
+		Type:    holder.Type,
+		Channel: claimChannelURL(c),
+		ClaimID: c.ClaimID,
+	}, holder)
+}
@@ -4,6 +4,16 @@ import (
 	"sort"
 	"sync"
 	"sync/atomic"
+	"time"
+
+	"github.com/lbryio/transcoder/internal/metrics"
+)
+
+const (
+	// uniqueViewsWindow is for how long clients are remembered per video, their views are counted again afterwards.
+	uniqueViewsWindow = time.Hour
+	// maxUniqueViewers is the number of clients remembered per video, a new window is started once it's reached.
+	maxUniqueViewers = 10000
 )
 
 // A Counter is a thread-safe counter implementation
@@ -30,6 +40,9 @@ type TallyItem struct {
 	URL    string
 	SDHash string
 	Count  uint64
+
+	viewers      map[string]bool
+	viewersSince time.Time
 }
 
 func NewSweeper() *sweeper {
@@ -50,6 +63,28 @@ func (s *sweeper) Inc(url, sdHash string) {
 	s.mu.Unlock()
 }
 
+// IncUnique counts a view of `client` (like an IP address), skipping views of clients that have already been counted
+// within uniqueViewsWindow.
+func (s *sweeper) IncUnique(url, sdHash, client string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i, ok := s.counters[url]
+	if !ok {
+		i = &TallyItem{URL: url, SDHash: sdHash}
+		s.counters[url] = i
+	}
+	if i.viewers == nil || len(i.viewers) >= maxUniqueViewers || time.Since(i.viewersSince) > uniqueViewsWindow {
+		i.viewers = map[string]bool{}
+		i.viewersSince = time.Now()
+	}
+	if i.viewers[client] {
+		metrics.SweeperDuplicateViews.Inc()
+		return
+	}
+	i.viewers[client] = true
+	i.Count++
+}
+
 func (s *sweeper) Top(n, lb int) []*TallyItem {
 	tally := []*TallyItem{}
 	s.mu.Lock()
@@ -69,7 +104,10 @@ func (s *sweeper) Top(n, lb int) []*TallyItem {
 }
 
 func (s *sweeper) Sweep(ti []*TallyItem) {
+	s.mu.Lock()
 	for _, i := range ti {
 		s.swept[i.SDHash] = true
+		i.viewers = nil
 	}
+	s.mu.Unlock()
 }
@@ -3,6 +3,7 @@ package video
 import (
 	"math/rand"
 	"sort"
+	"strconv"
 	"sync"
 	"testing"
 	"time"
@@ -73,3 +74,34 @@ func TestSweeperSweep(t *testing.T) {
 	s.Sweep(s.Top(3, 0))
 	assert.Len(t, s.Top(5, 0), 1)
 }
+
+func TestSweeperIncUnique(t *testing.T) {
+	s := NewSweeper()
+	for range [10]int{} {
+		s.IncUnique("abc", "asdasda", "1.1.1.1")
+		s.IncUnique("cde", "sdagkkj", "1.1.1.1")
+	}
+	s.IncUnique("cde", "sdagkkj", "2.2.2.2")
+
+	top := s.Top(2, 0)
+	require.Len(t, top, 2)
+	assert.EqualValues(t, 2, top[0].Count)
+	assert.EqualValues(t, 1, top[1].Count)
+	assert.Equal(t, "cde", top[0].URL)
+
+	s.Sweep(top[:1])
+	top = s.Top(2, 0)
+	require.Len(t, top, 1)
+	assert.Equal(t, "abc", top[0].URL)
+
+	s.counters["abc"].viewersSince = time.Now().Add(-uniqueViewsWindow - time.Second)
+	s.IncUnique("abc", "asdasda", "1.1.1.1")
+	s.IncUnique("abc", "asdasda", "1.1.1.1")
+	assert.EqualValues(t, 2, s.counters["abc"].Count)
+	assert.Len(t, s.counters["abc"].viewers, 1)
+
+	for i := 0; i < maxUniqueViewers; i++ {
+		s.IncUnique("cde", "sdagkkj", strconv.Itoa(i))
+	}
+	assert.LessOrEqual(t, len(s.counters["cde"].viewers), maxUniqueViewers)
+}
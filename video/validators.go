@@ -1,46 +1,147 @@
 package video
 
 import (
-	"strings"
+	"strconv"
+	"time"
 
 	"github.com/lbryio/transcoder/pkg/claim"
 )
 
-var enabledChannels = []string{}
+// unlistedTag marks claims that are not supposed to be discoverable.
+const unlistedTag = "c:unlisted"
 
+// Signed requests are accepted for this long after signing and this early before it to allow for clock skew.
+var (
+	signatureMaxAge  = time.Hour
+	signatureMaxSkew = 5 * time.Minute
+)
+
+// LoadEnabledChannels replaces access rules in effect with allow rules for `channels` without storing them.
+// Use Library.ImportEnabledChannels to keep rules in the database.
 func LoadEnabledChannels(channels []string) {
-	enabledChannels = channels
-	logger.Infow("loaded enabled channels", "count", len(enabledChannels))
+	rules := []*AccessRule{}
+	for _, cn := range channels {
+		rules = append(rules, &AccessRule{Kind: RuleChannel, Subject: normalizeSubject(RuleChannel, cn), Policy: PolicyAllow})
+	}
+	access.replace(rules)
+	logger.Infow("loaded enabled channels", "count", len(rules))
 }
 
-// ValidateIncomingVideo checks if supplied video can be accepted for processing.
-func ValidateIncomingVideo(uri string) (*claim.Claim, error) {
+// ValidateIncomingVideo checks if supplied video can be accepted for processing, resolving it with `r`.
+func ValidateIncomingVideo(r claim.Resolver, uri string) (*claim.Claim, error) {
 	// Validate here if video exists on LBRY (resolve)
 	// Validate here if video is in the whitelist (claim.signing_channel)
-	c, err := claim.Resolve(uri)
+	c, err := r.Resolve(uri)
 	if err != nil {
 		return nil, err
 	}
 	return c, ValidateByClaim(c)
 }
 
+// ValidateByClaim checks if claim is allowed to be transcoded by access rules and its channel is within quotas.
 func ValidateByClaim(c *claim.Claim) error {
-	channelEnabled := false
 	ll := logger.With("canonical_url", c.CanonicalURL)
+	if err := CheckBlocked(c); err != nil {
+		return err
+	}
+	if access.policy(RuleClaim, c.ClaimID) == PolicyAllow {
+		ll.Debug("claim transcoding enabled")
+		return CheckQuota(claimChannel(c), time.Now())
+	}
 	if c.SigningChannel == nil {
 		ll.Debug("missing signing channel")
 		return ErrNoSigningChannel
 	}
-	for _, cn := range enabledChannels {
-		if strings.ToLower(c.SigningChannel.CanonicalURL) == strings.ToLower("lbry://"+cn) {
-			channelEnabled = true
-			break
-		}
-	}
-	if !channelEnabled {
+	if access.policy(RuleChannel, c.SigningChannel.CanonicalURL) != PolicyAllow {
 		ll.Debugw("channel transcoding not enabled", "channel", c.SigningChannel.CanonicalURL)
 		return ErrChannelNotEnabled
 	}
 	ll.Debug("channel transcoding enabled")
+	return CheckQuota(claimChannel(c), time.Now())
+}
+
+// CheckBlocked returns an error if claim or its channel is blocked, unless the claim is explicitly allowed.
+func CheckBlocked(c *claim.Claim) error {
+	switch access.policy(RuleClaim, c.ClaimID) {
+	case PolicyAllow:
+		return nil
+	case PolicyBlock:
+		logger.Debugw("claim blocked", "canonical_url", c.CanonicalURL)
+		return ErrClaimBlocked
+	}
+	if c.SigningChannel != nil && access.policy(RuleChannel, c.SigningChannel.CanonicalURL) == PolicyBlock {
+		logger.Debugw("channel blocked", "canonical_url", c.CanonicalURL, "channel", c.SigningChannel.CanonicalURL)
+		return ErrChannelBlocked
+	}
 	return nil
 }
+
+// HasBlocks returns true if any claim or channel is blocked.
+func HasBlocks() bool {
+	return access.hasBlocks()
+}
+
+// CheckVideoBlocked does the same as CheckBlocked for a video already in the library,
+// using the claim ID and the channel recorded for it.
+func CheckVideoBlocked(v *Video) error {
+	if !access.hasBlocks() {
+		return nil
+	}
+	if v.ClaimID != "" {
+		switch access.policy(RuleClaim, v.ClaimID) {
+		case PolicyAllow:
+			return nil
+		case PolicyBlock:
+			logger.Debugw("claim blocked", "sd_hash", v.SDHash, "claim_id", v.ClaimID)
+			return ErrClaimBlocked
+		}
+	}
+	if v.Channel != "" && access.policy(RuleChannel, v.Channel) == PolicyBlock {
+		logger.Debugw("channel blocked", "sd_hash", v.SDHash, "channel", v.Channel)
+		return ErrChannelBlocked
+	}
+	return nil
+}
+
+// ValidateSignedRequest checks that transcoding of the claim was requested by the owner of its signing channel,
+// who signed claim ID with the channel key at `signingTS` (unix time). Valid requests are accepted
+// regardless of enabled channels, unless the claim or the channel is blocked.
+func ValidateSignedRequest(c *claim.Claim, signature, signingTS string, now time.Time) error {
+	ll := logger.With("canonical_url", c.CanonicalURL)
+	if err := CheckBlocked(c); err != nil {
+		return err
+	}
+	if c.SigningChannel == nil {
+		ll.Debug("missing signing channel")
+		return ErrNoSigningChannel
+	}
+	ts, err := strconv.ParseInt(signingTS, 10, 64)
+	if err != nil {
+		ll.Debugw("malformed signing timestamp", "signing_ts", signingTS)
+		return ErrInvalidSignature
+	}
+	signed := time.Unix(ts, 0)
+	if now.Sub(signed) > signatureMaxAge || signed.Sub(now) > signatureMaxSkew {
+		ll.Debugw("signature expired", "signing_ts", signingTS)
+		return ErrSignatureExpired
+	}
+	if err := c.VerifyChannelSignature([]byte(c.ClaimID), signature, signingTS); err != nil {
+		ll.Infow("channel signature rejected", "channel", c.SigningChannel.CanonicalURL, "err", err)
+		return ErrInvalidSignature
+	}
+	ll.Debugw("channel signature verified", "channel", c.SigningChannel.CanonicalURL)
+	return CheckQuota(claimChannel(c), now)
+}
+
+// NeedsEncryption checks if stream is paid or unlisted content and should be encrypted.
+func NeedsEncryption(c *claim.Claim) bool {
+	if fee := c.Value.GetStream().GetFee(); fee != nil && fee.GetAmount() > 0 {
+		return true
+	}
+	for _, t := range c.Value.GetTags() {
+		if t == unlistedTag {
+			return true
+		}
+	}
+	return false
+}
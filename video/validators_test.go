@@ -1,11 +1,50 @@
 package video
 
 import (
+	"crypto/sha256"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"fmt"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/btcsuite/btcd/btcec"
+	ljsonrpc "github.com/lbryio/lbry.go/v2/extras/jsonrpc"
+	"github.com/lbryio/transcoder/pkg/claim"
+	pb "github.com/lbryio/types/v2/go"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
+// channelKeyDER encodes public key the way LBRY channel claims store it.
+func channelKeyDER(t *testing.T, k *btcec.PrivateKey) []byte {
+	curve, err := asn1.Marshal(asn1.ObjectIdentifier{1, 3, 132, 0, 10})
+	require.NoError(t, err)
+	der, err := asn1.Marshal(struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}{
+		Algorithm: pkix.AlgorithmIdentifier{
+			Algorithm:  asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1},
+			Parameters: asn1.RawValue{FullBytes: curve},
+		},
+		PublicKey: asn1.BitString{Bytes: k.PubKey().SerializeUncompressed(), BitLength: 65 * 8},
+	})
+	require.NoError(t, err)
+	return der
+}
+
+func signClaimID(t *testing.T, k *btcec.PrivateKey, claimID string, ts time.Time) (string, string) {
+	signingTS := fmt.Sprintf("%v", ts.Unix())
+	digest := sha256.Sum256([]byte(signingTS + claimID))
+	sig, err := k.Sign(digest[:])
+	require.NoError(t, err)
+	raw := append(sig.R.FillBytes(make([]byte, 32)), sig.S.FillBytes(make([]byte, 32))...)
+	return hex.EncodeToString(raw), signingTS
+}
+
 func TestValidateIncomingVideo(t *testing.T) {
 	LoadEnabledChannels(
 		[]string{
@@ -23,16 +62,82 @@ func TestValidateIncomingVideo(t *testing.T) {
 	urlsNoChannel := []string{
 		"lbry://what#1",
 	}
+	r := claim.NewMemoryResolver()
+	addClaims := func(urls []string, channel func(string) string) {
+		for i, u := range urls {
+			c, err := claim.NewFakeClaim(u, fmt.Sprintf("%x", i), strings.Repeat("ab", 48), channel(u))
+			require.NoError(t, err)
+			r.Add(u, c)
+		}
+	}
+	channelOf := func(u string) string { return strings.SplitN(u, "/", 4)[2] }
+	addClaims(urlsEnabled, channelOf)
+	addClaims(urlsDisabled, channelOf)
+	addClaims(urlsNoChannel, func(string) string { return "" })
+
 	for _, u := range urlsEnabled {
-		_, err := ValidateIncomingVideo(u)
+		_, err := ValidateIncomingVideo(r, u)
 		assert.NoError(t, err)
 	}
 	for _, u := range urlsDisabled {
-		_, err := ValidateIncomingVideo(u)
+		_, err := ValidateIncomingVideo(r, u)
 		assert.Equal(t, ErrChannelNotEnabled, err)
 	}
 	for _, u := range urlsNoChannel {
-		_, err := ValidateIncomingVideo(u)
+		_, err := ValidateIncomingVideo(r, u)
 		assert.Equal(t, ErrNoSigningChannel, err)
 	}
+	_, err := ValidateIncomingVideo(r, "lbry://@davidpakman#7/missing#1")
+	assert.Equal(t, claim.ErrStreamNotFound, err)
+}
+
+func TestNeedsEncryption(t *testing.T) {
+	newClaim := func(fee *pb.Fee, tags []string) *claim.Claim {
+		return &claim.Claim{Claim: &ljsonrpc.Claim{Value: pb.Claim{
+			Type: &pb.Claim_Stream{Stream: &pb.Stream{Fee: fee}},
+			Tags: tags,
+		}}}
+	}
+	assert.False(t, NeedsEncryption(newClaim(nil, nil)))
+	assert.False(t, NeedsEncryption(newClaim(&pb.Fee{Amount: 0}, []string{"science"})))
+	assert.True(t, NeedsEncryption(newClaim(&pb.Fee{Amount: 1000}, nil)))
+	assert.True(t, NeedsEncryption(newClaim(nil, []string{"science", "c:unlisted"})))
+}
+
+func TestValidateSignedRequest(t *testing.T) {
+	LoadEnabledChannels([]string{})
+	key, err := btcec.NewPrivateKey(btcec.S256())
+	require.NoError(t, err)
+	otherKey, err := btcec.NewPrivateKey(btcec.S256())
+	require.NoError(t, err)
+
+	c := &claim.Claim{Claim: &ljsonrpc.Claim{
+		ClaimID:      "8a2f6b0d86a7f6bf2f2f2b3f5e4c1b0a9d8e7f61",
+		CanonicalURL: "lbry://@owner#1/video#8",
+		SigningChannel: &ljsonrpc.Claim{
+			CanonicalURL: "lbry://@owner#1",
+			Value:        pb.Claim{Type: &pb.Claim_Channel{Channel: &pb.Channel{PublicKey: channelKeyDER(t, key)}}},
+		},
+	}}
+	now := time.Now()
+
+	assert.Equal(t, ErrChannelNotEnabled, ValidateByClaim(c))
+
+	sig, ts := signClaimID(t, key, c.ClaimID, now.Add(-time.Minute))
+	assert.NoError(t, ValidateSignedRequest(c, sig, ts, now))
+
+	sig, ts = signClaimID(t, otherKey, c.ClaimID, now)
+	assert.Equal(t, ErrInvalidSignature, ValidateSignedRequest(c, sig, ts, now))
+
+	sig, ts = signClaimID(t, key, "0000000000000000000000000000000000000000", now)
+	assert.Equal(t, ErrInvalidSignature, ValidateSignedRequest(c, sig, ts, now))
+
+	sig, ts = signClaimID(t, key, c.ClaimID, now.Add(-2*time.Hour))
+	assert.Equal(t, ErrSignatureExpired, ValidateSignedRequest(c, sig, ts, now))
+
+	sig, _ = signClaimID(t, key, c.ClaimID, now)
+	assert.Equal(t, ErrInvalidSignature, ValidateSignedRequest(c, sig, fmt.Sprintf("%v", now.Unix()-1), now))
+	assert.Equal(t, ErrInvalidSignature, ValidateSignedRequest(c, sig, "yesterday", now))
+
+	assert.Equal(t, ErrNoSigningChannel, ValidateSignedRequest(&claim.Claim{Claim: &ljsonrpc.Claim{}}, sig, ts, now))
 }
@@ -2,19 +2,40 @@ package video
 
 import (
 	"context"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
 	"time"
 
 	"github.com/lbryio/transcoder/db"
+	"github.com/lbryio/transcoder/internal/metrics"
+	"github.com/lbryio/transcoder/pkg/events"
 	"github.com/lbryio/transcoder/storage"
+	"github.com/lbryio/transcoder/webhooks"
+
+	cmap "github.com/orcaman/concurrent-map"
 )
 
 type Config struct {
-	db     *db.DB
-	local  storage.LocalDriver
-	remote storage.RemoteDriver
+	db      *db.DB
+	local   storage.LocalDriver
+	remote  storage.RemoteDriver
+	archive storage.RemoteDriver
 
 	maxLocalSize  uint64
 	maxRemoteSize uint64
+
+	policies         []TierPolicy
+	simulatePolicies bool
+
+	keyServerURL string
+
+	events *events.Bus
+	hooks  *webhooks.Manager
 }
 
 func Configure() *Config {
@@ -39,6 +60,12 @@ func (c *Config) RemoteStorage(s storage.RemoteDriver) *Config {
 	return c
 }
 
+// ArchiveStorage sets a cold storage tier where videos evicted from remote storage are moved to.
+func (c *Config) ArchiveStorage(s storage.RemoteDriver) *Config {
+	c.archive = s
+	return c
+}
+
 // MaxLocalSize ...
 func (c *Config) MaxLocalSize(s string) *Config {
 	c.maxLocalSize = StringToSize(s)
@@ -51,33 +78,142 @@ func (c *Config) MaxRemoteSize(s string) *Config {
 	return c
 }
 
+// TierPolicy adds a storage tier eviction policy. If none are added, policies are derived
+// from MaxLocalSize and MaxRemoteSize.
+func (c *Config) TierPolicy(p TierPolicy) *Config {
+	c.policies = append(c.policies, p)
+	return c
+}
+
+// SimulatePolicies makes library maintenance only report which videos would be evicted without touching them.
+func (c *Config) SimulatePolicies(simulate bool) *Config {
+	c.simulatePolicies = simulate
+	return c
+}
+
+// KeyServerURL enables encryption of streams that require protection, setting the base URL of the key-delivery endpoint.
+func (c *Config) KeyServerURL(u string) *Config {
+	c.keyServerURL = strings.TrimSuffix(u, "/")
+	return c
+}
+
+// EventBus sets a bus where video processing events are published.
+func (c *Config) EventBus(b *events.Bus) *Config {
+	c.events = b
+	return c
+}
+
+// Webhooks sets a manager that is notified about completed and failed tasks.
+func (c *Config) Webhooks(m *webhooks.Manager) *Config {
+	c.hooks = m
+	return c
+}
+
 // Library contains methods for accessing videos database.
 type Library struct {
 	*Config
 	queries Queries
 	sweeper *sweeper
+	// uploader is set when videos are being uploaded to remote storage by SpawnS3Uploader.
+	uploader *S3Uploader
+	// storing holds tasks of encoded videos that are being uploaded to remote storage, keyed by sd hash.
+	storing cmap.ConcurrentMap
 }
 
 func NewLibrary(cfg *Config) *Library {
+	if cfg.events == nil {
+		cfg.events = events.NewBus()
+	}
 	l := &Library{
 		Config:  cfg,
 		queries: Queries{cfg.db},
 		sweeper: NewSweeper(),
+		storing: cmap.New(),
 	}
 	return l
 }
 
+// Events returns the bus where video processing events are published.
+func (q Library) Events() *events.Bus {
+	return q.events
+}
+
 func (q Library) IncViews(uri, sdHash string) {
 	q.sweeper.Inc(uri, sdHash)
 }
 
+// IncClientViews does the same as IncViews but counts each `client` once per video,
+// so a single client cannot push a video to the top of popular ones.
+func (q Library) IncClientViews(uri, sdHash, client string) {
+	if client == "" {
+		q.IncViews(uri, sdHash)
+		return
+	}
+	q.sweeper.IncUnique(uri, sdHash, client)
+}
+
 // Add records data about video into database.
 func (q Library) Add(params AddParams) (*Video, error) {
 	return q.queries.Add(context.Background(), params)
 }
 
+// Get returns video by its sd_hash. For videos sharing stream output with others,
+// the video currently holding that output is returned.
 func (q Library) Get(sdHash string) (*Video, error) {
-	return q.queries.Get(context.Background(), sdHash)
+	v, err := q.queries.Get(context.Background(), sdHash)
+	if err != nil {
+		return nil, err
+	}
+	if v.IsLink() {
+		return q.queries.GetHolder(context.Background(), v.Origin, true)
+	}
+	return v, nil
+}
+
+// Peek does the same as Get but without counting video as accessed.
+func (q Library) Peek(sdHash string) (*Video, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	v, err := q.queries.Peek(ctx, sdHash)
+	if err != nil {
+		return nil, err
+	}
+	if v.IsLink() {
+		return q.queries.GetHolder(ctx, v.Origin, false)
+	}
+	return v, nil
+}
+
+// Lookup returns the library record of a video as is, without resolving links or recording access.
+func (q Library) Lookup(sdHash string) (*Video, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	return q.queries.Peek(ctx, sdHash)
+}
+
+// FindBySource returns video holding stream output transcoded from a source with `sourceHash`.
+func (q Library) FindBySource(sourceHash string) (*Video, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	storageKey, err := q.queries.GetSource(ctx, sourceHash)
+	if err != nil {
+		return nil, err
+	}
+	return q.queries.GetHolder(ctx, storageKey, true)
+}
+
+// AddSource records hash of the source `sdHash` video was transcoded from.
+func (q Library) AddSource(sourceHash, sdHash string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	return q.queries.AddSource(ctx, sourceHash, sdHash)
+}
+
+// Link records a video which is served from stream output of `holder` instead of being transcoded.
+func (q Library) Link(params AddParams, holder *Video) (*Video, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	return q.queries.AddLink(ctx, params, holder.GetStorageKey())
 }
 
 func (q Library) Furlough(v *Video) error {
@@ -85,7 +221,7 @@ func (q Library) Furlough(v *Video) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	err := q.local.Delete(v.SDHash)
+	err := q.local.Delete(v.GetStorageKey())
 	if err != nil {
 		ll.Warnw("failed to delete local video", "err", err)
 		return err
@@ -96,21 +232,109 @@ func (q Library) Furlough(v *Video) error {
 		ll.Warnw("failed to mark video as deleted locally", "err", err)
 		return err
 	}
+	err = q.queries.MarkRenditionsLocal(ctx, v.GetStorageKey(), false)
+	if err != nil {
+		ll.Warnw("failed to mark renditions as deleted locally", "err", err)
+	}
 
 	ll.Infow("video furloughed", "url", v.URL, "size", v.GetSize(), "age", v.CreatedAt, "last_accessed", v.LastAccessed)
 	return nil
 }
 
-func (q Library) Retire(v *Video) error {
+// Archive moves video from remote storage to archive storage.
+func (q Library) Archive(v *Video) error {
 	ll := logger.With("sd_hash", v.SDHash)
+	if q.archive == nil {
+		return errors.New("archive storage is not configured")
+	}
+
+	tmpStorage := storage.Local(path.Join(os.TempDir(), "transcoder", "archiving"))
+	ls, err := tmpStorage.Pull(q.remote, v.GetStorageKey())
+	if err != nil {
+		ll.Warnw("failed to pull remote video", "err", err)
+		return err
+	}
+	defer tmpStorage.Delete(v.GetStorageKey())
+
+	rs, err := q.archive.Put(ls)
+	if err != nil {
+		ll.Warnw("failed to upload video to archive", "err", err)
+		return err
+	}
+
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
+	err = q.queries.UpdateArchivePath(ctx, v.SDHash, rs.URL())
+	if err != nil {
+		ll.Warnw("failed to mark video as archived", "err", err)
+		return err
+	}
 
-	err := q.remote.Delete(v.SDHash)
+	err = q.remote.Delete(v.GetStorageKey())
 	if err != nil {
 		ll.Warnw("failed to delete remote video", "err", err)
 		return err
 	}
+	err = q.queries.UpdateRemotePath(ctx, v.SDHash, "")
+	if err != nil {
+		ll.Warnw("failed to mark video as deleted remotely", "err", err)
+		return err
+	}
+
+	ll.Infow("video archived", "url", v.URL, "size", v.GetSize(), "age", v.CreatedAt, "last_accessed", v.LastAccessed)
+	return nil
+}
+
+// Retire removes video from the library along with its stream output.
+// Output shared with other videos is kept for as long as any of them references it.
+func (q Library) Retire(v *Video) error {
+	ll := logger.With("sd_hash", v.SDHash)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if v.IsLink() {
+		err := q.queries.Delete(ctx, v.SDHash)
+		if err != nil {
+			ll.Warnw("failed to delete video record", "err", err)
+			return err
+		}
+		ll.Infow("linked video retired", "url", v.URL, "origin", v.Origin)
+		return nil
+	}
+
+	links, err := q.queries.ListLinks(ctx, v.GetStorageKey())
+	if err != nil {
+		ll.Warnw("failed to list linked videos", "err", err)
+		return err
+	}
+	if len(links) > 0 {
+		err := q.queries.Promote(ctx, v, links[0].SDHash)
+		if err != nil {
+			ll.Warnw("failed to hand stream over to linked video", "err", err)
+			return err
+		}
+		ll.Infow("video retired, stream kept for linked videos", "url", v.URL, "holder", links[0].SDHash, "references", len(links))
+		return nil
+	}
+
+	// The record is kept so archived stream is not lost track of.
+	if v.ArchivePath != "" && q.archive == nil {
+		return errors.New("archive storage is not configured")
+	}
+	if q.remote != nil && (v.RemotePath != "" || v.ArchivePath == "") {
+		err := q.remote.Delete(v.GetStorageKey())
+		if err != nil {
+			ll.Warnw("failed to delete remote video", "err", err)
+			return err
+		}
+	}
+	if v.ArchivePath != "" {
+		err := q.archive.Delete(v.GetStorageKey())
+		if err != nil {
+			ll.Warnw("failed to delete archived video", "err", err)
+			return err
+		}
+	}
 
 	err = q.queries.Delete(ctx, v.SDHash)
 	if err != nil {
@@ -118,10 +342,94 @@ func (q Library) Retire(v *Video) error {
 		return err
 	}
 
+	err = q.queries.DeleteSources(ctx, v.GetStorageKey())
+	if err != nil {
+		ll.Warnw("failed to delete source hashes", "err", err)
+	}
+	err = q.queries.DeleteKey(ctx, v.GetStorageKey())
+	if err != nil {
+		ll.Warnw("failed to delete encryption key", "err", err)
+	}
+	err = q.queries.DeleteRenditions(ctx, v.GetStorageKey())
+	if err != nil {
+		ll.Warnw("failed to delete renditions", "err", err)
+	}
+
 	ll.Infow("video retired", "url", v.URL, "size", v.GetSize(), "age", v.CreatedAt, "last_accessed", v.LastAccessed)
 	return nil
 }
 
+// Delete removes video from all storage tiers and from the library.
+func (q Library) Delete(v *Video) error {
+	if v.Path != "" && !v.IsLink() {
+		ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+		defer cancel()
+		links, err := q.queries.ListLinks(ctx, v.GetStorageKey())
+		if err != nil {
+			return err
+		}
+		// Linked videos take over the output in Retire, so the local copy has to stay for them.
+		if len(links) == 0 {
+			if err := q.local.Delete(v.GetStorageKey()); err != nil {
+				logger.Warnw("failed to delete local video", "sd_hash", v.SDHash, "err", err)
+				return err
+			}
+		}
+	}
+	return q.Retire(v)
+}
+
+// Upload puts local copy of the video into remote storage.
+func (q Library) Upload(v *Video) error {
+	if q.remote == nil {
+		return errors.New("remote storage is not configured")
+	}
+	lv, err := q.local.Open(v.GetStorageKey())
+	if err != nil {
+		return err
+	}
+
+	q.events.Publish(events.Event{Stage: events.StageUploading, SDHash: v.SDHash, URL: v.URL, Channel: v.Channel})
+	rs, err := q.remote.Put(lv)
+	if err != nil {
+		q.events.Publish(events.Event{Stage: events.StageFailed, SDHash: v.SDHash, URL: v.URL, Channel: v.Channel, Error: err.Error()})
+		return err
+	}
+	v.RemotePath = rs.URL()
+
+	err = q.UpdateRemotePath(v.SDHash, v.RemotePath)
+	if err != nil {
+		logger.Errorw("error updating video", "sd_hash", v.SDHash, "remote_path", rs.URL(), "err", err)
+		return err
+	}
+	metrics.S3UploadedSizeMB.Add(float64(v.GetSize()))
+	q.events.Publish(events.Event{Stage: events.StageUploaded, SDHash: v.SDHash, URL: v.URL, Channel: v.Channel})
+	return nil
+}
+
+// QueueUpload schedules upload of local copy of the video to remote storage, unless it's being uploaded already.
+func (q Library) QueueUpload(v *Video) error {
+	if q.uploader == nil {
+		return errors.New("remote storage is not configured")
+	}
+	q.uploader.enqueue(v)
+	return nil
+}
+
+// List returns a page of videos matching `params` along with the total number of matching videos.
+func (q Library) List(params ListParams) ([]*Video, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	return q.queries.ListPage(ctx, params)
+}
+
+// Totals returns the number and size of videos per storage tier and per channel.
+func (q Library) Totals() (*Totals, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	return q.queries.Totals(ctx)
+}
+
 func (q Library) ListLocalOnly() ([]*Video, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
 	defer cancel()
@@ -140,8 +448,218 @@ func (q Library) ListRemoteOnly() ([]*Video, error) {
 	return q.queries.ListRemoteOnly(ctx)
 }
 
+// SigningRemote returns remote storage driver if it is configured to keep streams private, nil otherwise.
+func (q Library) SigningRemote() storage.SigningDriver {
+	if sd, ok := q.remote.(storage.SigningDriver); ok && sd.IsPrivate() {
+		return sd
+	}
+	return nil
+}
+
+func (q Library) ListArchived() ([]*Video, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	return q.queries.ListArchived(ctx)
+}
+
+// SetClaimID records ID of the claim video `sdHash` was transcoded for, so blocks of the claim apply to its stream files.
+func (q Library) SetClaimID(sdHash, claimID string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	return q.queries.UpdateClaimID(ctx, sdHash, claimID)
+}
+
 func (q Library) UpdateRemotePath(sdHash, url string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
 	defer cancel()
-	return q.queries.UpdateRemotePath(ctx, sdHash, url)
+	err := q.queries.UpdateRemotePath(ctx, sdHash, url)
+	if err != nil || url == "" {
+		return err
+	}
+	storageKey, err := q.queries.GetStorageKey(ctx, sdHash)
+	if err != nil {
+		return err
+	}
+	return q.queries.MarkRenditionsRemote(ctx, storageKey, true)
+}
+
+// AddRenditions records variant streams of a freshly encoded video.
+func (q Library) AddRenditions(sdHash string, rs []storage.Rendition) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	return q.queries.AddRenditions(ctx, sdHash, rs)
+}
+
+// Renditions lists variant streams of a video, tallest first.
+func (q Library) Renditions(sdHash string) ([]*Rendition, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	return q.queries.ListRenditions(ctx, sdHash)
+}
+
+// videoRenditions lists variant streams of a video, tallest first. Videos stored before renditions were recorded
+// get them backfilled from the master playlist kept in remote storage.
+func (q Library) videoRenditions(v *Video) ([]*Rendition, error) {
+	rs, err := q.Renditions(v.GetStorageKey())
+	if err != nil || len(rs) > 0 || v.RemotePath == "" || q.remote == nil {
+		return rs, err
+	}
+	if err := q.backfillRenditions(v); err != nil {
+		return nil, err
+	}
+	return q.Renditions(v.GetStorageKey())
+}
+
+func (q Library) backfillRenditions(v *Video) error {
+	data, err := q.readRemoteFile(v.GetStorageKey(), storage.MasterPlaylistName)
+	if err != nil {
+		return err
+	}
+	rs, err := storage.MasterRenditions(data)
+	if err != nil {
+		return err
+	}
+	// Remote file sizes are not known, so video size is split between renditions by their bandwidth.
+	var totalBandwidth int64
+	for _, r := range rs {
+		totalBandwidth += int64(r.Bandwidth)
+	}
+	for i := range rs {
+		if totalBandwidth > 0 {
+			rs[i].Size = v.Size * int64(rs[i].Bandwidth) / totalBandwidth
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := q.queries.AddRenditions(ctx, v.GetStorageKey(), rs); err != nil {
+		return err
+	}
+	if err := q.queries.MarkRenditionsRemote(ctx, v.GetStorageKey(), true); err != nil {
+		return err
+	}
+	if err := q.queries.MarkRenditionsLocal(ctx, v.GetStorageKey(), v.Path != ""); err != nil {
+		return err
+	}
+	logger.Infow("video renditions backfilled", "sd_hash", v.SDHash, "renditions", len(rs))
+	return nil
+}
+
+// TrimRenditions removes renditions taller than `maxHeight` from remote storage and regenerates
+// master playlist to only list the remaining ones. At least one rendition is always kept.
+func (q Library) TrimRenditions(v *Video, maxHeight int) error {
+	ll := logger.With("sd_hash", v.SDHash)
+	rs, err := q.videoRenditions(v)
+	if err != nil {
+		return err
+	}
+	trimmed := trimmableRenditions(rs, maxHeight)
+	if len(trimmed) == 0 {
+		return nil
+	}
+
+	drop := map[string]bool{}
+	files := []string{}
+	var freedSize int64
+	for _, r := range trimmed {
+		drop[r.Name] = true
+		files = append(files, r.Name)
+		freedSize += r.Size
+		data, err := q.readRemoteFile(v.GetStorageKey(), r.Name)
+		if err != nil {
+			return err
+		}
+		segments, err := storage.PlaylistURIs(data)
+		if err != nil {
+			return err
+		}
+		files = append(files, segments...)
+	}
+
+	data, err := q.readRemoteFile(v.GetStorageKey(), storage.MasterPlaylistName)
+	if err != nil {
+		return err
+	}
+	master, err := storage.FilterMasterPlaylist(data, drop)
+	if err != nil {
+		return err
+	}
+	// Master playlist goes first so players are not directed to renditions that are about to disappear.
+	if err := q.remote.PutFragment(v.GetStorageKey(), storage.MasterPlaylistName, master); err != nil {
+		ll.Warnw("failed to upload trimmed master playlist", "err", err)
+		return err
+	}
+	if err := q.remote.DeleteFragments(v.GetStorageKey(), files...); err != nil {
+		ll.Warnw("failed to delete remote renditions", "err", err)
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, r := range trimmed {
+		if err := q.queries.MarkRenditionRemote(ctx, v.GetStorageKey(), r.Name, false); err != nil {
+			return err
+		}
+	}
+	if err := q.queries.UpdateSize(ctx, v.SDHash, v.Size-freedSize); err != nil {
+		return err
+	}
+
+	ll.Infow("video renditions trimmed", "url", v.URL, "renditions", len(trimmed), "freed_size", freedSize, "max_height", maxHeight)
+	return nil
+}
+
+// RemoteFragment opens stream file kept in remote storage.
+func (q Library) RemoteFragment(sdHash, name string) (storage.StreamFragment, error) {
+	if q.remote == nil {
+		return nil, errors.New("remote storage is not configured")
+	}
+	return q.remote.GetFragment(sdHash, name)
+}
+
+func (q Library) readRemoteFile(sdHash, name string) ([]byte, error) {
+	f, err := q.RemoteFragment(sdHash, name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return ioutil.ReadAll(f)
+}
+
+// EncryptionEnabled returns true if the library is configured to encrypt streams.
+func (q Library) EncryptionEnabled() bool {
+	return q.keyServerURL != ""
+}
+
+// KeyURI returns the URI under which the encryption key for the stream will be served.
+func (q Library) KeyURI(sdHash string) string {
+	return fmt.Sprintf("%v/%v", q.keyServerURL, sdHash)
+}
+
+// GenerateKey creates a new AES-128 key for the stream and saves it into the database.
+func (q Library) GenerateKey(sdHash string) ([]byte, error) {
+	key := make([]byte, 16)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := q.queries.AddKey(ctx, sdHash, key); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// DeleteKey removes stream encryption key, used when the stream it was generated for is not stored after all.
+func (q Library) DeleteKey(sdHash string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	return q.queries.DeleteKey(ctx, sdHash)
+}
+
+// GetKey returns stream encryption key, `sql.ErrNoRows` is returned for streams that are not encrypted.
+func (q Library) GetKey(sdHash string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	return q.queries.GetKey(ctx, sdHash)
 }
@@ -0,0 +1,109 @@
+package video
+
+import (
+	"time"
+
+	"github.com/lbryio/transcoder/pkg/events"
+	"github.com/lbryio/transcoder/queue"
+	"github.com/lbryio/transcoder/webhooks"
+)
+
+// storingTask is a task whose video has been encoded and is waiting to be uploaded to remote storage.
+type storingTask struct {
+	task  *queue.Task
+	event events.Event
+}
+
+// finishTask publishes the final outcome of task `t` and schedules webhook notifications about it.
+// Notifications are scheduled here and not by a bus subscriber, so they are never dropped.
+func finishTask(lib *Library, p *queue.Poller, t *queue.Task, e events.Event) {
+	lib.finishTask(currentTask(p, t), e)
+}
+
+// completeTask reports task `t` as done once its video is fully stored,
+// which is after it's uploaded if remote storage is configured.
+func completeTask(lib *Library, p *queue.Poller, t *queue.Task, e events.Event) {
+	t = currentTask(p, t)
+	if lib.remote == nil {
+		lib.finishTask(t, e)
+		return
+	}
+	lib.storing.Set(e.SDHash, &storingTask{task: t, event: e})
+}
+
+// currentTask returns task `t` as it's stored in the queue, so that its start time is known.
+func currentTask(p *queue.Poller, t *queue.Task) *queue.Task {
+	ct, err := p.GetTask(t)
+	if err != nil || ct == nil {
+		logger.Warnw("refreshing task failed", "task_id", t.ID, "err", err)
+		return t
+	}
+	return ct
+}
+
+// uploaded finishes the task that waits for video `sdHash` to be uploaded, if there is one.
+func (q Library) uploaded(sdHash string) {
+	if st, ok := q.storing.Pop(sdHash); ok {
+		st := st.(*storingTask)
+		q.finishTask(st.task, st.event)
+	}
+}
+
+func (q Library) finishTask(t *queue.Task, e events.Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	q.events.Publish(e)
+	// Events without a task (like uploads) are not final outcomes of processing.
+	if q.hooks == nil || e.TaskID == 0 {
+		return
+	}
+	p, err := webhookPayload(&q, t, e)
+	if err != nil {
+		logger.Errorw("building webhook payload failed", "sd_hash", e.SDHash, "err", err)
+		return
+	}
+	if err := q.hooks.Notify(p); err != nil {
+		logger.Errorw("scheduling webhook notification failed", "sd_hash", e.SDHash, "err", err)
+	}
+}
+
+func webhookPayload(lib *Library, t *queue.Task, e events.Event) (*webhooks.Payload, error) {
+	p := &webhooks.Payload{
+		Event:   webhooks.EventFailed,
+		SDHash:  e.SDHash,
+		URL:     e.URL,
+		Channel: e.Channel,
+		Error:   e.Error,
+		Timings: webhooks.Timings{FinishedAt: e.Time},
+	}
+
+	if queuedAt, err := time.Parse(sqliteTimeLayout, t.CreatedAt); err == nil {
+		p.Timings.QueuedAt = &queuedAt
+	}
+	if startedAt, err := time.Parse(sqliteTimeLayout, t.StartedAt.String); t.StartedAt.Valid && err == nil {
+		p.Timings.StartedAt = &startedAt
+		p.Timings.ProcessingSeconds = e.Time.Sub(startedAt).Seconds()
+	}
+
+	if e.Stage != events.StageDone {
+		return p, nil
+	}
+	p.Event = webhooks.EventCompleted
+	v, err := lib.Peek(e.SDHash)
+	if err != nil {
+		return nil, err
+	}
+	if p.Channel == "" {
+		p.Channel = v.Channel
+	}
+	p.Location, p.Remote = v.GetLocation()
+	rs, err := lib.Renditions(v.GetStorageKey())
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range rs {
+		p.Renditions = append(p.Renditions, webhooks.Rendition{Name: r.Name, Height: r.Height, Bandwidth: r.Bandwidth})
+	}
+	return p, nil
+}
@@ -0,0 +1,81 @@
+package video
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/lbryio/transcoder/db"
+	"github.com/lbryio/transcoder/formats"
+	"github.com/lbryio/transcoder/pkg/events"
+	"github.com/lbryio/transcoder/queue"
+	"github.com/lbryio/transcoder/storage"
+	"github.com/lbryio/transcoder/webhooks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWebhookPayload(t *testing.T) {
+	vdb := db.OpenTestDB()
+	require.NoError(t, vdb.Migrate(Migrations...))
+	qdb := db.OpenTestDB()
+	require.NoError(t, qdb.Migrate(queue.Migrations...))
+	lib := NewLibrary(Configure().LocalStorage(storage.Local("/tmp/test")).DB(vdb))
+	q := queue.NewQueue(qdb)
+
+	sdHash := randomString(96)
+	task, err := q.Add("lbry://video", sdHash, formats.TypeHLS)
+	require.NoError(t, err)
+	_, err = q.Poll()
+	require.NoError(t, err)
+	require.NoError(t, q.Start(task.ID))
+	_, err = lib.Add(AddParams{SDHash: sdHash, URL: "lbry://video", Path: sdHash, Channel: "lbry://@channel#1", Type: formats.TypeHLS})
+	require.NoError(t, err)
+	require.NoError(t, lib.AddRenditions(sdHash, []storage.Rendition{{Name: "stream_0.m3u8", Height: 720, Bandwidth: 2000000}}))
+
+	finished := time.Now().UTC().Add(time.Minute)
+	task, err = q.Get(task.ID)
+	require.NoError(t, err)
+	p, err := webhookPayload(lib, task, events.Event{Stage: events.StageDone, SDHash: sdHash, URL: "lbry://video", TaskID: task.ID, Time: finished})
+	require.NoError(t, err)
+	assert.Equal(t, webhooks.EventCompleted, p.Event)
+	assert.Equal(t, "lbry://@channel#1", p.Channel)
+	assert.Equal(t, sdHash+"/"+storage.MasterPlaylistName, p.Location)
+	assert.False(t, p.Remote)
+	assert.Equal(t, []webhooks.Rendition{{Name: "stream_0.m3u8", Height: 720, Bandwidth: 2000000}}, p.Renditions)
+	require.NotNil(t, p.Timings.QueuedAt)
+	require.NotNil(t, p.Timings.StartedAt)
+	assert.InDelta(t, 60, p.Timings.ProcessingSeconds, 5)
+
+	p, err = webhookPayload(lib, task, events.Event{Stage: events.StageFailed, SDHash: sdHash, TaskID: task.ID, Error: errors.New("encoding failure").Error()})
+	require.NoError(t, err)
+	assert.Equal(t, webhooks.EventFailed, p.Event)
+	assert.Equal(t, "encoding failure", p.Error)
+	assert.Empty(t, p.Location)
+}
+
+func TestFinishTaskNotifies(t *testing.T) {
+	vdb := db.OpenTestDB()
+	require.NoError(t, vdb.Migrate(Migrations...))
+	wdb := db.OpenTestDB()
+	require.NoError(t, wdb.Migrate(webhooks.Migrations...))
+	hooks := webhooks.NewManager(webhooks.Configure().DB(wdb))
+	_, err := hooks.AddHook("http://hooks/all", "")
+	require.NoError(t, err)
+	lib := NewLibrary(Configure().LocalStorage(storage.Local("/tmp/test")).DB(vdb).Webhooks(hooks))
+
+	sdHash := randomString(96)
+	task := &queue.Task{ID: 1, SDHash: sdHash, URL: "lbry://video"}
+	sub := lib.events.Subscribe(sdHash)
+	defer sub.Close()
+
+	lib.finishTask(task, taskFailed(task, nil, errors.New("encoding failure")))
+	e := <-sub.C
+	assert.Equal(t, events.StageFailed, e.Stage)
+
+	ds, err := hooks.Deliveries(sdHash, 10)
+	require.NoError(t, err)
+	require.Len(t, ds, 1)
+	assert.Equal(t, "http://hooks/all", ds[0].URL)
+	assert.Equal(t, webhooks.EventFailed, ds[0].Event)
+}
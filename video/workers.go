@@ -6,6 +6,9 @@ import (
 	"fmt"
 	"os"
 	"path"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/lbryio/transcoder/encoder"
@@ -13,133 +16,429 @@ import (
 	"github.com/lbryio/transcoder/internal/metrics"
 	"github.com/lbryio/transcoder/pkg/claim"
 	"github.com/lbryio/transcoder/pkg/dispatcher"
+	"github.com/lbryio/transcoder/pkg/events"
 	"github.com/lbryio/transcoder/pkg/timer"
 	"github.com/lbryio/transcoder/queue"
 
 	cmap "github.com/orcaman/concurrent-map"
 )
 
-func SpawnProcessing(q *queue.Queue, lib *Library, p *queue.Poller) {
+// ProcessingOpts sets additional options for SpawnProcessing routine.
+type ProcessingOpts struct {
+	// Resolver looks up claims of queued URLs, `claim.DefaultResolver` is used if it's not set.
+	Resolver claim.Resolver
+	// Fetcher retrieves original streams for encoding, `claim.DefaultFetcher` is used if it's not set.
+	Fetcher claim.SourceFetcher
+	// DirectFetcher retrieves sources of tasks for local files, HTTP(S) URLs and uploads.
+	// Only HTTP(S) URLs are retrieved if it's not set.
+	DirectFetcher claim.SourceFetcher
+}
+
+func (opts *ProcessingOpts) setDefaults() {
+	if opts.Resolver == nil {
+		opts.Resolver = claim.DefaultResolver
+	}
+	if opts.Fetcher == nil {
+		opts.Fetcher = claim.DefaultFetcher
+	}
+	if opts.DirectFetcher == nil {
+		opts.DirectFetcher = claim.DirectFetcher{}
+	}
+}
+
+// PipelineOpts sets sizes of SpawnPipeline stages.
+type PipelineOpts struct {
+	ProcessingOpts
+	// Downloaders is the number of sources retrieved concurrently.
+	Downloaders int
+	// Encoders is the number of videos encoded concurrently.
+	Encoders int
+	// Buffer is the number of downloaded sources that can wait for an encoder,
+	// downloaders stop picking up tasks when it's full.
+	Buffer int
+}
+
+// source is a downloaded stream ready to be encoded.
+type source struct {
+	task       *queue.Task
+	claim      *claim.Claim
+	file       string
+	sourceHash string
+	channel    string
+	settings   ChannelSettings
+	// fetcher is what retrieved the source, it's released once the video is stored.
+	fetcher claim.SourceFetcher
+}
+
+// SpawnProcessing downloads and encodes incoming tasks one by one.
+func SpawnProcessing(q *queue.Queue, lib *Library, p *queue.Poller, opts ProcessingOpts) {
 	logger.Info("started video processor")
 	defer logger.Info("quit video processor")
 
+	opts.setDefaults()
 	for t := range p.IncomingTasks() {
-		ll := logger.Named("worker").With("url", t.URL, "task_id", t.ID)
+		if src := fetchSource(lib, p, t, opts); src != nil {
+			encodeSource(lib, p, src)
+		}
+	}
+}
 
-		c, err := claim.Resolve(t.URL)
-		if err != nil {
-			ll.Errorw("resolve failed", "err", err)
-			p.RejectTask(t)
-			continue
+// SpawnPipeline processes incoming tasks in two stages, so that encoders don't sit idle waiting on the network:
+// `opts.Downloaders` routines retrieve sources and pass them over to `opts.Encoders` routines through a bounded buffer.
+// It returns once all incoming tasks are processed.
+func SpawnPipeline(q *queue.Queue, lib *Library, p *queue.Poller, opts PipelineOpts) {
+	opts.setDefaults()
+	if opts.Downloaders < 1 {
+		opts.Downloaders = 1
+	}
+	if opts.Encoders < 1 {
+		opts.Encoders = 1
+	}
+	if opts.Buffer < 0 {
+		opts.Buffer = 0
+	}
+	logger.Infow("started video pipeline", "downloaders", opts.Downloaders, "encoders", opts.Encoders, "buffer", opts.Buffer)
+	defer logger.Info("quit video pipeline")
+
+	ready := make(chan *source, opts.Buffer)
+	var downloaders, encoders sync.WaitGroup
+	for i := 0; i < opts.Downloaders; i++ {
+		downloaders.Add(1)
+		go func() {
+			defer downloaders.Done()
+			for t := range p.IncomingTasks() {
+				if src := fetchSource(lib, p, t, opts.ProcessingOpts); src != nil {
+					ready <- src
+					metrics.PipelineBuffered.Set(float64(len(ready)))
+				}
+			}
+		}()
+	}
+	for i := 0; i < opts.Encoders; i++ {
+		encoders.Add(1)
+		go func() {
+			defer encoders.Done()
+			for src := range ready {
+				metrics.PipelineBuffered.Set(float64(len(ready)))
+				encodeSource(lib, p, src)
+			}
+		}()
+	}
+	downloaders.Wait()
+	close(ready)
+	encoders.Wait()
+}
+
+// fetchSource validates task claim and downloads its stream, returning nil if the task cannot proceed to encoding.
+func fetchSource(lib *Library, p *queue.Poller, t *queue.Task, opts ProcessingOpts) *source {
+	ll := logger.Named("worker").With("url", t.URL, "task_id", t.ID)
+
+	var c *claim.Claim
+	var err error
+	fetcher := opts.Fetcher
+	if claim.IsDirect(t.URL) {
+		c, err = claim.NewDirectClaim(t.URL, t.SDHash)
+		fetcher = opts.DirectFetcher
+	} else {
+		c, err = opts.Resolver.Resolve(t.URL)
+	}
+	if err != nil {
+		ll.Errorw("resolve failed", "err", err)
+		p.RejectTask(t)
+		finishTask(lib, p, t, taskFailed(t, nil, err))
+		return nil
+	}
+
+	channel := claimChannel(c)
+	if err := CheckQuota(channel, time.Now()); err != nil {
+		ll.Infow("task rejected", "reason", "channel quota exceeded", "channel", channel, "err", err)
+		p.RejectTask(t)
+		finishTask(lib, p, t, taskFailed(t, c, err))
+		return nil
+	}
+	settings := GetChannelSettings(channel)
+
+	ll.Infow("starting task")
+	if err := p.StartTask(t); err != nil {
+		// Task was rejected or canceled after being picked up.
+		ll.Infow("task dropped", "reason", "task could not be started", "err", err)
+		return nil
+	}
+	p.StageTask(t, queue.StageDownloading)
+	lib.events.Publish(taskEvent(t, c, events.StageDownloading))
+	metrics.PipelineDownloading.Inc()
+	streamFH, streamSize, err := fetcher.Fetch(c, path.Join(os.TempDir(), "transcoder", "streams"))
+	metrics.PipelineDownloading.Dec()
+	metrics.DownloadedSizeMB.Add(float64(streamSize) / 1024 / 1024)
+
+	if errors.Is(err, claim.ErrSourceMismatch) {
+		ll.Errorw("task rejected", "reason", "downloaded stream verification failed", "err", err)
+		p.RejectTask(t)
+		finishTask(lib, p, t, taskFailed(t, c, err))
+		return nil
+	} else if err != nil {
+		ll.Errorw("task released", "reason", "download failed", "err", err)
+		tErr := p.ReleaseTask(t)
+		if tErr != nil {
+			ll.Errorw("error releasing task", "tid", t.ID, "err", tErr)
 		}
+		lib.events.Publish(taskReleased(t, c, err))
+		return nil
+	}
 
-		ll.Infow("starting task")
-		p.StartTask(t)
-		streamFH, streamSize, err := c.Download(path.Join(os.TempDir(), "transcoder", "streams"))
-		metrics.DownloadedSizeMB.Add(float64(streamSize) / 1024 / 1024)
+	ll = ll.With("file", streamFH.Name())
 
+	if err := streamFH.Close(); err != nil {
+		ll.Errorw("task released", "reason", "closing downloaded file failed", "err", err)
+		p.ReleaseTask(t)
+		lib.events.Publish(taskReleased(t, c, err))
+		return nil
+	}
+
+	sourceHash, err := HashSource(streamFH.Name())
+	if err != nil {
+		ll.Warnw("hashing source failed", "err", err)
+	} else {
+		v, err := LinkDuplicate(lib, c, t.URL, sourceHash)
 		if err != nil {
-			ll.Errorw("task released", "reason", "download failed", "err", err)
-			tErr := p.ReleaseTask(t)
-			if tErr != nil {
-				ll.Errorw("error releasing task", "tid", t.ID, "err", tErr)
+			ll.Warnw("linking to identical source failed", "err", err)
+		} else if v != nil {
+			if err := p.CompleteTask(t); err != nil {
+				ll.Errorw("completing task failed", "err", err)
 			}
-			continue
+			metrics.TranscodingDeduplicatedCount.Inc()
+			ll.Infow("identical source already transcoded, linked", "source_hash", sourceHash, "origin", v.Origin)
+			finishTask(lib, p, t, taskEvent(t, c, events.StageDone))
+			releaseSource(fetcher, c)
+			if err := os.Remove(streamFH.Name()); err != nil {
+				ll.Errorw("cleanup failed", "err", err)
+			}
+			return nil
 		}
+	}
 
-		ll = ll.With("file", streamFH.Name())
+	p.StageTask(t, queue.StageReady)
+	return &source{
+		task:       t,
+		claim:      c,
+		file:       streamFH.Name(),
+		sourceHash: sourceHash,
+		channel:    channel,
+		settings:   settings,
+		fetcher:    fetcher,
+	}
+}
 
-		if err := streamFH.Close(); err != nil {
-			ll.Errorw("task released", "reason", "closing downloaded file failed", "err", err)
-			p.ReleaseTask(t)
-			continue
+// encodeSource transcodes a downloaded stream and adds the result to the library. The source file is removed afterwards.
+func encodeSource(lib *Library, p *queue.Poller, src *source) {
+	t, c, channel, settings := src.task, src.claim, src.channel, src.settings
+	ll := logger.Named("worker").With("url", t.URL, "task_id", t.ID, "file", src.file)
+	defer func() {
+		if err := os.Remove(src.file); err != nil && !os.IsNotExist(err) {
+			ll.Errorw("cleanup failed", "err", err)
 		}
+	}()
 
-		tmr := timer.Start()
+	p.StageTask(t, queue.StageEncoding)
+	tmr := timer.Start()
 
-		localStream := lib.local.New(c.SDHash)
+	localStream := lib.local.New(c.SDHash)
 
-		enc, err := encoder.NewEncoder(streamFH.Name(), localStream.FullPath())
+	enc, err := encoder.NewEncoder(src.file, localStream.FullPath())
+	if err != nil {
+		ll.Errorw("task rejected", "reason", "encoder initialization failure", "err", err)
+		p.RejectTask(t)
+		finishTask(lib, p, t, taskFailed(t, c, err))
+		return
+	}
+
+	encrypted := lib.EncryptionEnabled() && NeedsEncryption(c)
+	if encrypted {
+		key, err := lib.GenerateKey(c.SDHash)
 		if err != nil {
-			ll.Errorw("task rejected", "reason", "encoder initialization failure", "err", err)
-			p.RejectTask(t)
-			continue
+			ll.Errorw("task released", "reason", "encryption key generation failure", "err", err)
+			p.ReleaseTask(t)
+			lib.events.Publish(taskReleased(t, c, err))
+			return
+		}
+		enc.Encrypt(key, lib.KeyURI(c.SDHash))
+	}
+	// dropKey removes the key generated for a stream that didn't make it into the library.
+	dropKey := func() {
+		if !encrypted {
+			return
 		}
+		if err := lib.DeleteKey(c.SDHash); err != nil {
+			ll.Errorw("deleting encryption key failed", "err", err)
+		}
+	}
+	if err := enc.Profile(settings.Profile, settings.MaxHeight); err != nil {
+		ll.Warnw("channel encoding profile ignored", "channel", channel, "err", err)
+	}
 
-		ll.Infow("starting encoding")
+	ll.Infow("starting encoding")
 
-		metrics.TranscodingRunning.Inc()
-		e, err := enc.Encode()
-		if err != nil {
-			ll.Errorw("task rejected", "reason", "encoding failure", "err", err)
-			p.RejectTask(t)
+	metrics.TranscodingRunning.Inc()
+	e, err := enc.Encode()
+	if err != nil {
+		ll.Errorw("task rejected", "reason", "encoding failure", "err", err)
+		p.RejectTask(t)
+		finishTask(lib, p, t, taskFailed(t, c, err))
+		metrics.TranscodingRunning.Dec()
+		enc.Cleanup()
+		dropKey()
+		return
+	}
+
+	var completeErr error
+	for i := range e {
+		ll.Debugw("encoding", "progress", fmt.Sprintf("%.2f", i.GetProgress()))
+		p.ProgressTask(t, i.GetProgress(), parseSpeed(i.GetSpeed()))
+		ev := taskEvent(t, c, events.StageEncoding)
+		ev.Progress = i.GetProgress()
+		lib.events.Publish(ev)
+
+		if i.GetProgress() >= 99.9 {
+			completeErr = p.CompleteTask(t)
 			metrics.TranscodingRunning.Dec()
-			continue
+			metrics.TranscodingSpentSeconds.Add(tmr.Duration())
+			ll.Infow(
+				"encoding complete",
+				"out", localStream.FullPath(),
+				"seconds_spent", tmr.String(),
+				"duration", enc.Meta.Format.Duration,
+				"bitrate", enc.Meta.Format.GetBitRate(),
+			)
+			break
 		}
+	}
 
-		for i := range e {
-			ll.Debugw("encoding", "progress", fmt.Sprintf("%.2f", i.GetProgress()))
-			p.ProgressTask(t, i.GetProgress())
-
-			if i.GetProgress() >= 99.9 {
-				p.CompleteTask(t)
-				metrics.TranscodingRunning.Dec()
-				metrics.TranscodingSpentSeconds.Add(tmr.Duration())
-				ll.Infow(
-					"encoding complete",
-					"out", localStream.FullPath(),
-					"seconds_spent", tmr.String(),
-					"duration", enc.Meta.Format.Duration,
-					"bitrate", enc.Meta.Format.GetBitRate(),
-				)
-				break
-			}
+	time.Sleep(10 * time.Second)
+	if err := enc.Cleanup(); err != nil {
+		ll.Errorw("encoder cleanup failed", "err", err)
+	}
+	if completeErr != nil {
+		// Task is no longer started, so its output is not wanted.
+		ll.Errorw("encoded stream discarded", "reason", "task could not be completed", "err", completeErr)
+		if err := lib.local.Delete(c.SDHash); err != nil {
+			ll.Errorw("deleting encoded stream failed", "err", err)
 		}
+		dropKey()
+		return
+	}
 
-		time.Sleep(10 * time.Second)
-		err = localStream.ReadMeta()
-		if err != nil {
-			logger.Errorw("filling stream metadata failed", "err", err)
+	err = localStream.ReadMeta()
+	if err != nil {
+		logger.Errorw("filling stream metadata failed", "err", err)
+	}
+	renditions, err := localStream.Renditions()
+	if err != nil {
+		logger.Errorw("reading stream renditions failed", "err", err)
+	}
+
+	_, err = lib.Add(AddParams{
+		URL:      t.URL,
+		SDHash:   t.SDHash,
+		Type:     formats.TypeHLS,
+		Channel:  claimChannelURL(c),
+		Path:     localStream.LastPath(),
+		Size:     localStream.Size(),
+		Checksum: localStream.Checksum(),
+		ClaimID:  c.ClaimID,
+	})
+	if err != nil {
+		logger.Errorw("adding to video library failed", "err", err)
+		finishTask(lib, p, t, taskFailed(t, c, err))
+		dropKey()
+	} else {
+		if err := lib.AddRenditions(t.SDHash, renditions); err != nil {
+			logger.Errorw("adding renditions to video library failed", "err", err)
 		}
+		if src.sourceHash != "" {
+			if err := lib.AddSource(src.sourceHash, t.SDHash); err != nil {
+				logger.Errorw("adding source hash to video library failed", "err", err)
+			}
+		}
+		releaseSource(src.fetcher, c)
+		completeTask(lib, p, t, taskEvent(t, c, events.StageDone))
 
-		_, err = lib.Add(AddParams{
-			URL:      t.URL,
-			SDHash:   t.SDHash,
-			Type:     formats.TypeHLS,
-			Channel:  c.SigningChannel.CanonicalURL,
-			Path:     localStream.LastPath(),
-			Size:     localStream.Size(),
-			Checksum: localStream.Checksum(),
-		})
-		if err != nil {
-			logger.Errorw("adding to video library failed", "err", err)
+		// Recorded after adding the video so its size is counted towards channel storage as well.
+		dur, _ := strconv.ParseFloat(enc.Meta.Format.Duration, 64)
+		if err := lib.RecordEncoded(channel, dur/60); err != nil {
+			ll.Errorw("recording channel usage failed", "err", err)
 		}
+	}
 
-		metrics.TranscodedCount.Inc()
-		metrics.TranscodedSizeMB.Add(float64(localStream.Size()) / 1024 / 1024)
+	metrics.TranscodedCount.Inc()
+	metrics.TranscodedSizeMB.Add(float64(localStream.Size()) / 1024 / 1024)
+}
 
-		err = os.Remove(streamFH.Name())
-		if err != nil {
-			logger.Errorw("cleanup failed", "err", err)
-		}
+// releaseSource lets fetcher dispose of the original source of a video that has been stored.
+func releaseSource(f claim.SourceFetcher, c *claim.Claim) {
+	r, ok := f.(claim.SourceReleaser)
+	if !ok {
+		return
+	}
+	if err := r.Release(c); err != nil {
+		logger.Errorw("releasing source failed", "url", c.CanonicalURL, "err", err)
+	}
+}
+
+func taskEvent(t *queue.Task, c *claim.Claim, stage string) events.Event {
+	e := events.Event{Stage: stage, SDHash: t.SDHash, URL: t.URL, TaskID: t.ID}
+	if c != nil && c.SigningChannel != nil {
+		e.Channel = c.SigningChannel.CanonicalURL
+	}
+	return e
+}
+
+func taskFailed(t *queue.Task, c *claim.Claim, err error) events.Event {
+	e := taskEvent(t, c, events.StageFailed)
+	e.Error = err.Error()
+	return e
+}
+
+// taskReleased reports a task returned back to the queue to be retried later.
+func taskReleased(t *queue.Task, c *claim.Claim, err error) events.Event {
+	e := taskEvent(t, c, events.StageQueued)
+	e.Error = err.Error()
+	return e
+}
+
+// parseSpeed converts ffmpeg speed value (like `1.25x`) to a number, returning zero when it's not available.
+func parseSpeed(speed string) float64 {
+	v, err := strconv.ParseFloat(strings.TrimSuffix(strings.TrimSpace(speed), "x"), 64)
+	if err != nil {
+		return 0
 	}
+	return v
 }
 
 type S3Uploader struct {
 	lib        *Library
 	processing cmap.ConcurrentMap
+	dispatcher dispatcher.Dispatcher
+}
+
+// enqueue schedules upload of video `v`, returning false if it's being uploaded already.
+func (u *S3Uploader) enqueue(v *Video) bool {
+	if !u.processing.SetIfAbsent(v.SDHash, v) {
+		return false
+	}
+	u.dispatcher.Dispatch(v)
+	return true
 }
 
 func (u S3Uploader) Do(t dispatcher.Task) error {
 	v := t.Payload.(*Video)
-	u.processing.Set(v.SDHash, v)
+	defer u.processing.Remove(v.SDHash)
 
 	logger.Infow("uploading stream to S3", "sd_hash", v.SDHash, "size", v.GetSize())
 
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 	err := dispatcher.WaitUntilTrue(ctx, 300*time.Millisecond, func() bool {
-		if _, err := u.lib.local.Open(v.SDHash); err == nil {
+		if _, err := u.lib.local.Open(v.GetStorageKey()); err == nil {
 			return true
 		}
 		return false
@@ -148,34 +447,20 @@ func (u S3Uploader) Do(t dispatcher.Task) error {
 		return errors.New("timed out waiting for master playlist to appear")
 	}
 
-	lv, err := u.lib.local.Open(v.SDHash)
-	if err != nil {
-		u.processing.Remove(v.SDHash)
-		return err
-	}
-
-	rs, err := u.lib.remote.Put(lv)
-	if err != nil {
-		u.processing.Remove(v.SDHash)
-		return err
-	}
-	v.RemotePath = rs.URL()
-
-	err = u.lib.UpdateRemotePath(v.SDHash, v.RemotePath)
+	err = u.lib.Upload(v)
 	if err != nil {
-		logger.Errorw("error updating video", "sd_hash", v.SDHash, "remote_path", rs.URL(), "err", err)
-		u.processing.Remove(v.SDHash)
 		return err
 	}
-	metrics.S3UploadedSizeMB.Add(float64(v.GetSize()))
-	logger.Infow("uploaded stream to S3", "sd_hash", v.SDHash, "remote_path", rs.URL(), "size", v.GetSize())
+	u.lib.uploaded(v.SDHash)
+	logger.Infow("uploaded stream to S3", "sd_hash", v.SDHash, "remote_path", v.RemotePath, "size", v.GetSize())
 	return nil
 }
 
 func SpawnS3Uploader(lib *Library) dispatcher.Dispatcher {
 	logger.Info("starting s3 uploader")
-	s3up := S3Uploader{lib: lib, processing: cmap.New()}
-	d := dispatcher.Start(5, s3up)
+	s3up := &S3Uploader{lib: lib, processing: cmap.New()}
+	s3up.dispatcher = dispatcher.Start(5, s3up)
+	lib.uploader = s3up
 	ticker := time.NewTicker(5 * time.Second)
 
 	go func() {
@@ -188,14 +473,11 @@ func SpawnS3Uploader(lib *Library) dispatcher.Dispatcher {
 					return
 				}
 				for _, v := range videos {
-					absent := s3up.processing.SetIfAbsent(v.SDHash, &v)
-					if absent {
-						d.Dispatch(v)
-					}
+					s3up.enqueue(v)
 				}
 			}
 		}
 	}()
 
-	return d
+	return s3up.dispatcher
 }
@@ -0,0 +1,109 @@
+package video
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/lbryio/transcoder/db"
+	"github.com/lbryio/transcoder/formats"
+	"github.com/lbryio/transcoder/pkg/claim"
+	"github.com/lbryio/transcoder/queue"
+	"github.com/lbryio/transcoder/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stageFetcher records task stage seen while fetching.
+type stageFetcher struct {
+	*claim.FileFetcher
+	q      *queue.Queue
+	stages chan string
+	files  chan string
+}
+
+func (f stageFetcher) Fetch(c *claim.Claim, dest string) (*os.File, int64, error) {
+	t, err := f.q.GetBySDHash(c.SDHash)
+	if err != nil {
+		return nil, 0, err
+	}
+	f.stages <- t.Stage.String
+	fh, n, err := f.FileFetcher.Fetch(c, dest)
+	if fh != nil {
+		f.files <- fh.Name()
+	}
+	return fh, n, err
+}
+
+func TestSpawnPipeline(t *testing.T) {
+	vdb := db.OpenTestDB()
+	require.NoError(t, vdb.Migrate(Migrations...))
+	qdb := db.OpenTestDB()
+	require.NoError(t, qdb.Migrate(queue.Migrations...))
+	lib := NewLibrary(Configure().LocalStorage(storage.Local(path.Join(os.TempDir(), "transcoder_test_pipeline"))).DB(vdb))
+	q := queue.NewQueue(qdb)
+
+	sdHash := strings.Repeat("ef", 48)
+	c, err := claim.NewFakeClaim("lbry://video#a", "a", sdHash, "lbry://@channel#1")
+	require.NoError(t, err)
+	resolver := claim.NewMemoryResolver()
+	resolver.Add("lbry://video#a", c)
+
+	// Not a video, so the task fails once it reaches the encoder.
+	src := path.Join(os.TempDir(), "transcoder_test_pipeline_source")
+	require.NoError(t, ioutil.WriteFile(src, []byte("not a video"), 0644))
+	defer os.Remove(src)
+	fetcher := stageFetcher{FileFetcher: claim.NewFileFetcher(), q: q, stages: make(chan string, 1), files: make(chan string, 1)}
+	fetcher.Add(sdHash, src)
+
+	task, err := q.Add("lbry://video#a", sdHash, formats.TypeHLS)
+	require.NoError(t, err)
+
+	go SpawnPipeline(q, lib, q.StartPoller(1), PipelineOpts{
+		ProcessingOpts: ProcessingOpts{Resolver: resolver, Fetcher: fetcher},
+		Downloaders:    2,
+		Encoders:       1,
+		Buffer:         1,
+	})
+
+	select {
+	case stage := <-fetcher.stages:
+		assert.Equal(t, queue.StageDownloading, stage)
+	case <-time.After(5 * time.Second):
+		t.Fatal("task was not picked up")
+	}
+	downloaded := <-fetcher.files
+
+	require.Eventually(t, func() bool {
+		tt, err := q.Get(task.ID)
+		return err == nil && tt.Status == queue.StatusRejected
+	}, 5*time.Second, 50*time.Millisecond)
+	tt, err := q.Get(task.ID)
+	require.NoError(t, err)
+	assert.False(t, tt.Stage.Valid)
+	_, err = os.Stat(downloaded)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestQueueUpload(t *testing.T) {
+	vdb := db.OpenTestDB()
+	require.NoError(t, vdb.Migrate(Migrations...))
+	localPath := t.TempDir()
+	lib := NewLibrary(Configure().LocalStorage(storage.Local(localPath)).RemoteStorage(storage.Dummy()).DB(vdb))
+
+	sdHash := randomString(96)
+	v, err := lib.Add(AddParams{URL: "lbry://video", SDHash: sdHash, Type: formats.TypeHLS, Path: sdHash})
+	require.NoError(t, err)
+	require.Error(t, lib.QueueUpload(v), "uploads are not accepted until uploader is started")
+
+	require.NoError(t, os.MkdirAll(path.Join(localPath, sdHash), os.ModePerm))
+	SpawnS3Uploader(lib)
+	require.NoError(t, lib.QueueUpload(v))
+	require.Eventually(t, func() bool {
+		v, err := lib.Lookup(sdHash)
+		return err == nil && v.RemotePath != ""
+	}, 3*time.Second, 50*time.Millisecond)
+}
@@ -0,0 +1,67 @@
+package webhooks
+
+import (
+	"database/sql"
+	"time"
+)
+
+const (
+	EventCompleted = "completed"
+	EventFailed    = "failed"
+
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	StatusFailed    = "failed"
+)
+
+// Hook is a registered webhook URL. Hooks with empty channel receive notifications for all videos.
+type Hook struct {
+	ID        int64  `json:"id"`
+	URL       string `json:"url"`
+	Channel   string `json:"channel,omitempty"`
+	CreatedAt string `json:"created_at"`
+}
+
+// Delivery is a single notification sent (or to be sent) to a webhook URL.
+type Delivery struct {
+	ID             int64          `json:"id"`
+	URL            string         `json:"url"`
+	SDHash         string         `json:"sd_hash"`
+	Event          string         `json:"event"`
+	Payload        string         `json:"payload"`
+	Status         string         `json:"status"`
+	Attempts       int            `json:"attempts"`
+	NextAttemptAt  string         `json:"next_attempt_at"`
+	LastError      string         `json:"last_error,omitempty"`
+	LastStatusCode int            `json:"last_status_code,omitempty"`
+	CreatedAt      string         `json:"created_at"`
+	DeliveredAt    sql.NullString `json:"-"`
+}
+
+// Payload is the JSON body posted to webhook URLs.
+type Payload struct {
+	Event   string `json:"event"`
+	SDHash  string `json:"sd_hash"`
+	URL     string `json:"url"`
+	Channel string `json:"channel,omitempty"`
+	// Location is a remote stream URL or a path relative to the streams endpoint.
+	Location   string      `json:"location,omitempty"`
+	Remote     bool        `json:"remote"`
+	Renditions []Rendition `json:"renditions,omitempty"`
+	Error      string      `json:"error,omitempty"`
+	Timings    Timings     `json:"timings"`
+}
+
+type Rendition struct {
+	Name      string `json:"name"`
+	Height    int    `json:"height"`
+	Bandwidth int    `json:"bandwidth"`
+}
+
+type Timings struct {
+	QueuedAt   *time.Time `json:"queued_at,omitempty"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt time.Time  `json:"finished_at"`
+	// Seconds spent in processing since the task was picked up from the queue.
+	ProcessingSeconds float64 `json:"processing_seconds,omitempty"`
+}
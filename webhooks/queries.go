@@ -0,0 +1,201 @@
+package webhooks
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const sqliteTimeLayout = "2006-01-02 15:04:05"
+
+var (
+	allDeliveryColumns = `id, url, sd_hash, event, payload, status, attempts, next_attempt_at,
+		last_error, last_status_code, created_at, delivered_at`
+
+	queryHookAdd    = `insert or ignore into hooks (url, channel, created_at) values ($1, $2, datetime('now'))`
+	queryHookGet    = `select id, url, channel, created_at from hooks where url = $1 and channel = $2`
+	queryHookList   = `select id, url, channel, created_at from hooks order by id`
+	queryHookDelete = `delete from hooks where id = $1`
+	queryHookMatch  = `select id, url, channel, created_at from hooks where channel = "" or lower(channel) = lower($1)`
+
+	queryCallbackAdd    = `insert or ignore into callbacks (sd_hash, url, created_at) values ($1, $2, datetime('now'))`
+	queryCallbackList   = `select url from callbacks where sd_hash = $1`
+	queryCallbackDelete = `delete from callbacks where sd_hash = $1`
+
+	queryDeliveryAdd = `
+		insert into deliveries (
+			url, sd_hash, event, payload, status, next_attempt_at, created_at
+		) values (
+			$1, $2, $3, $4, "pending", datetime('now'), datetime('now')
+		)`
+	queryDeliveryDue = fmt.Sprintf(`
+		select %v from deliveries where status = "pending" and next_attempt_at <= $1
+		order by next_attempt_at limit $2`, allDeliveryColumns)
+	queryDeliveryList = fmt.Sprintf(`
+		select %v from deliveries where ($1 = "" or sd_hash = $1) order by id desc limit $2`, allDeliveryColumns)
+	queryDeliveryDelivered = `
+		update deliveries set status = "delivered", attempts = attempts + 1, last_status_code = $1,
+		last_error = "", delivered_at = datetime('now') where id = $2`
+	queryDeliveryAttemptFailed = `
+		update deliveries set status = $1, attempts = attempts + 1, last_status_code = $2, last_error = $3,
+		next_attempt_at = $4 where id = $5`
+)
+
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func (q *Queries) AddHook(ctx context.Context, url, channel string) (*Hook, error) {
+	_, err := q.db.ExecContext(ctx, queryHookAdd, url, channel)
+	if err != nil {
+		return nil, err
+	}
+	var h Hook
+	row := q.db.QueryRowContext(ctx, queryHookGet, url, channel)
+	if err := row.Scan(&h.ID, &h.URL, &h.Channel, &h.CreatedAt); err != nil {
+		return nil, err
+	}
+	return &h, nil
+}
+
+func (q *Queries) ListHooks(ctx context.Context) ([]*Hook, error) {
+	return q.listHooks(ctx, queryHookList)
+}
+
+// MatchHooks returns global hooks and hooks registered for `channel`.
+func (q *Queries) MatchHooks(ctx context.Context, channel string) ([]*Hook, error) {
+	return q.listHooks(ctx, queryHookMatch, channel)
+}
+
+func (q *Queries) listHooks(ctx context.Context, query string, args ...interface{}) ([]*Hook, error) {
+	hooks := []*Hook{}
+	rows, err := q.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var h Hook
+		if err := rows.Scan(&h.ID, &h.URL, &h.Channel, &h.CreatedAt); err != nil {
+			return nil, err
+		}
+		hooks = append(hooks, &h)
+	}
+	return hooks, rows.Err()
+}
+
+func (q *Queries) DeleteHook(ctx context.Context, id int64) error {
+	r, err := q.db.ExecContext(ctx, queryHookDelete, id)
+	if err != nil {
+		return err
+	}
+	n, err := r.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+func (q *Queries) AddCallback(ctx context.Context, sdHash, url string) error {
+	_, err := q.db.ExecContext(ctx, queryCallbackAdd, sdHash, url)
+	return err
+}
+
+func (q *Queries) ListCallbacks(ctx context.Context, sdHash string) ([]string, error) {
+	urls := []string{}
+	rows, err := q.db.QueryContext(ctx, queryCallbackList, sdHash)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var u string
+		if err := rows.Scan(&u); err != nil {
+			return nil, err
+		}
+		urls = append(urls, u)
+	}
+	return urls, rows.Err()
+}
+
+func (q *Queries) DeleteCallbacks(ctx context.Context, sdHash string) error {
+	_, err := q.db.ExecContext(ctx, queryCallbackDelete, sdHash)
+	return err
+}
+
+func (q *Queries) AddDelivery(ctx context.Context, url, sdHash, event, payload string) error {
+	_, err := q.db.ExecContext(ctx, queryDeliveryAdd, url, sdHash, event, payload)
+	return err
+}
+
+// ListDue returns pending deliveries which should be attempted at `now`.
+func (q *Queries) ListDue(ctx context.Context, now time.Time, limit int) ([]*Delivery, error) {
+	return q.listDeliveries(ctx, queryDeliveryDue, now.UTC().Format(sqliteTimeLayout), limit)
+}
+
+// ListDeliveries returns latest deliveries for `sdHash`, or for all videos if it's empty.
+func (q *Queries) ListDeliveries(ctx context.Context, sdHash string, limit int) ([]*Delivery, error) {
+	return q.listDeliveries(ctx, queryDeliveryList, sdHash, limit)
+}
+
+func (q *Queries) listDeliveries(ctx context.Context, query string, args ...interface{}) ([]*Delivery, error) {
+	list := []*Delivery{}
+	rows, err := q.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		d, err := scanDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, d)
+	}
+	return list, rows.Err()
+}
+
+func (q *Queries) MarkDelivered(ctx context.Context, id int64, statusCode int) error {
+	_, err := q.db.ExecContext(ctx, queryDeliveryDelivered, statusCode, id)
+	return err
+}
+
+// MarkAttemptFailed records an unsuccessful attempt, scheduling the next one at `next`.
+// Delivery is marked as failed when `next` is zero.
+func (q *Queries) MarkAttemptFailed(ctx context.Context, id int64, statusCode int, lastErr string, next time.Time) error {
+	status := StatusPending
+	if next.IsZero() {
+		status = StatusFailed
+	}
+	_, err := q.db.ExecContext(
+		ctx, queryDeliveryAttemptFailed,
+		status, statusCode, lastErr, next.UTC().Format(sqliteTimeLayout), id,
+	)
+	return err
+}
+
+func scanDelivery(r rowScanner) (*Delivery, error) {
+	var d Delivery
+	err := r.Scan(
+		&d.ID,
+		&d.URL,
+		&d.SDHash,
+		&d.Event,
+		&d.Payload,
+		&d.Status,
+		&d.Attempts,
+		&d.NextAttemptAt,
+		&d.LastError,
+		&d.LastStatusCode,
+		&d.CreatedAt,
+		&d.DeliveredAt,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return &d, nil
+}
@@ -0,0 +1,76 @@
+package webhooks
+
+import "github.com/lbryio/transcoder/db"
+
+// Migrations contains all webhooks schema changes in the order they should be applied.
+var Migrations = []db.Migration{
+	{Name: "hooks", SQL: HooksMigration},
+	{Name: "callbacks", SQL: CallbacksMigration},
+	{Name: "deliveries", SQL: DeliveriesMigration},
+}
+
+var HooksMigration = `
+-- +migrate Up
+
+-- +migrate StatementBegin
+CREATE TABLE IF NOT EXISTS hooks (
+    "id" integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+    "url" TEXT NOT NULL,
+    "channel" TEXT NOT NULL DEFAULT "",
+    "created_at" TEXT NOT NULL,
+    UNIQUE (url, channel)
+);
+-- +migrate StatementEnd
+
+-- +migrate Down
+
+-- +migrate StatementBegin
+DROP TABLE hooks;
+-- +migrate StatementEnd
+`
+
+var CallbacksMigration = `
+-- +migrate Up
+
+-- +migrate StatementBegin
+CREATE TABLE IF NOT EXISTS callbacks (
+    "sd_hash" TEXT NOT NULL,
+    "url" TEXT NOT NULL,
+    "created_at" TEXT NOT NULL,
+    PRIMARY KEY (sd_hash, url)
+);
+-- +migrate StatementEnd
+
+-- +migrate Down
+
+-- +migrate StatementBegin
+DROP TABLE callbacks;
+-- +migrate StatementEnd
+`
+
+var DeliveriesMigration = `
+-- +migrate Up
+
+-- +migrate StatementBegin
+CREATE TABLE IF NOT EXISTS deliveries (
+    "id" integer NOT NULL PRIMARY KEY AUTOINCREMENT,
+    "url" TEXT NOT NULL,
+    "sd_hash" TEXT NOT NULL,
+    "event" TEXT NOT NULL,
+    "payload" TEXT NOT NULL,
+    "status" TEXT NOT NULL,
+    "attempts" INTEGER NOT NULL DEFAULT 0,
+    "next_attempt_at" TEXT NOT NULL,
+    "last_error" TEXT NOT NULL DEFAULT "",
+    "last_status_code" INTEGER NOT NULL DEFAULT 0,
+    "created_at" TEXT NOT NULL,
+    "delivered_at" TEXT
+);
+-- +migrate StatementEnd
+
+-- +migrate Down
+
+-- +migrate StatementBegin
+DROP TABLE deliveries;
+-- +migrate StatementEnd
+`
@@ -0,0 +1,267 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/lbryio/transcoder/db"
+	"github.com/lbryio/transcoder/pkg/worker"
+)
+
+const (
+	SignatureHeader = "X-Transcoder-Signature"
+	EventHeader     = "X-Transcoder-Event"
+	DeliveryHeader  = "X-Transcoder-Delivery"
+
+	signaturePrefix = "sha256="
+)
+
+type Config struct {
+	db          *db.DB
+	secret      string
+	client      *http.Client
+	maxAttempts int
+	backoff     time.Duration
+	maxBackoff  time.Duration
+	batchSize   int
+}
+
+func Configure() *Config {
+	return &Config{
+		client:      &http.Client{Timeout: 10 * time.Second},
+		maxAttempts: 10,
+		backoff:     30 * time.Second,
+		maxBackoff:  6 * time.Hour,
+		batchSize:   50,
+	}
+}
+
+// DB ...
+func (c *Config) DB(db *db.DB) *Config {
+	c.db = db
+	return c
+}
+
+// Secret sets a key for signing payloads. Receivers should verify `X-Transcoder-Signature` header
+// which contains hex-encoded HMAC-SHA256 of the request body.
+func (c *Config) Secret(secret string) *Config {
+	c.secret = secret
+	return c
+}
+
+// Client sets HTTP client used for deliveries.
+func (c *Config) Client(client *http.Client) *Config {
+	c.client = client
+	return c
+}
+
+// Retries sets the maximum number of delivery attempts and the delay before the first retry,
+// which is doubled for every subsequent one.
+func (c *Config) Retries(maxAttempts int, backoff time.Duration) *Config {
+	c.maxAttempts = maxAttempts
+	c.backoff = backoff
+	return c
+}
+
+// Manager keeps webhook registrations and persists notifications for delivery.
+type Manager struct {
+	*Config
+	queries Queries
+}
+
+func NewManager(cfg *Config) *Manager {
+	return &Manager{Config: cfg, queries: Queries{cfg.db}}
+}
+
+// AddHook registers `url` to be notified about videos of `channel`, or about all videos if `channel` is empty.
+func (m Manager) AddHook(url, channel string) (*Hook, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return m.queries.AddHook(ctx, url, channel)
+}
+
+func (m Manager) ListHooks() ([]*Hook, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return m.queries.ListHooks(ctx)
+}
+
+func (m Manager) DeleteHook(id int64) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return m.queries.DeleteHook(ctx, id)
+}
+
+// AddCallback registers a one-off `url` to be notified when processing of `sdHash` is finished.
+func (m Manager) AddCallback(sdHash, url string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return m.queries.AddCallback(ctx, sdHash, url)
+}
+
+// Deliveries returns up to `limit` latest deliveries for `sdHash`, or for all videos if it's empty.
+func (m Manager) Deliveries(sdHash string, limit int) ([]*Delivery, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return m.queries.ListDeliveries(ctx, sdHash, limit)
+}
+
+// Notify schedules delivery of payload `p` to all matching hooks and callbacks.
+func (m Manager) Notify(p *Payload) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	body, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	hooks, err := m.queries.MatchHooks(ctx, p.Channel)
+	if err != nil {
+		return err
+	}
+	callbacks, err := m.queries.ListCallbacks(ctx, p.SDHash)
+	if err != nil {
+		return err
+	}
+
+	urls := map[string]bool{}
+	for _, h := range hooks {
+		urls[h.URL] = true
+	}
+	for _, u := range callbacks {
+		urls[u] = true
+	}
+	for u := range urls {
+		if err := m.queries.AddDelivery(ctx, u, p.SDHash, p.Event, string(body)); err != nil {
+			return err
+		}
+	}
+	if err := m.queries.DeleteCallbacks(ctx, p.SDHash); err != nil {
+		return err
+	}
+	logger.Debugw("notification scheduled", "sd_hash", p.SDHash, "event", p.Event, "urls", len(urls))
+	return nil
+}
+
+// StartSender starts delivering scheduled notifications at `interval`.
+func (m *Manager) StartSender(interval time.Duration) *Sender {
+	s := &Sender{manager: m}
+	worker.NewTicker(s, interval).Start()
+	return s
+}
+
+// Sign returns signature of `body` as sent in `X-Transcoder-Signature` header.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks that `signature` is valid for `body`.
+func Verify(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
+
+// Sender is a worker delivering scheduled notifications.
+type Sender struct {
+	manager    *Manager
+	isShutdown bool
+}
+
+func (s *Sender) Process() error {
+	if s.IsShutdown() {
+		return worker.ErrShutdown
+	}
+	_, err := s.manager.deliverDue(time.Now())
+	return err
+}
+
+func (s *Sender) Shutdown() {
+	logger.Infow("webhook sender shutting down")
+	s.isShutdown = true
+}
+
+func (s *Sender) IsShutdown() bool {
+	return s.isShutdown
+}
+
+// deliverDue attempts all deliveries due at `now`, returning the number of successful ones.
+func (m Manager) deliverDue(now time.Time) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ds, err := m.queries.ListDue(ctx, now, m.batchSize)
+	cancel()
+	if err != nil {
+		return 0, err
+	}
+
+	delivered := 0
+	for _, d := range ds {
+		ll := logger.With("id", d.ID, "url", d.URL, "sd_hash", d.SDHash, "attempt", d.Attempts+1)
+		code, err := m.post(d)
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		if err == nil {
+			delivered++
+			err = m.queries.MarkDelivered(ctx, d.ID, code)
+			ll.Debugw("notification delivered", "status_code", code)
+		} else {
+			var next time.Time
+			if d.Attempts+1 < m.maxAttempts {
+				next = now.Add(m.retryDelay(d.Attempts + 1))
+			}
+			ll.Infow("notification delivery failed", "status_code", code, "err", err, "next_attempt", next)
+			err = m.queries.MarkAttemptFailed(ctx, d.ID, code, err.Error(), next)
+		}
+		cancel()
+		if err != nil {
+			return delivered, err
+		}
+	}
+	return delivered, nil
+}
+
+// retryDelay returns the delay before the next delivery attempt after `attempts` failed ones.
+func (m Manager) retryDelay(attempts int) time.Duration {
+	d := m.backoff
+	for i := 1; i < attempts && d < m.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > m.maxBackoff {
+		d = m.maxBackoff
+	}
+	return d
+}
+
+func (m Manager) post(d *Delivery) (int, error) {
+	body := []byte(d.Payload)
+	req, err := http.NewRequest(http.MethodPost, d.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, d.Event)
+	req.Header.Set(DeliveryHeader, fmt.Sprintf("%v", d.ID))
+	if m.secret != "" {
+		req.Header.Set(SignatureHeader, Sign(m.secret, body))
+	}
+
+	res, err := m.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	io.Copy(ioutil.Discard, res.Body)
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return res.StatusCode, fmt.Errorf("unexpected response status: %v", res.Status)
+	}
+	return res.StatusCode, nil
+}
@@ -0,0 +1,157 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/lbryio/transcoder/db"
+	"github.com/stretchr/testify/suite"
+)
+
+type WebhooksSuite struct {
+	suite.Suite
+	db *db.DB
+}
+
+type received struct {
+	body      []byte
+	signature string
+	event     string
+}
+
+func TestWebhooksSuite(t *testing.T) {
+	suite.Run(t, new(WebhooksSuite))
+}
+
+func (s *WebhooksSuite) SetupTest() {
+	s.db = db.OpenTestDB()
+	s.Require().NoError(s.db.Migrate(Migrations...))
+}
+
+func (s *WebhooksSuite) TestNotifyAndDeliver() {
+	var mu sync.Mutex
+	calls := map[string][]received{}
+	fail := true
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		body, _ := ioutil.ReadAll(r.Body)
+		calls[r.URL.Path] = append(calls[r.URL.Path], received{body, r.Header.Get(SignatureHeader), r.Header.Get(EventHeader)})
+		if r.URL.Path == "/flaky" && fail {
+			w.WriteHeader(http.StatusBadGateway)
+		}
+	}))
+	defer srv.Close()
+
+	m := NewManager(Configure().DB(s.db).Secret("s3cr3t").Retries(3, time.Minute))
+	_, err := m.AddHook(srv.URL+"/global", "")
+	s.Require().NoError(err)
+	_, err = m.AddHook(srv.URL+"/channel", "lbry://@Channel#1")
+	s.Require().NoError(err)
+	_, err = m.AddHook(srv.URL+"/other", "lbry://@other#2")
+	s.Require().NoError(err)
+	hooks, err := m.ListHooks()
+	s.Require().NoError(err)
+	s.Len(hooks, 3)
+
+	s.Require().NoError(m.AddCallback("sdhash1", srv.URL+"/flaky"))
+	s.Require().NoError(m.Notify(&Payload{
+		Event: EventCompleted, SDHash: "sdhash1", URL: "lbry://video", Channel: "lbry://@channel#1",
+		Renditions: []Rendition{{Name: "stream_0.m3u8", Height: 720}},
+	}))
+	// Callbacks are only notified once.
+	s.Require().NoError(m.Notify(&Payload{Event: EventFailed, SDHash: "sdhash1", Channel: "lbry://@channel#1"}))
+
+	now := time.Now()
+	n, err := m.deliverDue(now)
+	s.Require().NoError(err)
+	s.Equal(4, n)
+	s.Len(calls["/global"], 2)
+	s.Len(calls["/channel"], 2)
+	s.Len(calls["/other"], 0)
+	s.Require().Len(calls["/flaky"], 1)
+
+	r := calls["/channel"][0]
+	s.True(Verify("s3cr3t", r.body, r.signature))
+	s.False(Verify("wrong", r.body, r.signature))
+	var p Payload
+	s.Require().NoError(json.Unmarshal(r.body, &p))
+	s.Contains([]string{EventCompleted, EventFailed}, r.event)
+
+	ds, err := m.Deliveries("sdhash1", 10)
+	s.Require().NoError(err)
+	s.Len(ds, 5)
+	var flaky *Delivery
+	for _, d := range ds {
+		if d.URL == srv.URL+"/flaky" {
+			flaky = d
+		} else {
+			s.Equal(StatusDelivered, d.Status)
+		}
+	}
+	s.Require().NotNil(flaky)
+	s.Equal(StatusPending, flaky.Status)
+	s.Equal(1, flaky.Attempts)
+	s.Equal(http.StatusBadGateway, flaky.LastStatusCode)
+
+	// Not due yet.
+	n, err = m.deliverDue(now.Add(30 * time.Second))
+	s.Require().NoError(err)
+	s.Equal(0, n)
+	s.Len(calls["/flaky"], 1)
+
+	n, err = m.deliverDue(now.Add(61 * time.Second))
+	s.Require().NoError(err)
+	s.Equal(0, n)
+	s.Len(calls["/flaky"], 2)
+
+	// Gives up after the maximum number of attempts.
+	n, err = m.deliverDue(now.Add(4 * time.Minute))
+	s.Require().NoError(err)
+	s.Equal(0, n)
+	s.Len(calls["/flaky"], 3)
+	ds, err = m.Deliveries("sdhash1", 10)
+	s.Require().NoError(err)
+	for _, d := range ds {
+		if d.ID == flaky.ID {
+			s.Equal(StatusFailed, d.Status)
+			s.Equal(3, d.Attempts)
+		}
+	}
+
+	n, err = m.deliverDue(now.Add(24 * time.Hour))
+	s.Require().NoError(err)
+	s.Equal(0, n)
+	s.Len(calls["/flaky"], 3)
+}
+
+func (s *WebhooksSuite) TestDeleteHook() {
+	m := NewManager(Configure().DB(s.db))
+	h, err := m.AddHook("http://localhost/hook", "")
+	s.Require().NoError(err)
+	h2, err := m.AddHook("http://localhost/hook", "")
+	s.Require().NoError(err)
+	s.Equal(h.ID, h2.ID)
+
+	s.Require().NoError(m.DeleteHook(h.ID))
+	s.Error(m.DeleteHook(h.ID))
+	hooks, err := m.ListHooks()
+	s.Require().NoError(err)
+	s.Empty(hooks)
+}
+
+func TestRetryDelay(t *testing.T) {
+	m := NewManager(Configure().Retries(10, 30*time.Second))
+	for attempts, expected := range map[int]time.Duration{
+		1: 30 * time.Second, 2: time.Minute, 3: 2 * time.Minute, 20: 6 * time.Hour,
+	} {
+		if d := m.retryDelay(attempts); d != expected {
+			t.Errorf("expected %v delay after %v attempts, got %v", expected, attempts, d)
+		}
+	}
+}